@@ -0,0 +1,678 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gsm "github.com/bradleypeabody/gorilla-sessions-memcache"
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+var (
+	// Our parsed HTML templates
+	tmpl *template.Template
+
+	// Session cookie storage, shared with the webUI so admins log in there
+	store *gsm.MemcacheStore
+)
+
+// Checks a request to carry out an action (under /x/) is a logged in admin user submitting one of the admin forms,
+// sending an error response if it isn't.  Actions have to be POSTed from a page of the admin server itself, so other
+// sites can't trigger them through the browser of a logged in admin.
+func actionCheck(w http.ResponseWriter, r *http.Request) (adminUser string, ok bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Browsers send an Origin header with form submissions, and older ones at least send the Referer
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Referer()
+	}
+	u, err := url.Parse(source)
+	if source == "" || err != nil || strings.ToLower(u.Host) != strings.ToLower(r.Host) {
+		log.Printf("Rejected admin action '%s' from '%s'\n", r.URL.Path, source)
+		http.Error(w, "Admin actions can only be carried out from the admin server", http.StatusForbidden)
+		return
+	}
+	return adminCheck(w, r)
+}
+
+// Checks the request is from a logged in admin user, sending an error response if it isn't.
+func adminCheck(w http.ResponseWriter, r *http.Request) (adminUser string, ok bool) {
+	// Retrieve session data (if any)
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		http.Error(w, fmt.Sprintf("You need to log in at https://%s first", com.Conf.Web.ServerName),
+			http.StatusUnauthorized)
+		return
+	}
+	adminUser = u.(string)
+
+	// Make sure the user is in the admin list
+	for _, a := range com.Conf.Admin.Users {
+		if strings.ToLower(a) == strings.ToLower(adminUser) {
+			ok = true
+			return
+		}
+	}
+	log.Printf("Non admin user '%s' tried to access the admin server\n", adminUser)
+	http.Error(w, "You're not an administrator", http.StatusForbidden)
+	return
+}
+
+// Renders the audit log page, which can be filtered by actor, owner, database name, and action.
+func auditPage(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := adminCheck(w, r)
+	if !ok {
+		return
+	}
+	type auditRow struct {
+		com.AuditEntry
+		AfterJSON  string
+		BeforeJSON string
+	}
+	var pageData struct {
+		AdminUser string
+		Entries   []auditRow
+		Filter    com.AuditFilter
+		NextPage  int
+		PrevPage  int
+		Title     string
+	}
+	pageData.AdminUser = adminUser
+	pageData.Title = "Audit log"
+	pageData.Filter = com.AuditFilter{
+		Action: com.AuditAction(r.FormValue("action")),
+		Actor:  r.FormValue("actor"),
+		DBName: r.FormValue("dbname"),
+		Limit:  100,
+		Owner:  r.FormValue("username"),
+	}
+	if o := r.FormValue("offset"); o != "" {
+		offset, err := strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset value", http.StatusBadRequest)
+			return
+		}
+		pageData.Filter.Offset = offset
+	}
+
+	// Retrieve the matching entries.  One more than the page size is asked for, so we know if there's another page
+	f := pageData.Filter
+	f.Limit++
+	entries, err := com.AuditEntries(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) > pageData.Filter.Limit {
+		entries = entries[:pageData.Filter.Limit]
+		pageData.NextPage = pageData.Filter.Offset + pageData.Filter.Limit
+	}
+	pageData.PrevPage = -1
+	if pageData.Filter.Offset > 0 {
+		pageData.PrevPage = pageData.Filter.Offset - pageData.Filter.Limit
+		if pageData.PrevPage < 0 {
+			pageData.PrevPage = 0
+		}
+	}
+
+	// Present the before and after details as JSON
+	for _, e := range entries {
+		row := auditRow{AuditEntry: e}
+		if e.Before != nil {
+			b, _ := json.Marshal(e.Before)
+			row.BeforeJSON = string(b)
+		}
+		if e.After != nil {
+			a, _ := json.Marshal(e.After)
+			row.AfterJSON = string(a)
+		}
+		pageData.Entries = append(pageData.Entries, row)
+	}
+	renderPage(w, "auditPage", pageData)
+}
+
+// Returns to the page the request came from, after carrying out an action.
+func bounceBack(w http.ResponseWriter, r *http.Request) {
+	dest := r.Referer()
+	if dest == "" {
+		dest = "/"
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
+
+// Renders the database moderation page.
+func databasesPage(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := adminCheck(w, r)
+	if !ok {
+		return
+	}
+	var pageData struct {
+		AdminUser string
+		Databases []com.AdminDBEntry
+		Owner     string
+		Search    string
+		Title     string
+	}
+	pageData.AdminUser = adminUser
+	pageData.Title = "Databases"
+	pageData.Owner = r.FormValue("username")
+	pageData.Search = r.FormValue("q")
+
+	var err error
+	pageData.Databases, err = com.AdminDatabases(pageData.Owner, pageData.Search)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, "databasesPage", pageData)
+}
+
+// Moves a database to the trash of its owner.
+func deleteDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	dbOwner, dbFolder, dbName, ok := formDatabase(w, r)
+	if !ok {
+		return
+	}
+
+	// Invalidate the memcache data for the database before deleting it, as the invalidation needs the commit list
+	err := com.InvalidateCacheEntry(adminUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	}
+
+	// Record the deletion in the audit log first, as afterwards the database can't be looked up by its name
+	com.Audit(r, "admin", adminUser, dbOwner, dbFolder, dbName, com.AUDIT_DATABASE_DELETE, nil, nil)
+	err = com.DeleteDatabase(dbOwner, dbFolder, dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin '%s' deleted database '%s%s%s'\n", adminUser, dbOwner, dbFolder, dbName)
+	bounceBack(w, r)
+}
+
+// Disables or re-enables a user account.
+func disableUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	userName, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+	disabled, err := strconv.ParseBool(r.PostFormValue("disabled"))
+	if err != nil {
+		http.Error(w, "Invalid disabled value", http.StatusBadRequest)
+		return
+	}
+	err = com.SetUserDisabled(userName, disabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var action com.AuditAction = com.AUDIT_USER_ENABLE
+	if disabled {
+		action = com.AUDIT_USER_DISABLE
+	}
+	com.Audit(r, "admin", adminUser, userName, "", "", action, nil, nil)
+	log.Printf("Admin '%s' set disabled status of user '%s' to %v\n", adminUser, userName, disabled)
+	bounceBack(w, r)
+}
+
+// Removes everything from the cache.
+func flushCacheHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	err := com.FlushCache()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, "", "", "", com.AUDIT_CACHE_FLUSH, nil, nil)
+	log.Printf("Admin '%s' flushed the cache\n", adminUser)
+	bounceBack(w, r)
+}
+
+// Extracts and validates the database owner and name form fields.
+func formDatabase(w http.ResponseWriter, r *http.Request) (dbOwner string, dbFolder string, dbName string, ok bool) {
+	dbOwner, ok = formUser(w, r)
+	if !ok {
+		return
+	}
+	dbName = r.PostFormValue("dbname")
+	err := com.ValidateDB(dbName)
+	if err != nil {
+		http.Error(w, "Invalid database name", http.StatusBadRequest)
+		return "", "", "", false
+	}
+
+	// TODO: Add support for folders
+	dbFolder = "/"
+	return
+}
+
+// Extracts a limit from the form.  An empty field returns zero.
+func formLimit(r *http.Request, field string) (int64, error) {
+	s := r.PostFormValue(field)
+	if s == "" {
+		return 0, nil
+	}
+	l, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if l < 0 {
+		return 0, fmt.Errorf("Negative limit")
+	}
+	return l, nil
+}
+
+// Extracts and validates the user name form field.
+func formUser(w http.ResponseWriter, r *http.Request) (userName string, ok bool) {
+	userName = r.PostFormValue("username")
+	err := com.ValidateUser(userName)
+	if err != nil {
+		http.Error(w, "Invalid user name", http.StatusBadRequest)
+		return
+	}
+	ok = true
+	return
+}
+
+// Removes the cached data for a database.
+func invalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	dbOwner, dbFolder, dbName, ok := formDatabase(w, r)
+	if !ok {
+		return
+	}
+	err := com.InvalidateCacheEntry(adminUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, dbOwner, dbFolder, dbName, com.AUDIT_CACHE_INVALIDATE, nil, nil)
+	bounceBack(w, r)
+}
+
+func main() {
+	// Read server configuration
+	var err error
+	if err = com.ReadConfig(); err != nil {
+		log.Fatalf("Configuration file problem\n\n%v", err)
+	}
+	if len(com.Conf.Admin.Users) == 0 {
+		log.Fatalf("No admin users are set in the [admin] section of the configuration file")
+	}
+
+	// Set the temp dir environment variable
+	err = os.Setenv("TMPDIR", com.Conf.DiskCache.Directory)
+	if err != nil {
+		log.Fatalf("Setting temp directory environment variable failed: '%s'\n", err.Error())
+	}
+
+	// Parse our template files
+	tmpl = template.Must(template.New("templates").Delims("[[", "]]").ParseGlob(
+		filepath.Join(com.Conf.Web.BaseDir, "admin", "templates", "*.html")))
+
+	// Connect to PostgreSQL server
+	err = com.ConnectPostgreSQL()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	defer com.DisconnectPostgreSQL()
+
+	// Connect to the Memcached server
+	err = com.ConnectCache()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	// Setup session storage
+	store = gsm.NewMemcacheStore(com.MemcacheHandle(), "dbhub_", []byte(com.Conf.Web.SessionStorePassword))
+
+	// Our pages
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", statsPage)
+	mux.HandleFunc("/audit", auditPage)
+	mux.HandleFunc("/databases", databasesPage)
+	mux.HandleFunc("/queues", queuesPage)
+	mux.HandleFunc("/users", usersPage)
+	mux.HandleFunc("/x/deletedatabase", deleteDatabaseHandler)
+	mux.HandleFunc("/x/disableuser", disableUserHandler)
+	mux.HandleFunc("/x/flushcache", flushCacheHandler)
+	mux.HandleFunc("/x/invalidatecache", invalidateCacheHandler)
+	mux.HandleFunc("/x/makeprivate", makePrivateHandler)
+	mux.HandleFunc("/x/renameuser", renameUserHandler)
+	mux.HandleFunc("/x/resetcerts", resetCertsHandler)
+	mux.HandleFunc("/x/restoredatabase", restoreDatabaseHandler)
+	mux.HandleFunc("/x/setquota", setQuotaHandler)
+
+	// Start the admin server
+	if com.Conf.Admin.HTTPS {
+		log.Printf("DBHub admin server starting on https://%s\n", com.Conf.Admin.Server)
+		err = http.ListenAndServeTLS(com.Conf.Admin.Server, com.Conf.Admin.Certificate,
+			com.Conf.Admin.CertificateKey, mux)
+	} else {
+		log.Printf("DBHub admin server starting on http://%s\n", com.Conf.Admin.Server)
+		err = http.ListenAndServe(com.Conf.Admin.Server, mux)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Makes a database private.
+func makePrivateHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	dbOwner, dbFolder, dbName, ok := formDatabase(w, r)
+	if !ok {
+		return
+	}
+	err := com.SetDatabasePrivate(dbOwner, dbFolder, dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, dbOwner, dbFolder, dbName, com.AUDIT_DATABASE_MAKE_PRIVATE, nil,
+		map[string]bool{"public": false})
+	err = com.InvalidateCacheEntry(adminUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	}
+	log.Printf("Admin '%s' made database '%s%s%s' private\n", adminUser, dbOwner, dbFolder, dbName)
+	bounceBack(w, r)
+}
+
+// Renders the email and event queue page.
+func queuesPage(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := adminCheck(w, r)
+	if !ok {
+		return
+	}
+	var pageData struct {
+		AdminUser string
+		Emails    []com.EmailQueueEntry
+		Events    []com.EventQueueEntry
+		Title     string
+	}
+	pageData.AdminUser = adminUser
+	pageData.Title = "Queues"
+
+	var err error
+	pageData.Emails, err = com.EmailQueueEntries(100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pageData.Events, err = com.EventQueueEntries(100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, "queuesPage", pageData)
+}
+
+// Renames a user or organisation.
+func renameUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	userName, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+	newName := r.PostFormValue("newname")
+	err := com.ValidateUser(newName)
+	if err != nil {
+		http.Error(w, "Invalid new user name", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the new name isn't already taken.  Changing only the capitalisation of a name is fine though
+	if strings.ToLower(newName) != strings.ToLower(userName) {
+		exists, err := com.CheckUserExists(newName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, fmt.Sprintf("The name '%s' is already taken", newName), http.StatusConflict)
+			return
+		}
+	}
+	err = com.RenameUser(userName, newName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, newName, "", "", com.AUDIT_USER_RENAME, map[string]string{"user": userName},
+		map[string]string{"user": newName})
+	log.Printf("Admin '%s' renamed user '%s' to '%s'\n", adminUser, userName, newName)
+	http.Redirect(w, r, "/users?q="+newName, http.StatusSeeOther)
+}
+
+// Renders a page using the given template, reporting any failure to the client.
+func renderPage(w http.ResponseWriter, name string, pageData interface{}) {
+	t := tmpl.Lookup(name)
+	err := t.Execute(w, pageData)
+	if err != nil {
+		log.Printf("Error: %s", err)
+	}
+}
+
+// Invalidates the existing client certificates of a user.
+func resetCertsHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	userName, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+	err := com.ResetClientCerts(userName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, userName, "", "", com.AUDIT_USER_RESET_CERTS, nil, nil)
+	log.Printf("Admin '%s' reset the client certificates of user '%s'\n", adminUser, userName)
+	bounceBack(w, r)
+}
+
+// Restores a database from the trash of its owner.
+func restoreDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	dbOwner, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+	dbID, err := strconv.ParseInt(r.PostFormValue("dbid"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid database ID", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve the trash entry for the database
+	entry, err := com.DeletedDatabase(dbOwner, dbID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entry.DBName == "" {
+		http.Error(w, "That database isn't in the trash", http.StatusNotFound)
+		return
+	}
+
+	// Make sure a database with the same name hasn't been added since
+	exists, err := com.CheckDBExists(dbOwner, dbOwner, entry.Folder, entry.DBName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, fmt.Sprintf("'%s' already has a database called '%s'", dbOwner, entry.DBName),
+			http.StatusConflict)
+		return
+	}
+
+	// Make sure restoring the database won't take the owner over their database limit
+	err = com.CheckDatabaseQuota(dbOwner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	err = com.RestoreDatabase(dbOwner, dbID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, dbOwner, entry.Folder, entry.DBName, com.AUDIT_DATABASE_RESTORE, nil, nil)
+	log.Printf("Admin '%s' restored database '%s%s%s'\n", adminUser, dbOwner, entry.Folder, entry.DBName)
+	bounceBack(w, r)
+}
+
+// Sets the upload, storage, and database count limits for a user.
+func setQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := actionCheck(w, r)
+	if !ok {
+		return
+	}
+	userName, ok := formUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Empty values remove the override, so the configuration file settings apply again
+	var q com.UserQuota
+	var err error
+	q.MaxUploadSize, err = formLimit(r, "maxuploadsize")
+	if err != nil {
+		http.Error(w, "Invalid maximum upload size", http.StatusBadRequest)
+		return
+	}
+	q.MaxStorage, err = formLimit(r, "maxstorage")
+	if err != nil {
+		http.Error(w, "Invalid maximum storage", http.StatusBadRequest)
+		return
+	}
+	maxDBs, err := formLimit(r, "maxdatabases")
+	if err != nil {
+		http.Error(w, "Invalid maximum number of databases", http.StatusBadRequest)
+		return
+	}
+	q.MaxDatabases = int(maxDBs)
+	oldQ, err := com.UserQuotas(userName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = com.SetUserQuotas(userName, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "admin", adminUser, userName, "", "", com.AUDIT_USER_SET_QUOTA, oldQ, q)
+	log.Printf("Admin '%s' set the limits of user '%s' to: upload size %d MB, storage %d MB, databases %d\n",
+		adminUser, userName, q.MaxUploadSize, q.MaxStorage, q.MaxDatabases)
+	bounceBack(w, r)
+}
+
+// Renders the instance statistics page.
+func statsPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	adminUser, ok := adminCheck(w, r)
+	if !ok {
+		return
+	}
+	var pageData struct {
+		AdminUser string
+		Activity  com.ActivityStats
+		Stats     com.AdminStats
+		Title     string
+	}
+	pageData.AdminUser = adminUser
+	pageData.Title = "Statistics"
+
+	var err error
+	pageData.Stats, err = com.AdminStatistics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pageData.Activity, err = com.GetActivityStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, "statsPage", pageData)
+}
+
+// Renders the user management page.
+func usersPage(w http.ResponseWriter, r *http.Request) {
+	adminUser, ok := adminCheck(w, r)
+	if !ok {
+		return
+	}
+	var pageData struct {
+		AdminUser string
+		Search    string
+		Title     string
+		Users     []com.AdminUserEntry
+	}
+	pageData.AdminUser = adminUser
+	pageData.Title = "Users"
+	pageData.Search = r.FormValue("q")
+
+	var err error
+	pageData.Users, err = com.AdminUsers(pageData.Search)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, "usersPage", pageData)
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// Moves the review comment anchors of a merge request forward to the newest commit of its source branch.  Anchors are
+// flagged as outdated when the data they point to was changed (or removed) by the newer commits.
+func CarryForwardCommentAnchors(dbOwner string, dbFolder string, dbName string, discID int, mr MergeRequestEntry) error {
+	if len(mr.Commits) == 0 {
+		return nil
+	}
+	headID := mr.Commits[0].ID
+	anchors, err := commentAnchors(dbOwner, dbFolder, dbName, discID)
+	if err != nil {
+		return err
+	}
+
+	// The database files are opened as needed, and only once for each commit
+	dbs := make(map[string]*sqlite.Conn)
+	defer func() {
+		for _, sdb := range dbs {
+			sdb.Close()
+		}
+	}()
+	openCommit := func(commitID string) (*sqlite.Conn, error) {
+		if sdb, ok := dbs[commitID]; ok {
+			return sdb, nil
+		}
+		sdb, err := openAnchorCommit(mr, commitID)
+		if err != nil {
+			return nil, err
+		}
+		dbs[commitID] = sdb
+		return sdb, nil
+	}
+
+	for comID, a := range anchors {
+		if a.CommitID == headID {
+			continue
+		}
+		outdated := a.Outdated
+		if !outdated {
+			outdated, err = anchorChanged(a, openCommit, headID)
+			if err != nil {
+				return err
+			}
+		}
+		err = moveCommentAnchor(comID, headID, outdated)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks a review comment anchor points to a row (and column) which exists in one of the commits of a merge request.
+func CheckCommentAnchor(mr MergeRequestEntry, a CommentAnchor) error {
+	found := false
+	for _, c := range mr.Commits {
+		if c.ID == a.CommitID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("The commit isn't part of this merge request")
+	}
+	sdb, err := openAnchorCommit(mr, a.CommitID)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+	_, found, err = ReadSQLiteRow(sdb, a.Table, a.PrimaryKey, a.Column)
+	if err != nil {
+		return fmt.Errorf("Couldn't look up the row in table '%s': %v", a.Table, err)
+	}
+	if !found {
+		return fmt.Errorf("No row with that primary key in table '%s'", a.Table)
+	}
+	return nil
+}
+
+// Returns true if the data a review comment anchor points to is different in another commit.  If the commit the anchor
+// was made against can't be opened any more (eg the source branch was rewritten), the anchor is treated as changed.
+func anchorChanged(a CommentAnchor, openCommit func(string) (*sqlite.Conn, error), commitID string) (bool, error) {
+	oldDB, err := openCommit(a.CommitID)
+	if err != nil {
+		log.Printf("Couldn't open commit '%s' for a comment anchor, so marking it as outdated: %v\n", a.CommitID,
+			err)
+		return true, nil
+	}
+	newDB, err := openCommit(commitID)
+	if err != nil {
+		return false, err
+	}
+	oldVal, oldFound, err := ReadSQLiteRow(oldDB, a.Table, a.PrimaryKey, a.Column)
+	if err != nil {
+		return true, nil
+	}
+	newVal, newFound, err := ReadSQLiteRow(newDB, a.Table, a.PrimaryKey, a.Column)
+	if err != nil {
+		// The table or column has probably been removed
+		return true, nil
+	}
+	return oldFound != newFound || !reflect.DeepEqual(oldVal, newVal), nil
+}
+
+// Opens the database file for a commit in the source database of a merge request.
+func openAnchorCommit(mr MergeRequestEntry, commitID string) (*sqlite.Conn, error) {
+	bucket, id, _, err := MinioLocation(mr.SourceOwner, mr.SourceFolder, mr.SourceDBName, commitID, mr.SourceOwner)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("Database file for commit '%s' not found", commitID)
+	}
+	return OpenMinioObject(bucket, id)
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Works out whether a merge request satisfies the merge policy of its database.  Only reviews of the current head
+// commit from users with write access count, and the creator of a merge request can't approve it themselves.
+func CheckMergePolicy(dbOwner string, dbFolder string, dbName string, mr DiscussionEntry) (status MergePolicyStatus,
+	err error) {
+	policy, err := GetMergePolicy(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	status.Reviews, err = MergeRequestReviews(dbOwner, dbFolder, dbName, mr.ID)
+	if err != nil {
+		return
+	}
+	if status.Reviews == nil {
+		status.Reviews = []MergeRequestReview{}
+	}
+	status.Missing = []string{}
+
+	var headID string
+	if len(mr.MRDetails.Commits) > 0 {
+		headID = mr.MRDetails.Commits[0].ID
+	}
+
+	// Sort the reviews which count into approvals and change requests
+	approvers := make(map[string]bool)
+	var changesRequested []string
+	for _, rev := range status.Reviews {
+		if rev.State == REVIEW_DISMISSED || strings.ToLower(rev.User) == strings.ToLower(mr.Creator) {
+			continue
+		}
+		var canWrite bool
+		canWrite, err = CheckWriteAccess(rev.User, dbOwner, dbFolder, dbName)
+		if err != nil {
+			return
+		}
+		if !canWrite {
+			continue
+		}
+		switch rev.State {
+		case REVIEW_APPROVED:
+			if rev.CommitID == headID {
+				approvers[strings.ToLower(rev.User)] = true
+			}
+		case REVIEW_CHANGES_REQUESTED:
+			changesRequested = append(changesRequested, rev.User)
+		}
+	}
+	status.Approvals = len(approvers)
+
+	// Databases without a merge policy can be merged as before
+	if policy.MinApprovals == 0 && len(policy.Reviewers) == 0 && len(policy.Teams) == 0 {
+		status.Satisfied = true
+		return
+	}
+
+	if status.Approvals < policy.MinApprovals {
+		status.Missing = append(status.Missing, fmt.Sprintf("%d of %d required approvals given", status.Approvals,
+			policy.MinApprovals))
+	}
+	for _, reviewer := range policy.Reviewers {
+		if !approvers[strings.ToLower(reviewer)] {
+			status.Missing = append(status.Missing, fmt.Sprintf("Needs approval from '%s'", reviewer))
+		}
+	}
+	if len(policy.Teams) > 0 {
+		var teams []TeamEntry
+		teams, err = OrgTeams(dbOwner)
+		if err != nil {
+			return
+		}
+		for _, teamName := range policy.Teams {
+			approved := false
+			for _, t := range teams {
+				if strings.ToLower(t.Name) != strings.ToLower(teamName) {
+					continue
+				}
+				for _, member := range t.Members {
+					if approvers[strings.ToLower(member)] {
+						approved = true
+						break
+					}
+				}
+			}
+			if !approved {
+				status.Missing = append(status.Missing, fmt.Sprintf("Needs approval from a member of team '%s'",
+					teamName))
+			}
+		}
+	}
+	for _, user := range changesRequested {
+		status.Missing = append(status.Missing, fmt.Sprintf("'%s' has requested changes", user))
+	}
+	status.Satisfied = len(status.Missing) == 0
+	return
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// Records a state changing action in the audit log.  The before and after values hold the details of what changed,
+// are stored as JSON, and can be nil.  Failures are logged rather than returned, as by the time this is called the
+// action has already been carried out.
+func Audit(r *http.Request, serverSw string, actor string, owner string, folder string, dbName string,
+	action AuditAction, before interface{}, after interface{}) {
+	e := AuditEntry{
+		Action:   action,
+		Actor:    actor,
+		After:    after,
+		Before:   before,
+		DBName:   dbName,
+		Folder:   folder,
+		Owner:    owner,
+		ServerSw: serverSw,
+	}
+	if r != nil {
+		e.IPAddr = r.RemoteAddr
+	}
+	err := StoreAuditEntry(e)
+	if err != nil {
+		log.Printf("Couldn't record '%s' by '%s' in the audit log: %v\n", action, actor, err)
+	}
+}
+
+// Adds a value to the before and after details of an audit log entry, when it was changed.
+func AuditChange(before map[string]interface{}, after map[string]interface{}, name string, oldVal interface{},
+	newVal interface{}) {
+	if reflect.DeepEqual(oldVal, newVal) {
+		return
+	}
+	before[name] = oldVal
+	after[name] = newVal
+}
+
+// Retrieves the audit log entries for a database, most recent first.
+func DatabaseAuditEntries(dbOwner string, dbFolder string, dbName string, limit int, offset int) ([]AuditEntry,
+	error) {
+	dbID, err := databaseID(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return AuditEntries(AuditFilter{DBID: int64(dbID), Limit: limit, Offset: offset})
+}
@@ -0,0 +1,181 @@
+package common
+
+/*
+#cgo linux freebsd pkg-config: sqlite3
+#cgo !linux,!freebsd LDFLAGS: -lsqlite3
+
+#define SQLITE_ENABLE_SESSION 1
+#define SQLITE_ENABLE_PREUPDATE_HOOK 1
+#include <sqlite3.h>
+#include <stdlib.h>
+
+// Details of the first conflict found when applying a changeset
+typedef struct {
+	int conflict;
+	int op;
+	char *table;
+} changesetConflictInfo;
+
+// Conflict handler which records the details of the first conflict, then aborts the changeset application
+static int changesetConflict(void *pCtx, int eConflict, sqlite3_changeset_iter *pIter) {
+	changesetConflictInfo *info = (changesetConflictInfo *)pCtx;
+	const char *zTab = 0;
+	int nCol = 0, op = 0, indirect = 0;
+	if (info->conflict == 0) {
+		info->conflict = eConflict;
+		if (eConflict != SQLITE_CHANGESET_FOREIGN_KEY &&
+				sqlite3changeset_op(pIter, &zTab, &nCol, &op, &indirect) == SQLITE_OK) {
+			info->op = op;
+			info->table = sqlite3_mprintf("%s", zTab);
+		}
+	}
+	return SQLITE_CHANGESET_ABORT;
+}
+
+static int applyChangeset(sqlite3 *db, int nChangeset, void *pChangeset, changesetConflictInfo *info) {
+	return sqlite3changeset_apply(db, nChangeset, pChangeset, 0, changesetConflict, info);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+	"unsafe"
+)
+
+// Returned when a changeset can't be applied cleanly to a database
+type ChangesetConflictError struct {
+	Operation string
+	Table     string
+	Type      string
+}
+
+func (e ChangesetConflictError) Error() string {
+	if e.Table == "" {
+		return fmt.Sprintf("Changeset doesn't apply cleanly (%s conflict)", e.Type)
+	}
+	return fmt.Sprintf("Changeset doesn't apply cleanly (%s conflict for %s on table '%s')", e.Type, e.Operation,
+		e.Table)
+}
+
+// Applies a SQLite session extension changeset (or patchset) to a database file.  The changes are applied in a single
+// transaction, and if any of them conflict with the data in the database then nothing is changed and a
+// ChangesetConflictError is returned.
+func ApplyChangeset(dbFile string, changeset []byte) error {
+	if len(changeset) == 0 {
+		return errors.New("Changeset is empty")
+	}
+	if len(changeset) > math.MaxInt32 {
+		return errors.New("Changeset is too large")
+	}
+
+	// Open the database
+	cName := C.CString(dbFile)
+	defer C.free(unsafe.Pointer(cName))
+	var db *C.sqlite3
+	rc := C.sqlite3_open_v2(cName, &db, C.SQLITE_OPEN_READWRITE, nil)
+	if db != nil {
+		defer C.sqlite3_close(db)
+	}
+	if rc != C.SQLITE_OK {
+		log.Printf("Couldn't open database file '%s' to apply a changeset: %s\n", dbFile,
+			C.GoString(C.sqlite3_errstr(rc)))
+		return errors.New("Couldn't open the database to apply the changeset to")
+	}
+
+	// Apply the changeset
+	var info C.changesetConflictInfo
+	rc = C.applyChangeset(db, C.int(len(changeset)), unsafe.Pointer(&changeset[0]), &info)
+	if info.table != nil {
+		defer C.sqlite3_free(unsafe.Pointer(info.table))
+	}
+	if info.conflict != 0 {
+		e := ChangesetConflictError{Table: C.GoString(info.table)}
+		switch info.conflict {
+		case C.SQLITE_CHANGESET_DATA:
+			e.Type = "data"
+		case C.SQLITE_CHANGESET_NOTFOUND:
+			e.Type = "row not found"
+		case C.SQLITE_CHANGESET_CONFLICT:
+			e.Type = "primary key"
+		case C.SQLITE_CHANGESET_CONSTRAINT:
+			e.Type = "constraint"
+		case C.SQLITE_CHANGESET_FOREIGN_KEY:
+			e.Type = "foreign key"
+		}
+		switch info.op {
+		case C.SQLITE_INSERT:
+			e.Operation = "insert"
+		case C.SQLITE_UPDATE:
+			e.Operation = "update"
+		case C.SQLITE_DELETE:
+			e.Operation = "delete"
+		}
+		return e
+	}
+	if rc != C.SQLITE_OK {
+		return fmt.Errorf("Applying the changeset failed: %s", C.GoString(C.sqlite3_errstr(rc)))
+	}
+	return nil
+}
+
+// Applies a changeset (or patchset) to the database file of a branch head commit, then adds the result to the
+// system as a new commit on the branch.  The resulting database goes through the same checks as an uploaded one.
+func CommitChangeset(r *http.Request, loggedInUser string, dbOwner string, dbFolder string, dbName string,
+	branchName string, headCommit string, changeset []byte, commitMsg string, serverSw string, commitTime time.Time,
+	authorName string, authorEmail string, committerName string, committerEmail string) (numBytes int64,
+	newCommitID string, err error) {
+	// Retrieve the database file for the branch head
+	bkt, id, _, err := MinioLocation(dbOwner, dbFolder, dbName, headCommit, loggedInUser)
+	if err != nil {
+		return
+	}
+	if bkt == "" || id == "" {
+		err = fmt.Errorf("Couldn't find the database file for commit '%s'", headCommit)
+		return
+	}
+	cachedFile, err := CacheMinioObject(bkt, id)
+	if err != nil {
+		return
+	}
+
+	// Apply the changeset to a copy of the database, as the cached file is shared
+	tempDB, err := ioutil.TempFile(Conf.DiskCache.Directory, "dbhub-changeset-")
+	if err != nil {
+		log.Printf("Error creating temporary file for changeset. User: '%s', Database: '%s%s%s', Error: %v\n",
+			loggedInUser, dbOwner, dbFolder, dbName, err)
+		return
+	}
+	defer os.Remove(tempDB.Name())
+	defer tempDB.Close()
+	src, err := os.Open(cachedFile)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(tempDB, src)
+	src.Close()
+	if err != nil {
+		return
+	}
+	err = ApplyChangeset(tempDB.Name(), changeset)
+	if err != nil {
+		return
+	}
+	_, err = tempDB.Seek(0, 0)
+	if err != nil {
+		return
+	}
+
+	// Add the changed database as a new commit on the branch
+	return AddDatabase(r, loggedInUser, dbOwner, dbFolder, dbName, false, branchName, headCommit, false, false, "",
+		commitMsg, "", tempDB, serverSw, time.Now(), commitTime, authorName, authorEmail, committerName,
+		committerEmail, nil, "")
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// How long a SQL assertion is allowed to run before it's stopped and treated as failed
+const sqlAssertionTimeout = 30 * time.Second
+
+// Checks the status checks required by the protection settings of the destination branch of a merge request.  Returns
+// the status checks for the head commit of the MR, along with a description of each required check which hasn't passed.
+func CheckRequiredStatuses(dbOwner string, dbFolder string, dbName string, mr MergeRequestEntry) (
+	statuses []CommitStatus, missing []string, err error) {
+	statuses = []CommitStatus{}
+	missing = []string{}
+	if len(mr.Commits) == 0 {
+		return
+	}
+	statuses, err = CommitStatuses(dbOwner, dbFolder, dbName, mr.Commits[0].ID)
+	if err != nil {
+		return
+	}
+	protection, err := GetBranchProtection(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	for _, context := range protection[mr.DestBranch].RequiredChecks {
+		var state StatusCheckState
+		for _, s := range statuses {
+			if s.Context == context {
+				state = s.State
+				break
+			}
+		}
+		switch state {
+		case CHECK_SUCCESS:
+			continue
+		case "":
+			missing = append(missing, fmt.Sprintf("Check '%s' hasn't run", context))
+		default:
+			missing = append(missing, fmt.Sprintf("Check '%s' is %s", context, state))
+		}
+	}
+	return
+}
+
+// Returns the status check name used for the results of a SQL assertion.
+func SQLAssertionContext(name string) string {
+	return "sql/" + name
+}
+
+// Starts the SQL assertions of a database running against the head commit of a merge request.  Assertions which
+// already have a result for the commit are skipped, as are ones which are still running.  The others are marked as
+// pending, then run in the background.  Pending assertions which should have finished long ago are run again, so a
+// run which never finished (say, because the server restarted) doesn't block the merge request forever.
+func StartSQLAssertions(dbOwner string, dbFolder string, dbName string, mr MergeRequestEntry) error {
+	if len(mr.Commits) == 0 || mr.SourceDBID == 0 {
+		return nil
+	}
+	headID := mr.Commits[0].ID
+	assertions, err := GetSQLAssertions(dbOwner, dbFolder, dbName)
+	if err != nil || len(assertions) == 0 {
+		return err
+	}
+	statuses, err := CommitStatuses(dbOwner, dbFolder, dbName, headID)
+	if err != nil {
+		return err
+	}
+	// Every assertion in a run is allowed to take the full timeout, so pending ones are only given up on after that
+	stale := time.Now().Add(-time.Duration(len(assertions)+1) * sqlAssertionTimeout)
+	done := make(map[string]bool)
+	for _, s := range statuses {
+		if s.State == CHECK_PENDING && s.DateUpdated.Before(stale) {
+			continue
+		}
+		done[s.Context] = true
+	}
+	var pending []SQLAssertion
+	for _, a := range assertions {
+		if done[SQLAssertionContext(a.Name)] {
+			continue
+		}
+		err = StoreCommitStatus(dbOwner, dbFolder, dbName, "", CommitStatus{
+			CommitID:    headID,
+			Context:     SQLAssertionContext(a.Name),
+			Description: "Waiting to run",
+			State:       CHECK_PENDING,
+		})
+		if err != nil {
+			return err
+		}
+		pending = append(pending, a)
+	}
+	if len(pending) > 0 {
+		go runSQLAssertions(dbOwner, dbFolder, dbName, mr, headID, pending)
+	}
+	return nil
+}
+
+// Runs a SQL assertion against a database, returning the resulting state of the check and a short description of it.
+func checkSQLAssertion(sdb *sqlite.Conn, query string) (StatusCheckState, string) {
+	// Only single SELECT statements are allowed, so the assertions can't change (or attach) anything
+	q := strings.ToLower(strings.TrimSpace(query))
+	if !strings.HasPrefix(q, "select") && !strings.HasPrefix(q, "with") {
+		return CHECK_FAILURE, "Only SELECT queries can be used as assertions"
+	}
+	stmt, err := sdb.Prepare(query)
+	if err != nil {
+		return CHECK_FAILURE, fmt.Sprintf("Error in the assertion query: %v", err)
+	}
+	defer stmt.Finalize()
+	if !stmt.ReadOnly() || strings.TrimSpace(stmt.Tail()) != "" {
+		return CHECK_FAILURE, "Only single read only queries can be used as assertions"
+	}
+
+	// Stop the query if it runs for too long
+	timer := time.AfterFunc(sqlAssertionTimeout, sdb.Interrupt)
+	defer timer.Stop()
+
+	// Every row returned is a violation of the assertion
+	numRows := 0
+	for {
+		ok, err := stmt.Next()
+		if err != nil {
+			return CHECK_FAILURE, fmt.Sprintf("Error when running the assertion query: %v", err)
+		}
+		if !ok {
+			break
+		}
+		numRows++
+		if numRows == 1000 {
+			return CHECK_FAILURE, "At least 1000 rows break the assertion"
+		}
+	}
+	if numRows > 0 {
+		return CHECK_FAILURE, fmt.Sprintf("%d row(s) break the assertion", numRows)
+	}
+	return CHECK_SUCCESS, "The assertion holds"
+}
+
+// Opens the database file for a commit of the source database of a merge request, without allowing changes to it.
+func openCommitReadOnly(mr MergeRequestEntry, commitID string) (*sqlite.Conn, error) {
+	bucket, id, _, err := MinioLocation(mr.SourceOwner, mr.SourceFolder, mr.SourceDBName, commitID, mr.SourceOwner)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("Database file for commit '%s' not found", commitID)
+	}
+	fileName, err := CacheMinioObject(bucket, id)
+	if err != nil {
+		return nil, err
+	}
+	return sqlite.Open(fileName, sqlite.OpenReadOnly)
+}
+
+// Runs SQL assertions against the head commit of a merge request, saving the results as status checks.
+func runSQLAssertions(dbOwner string, dbFolder string, dbName string, mr MergeRequestEntry, headID string,
+	assertions []SQLAssertion) {
+	results := make(map[string]CommitStatus)
+	sdb, err := openCommitReadOnly(mr, headID)
+	if err != nil {
+		log.Printf("Couldn't open commit '%s' to run SQL assertions for database '%s%s%s': %v\n", headID, dbOwner,
+			dbFolder, dbName, err)
+		for _, a := range assertions {
+			results[a.Name] = CommitStatus{State: CHECK_FAILURE, Description: "The database couldn't be opened"}
+		}
+	} else {
+		defer sdb.Close()
+		for _, a := range assertions {
+			state, desc := checkSQLAssertion(sdb, a.Query)
+			results[a.Name] = CommitStatus{State: state, Description: desc}
+		}
+	}
+	for name, s := range results {
+		s.CommitID = headID
+		s.Context = SQLAssertionContext(name)
+		err = StoreCommitStatus(dbOwner, dbFolder, dbName, "", s)
+		if err != nil {
+			log.Printf("Couldn't save the result of SQL assertion '%s' for database '%s%s%s': %v\n", name, dbOwner,
+				dbFolder, dbName, err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -150,10 +151,100 @@ func ReadConfig() error {
 		Conf.Event.EmailQueueProcessingDelay = 10
 	}
 
-	// Warn if the email queue directory isn't set in the config file
-	if Conf.Event.EmailQueueDir == "" {
-		log.Printf("WARN: Email queue directory isn't set in the config file. Defaulting to /tmp.")
-		Conf.Event.EmailQueueDir = "/tmp"
+	// Check the outgoing email settings
+	switch Conf.Email.Mode {
+	case "":
+		log.Printf("WARN: Email delivery mode isn't set in the config file. Defaulting to smtp.")
+		Conf.Email.Mode = "smtp"
+	case "smtp", "mbox":
+	default:
+		return fmt.Errorf("Unknown email delivery mode '%s'.  It should be either smtp or mbox", Conf.Email.Mode)
+	}
+	switch Conf.Email.TLS {
+	case "":
+		Conf.Email.TLS = "none"
+	case "none", "starttls", "tls":
+	default:
+		return fmt.Errorf("Unknown email TLS mode '%s'.  It should be one of none, starttls, or tls", Conf.Email.TLS)
+	}
+	if Conf.Email.From == "" {
+		log.Printf("WARN: Email from address isn't set in the config file. Defaulting to updates@dbhub.io.")
+		Conf.Email.From = "updates@dbhub.io"
+	}
+	if Conf.Email.Mode == "smtp" && Conf.Email.Server == "" {
+		log.Printf("WARN: SMTP server isn't set in the config file. Defaulting to localhost.")
+		Conf.Email.Server = "localhost"
+	}
+
+	// The SMTP password would be sent in the clear without TLS, so only allow that when the relay server is local
+	if Conf.Email.Mode == "smtp" && Conf.Email.Username != "" && Conf.Email.TLS == "none" {
+		ip := net.ParseIP(Conf.Email.Server)
+		if Conf.Email.Server != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("An SMTP username is set, but TLS isn't.  Set the email TLS mode to starttls or tls, " +
+				"so the password isn't sent in the clear")
+		}
+	}
+	if Conf.Email.Port == 0 {
+		switch Conf.Email.TLS {
+		case "tls":
+			Conf.Email.Port = 465
+		case "starttls":
+			Conf.Email.Port = 587
+		default:
+			Conf.Email.Port = 25
+		}
+	}
+	if Conf.Email.Mode == "mbox" && Conf.Email.Mbox == "" {
+		log.Printf("WARN: Email mbox file isn't set in the config file. Defaulting to /tmp/dbhub.mbox.")
+		Conf.Email.Mbox = "/tmp/dbhub.mbox"
+	}
+	if Conf.Email.MaxAttempts == 0 {
+		log.Printf("WARN: Maximum email delivery attempts isn't set in the config file. Defaulting to 5.")
+		Conf.Email.MaxAttempts = 5
+	}
+	if Conf.Email.TemplateDir == "" {
+		Conf.Email.TemplateDir = filepath.Join(Conf.Web.BaseDir, "common", "email_templates")
+	}
+
+	// The key for signing unsubscribe links needs to be set, and kept apart from the other secrets.  A generated one
+	// wouldn't do, as links would stop working after a restart, and wouldn't work across servers
+	if Conf.Event.UnsubscribeKey == "" {
+		return fmt.Errorf("The key for signing unsubscribe links (unsubscribe_key) isn't set in the config file")
+	}
+
+	// Warn if the webhook delivery settings aren't set in the config file
+	if Conf.Event.WebhookMaxAttempts == 0 {
+		log.Printf("WARN: Maximum webhook delivery attempts isn't set in the config file. Defaulting to 8.")
+		Conf.Event.WebhookMaxAttempts = 8
+	}
+	if Conf.Event.WebhookTimeout == 0 {
+		log.Printf("WARN: Webhook delivery timeout isn't set in the config file. Defaulting to 10 seconds.")
+		Conf.Event.WebhookTimeout = 10
+	}
+
+	// Warn if the maximum database upload size isn't set in the config file
+	if Conf.Upload.MaxDatabaseSize == 0 {
+		log.Printf("WARN: Maximum database upload size isn't set in the config file. Defaulting to %d MB.",
+			MaxDatabaseSize)
+		Conf.Upload.MaxDatabaseSize = MaxDatabaseSize
+	}
+
+	// Warn if the chunk size for resumable uploads isn't set in the config file
+	if Conf.Upload.ChunkSize == 0 {
+		log.Printf("WARN: Upload chunk size isn't set in the config file. Defaulting to 8 MB.")
+		Conf.Upload.ChunkSize = 8
+	}
+
+	// Warn if the timeout for unfinished uploads isn't set in the config file
+	if Conf.Upload.SessionTimeout == 0 {
+		log.Printf("WARN: Upload session timeout isn't set in the config file. Defaulting to 24 hours.")
+		Conf.Upload.SessionTimeout = 24
+	}
+
+	// Warn if the retention period for deleted databases isn't set in the config file
+	if Conf.Trash.Retention == 0 {
+		log.Printf("WARN: Retention period for deleted databases isn't set in the config file. Defaulting to 30 days.")
+		Conf.Trash.Retention = 30
 	}
 
 	// Set the PostgreSQL configuration values
@@ -0,0 +1,194 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Database deltas are a list of the pages which differ between two versions of a SQLite database file.  The format is:
+//
+//   * [ magic "DBHDELT1" ] [ page size (uint32) ] [ new file size (uint64) ] [ new file sha256 (32 bytes) ]
+//   * Then for each changed page: [ page number (uint32, starting from 0) ] [ page data ]
+//   * Then the end marker: [ 0xFFFFFFFF (uint32) ]
+//
+// All integers are big endian.  Each page is "page size" bytes long, except for the last page of the file which may
+// be shorter.  To reconstruct the new file, take a copy of the old one, truncate (or extend) it to the new file size,
+// then write the changed pages at their offsets.  The sha256 of the result must match the one in the header.
+
+// The content type used when sending database deltas to clients
+const DeltaContentType = "application/x-dbhub-delta"
+
+// Magic bytes identifying a database delta
+const deltaMagic = "DBHDELT1"
+
+// Page number used to mark the end of the changed page list
+const deltaEndMarker = 0xFFFFFFFF
+
+// Page size used when the new file doesn't have a valid SQLite header
+const deltaDefaultPageSize = 4096
+
+// Applies a database delta to an old version of a database file, writing the reconstructed new version to the given
+// output file.  The sha256 of the reconstructed file is checked against the one in the delta.
+func ApplyDatabaseDelta(oldFile string, delta io.Reader, newFile *os.File) error {
+	// Read and check the delta header
+	var magic [8]byte
+	var pageSize uint32
+	var newSize uint64
+	var newSha [32]byte
+	if _, err := io.ReadFull(delta, magic[:]); err != nil {
+		return err
+	}
+	if string(magic[:]) != deltaMagic {
+		return errors.New("Not a database delta")
+	}
+	if err := binary.Read(delta, binary.BigEndian, &pageSize); err != nil {
+		return err
+	}
+	if err := binary.Read(delta, binary.BigEndian, &newSize); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(delta, newSha[:]); err != nil {
+		return err
+	}
+	if pageSize == 0 {
+		return errors.New("Invalid page size in database delta")
+	}
+
+	// Start with a copy of the old file, truncated or extended to the size of the new one
+	old, err := os.Open(oldFile)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	if _, err = io.Copy(newFile, io.LimitReader(old, int64(newSize))); err != nil {
+		return err
+	}
+	if err = newFile.Truncate(int64(newSize)); err != nil {
+		return err
+	}
+
+	// Write the changed pages into place
+	page := make([]byte, pageSize)
+	for {
+		var pageNum uint32
+		if err = binary.Read(delta, binary.BigEndian, &pageNum); err != nil {
+			return err
+		}
+		if pageNum == deltaEndMarker {
+			break
+		}
+		off := int64(pageNum) * int64(pageSize)
+		if off >= int64(newSize) {
+			return fmt.Errorf("Page %d in database delta is past the end of the file", pageNum)
+		}
+		n := int64(pageSize)
+		if off+n > int64(newSize) {
+			n = int64(newSize) - off
+		}
+		if _, err = io.ReadFull(delta, page[:n]); err != nil {
+			return err
+		}
+		if _, err = newFile.WriteAt(page[:n], off); err != nil {
+			return err
+		}
+	}
+
+	// Make sure the reconstructed file is correct
+	if _, err = newFile.Seek(0, 0); err != nil {
+		return err
+	}
+	s := sha256.New()
+	if _, err = io.Copy(s, newFile); err != nil {
+		return err
+	}
+	if !bytes.Equal(s.Sum(nil), newSha[:]) {
+		return errors.New("Checksum of the reconstructed database doesn't match")
+	}
+	return nil
+}
+
+// Writes a delta containing the pages which differ between two versions of a SQLite database file.  Returns the
+// number of changed pages.
+func CreateDatabaseDelta(oldFile string, newFile string, delta io.Writer) (changedPages int, err error) {
+	oldDB, err := os.Open(oldFile)
+	if err != nil {
+		return
+	}
+	defer oldDB.Close()
+	newDB, err := os.Open(newFile)
+	if err != nil {
+		return
+	}
+	defer newDB.Close()
+
+	// Determine the size and sha256 of the new file
+	s := sha256.New()
+	newSize, err := io.Copy(s, newDB)
+	if err != nil {
+		return
+	}
+
+	// Retrieve the page size from the SQLite header of the new file.  It's stored as a big endian uint16 at offset
+	// 16, with the value 1 meaning 65536
+	pageSize := uint32(deltaDefaultPageSize)
+	hdr := make([]byte, 2)
+	if _, err = newDB.ReadAt(hdr, 16); err == nil {
+		switch p := binary.BigEndian.Uint16(hdr); {
+		case p == 1:
+			pageSize = 65536
+		case p >= 512 && p&(p-1) == 0:
+			pageSize = uint32(p)
+		}
+	}
+
+	// Write the delta header
+	if _, err = io.WriteString(delta, deltaMagic); err != nil {
+		return
+	}
+	if err = binary.Write(delta, binary.BigEndian, pageSize); err != nil {
+		return
+	}
+	if err = binary.Write(delta, binary.BigEndian, uint64(newSize)); err != nil {
+		return
+	}
+	if _, err = delta.Write(s.Sum(nil)); err != nil {
+		return
+	}
+
+	// Compare the files page by page, writing out the pages which differ
+	if _, err = newDB.Seek(0, 0); err != nil {
+		return
+	}
+	oldPage := make([]byte, pageSize)
+	newPage := make([]byte, pageSize)
+	for pageNum := uint32(0); int64(pageNum)*int64(pageSize) < newSize; pageNum++ {
+		var n, o int
+		n, err = io.ReadFull(newDB, newPage)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return
+		}
+		o, err = oldDB.ReadAt(oldPage, int64(pageNum)*int64(pageSize))
+		if err != nil && err != io.EOF {
+			return
+		}
+		if o >= n && bytes.Equal(oldPage[:n], newPage[:n]) {
+			continue
+		}
+		if err = binary.Write(delta, binary.BigEndian, pageNum); err != nil {
+			return
+		}
+		if _, err = delta.Write(newPage[:n]); err != nil {
+			return
+		}
+		changedPages++
+	}
+
+	// Write the end marker
+	err = binary.Write(delta, binary.BigEndian, uint32(deltaEndMarker))
+	return
+}
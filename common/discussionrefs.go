@@ -0,0 +1,105 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Matches plain #123 references to discussions and merge requests.  As with mentions, the character before the # is
+// captured too, so things like URL fragments and HTML entities aren't mistaken for references
+var discussionRefRegex = regexp.MustCompile(`(^|[^A-Za-z0-9_&#/])#([0-9]+)\b`)
+
+// Matches commit message phrases like "Fixes #12" or "closes #12", which close the referenced discussion
+var closingRefRegex = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)\s+#([0-9]+)\b`)
+
+// Returns the IDs of the discussions a commit message says it closes, without duplicates.
+func ClosingReferences(msg string) (ids []int) {
+	seen := make(map[int]bool)
+	for _, m := range closingRefRegex.FindAllStringSubmatch(msg, -1) {
+		id, err := strconv.Atoi(m[1])
+		if err != nil || id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return
+}
+
+// Closes the discussions referenced by closing phrases in the messages of commits which have landed on the default
+// branch of a database.  Each closed discussion gets a comment pointing back to the commit which closed it.
+func CloseDiscussionsFromCommits(r *http.Request, serverSw string, pusher string, dbOwner string, dbFolder string,
+	dbName string, commits []CommitEntry) error {
+	for _, c := range commits {
+		for _, id := range ClosingReferences(c.Message) {
+			// Only open discussions are closed.  Merge requests are left alone, as they're closed by merging them
+			disc, err := Discussions(dbOwner, dbFolder, dbName, DISCUSSION, id)
+			if err != nil {
+				return err
+			}
+			if len(disc) != 1 || !disc[0].Open {
+				continue
+			}
+
+			comText := fmt.Sprintf("Closed by commit [%.8s](/%s%s%s?commit=%s)", c.ID, url.PathEscape(dbOwner),
+				dbFolder, url.PathEscape(dbName), url.QueryEscape(c.ID))
+			err = storeComment(dbOwner, dbFolder, dbName, pusher, id, comText, true, OPEN, nil, true)
+			if err != nil {
+				return err
+			}
+			Audit(r, serverSw, pusher, dbOwner, dbFolder, dbName, AUDIT_DISCUSSION_CLOSE, nil,
+				map[string]interface{}{"discussion": id, "commit": c.ID})
+		}
+	}
+	return nil
+}
+
+// Turns the plain #123 references in some markdown text into links to the matching discussion or merge request of
+// a database.  References to IDs which don't exist, and ones inside code, are left as is.
+func LinkDiscussionRefs(dbOwner string, dbFolder string, dbName string, text string,
+	types map[int]DiscussionType) string {
+	if len(types) == 0 {
+		return text
+	}
+	return mapMentionText(text, func(s string) string {
+		return discussionRefRegex.ReplaceAllStringFunc(s, func(m string) string {
+			sub := discussionRefRegex.FindStringSubmatch(m)
+			id, err := strconv.Atoi(sub[2])
+			if err != nil {
+				return m
+			}
+			discType, ok := types[id]
+			if !ok {
+				return m
+			}
+			page := "discuss"
+			if discType == MERGE_REQUEST {
+				page = "merge"
+			}
+			return fmt.Sprintf("%s[#%d](/%s/%s%s%s?id=%d)", sub[1], id, page, url.PathEscape(dbOwner), dbFolder,
+				url.PathEscape(dbName), id)
+		})
+	})
+}
+
+// Renders the markdown text of a discussion, comment or commit message of a database to HTML, turning both @mentions
+// and #123 references into links.
+func RenderDatabaseMarkdown(dbOwner string, dbFolder string, dbName string, msg string,
+	types map[int]DiscussionType) string {
+	return RenderDiscussionMarkdown(LinkDiscussionRefs(dbOwner, dbFolder, dbName, msg, types))
+}
+
+// Renders the markdown texts of several discussions or comments of a database to HTML, in the same way as
+// RenderDatabaseMarkdown.  The mentioned users are looked up for all of the texts at once, rather than one at a time.
+func renderDatabaseMarkdownList(dbOwner string, dbFolder string, dbName string, texts []string,
+	types map[int]DiscussionType) []string {
+	users := mentionedUsers(texts...)
+	rendered := make([]string, len(texts))
+	for i, t := range texts {
+		rendered[i] = renderMarkdown(LinkDiscussionRefs(dbOwner, dbFolder, dbName, t, types), users)
+	}
+	return rendered
+}
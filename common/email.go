@@ -0,0 +1,320 @@
+package common
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// The most queued emails claimed by a server at once
+	emailBatchSize = 20
+
+	// How long sending a single email through the SMTP relay server is allowed to take
+	smtpTimeout = time.Minute
+
+	// How long claimed emails are held for.  It's long enough to send the whole batch, even if every email times out
+	emailLease = emailBatchSize*smtpTimeout + time.Minute
+)
+
+var (
+	// The text and HTML email templates, loaded on first use
+	emailHTMLTmpl *htmltemplate.Template
+	emailTextTmpl *template.Template
+	emailTmplErr  error
+	emailTmplOnce sync.Once
+
+	// Stops emails written to the mbox file from being interleaved
+	mboxLock sync.Mutex
+)
+
+// The information available to the templates for a single event email
+type emailEventData struct {
+	Database    string
+	Details     EventDetails
+	Event       string
+	Server      string
+	Unsubscribe string
+}
+
+// An email waiting in the queue
+type queuedEmail struct {
+	Attempts    int
+	Body        string
+	HTMLBody    string
+	ID          int64
+	Subject     string
+	To          string
+	Unsubscribe string
+}
+
+// Sends the queued emails, either through the SMTP relay server or into the mbox file.  Failed emails are retried with
+// exponential backoff, until the maximum number of attempts is reached.
+func SendEmails() {
+	log.Printf("Email sending loop started, using %s delivery.  %d second refresh.", Conf.Email.Mode,
+		Conf.Event.EmailQueueProcessingDelay)
+	for {
+		emails, err := claimEmails(emailLease)
+		if err == nil {
+			for _, e := range emails {
+				deliverEmail(e)
+			}
+		}
+
+		// Pause before running the loop again
+		time.Sleep(Conf.Event.EmailQueueProcessingDelay * time.Second)
+	}
+}
+
+// Builds the raw message for an email, including both the text and HTML versions if there's a HTML body.
+func buildEmailMessage(e queuedEmail) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	host := strings.Split(Conf.Web.ServerName, ":")[0]
+	header("From", Conf.Email.From)
+	header("To", e.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%d.%d@%s>", e.ID, time.Now().UnixNano(), host))
+	header("MIME-Version", "1.0")
+
+	// Let mail clients offer one-click unsubscribing (RFC 8058)
+	if e.Unsubscribe != "" {
+		header("List-Unsubscribe", fmt.Sprintf("<%s>", e.Unsubscribe))
+		header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	// Plain text only emails don't need a multipart body
+	if e.HTMLBody == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		err := writeQuotedPrintable(&buf, e.Body)
+		return buf.Bytes(), err
+	}
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, p := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", e.Body},
+		{"text/html; charset=utf-8", e.HTMLBody},
+	} {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = writeQuotedPrintable(part, p.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := mw.Close()
+	return buf.Bytes(), err
+}
+
+// Makes one delivery attempt for a queued email, and records the result.
+func deliverEmail(e queuedEmail) {
+	attempts := e.Attempts + 1
+	msg, err := buildEmailMessage(e)
+	if err == nil {
+		if Conf.Email.Mode == "mbox" {
+			err = writeMbox(msg)
+		} else {
+			err = sendSMTP(e.To, msg)
+		}
+	}
+	if err == nil {
+		storeEmailAttempt(e.ID, true, attempts, time.Now(), "")
+		return
+	}
+
+	// Wait 1, 2, 4, 8, ... minutes between attempts
+	if attempts >= Conf.Email.MaxAttempts {
+		log.Printf("Giving up on email '%d' to '%s' after %d attempts: %v\n", e.ID, e.To, attempts, err)
+	}
+	nextAttempt := time.Now().Add(time.Duration(1<<uint(attempts-1)) * time.Minute)
+	storeEmailAttempt(e.ID, false, attempts, nextAttempt, err.Error())
+}
+
+// Loads the email templates.  The text templates are used for the subject lines and plain text bodies, and the HTML
+// templates for the HTML bodies.  The HTML templates can use the text ones too, which are escaped when included.
+func loadEmailTemplates() error {
+	emailTmplOnce.Do(func() {
+		emailTextTmpl, emailTmplErr = template.ParseGlob(filepath.Join(Conf.Email.TemplateDir, "*.txt"))
+		if emailTmplErr != nil {
+			log.Printf("Loading the text email templates failed: %v\n", emailTmplErr)
+			return
+		}
+		emailHTMLTmpl, emailTmplErr = htmltemplate.ParseGlob(filepath.Join(Conf.Email.TemplateDir, "*.txt"))
+		if emailTmplErr == nil {
+			emailHTMLTmpl, emailTmplErr = emailHTMLTmpl.ParseGlob(filepath.Join(Conf.Email.TemplateDir, "*.html"))
+		}
+		if emailTmplErr != nil {
+			log.Printf("Loading the HTML email templates failed: %v\n", emailTmplErr)
+		}
+	})
+	return emailTmplErr
+}
+
+// Renders the summary (used as the subject line and in digests), and the text and HTML bodies of an email about an
+// event.
+func renderEventEmail(details EventDetails, unsubURL string) (summary string, text string, html string, err error) {
+	data := emailEventData{
+		Database:    fmt.Sprintf("%s%s%s", details.Owner, details.Folder, details.DBName),
+		Details:     details,
+		Event:       EventNames[details.Type],
+		Server:      Conf.Web.ServerName,
+		Unsubscribe: unsubURL,
+	}
+	return renderEmail("event", data)
+}
+
+// Renders the "<name>Subject", "<name>Text", and "<name>HTML" templates with the given data.
+func renderEmail(name string, data interface{}) (subject string, text string, html string, err error) {
+	err = loadEmailTemplates()
+	if err != nil {
+		return
+	}
+	var b bytes.Buffer
+	err = emailTextTmpl.ExecuteTemplate(&b, name+"Subject", data)
+	if err != nil {
+		return
+	}
+	subject = strings.TrimSpace(b.String())
+	b.Reset()
+	err = emailTextTmpl.ExecuteTemplate(&b, name+"Text", data)
+	if err != nil {
+		return
+	}
+	text = strings.TrimSpace(b.String())
+	b.Reset()
+	err = emailHTMLTmpl.ExecuteTemplate(&b, name+"HTML", data)
+	if err != nil {
+		return
+	}
+	html = b.String()
+	return
+}
+
+// Sends an email through the configured SMTP relay server.
+func sendSMTP(to string, msg []byte) error {
+	addr := net.JoinHostPort(Conf.Email.Server, strconv.Itoa(Conf.Email.Port))
+	tlsConfig := &tls.Config{ServerName: Conf.Email.Server}
+	var conn net.Conn
+	var err error
+	if Conf.Email.TLS == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, Conf.Email.Server)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if Conf.Email.TLS == "starttls" {
+		err = c.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if Conf.Email.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", Conf.Email.Username, Conf.Email.Password, Conf.Email.Server))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The envelope needs the bare addresses
+	from, err := mail.ParseAddress(Conf.Email.From)
+	if err != nil {
+		return err
+	}
+	err = c.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(to)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Appends an email to the mbox file, for development and testing.
+func writeMbox(msg []byte) error {
+	mboxLock.Lock()
+	defer mboxLock.Unlock()
+	f, err := os.OpenFile(Conf.Email.Mbox, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Lines in the message starting with "From " would be mistaken for the start of a new message, so they're quoted
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From MAILER-DAEMON %s\n", time.Now().UTC().Format(time.ANSIC))
+	for _, line := range strings.Split(strings.Replace(string(msg), "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			b.WriteString(">")
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	_, err = f.Write(b.Bytes())
+	return err
+}
+
+// Writes text using quoted-printable encoding.
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(text))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
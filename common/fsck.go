@@ -0,0 +1,297 @@
+package common
+
+import (
+	"fmt"
+	"log"
+)
+
+// Checks the commit list, branch heads, tags, and releases of a database for consistency.  If repair is true, then
+// any problems which can be safely fixed are fixed, and marked as such in the returned problem list.
+func Fsck(dbOwner string, dbFolder string, dbName string, repair bool) (problems []FsckProblem, err error) {
+	// Retrieve the metadata for the database
+	commitList, err := GetCommitList(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	branches, err := GetBranches(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	tags, err := GetTags(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	rels, err := GetReleases(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+
+	// Check each commit in the commit list
+	historyBroken := false
+	checkedFiles := make(map[string]bool)
+	for id, c := range commitList {
+		// Recompute the tree ID
+		if treeID := CreateDBTreeID(c.Tree.Entries); treeID != c.Tree.ID {
+			problems = append(problems, FsckProblem{
+				Commit:  id,
+				Details: fmt.Sprintf("Tree ID is '%s', but its entries hash to '%s'", c.Tree.ID, treeID),
+				Type:    FSCK_BAD_TREE_ID,
+			})
+		}
+
+		// Recompute the commit ID
+		if commitID := CreateCommitID(c); commitID != c.ID || id != c.ID {
+			problems = append(problems, FsckProblem{
+				Commit: id,
+				Details: fmt.Sprintf("Commit is stored under '%s' with ID '%s', but its contents hash to '%s'", id,
+					c.ID, commitID),
+				Type: FSCK_BAD_COMMIT_ID,
+			})
+		}
+
+		// Make sure the parent commits are present
+		parents := c.OtherParents
+		if c.Parent != "" {
+			parents = append([]string{c.Parent}, parents...)
+		}
+		for _, p := range parents {
+			if _, ok := commitList[p]; !ok {
+				historyBroken = true
+				problems = append(problems, FsckProblem{
+					Commit:  id,
+					Details: fmt.Sprintf("Parent commit '%s' isn't in the commit list", p),
+					Type:    FSCK_MISSING_PARENT,
+				})
+			}
+		}
+
+		// Make sure the database files for the commit are present
+		for _, e := range c.Tree.Entries {
+			if e.EntryType != DATABASE || checkedFiles[e.Sha256] {
+				continue
+			}
+			checkedFiles[e.Sha256] = true
+			if len(e.Sha256) <= MinioFolderChars {
+				problems = append(problems, FsckProblem{
+					Commit:  id,
+					Details: fmt.Sprintf("Database file '%s' has an invalid sha256 '%s'", e.Name, e.Sha256),
+					Name:    e.Name,
+					Type:    FSCK_MISSING_OBJECT,
+				})
+				continue
+			}
+			var found bool
+			found, err = MinioObjectExists(e.Sha256[:MinioFolderChars], e.Sha256[MinioFolderChars:])
+			if err != nil {
+				return
+			}
+			if !found {
+				problems = append(problems, FsckProblem{
+					Commit:  id,
+					Details: fmt.Sprintf("Database file '%s' (sha256 '%s') isn't in Minio", e.Name, e.Sha256),
+					Name:    e.Name,
+					Type:    FSCK_MISSING_OBJECT,
+				})
+				continue
+			}
+			found, err = CheckDatabaseFileExists(e.Sha256)
+			if err != nil {
+				return
+			}
+			if !found {
+				p := FsckProblem{
+					Commit: id,
+					Details: fmt.Sprintf("Database file '%s' (sha256 '%s') has no database_files entry", e.Name,
+						e.Sha256),
+					Name: e.Name,
+					Type: FSCK_MISSING_FILE_ENTRY,
+				}
+				if repair {
+					err = StoreDatabaseFileEntry(e.Sha256)
+					if err != nil {
+						return
+					}
+					p.Repaired = true
+				}
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	// Check each branch head, and the commit count for the branch
+	branchesChanged := false
+	reachable := make(map[string]bool)
+	for bName, b := range branches {
+		c, ok := commitList[b.Commit]
+		if !ok {
+			historyBroken = true
+			problems = append(problems, FsckProblem{
+				Commit:  b.Commit,
+				Details: fmt.Sprintf("Head commit of branch '%s' isn't in the commit list", bName),
+				Name:    bName,
+				Type:    FSCK_DANGLING_BRANCH,
+			})
+			continue
+		}
+
+		// Count the commits in the branch, the same way it's done when a commit is added
+		commitCount := 1
+		for c.Parent != "" {
+			c, ok = commitList[c.Parent]
+			if !ok {
+				// Missing parents have already been reported above
+				break
+			}
+			commitCount++
+		}
+		if ok && commitCount != b.CommitCount {
+			p := FsckProblem{
+				Commit: b.Commit,
+				Details: fmt.Sprintf("Branch '%s' has a commit count of %d, but contains %d commits", bName,
+					b.CommitCount, commitCount),
+				Name: bName,
+				Type: FSCK_WRONG_BRANCH_COUNT,
+			}
+			if repair {
+				b.CommitCount = commitCount
+				branches[bName] = b
+				branchesChanged = true
+				p.Repaired = true
+			}
+			problems = append(problems, p)
+		}
+
+		// Mark everything in the branch history as reachable, including the commits brought in by merges
+		toVisit := []string{b.Commit}
+		for len(toVisit) > 0 {
+			id := toVisit[len(toVisit)-1]
+			toVisit = toVisit[:len(toVisit)-1]
+			if reachable[id] {
+				continue
+			}
+			c, ok := commitList[id]
+			if !ok {
+				continue
+			}
+			reachable[id] = true
+			if c.Parent != "" {
+				toVisit = append(toVisit, c.Parent)
+			}
+			toVisit = append(toVisit, c.OtherParents...)
+		}
+	}
+	if branchesChanged {
+		err = StoreBranches(dbOwner, dbFolder, dbName, branches)
+		if err != nil {
+			return
+		}
+	}
+
+	// Check the tags and releases point to commits which exist
+	tagsChanged := false
+	for tName, t := range tags {
+		if _, ok := commitList[t.Commit]; ok {
+			continue
+		}
+		p := FsckProblem{
+			Commit:  t.Commit,
+			Details: fmt.Sprintf("Tag '%s' points to a commit which isn't in the commit list", tName),
+			Name:    tName,
+			Type:    FSCK_DANGLING_TAG,
+		}
+		if repair {
+			delete(tags, tName)
+			tagsChanged = true
+			p.Repaired = true
+		}
+		problems = append(problems, p)
+	}
+	if tagsChanged {
+		err = StoreTags(dbOwner, dbFolder, dbName, tags)
+		if err != nil {
+			return
+		}
+	}
+	relsChanged := false
+	for rName, r := range rels {
+		if _, ok := commitList[r.Commit]; ok {
+			continue
+		}
+		p := FsckProblem{
+			Commit:  r.Commit,
+			Details: fmt.Sprintf("Release '%s' points to a commit which isn't in the commit list", rName),
+			Name:    rName,
+			Type:    FSCK_DANGLING_RELEASE,
+		}
+		if repair {
+			delete(rels, rName)
+			relsChanged = true
+			p.Repaired = true
+		}
+		problems = append(problems, p)
+	}
+	if relsChanged {
+		err = StoreReleases(dbOwner, dbFolder, dbName, rels)
+		if err != nil {
+			return
+		}
+	}
+
+	// Look for commits which can't be reached from any branch.  Tagged or released commits are left alone along with
+	// their history, as are all commits when the history is broken, as in those cases we can't be sure what's safe to
+	// remove
+	keep := make(map[string]bool)
+	var toKeep []string
+	for _, t := range tags {
+		toKeep = append(toKeep, t.Commit)
+	}
+	for _, r := range rels {
+		toKeep = append(toKeep, r.Commit)
+	}
+	for len(toKeep) > 0 {
+		id := toKeep[len(toKeep)-1]
+		toKeep = toKeep[:len(toKeep)-1]
+		if id == "" || keep[id] {
+			continue
+		}
+		keep[id] = true
+		if c, ok := commitList[id]; ok {
+			toKeep = append(toKeep, c.Parent)
+			toKeep = append(toKeep, c.OtherParents...)
+		}
+	}
+	commitsChanged := false
+	for id := range commitList {
+		if reachable[id] {
+			continue
+		}
+		p := FsckProblem{
+			Commit:  id,
+			Details: "Commit isn't reachable from any branch",
+			Type:    FSCK_UNREACHABLE_COMMIT,
+		}
+		if repair && !historyBroken && !keep[id] {
+			delete(commitList, id)
+			commitsChanged = true
+			p.Repaired = true
+		}
+		problems = append(problems, p)
+	}
+	if commitsChanged {
+		err = StoreCommits(dbOwner, dbFolder, dbName, commitList)
+		if err != nil {
+			return
+		}
+	}
+
+	// If anything was repaired, make sure the cached metadata for the database gets refreshed
+	if branchesChanged || tagsChanged || relsChanged || commitsChanged {
+		err = InvalidateCacheEntry(dbOwner, dbOwner, dbFolder, dbName, "")
+		if err != nil {
+			log.Printf("Error when invalidating memcache entries for '%s%s%s' after repair: %v\n", dbOwner,
+				dbFolder, dbName, err)
+			return
+		}
+	}
+	return
+}
@@ -6,6 +6,14 @@ import (
 	"path/filepath"
 )
 
+// Returned when a database can't be given to a new owner, because the new owner already has a different licence
+// with the same name as one used by the database
+type LicenceClashError string
+
+func (e LicenceClashError) Error() string {
+	return string(e)
+}
+
 // Add the default licences
 func AddDefaultLicences() (err error) {
 	// The default licences to load into the system
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// The PostgreSQL notification channels we use.  Live updates are passed on to the open pages of the webUI, while new
+// events wake up the status update processing loop
+const (
+	eventsChannel = "dbhub_events"
+	liveChannel   = "dbhub_live"
+)
+
+var (
+	// Signalled when new events are waiting to be processed
+	eventsReady = make(chan struct{}, 1)
+
+	// The receivers of live updates on this server
+	liveMutex       sync.Mutex
+	liveSubscribers = make(map[chan LiveUpdate]struct{})
+)
+
+// Listens for PostgreSQL notifications, passing live updates on to their receivers on this server, and waking up the
+// status update processing loop when new events arrive.  If the connection to PostgreSQL is lost, it's reopened after
+// a short wait.
+func LiveUpdatesLoop() {
+	// Ensure a warning message is displayed on the console if the live update loop exits
+	defer func() {
+		log.Printf("WARN: Live update loop exited")
+	}()
+
+	for {
+		err := listenForUpdates()
+		log.Printf("Listening for live updates failed, will retry: %v\n", err)
+
+		// Notifications sent while we weren't listening are lost, so make sure any waiting events are processed
+		wakeEventsLoop()
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Sends a live update to the open pages of the webUI, across all of our servers.
+func PublishLiveUpdate(u LiveUpdate) error {
+	payload, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	_, err = pdb.Exec(`SELECT pg_notify($1, $2)`, liveChannel, string(payload))
+	if err != nil {
+		log.Printf("Sending live update of type '%s' failed: %v\n", u.Type, err)
+	}
+	return err
+}
+
+// Registers a new receiver of live updates.  The returned function stops the updates, and needs to be called once the
+// receiver is finished with them.
+func SubscribeLiveUpdates() (<-chan LiveUpdate, func()) {
+	ch := make(chan LiveUpdate, 16)
+	liveMutex.Lock()
+	liveSubscribers[ch] = struct{}{}
+	liveMutex.Unlock()
+	return ch, func() {
+		liveMutex.Lock()
+		delete(liveSubscribers, ch)
+		liveMutex.Unlock()
+	}
+}
+
+// Opens a dedicated connection to PostgreSQL, and hands out the notifications arriving on it until something goes
+// wrong.  The connection isn't taken from the pool, as it's kept for as long as the server runs.
+func listenForUpdates() error {
+	conn, err := pgx.Connect(*pgConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, channel := range []string{eventsChannel, liveChannel} {
+		err = conn.Listen(channel)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Listening for live updates from PostgreSQL.\n")
+
+	for {
+		n, err := conn.WaitForNotification(time.Minute)
+		if err == pgx.ErrNotificationTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch n.Channel {
+		case eventsChannel:
+			wakeEventsLoop()
+		case liveChannel:
+			var u LiveUpdate
+			err = json.Unmarshal([]byte(n.Payload), &u)
+			if err != nil {
+				log.Printf("Couldn't decode live update '%s': %v\n", n.Payload, err)
+				continue
+			}
+			liveMutex.Lock()
+			for ch := range liveSubscribers {
+				// Slow receivers miss the update, rather than holding up everyone else
+				select {
+				case ch <- u:
+				default:
+				}
+			}
+			liveMutex.Unlock()
+		}
+	}
+}
+
+// Tells the status update processing loop about new events, by way of every server listening on the events channel.
+func notifyNewEvents() {
+	_, err := pdb.Exec(`SELECT pg_notify($1, '')`, eventsChannel)
+	if err != nil {
+		log.Printf("Sending the new events notification failed: %v\n", err)
+	}
+}
+
+// Waits until new events are waiting to be processed, or the timeout has passed.  The timeout is kept as a fallback,
+// in case a notification is missed.
+func waitForEvents(timeout time.Duration) {
+	select {
+	case <-eventsReady:
+	case <-time.After(timeout):
+	}
+}
+
+// Wakes up the status update processing loop, unless it's already due to run.
+func wakeEventsLoop() {
+	select {
+	case eventsReady <- struct{}{}:
+	default:
+	}
+}
@@ -57,6 +57,15 @@ func ConnectCache() error {
 	return nil
 }
 
+// Removes everything from the cache.
+func FlushCache() error {
+	err := memCache.FlushAll()
+	if err != nil {
+		log.Printf("Flushing the cache failed: %v\n", err)
+	}
+	return err
+}
+
 // Retrieves cached data from Memcached
 func GetCachedData(cacheKey string, cacheData interface{}) (bool, error) {
 	cacheItem, err := memCache.Get(cacheKey)
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	gfm "github.com/sqlitebrowser/github_flavored_markdown"
+)
+
+// Matches @username mentions.  The character before the @ is captured too, so things like email addresses aren't
+// mistaken for mentions.  Trailing dots and dashes are left out, as they're more likely to be punctuation
+var mentionRegex = regexp.MustCompile(`(^|[^A-Za-z0-9_@./-])@([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9_])?)`)
+
+// Returns the (lower case) names of the users mentioned in a piece of markdown text, without duplicates.  Mentions
+// inside code blocks and code spans are ignored.
+func ParseMentions(text string) (names []string) {
+	seen := make(map[string]bool)
+	mapMentionText(text, func(s string) string {
+		for _, m := range mentionRegex.FindAllStringSubmatch(s, -1) {
+			n := strings.ToLower(m[2])
+			if !seen[n] && ValidateUser(n) == nil {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+		return s
+	})
+	return
+}
+
+// Renders the markdown text of a discussion or comment to HTML, turning the @mentions of existing users into links
+// to their profile page.
+func RenderDiscussionMarkdown(text string) string {
+	return renderMarkdown(text, mentionedUsers(text))
+}
+
+// Runs a function over the parts of some markdown text which aren't code, returning the updated text.
+func mapMentionText(text string, fn func(string) string) string {
+	lines := strings.Split(text, "\n")
+	inFence := false
+	for i, line := range lines {
+		// Skip fenced and indented code blocks
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence || strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		// Code spans are the odd numbered pieces between backticks
+		pieces := strings.Split(line, "`")
+		for j := 0; j < len(pieces); j += 2 {
+			pieces[j] = fn(pieces[j])
+		}
+		lines[i] = strings.Join(pieces, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the correctly capitalised names of the existing users mentioned in some pieces of markdown text, keyed by
+// their lower case name.  The users mentioned in all of the texts are looked up with a single query.
+func mentionedUsers(texts ...string) map[string]string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, t := range texts {
+		for _, n := range ParseMentions(t) {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	users, err := existingUsers(names)
+	if err != nil {
+		return nil
+	}
+	return users
+}
+
+// Renders markdown text to HTML, turning the @mentions of the given users into links to their profile page.
+func renderMarkdown(text string, users map[string]string) string {
+	if len(users) != 0 {
+		text = mapMentionText(text, func(s string) string {
+			return mentionRegex.ReplaceAllStringFunc(s, func(m string) string {
+				sub := mentionRegex.FindStringSubmatch(m)
+				userName, ok := users[strings.ToLower(sub[2])]
+				if !ok {
+					return m
+				}
+				return fmt.Sprintf("%s[@%s](/%s)", sub[1], strings.Replace(userName, "_", `\_`, -1), userName)
+			})
+		})
+	}
+	return string(gfm.Markdown([]byte(text)))
+}
@@ -52,10 +52,23 @@ func MinioHandleClose(userDB *minio.Object) (err error) {
 	return
 }
 
-// Retrieves a SQLite database from Minio, opens it, returns the connection handle.
-// Also returns the name of the temp file created, which the caller needs to delete (os.Remove()) when finished with it
-func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
+// Checks if a given object exists in Minio.
+func MinioObjectExists(bucket string, id string) (bool, error) {
+	_, err := minioClient.StatObject(bucket, id, minio.StatObjectOptions{})
+	if err != nil {
+		code := minio.ToErrorResponse(err).Code
+		if code == "NoSuchKey" || code == "NoSuchBucket" {
+			return false, nil
+		}
+		log.Printf("Error when checking if Minio object '%s/%s' exists: %v\n", bucket, id, err)
+		return false, err
+	}
+	return true, nil
+}
 
+// Ensures a database file from Minio is present in the local disk cache, fetching it if needed.  Returns the path
+// to the cached file.
+func CacheMinioObject(bucket string, id string) (string, error) {
 	// Check if the database file already exists
 	newDB := filepath.Join(Conf.DiskCache.Directory, bucket, id)
 	if _, err := os.Stat(newDB); os.IsNotExist(err) {
@@ -69,7 +82,7 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 			// Get a handle from Minio for the database object
 			userDB, err := MinioHandle(bucket, id)
 			if err != nil {
-				return nil, err
+				return "", err
 			}
 
 			// Close the object handle when this function finishes
@@ -85,16 +98,16 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 			f, err := os.OpenFile(newDB+".new", os.O_CREATE|os.O_WRONLY, 0750)
 			if err != nil {
 				log.Printf("Error creating new database file in the disk cache: %v\n", err)
-				return nil, errors.New("Internal server error")
+				return "", errors.New("Internal server error")
 			}
 			bytesWritten, err := io.Copy(f, userDB)
 			if err != nil {
 				log.Printf("Error writing to new database file in the disk cache : %v\n", err)
-				return nil, errors.New("Internal server error")
+				return "", errors.New("Internal server error")
 			}
 			if bytesWritten == 0 {
 				log.Printf("0 bytes written to the new SQLite database file: %s\n", newDB+".new")
-				return nil, errors.New("Internal server error")
+				return "", errors.New("Internal server error")
 			}
 			f.Close()
 
@@ -102,7 +115,7 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 			err = os.Rename(newDB+".new", newDB)
 			if err != nil {
 				log.Printf("Error when renaming .new database file to final form in the disk cache: %s\n", err.Error())
-				return nil, errors.New("Internal server error")
+				return "", errors.New("Internal server error")
 			}
 		} else {
 			// TODO: This is not a great approach, but should be ok for initial "get it working" code.
@@ -112,10 +125,21 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 			// TODO  current system time, to detect and handle the case where the "<filename>.new" file is a stale one
 			// TODO  left over from some other (interrupted) process.  In which case nuke that and proceed to recreate
 			// TODO  it.
-			return nil, errors.New("Database retrieval in progress, try again in a few seconds")
+			return "", errors.New("Database retrieval in progress, try again in a few seconds")
 		}
 	}
 
+	return newDB, nil
+}
+
+// Retrieves a SQLite database from Minio, opens it, returns the connection handle.
+func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
+	// Make sure the database file is in the disk cache
+	newDB, err := CacheMinioObject(bucket, id)
+	if err != nil {
+		return nil, err
+	}
+
 	// Open database
 	// NOTE - OpenFullMutex seems like the right thing for ensuring multiple connections to a database file don't
 	// screw things up, but it wouldn't be a bad idea to keep it in mind if weirdness shows up
@@ -131,6 +155,22 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 	return sdb, nil
 }
 
+// Removes a database file from Minio, along with any copy of it in the local disk cache.
+func RemoveDatabaseFile(sha string) error {
+	bkt := sha[:MinioFolderChars]
+	id := sha[MinioFolderChars:]
+	err := minioClient.RemoveObject(bkt, id)
+	if err != nil {
+		log.Printf("Removing Minio object '%s/%s' failed: %v\n", bkt, id, err)
+		return err
+	}
+	err = os.Remove(filepath.Join(Conf.DiskCache.Directory, bkt, id))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Removing disk cache file '%s/%s' failed: %v\n", bkt, id, err)
+	}
+	return nil
+}
+
 // Store a database file in Minio.
 func StoreDatabaseFile(db *os.File, sha string, dbSize int64) error {
 	bkt := sha[:MinioFolderChars]
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A digest email waiting to be sent
+type digestJob struct {
+	Email     string
+	Frequency NotifyFrequency
+	Items     []digestItem
+	UserID    int64
+	UserName  string
+}
+
+// The information available to the templates for a digest email
+type digestData struct {
+	Databases   []digestDatabase
+	Frequency   NotifyFrequency
+	Period      string
+	Server      string
+	Unsubscribe string
+	Updates     int
+}
+
+// The events for one database in a digest email
+type digestDatabase struct {
+	Items []digestItem
+	Name  string
+}
+
+// One event in a digest email
+type digestItem struct {
+	DBName  string
+	ID      int64
+	Summary string
+	Title   string
+	URL     string
+}
+
+// Periodically sends the daily and weekly digest emails.  A digest is sent once its oldest event has been waiting for
+// a full day or week.
+func DigestLoop() {
+	log.Printf("Digest email loop started.  1 minute refresh.")
+	for {
+		jobs, err := pendingDigests()
+		if err == nil {
+			for _, j := range jobs {
+				unsubURL := UnsubscribeURL(j.UserName, 0)
+				subj, text, html, err := digestEmail(j, unsubURL)
+				if err != nil {
+					log.Printf("Rendering the %s digest for user '%s' failed: %v\n", j.Frequency, j.UserName, err)
+					continue
+				}
+				err = storeDigest(j, subj, text, html, unsubURL)
+				if err != nil {
+					log.Printf("Queuing the %s digest for user '%s' failed: %v\n", j.Frequency, j.UserName, err)
+				}
+			}
+		}
+		time.Sleep(time.Minute) // Digests don't need to be sent to the second
+	}
+}
+
+// Returns the link for unsubscribing a user from notification emails.  A database ID of 0 unsubscribes them from all
+// notification emails.
+func UnsubscribeURL(userName string, dbID int64) string {
+	return fmt.Sprintf("https://%s/x/unsubscribe?user=%s&db=%d&sig=%s", Conf.Web.ServerName,
+		url.QueryEscape(userName), dbID, unsubscribeSignature(userName, dbID))
+}
+
+// Checks the signature of an unsubscribe link.
+func ValidUnsubscribeSignature(userName string, dbID int64, sig string) bool {
+	return hmac.Equal([]byte(sig), []byte(unsubscribeSignature(userName, dbID)))
+}
+
+// Renders the subject, and the text and HTML bodies of a digest email.  The events are grouped by database.
+func digestEmail(j digestJob, unsubURL string) (subj string, text string, html string, err error) {
+	data := digestData{
+		Frequency:   j.Frequency,
+		Period:      "day",
+		Server:      Conf.Web.ServerName,
+		Unsubscribe: unsubURL,
+		Updates:     len(j.Items),
+	}
+	if j.Frequency == NOTIFY_WEEKLY {
+		data.Period = "week"
+	}
+	for _, i := range j.Items {
+		if n := len(data.Databases); n == 0 || data.Databases[n-1].Name != i.DBName {
+			data.Databases = append(data.Databases, digestDatabase{Name: i.DBName})
+		}
+		db := &data.Databases[len(data.Databases)-1]
+		db.Items = append(db.Items, i)
+	}
+	return renderEmail("digest", data)
+}
+
+// Returns true if a user wants to be notified about an event type.  An empty list means all events are wanted.
+func notifyWanted(events []int32, eType EventType) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if EventType(e) == eType {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the signature used in the unsubscribe link for a user and database.
+func unsubscribeSignature(userName string, dbID int64) string {
+	mac := hmac.New(sha256.New, []byte(Conf.Event.UnsubscribeKey))
+	mac.Write([]byte(strings.ToLower(userName) + ":" + strconv.FormatInt(dbID, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
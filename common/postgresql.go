@@ -9,13 +9,11 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/hectane/hectane/email"
-	"github.com/hectane/hectane/queue"
 	"github.com/jackc/pgx"
-	gfm "github.com/sqlitebrowser/github_flavored_markdown"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -92,6 +90,259 @@ func AddUser(auth0ID string, userName string, password string, email string, dis
 	return nil
 }
 
+// Returns databases matching the given (optional) owner and name filters, for the admin server.  Deleted databases
+// are included, with those in the trash shown under their original name.
+func AdminDatabases(owner string, search string) (list []AdminDBEntry, err error) {
+	dbQuery := `
+		SELECT db.db_id, u.user_name, db.folder, coalesce(t.db_name, db.db_name), db.public, db.is_deleted,
+			t.db_id IS NOT NULL, db.last_modified
+		FROM sqlite_databases AS db
+			JOIN users AS u ON u.user_id = db.user_id
+			LEFT JOIN database_trash AS t ON t.db_id = db.db_id
+		WHERE ($1 = '' OR lower(u.user_name) = lower($1))
+			AND ($2 = '' OR coalesce(t.db_name, db.db_name) ILIKE '%' || $2 || '%')
+		ORDER BY db.last_modified DESC
+		LIMIT 100`
+	rows, err := pdb.Query(dbQuery, owner, search)
+	if err != nil {
+		log.Printf("Retrieving database list for the admin server failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d AdminDBEntry
+		err = rows.Scan(&d.DBID, &d.Owner, &d.Folder, &d.DBName, &d.Public, &d.Deleted, &d.InTrash,
+			&d.LastModified)
+		if err != nil {
+			log.Printf("Error retrieving database list for the admin server: %v\n", err)
+			return
+		}
+		list = append(list, d)
+	}
+	return
+}
+
+// Returns the instance wide statistics shown on the admin server.
+func AdminStatistics() (stats AdminStats, err error) {
+	dbQuery := `
+		SELECT
+			(SELECT count(*) FROM users WHERE is_org = false),
+			(SELECT count(*) FROM users WHERE is_org = true),
+			(SELECT count(*) FROM users WHERE disabled = true),
+			(SELECT count(*) FROM sqlite_databases WHERE is_deleted = false),
+			(SELECT count(*) FROM sqlite_databases WHERE is_deleted = false AND public = true),
+			(SELECT count(*) FROM database_trash),
+			(SELECT count(*) FROM database_files),
+			(SELECT count(*) FROM database_stars),
+			(SELECT count(*) FROM discussions WHERE discussion_type = $1),
+			(SELECT count(*) FROM discussions WHERE discussion_type = $2),
+			(SELECT count(*) FROM api_tokens),
+			(SELECT count(*) FROM upload_sessions),
+			(SELECT count(*) FROM events),
+			(SELECT count(*) FROM email_queue WHERE sent = false)`
+	err = pdb.QueryRow(dbQuery, DISCUSSION, MERGE_REQUEST).Scan(&stats.Users, &stats.Orgs, &stats.DisabledUsers,
+		&stats.Databases, &stats.PublicDBs, &stats.TrashedDBs, &stats.DatabaseFiles, &stats.Stars,
+		&stats.Discussions, &stats.MergeRequests, &stats.APITokens, &stats.UploadSessions, &stats.EventsPending,
+		&stats.EmailsPending)
+	if err != nil {
+		log.Printf("Retrieving instance statistics failed: %v\n", err)
+	}
+	return
+}
+
+// Returns the users and organisations whose name, display name, or email address contains the search string, for
+// the admin server.
+func AdminUsers(search string) (list []AdminUserEntry, err error) {
+	dbQuery := `
+		SELECT u.user_name, u.display_name, u.email, u.date_joined, u.is_org, u.disabled, u.certs_valid_from,
+			coalesce(u.max_upload_size, 0), coalesce(u.max_storage, 0), coalesce(u.max_databases, 0), (
+				SELECT count(*)
+				FROM sqlite_databases AS db
+				WHERE db.user_id = u.user_id
+					AND db.is_deleted = false
+			)
+		FROM users AS u
+		WHERE $1 = ''
+			OR u.user_name ILIKE '%' || $1 || '%'
+			OR u.display_name ILIKE '%' || $1 || '%'
+			OR u.email ILIKE '%' || $1 || '%'
+		ORDER BY lower(u.user_name)
+		LIMIT 100`
+	rows, err := pdb.Query(dbQuery, search)
+	if err != nil {
+		log.Printf("Retrieving user list for the admin server failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AdminUserEntry
+		var displayName, email pgx.NullString
+		var certsValidFrom pgx.NullTime
+		err = rows.Scan(&e.UserName, &displayName, &email, &e.DateJoined, &e.IsOrg, &e.Disabled, &certsValidFrom,
+			&e.Quota.MaxUploadSize, &e.Quota.MaxStorage, &e.Quota.MaxDatabases, &e.NumDatabases)
+		if err != nil {
+			log.Printf("Error retrieving user list for the admin server: %v\n", err)
+			return
+		}
+		e.DisplayName = displayName.String
+		e.Email = email.String
+		if certsValidFrom.Valid {
+			e.CertsValidFrom = certsValidFrom.Time
+		}
+		list = append(list, e)
+	}
+	return
+}
+
+// Returns the owner, folder, and name of every (non deleted) database in the system.
+func AllDatabases() (list []DBEntry, err error) {
+	dbQuery := `
+		SELECT users.user_name, db.folder, db.db_name, db.last_modified
+		FROM sqlite_databases AS db, users
+		WHERE db.user_id = users.user_id
+			AND db.is_deleted = false
+		ORDER BY users.user_name, db.folder, db.db_name`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		log.Printf("Retrieving the list of all databases failed: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow DBEntry
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.DateEntry)
+		if err != nil {
+			log.Printf("Error retrieving the list of all databases: %v\n", err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return list, nil
+}
+
+// Returns the user name and scopes for an API access token, updating the last used time of the token at the same time.
+// If the token isn't known, the user name returned is empty.
+func APITokenUser(tokenHash string) (userName string, scopes []APITokenScope, err error) {
+	dbQuery := `
+		WITH tok AS (
+			UPDATE api_tokens
+			SET last_used = now()
+			WHERE token_hash = $1
+			RETURNING user_id, scopes
+		)
+		SELECT users.user_name, tok.scopes
+		FROM tok, users
+		WHERE tok.user_id = users.user_id
+			AND users.disabled = false`
+	err = pdb.QueryRow(dbQuery, tokenHash).Scan(&userName, &scopes)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", nil, nil
+		}
+		log.Printf("Error when looking up API token: %v\n", err)
+		return "", nil, err
+	}
+	return
+}
+
+// Returns the list of API access tokens for a user.
+func APITokens(userName string) (tokens []APIToken, err error) {
+	dbQuery := `
+		SELECT token_id, name, scopes, date_created, last_used
+		FROM api_tokens
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+		ORDER BY date_created`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		log.Printf("Retrieving API tokens for user '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t APIToken
+		var lastUsed pgx.NullTime
+		err = rows.Scan(&t.ID, &t.Name, &t.Scopes, &t.DateCreated, &lastUsed)
+		if err != nil {
+			log.Printf("Error retrieving API tokens for user '%s': %v\n", userName, err)
+			return
+		}
+		if lastUsed.Valid {
+			t.LastUsed = lastUsed.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return
+}
+
+// Returns the audit log entries matching the given filter, newest first.
+func AuditEntries(f AuditFilter) (list []AuditEntry, err error) {
+	if f.Limit == 0 {
+		f.Limit = 100
+	}
+	dbQuery := `
+		SELECT audit_id, date_created, actor, ip_addr, server_sw, owner, folder, db_name, action, details
+		FROM audit_log
+		WHERE ($1 = '' OR action = $1)
+			AND ($2 = '' OR lower(actor) = lower($2))
+			AND ($3::bigint = 0 OR db_id = $3)
+			AND ($4 = '' OR db_name ILIKE '%' || $4 || '%')
+			AND ($5 = '' OR lower(owner) = lower($5))
+		ORDER BY audit_id DESC
+		LIMIT $6 OFFSET $7`
+	rows, err := pdb.Query(dbQuery, string(f.Action), f.Actor, f.DBID, f.DBName, f.Owner, f.Limit, f.Offset)
+	if err != nil {
+		log.Printf("Retrieving audit log entries failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		var action string
+		var actor, ipAddr, owner, folder, dbName pgx.NullString
+		var details struct {
+			After  interface{} `json:"after"`
+			Before interface{} `json:"before"`
+		}
+		err = rows.Scan(&e.ID, &e.Date, &actor, &ipAddr, &e.ServerSw, &owner, &folder, &dbName, &action, &details)
+		if err != nil {
+			log.Printf("Error retrieving audit log entries: %v\n", err)
+			return
+		}
+		e.Action = AuditAction(action)
+		e.Actor = actor.String
+		e.After = details.After
+		e.Before = details.Before
+		e.DBName = dbName.String
+		e.Folder = folder.String
+		e.IPAddr = ipAddr.String
+		e.Owner = owner.String
+		list = append(list, e)
+	}
+	return
+}
+
+// Check if a database file has an entry in the database_files table.
+func CheckDatabaseFileExists(sha string) (bool, error) {
+	dbQuery := `
+		SELECT count(db_sha256)
+		FROM database_files
+		WHERE db_sha256 = $1`
+	var fileCount int
+	err := pdb.QueryRow(dbQuery, sha).Scan(&fileCount)
+	if err != nil {
+		log.Printf("Checking if database file '%s' exists failed: %v\n", sha, err)
+		return true, err
+	}
+	if fileCount == 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
 // Check if a database exists
 // If an error occurred, the true/false value should be ignored, as only the error value is valid.
 func CheckDBExists(loggedInUser string, dbOwner string, dbFolder string, dbName string) (bool, error) {
@@ -107,13 +358,15 @@ func CheckDBExists(loggedInUser string, dbOwner string, dbFolder string, dbName
 			AND db_name = $3
 			AND is_deleted = false`
 	// If the request is from someone who's not logged in, or is for another users database, ensure we only consider
-	// public databases
+	// public databases, and private ones they've been given access to
+	args := []interface{}{dbOwner, dbFolder, dbName}
 	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) || loggedInUser == "" {
 		dbQuery += `
-			AND public = true`
+			AND ` + privateAccessSQL("sqlite_databases", "$4")
+		args = append(args, loggedInUser)
 	}
 	var DBCount int
-	err := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&DBCount)
+	err := pdb.QueryRow(dbQuery, args...).Scan(&DBCount)
 	if err != nil {
 		log.Printf("Checking if a database exists failed: %v\n", err)
 		return true, err
@@ -141,12 +394,14 @@ func CheckDBID(loggedInUser string, dbOwner string, dbID int64) (avail bool, dbF
 			AND db_id = $2
 			AND is_deleted = false`
 	// If the request is from someone who's not logged in, or is for another users database, ensure we only consider
-	// public databases
+	// public databases, and private ones they've been given access to
+	args := []interface{}{dbOwner, dbID}
 	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) || loggedInUser == "" {
 		dbQuery += `
-			AND public = true`
+			AND ` + privateAccessSQL("sqlite_databases", "$3")
+		args = append(args, loggedInUser)
 	}
-	err = pdb.QueryRow(dbQuery, dbOwner, dbID).Scan(&dbFolder, &dbName)
+	err = pdb.QueryRow(dbQuery, args...).Scan(&dbFolder, &dbName)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			avail = false
@@ -162,6 +417,17 @@ func CheckDBID(loggedInUser string, dbOwner string, dbID int64) (avail bool, dbF
 	return
 }
 
+// Checks if a user has at least the given level of access to a database.  If an error occurred, the true/false value
+// should be ignored, as only the error value is valid.
+func CheckDBPermissions(loggedInUser string, dbOwner string, dbFolder string, dbName string,
+	need Permission) (bool, error) {
+	perm, err := DBPermission(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		return false, err
+	}
+	return perm >= need, nil
+}
+
 // Check if a database has been starred by a given user.  The boolean return value is only valid when err is nil.
 func CheckDBStarred(loggedInUser string, dbOwner string, dbFolder string, dbName string) (bool, error) {
 	dbQuery := `
@@ -236,6 +502,49 @@ func CheckDBWatched(loggedInUser string, dbOwner string, dbFolder string, dbName
 	return true, nil
 }
 
+// Check if a user is subscribed to a discussion or merge request.  Users who haven't explicitly subscribed or
+// unsubscribed are subscribed if they're watching the database.  The boolean return value is only valid when err is
+// nil.
+func CheckDiscussionSubscribed(loggedInUser string, dbOwner string, dbFolder string, dbName string, discID int) (bool, error) {
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($4)
+		), d AS (
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		)
+		SELECT coalesce((
+				SELECT s.subscribed
+				FROM discussion_subscriptions AS s, d, u
+				WHERE s.db_id = d.db_id
+					AND s.disc_id = $5
+					AND s.user_id = u.user_id
+			), EXISTS (
+				SELECT 1
+				FROM watchers AS w, d, u
+				WHERE w.db_id = d.db_id
+					AND w.user_id = u.user_id
+			))`
+	var subscribed bool
+	err := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, discID).Scan(&subscribed)
+	if err != nil {
+		log.Printf("Error looking up subscription for user '%s' to discussion '%d' of '%s%s%s': %v\n",
+			loggedInUser, discID, dbOwner, dbFolder, dbName, err)
+		return false, err
+	}
+	return subscribed, nil
+}
+
 // Check if an email address already exists in our system. Returns true if the email is already in the system, false
 // if not.  If an error occurred, the true/false value should be ignored, as only the error value is valid.
 func CheckEmailExists(email string) (bool, error) {
@@ -311,6 +620,77 @@ func CheckUserExists(userName string) (bool, error) {
 	return true, nil
 }
 
+// Claims the queued emails which are due to be sent, so they're only sent by one server.  Claimed emails have their
+// next attempt pushed back by the lease time, which makes them available again if the server sending them stops
+// before recording the result.  Emails which have failed too many times are left alone.
+func claimEmails(lease time.Duration) (list []queuedEmail, err error) {
+	dbQuery := `
+		UPDATE email_queue
+		SET next_attempt = now() + $2 * interval '1 second'
+		WHERE email_id IN (
+				SELECT email_id
+				FROM email_queue
+				WHERE sent = false
+					AND attempts < $1
+					AND next_attempt <= now()
+				ORDER BY email_id
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED)
+		RETURNING email_id, mail_to, subject, body, coalesce(html_body, ''), coalesce(unsubscribe_url, ''), attempts`
+	rows, err := pdb.Query(dbQuery, Conf.Email.MaxAttempts, int64(lease/time.Second), emailBatchSize)
+	if err != nil {
+		log.Printf("Claiming queued emails failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e queuedEmail
+		err = rows.Scan(&e.ID, &e.To, &e.Subject, &e.Body, &e.HTMLBody, &e.Unsubscribe, &e.Attempts)
+		if err != nil {
+			log.Printf("Error claiming queued emails: %v\n", err)
+			return
+		}
+		list = append(list, e)
+	}
+	return
+}
+
+// Claims the webhook deliveries which are due to be attempted, so they're only sent by one server.  Claimed
+// deliveries have their next attempt pushed back by the lease time, which makes them available again if the server
+// sending them stops before recording the result.
+func claimWebhookDeliveries(lease time.Duration) (list []webhookJob, err error) {
+	dbQuery := `
+		UPDATE webhook_deliveries AS del
+		SET next_attempt = now() + $1 * interval '1 second'
+		FROM webhooks AS hook
+		WHERE del.webhook_id = hook.webhook_id
+			AND del.delivery_id IN (
+				SELECT delivery_id
+				FROM webhook_deliveries
+				WHERE state = 'pending'
+					AND next_attempt <= now()
+				ORDER BY delivery_id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED)
+		RETURNING del.delivery_id, del.webhook_id, del.event_type, del.payload, del.attempts, hook.url, hook.secret`
+	rows, err := pdb.Query(dbQuery, int64(lease/time.Second), webhookBatchSize)
+	if err != nil {
+		log.Printf("Claiming pending webhook deliveries failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var j webhookJob
+		err = rows.Scan(&j.ID, &j.WebhookID, &j.EventType, &j.Payload, &j.Attempts, &j.URL, &j.Secret)
+		if err != nil {
+			log.Printf("Error claiming pending webhook deliveries: %v\n", err)
+			return
+		}
+		list = append(list, j)
+	}
+	return
+}
+
 // Returns the certificate for a given user.
 func ClientCert(userName string) ([]byte, error) {
 	var cert []byte
@@ -326,80 +706,305 @@ func ClientCert(userName string) ([]byte, error) {
 	return cert, nil
 }
 
-// Creates a connection pool to the PostgreSQL server.
-func ConnectPostgreSQL() (err error) {
-	pgPoolConfig := pgx.ConnPoolConfig{*pgConfig, Conf.Pg.NumConnections, nil, 2 * time.Second}
-	pdb, err = pgx.NewConnPool(pgPoolConfig)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Couldn't connect to PostgreSQL server: %v\n", err))
-	}
-
-	// Log successful connection
-	log.Printf("Connected to PostgreSQL server: %v:%v\n", Conf.Pg.Server, uint16(Conf.Pg.Port))
-
-	return nil
-}
-
-// Returns the ID number for a given user's database.
-func databaseID(dbOwner string, dbFolder string, dbName string) (dbID int, err error) {
-	// Retrieve the database id
+// Returns the list of collaborators for a database.
+func Collaborators(dbOwner string, dbFolder string, dbName string) (list []CollaboratorEntry, err error) {
 	dbQuery := `
-		SELECT db_id
-		FROM sqlite_databases
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1))
-			AND folder = $2
-			AND db_name = $3
-			AND is_deleted = false`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&dbID)
+		SELECT u.user_name, u.display_name, u.avatar_url, c.role, c.date_added
+		FROM database_collaborators AS c, users AS u
+		WHERE c.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND c.user_id = u.user_id
+		ORDER BY lower(u.user_name)`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Error looking up database id. Owner: '%s', Database: '%s'. Error: %v\n", dbOwner, dbName,
-			err)
+		log.Printf("Retrieving collaborators for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c CollaboratorEntry
+		var avatarURL, displayName pgx.NullString
+		var role string
+		err = rows.Scan(&c.UserName, &displayName, &avatarURL, &role, &c.DateAdded)
+		if err != nil {
+			log.Printf("Error retrieving collaborators for '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+			return
+		}
+		c.AvatarURL = avatarURL.String
+		c.DisplayName = displayName.String
+		c.Role = CollaboratorRole(role)
+		list = append(list, c)
 	}
 	return
 }
 
-// Return a list of 1) users with public databases, 2) along with the logged in users' most recently modified database
-// (including their private one(s)).
-func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
-	// Retrieve the list of all users with public databases
+// Returns the anchors of the review comments on a merge request, keyed by comment ID.
+func commentAnchors(dbOwner string, dbFolder string, dbName string, discID int) (anchors map[int64]CommentAnchor,
+	err error) {
 	dbQuery := `
-		WITH public_dbs AS (
-			SELECT db_id, last_modified
-			FROM sqlite_databases
-			WHERE public = true
-			AND is_deleted = false
-			ORDER BY last_modified DESC
-		), public_users AS (
-			SELECT DISTINCT ON (db.user_id) db.user_id, db.last_modified
-			FROM public_dbs as pub, sqlite_databases AS db
-			WHERE db.db_id = pub.db_id
-			ORDER BY db.user_id, db.last_modified DESC
-		)
-		SELECT user_name, last_modified
-		FROM public_users AS pu, users
-		WHERE users.user_id = pu.user_id
-		ORDER BY last_modified DESC`
-	rows, err := pdb.Query(dbQuery)
+		SELECT anc.com_id, anc.commit_id, anc.original_commit_id, anc.table_name, anc.primary_key,
+			coalesce(anc.column_name, ''), anc.outdated
+		FROM discussion_comment_anchors AS anc, discussion_comments AS com, discussions AS disc
+		WHERE disc.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND disc.disc_id = $4
+			AND com.disc_id = disc.internal_id
+			AND anc.com_id = com.com_id`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName, discID)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return nil, err
+		log.Printf("Retrieving comment anchors for database '%s%s%s', MR '%d' failed: %v\n", dbOwner, dbFolder,
+			dbName, discID, err)
+		return
 	}
 	defer rows.Close()
-	list := make(map[string]UserInfo)
+	anchors = make(map[int64]CommentAnchor)
 	for rows.Next() {
-		var oneRow UserInfo
-		err = rows.Scan(&oneRow.Username, &oneRow.LastModified)
+		var comID int64
+		var a CommentAnchor
+		err = rows.Scan(&comID, &a.CommitID, &a.OriginalCommitID, &a.Table, &a.PrimaryKey, &a.Column, &a.Outdated)
 		if err != nil {
-			log.Printf("Error list of users with public databases: %v\n", err)
+			log.Printf("Error retrieving comment anchors for database '%s%s%s', MR '%d': %v\n", dbOwner, dbFolder,
+				dbName, discID, err)
 			return nil, err
 		}
-		list[oneRow.Username] = oneRow
+		anchors[comID] = a
 	}
+	return
+}
 
-	// Retrieve the last modified timestamp for the most recent database of the logged in user (if they have any)
+// Returns the status checks for a commit, in order of their names.
+func CommitStatuses(dbOwner string, dbFolder string, dbName string, commitID string) (list []CommitStatus, err error) {
+	dbQuery := `
+		SELECT st.context, st.state, coalesce(st.description, ''), coalesce(st.target_url, ''),
+			coalesce(u.user_name, ''), st.date_updated
+		FROM commit_statuses AS st
+			LEFT OUTER JOIN users AS u ON u.user_id = st.user_id
+		WHERE st.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND st.commit_id = $4
+		ORDER BY lower(st.context)`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName, commitID)
+	if err != nil {
+		log.Printf("Retrieving status checks for commit '%s' of database '%s%s%s' failed: %v\n", commitID,
+			dbOwner, dbFolder, dbName, err)
+		return
+	}
+	defer rows.Close()
+	list = []CommitStatus{}
+	for rows.Next() {
+		s := CommitStatus{CommitID: commitID}
+		var state string
+		err = rows.Scan(&s.Context, &state, &s.Description, &s.TargetURL, &s.Creator, &s.DateUpdated)
+		if err != nil {
+			log.Printf("Error retrieving status checks for commit '%s' of database '%s%s%s': %v\n", commitID,
+				dbOwner, dbFolder, dbName, err)
+			return nil, err
+		}
+		s.State = StatusCheckState(state)
+		list = append(list, s)
+	}
+	return
+}
+
+// Creates a connection pool to the PostgreSQL server.
+func ConnectPostgreSQL() (err error) {
+	pgPoolConfig := pgx.ConnPoolConfig{
+		ConnConfig:     *pgConfig,
+		MaxConnections: Conf.Pg.NumConnections,
+		AfterConnect:   nil,
+		AcquireTimeout: 2 * time.Second,
+	}
+	pdb, err = pgx.NewConnPool(pgPoolConfig)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't connect to PostgreSQL server: %v\n", err))
+	}
+
+	// Log successful connection
+	log.Printf("Connected to PostgreSQL server: %v:%v\n", Conf.Pg.Server, uint16(Conf.Pg.Port))
+
+	return nil
+}
+
+// Creates a new organisation, with the given user as its first owner.
+func CreateOrganisation(creator string, orgName string, displayName string) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Organisations live in the users table, so they share the same name space as users.  They can't log in, so they
+	// get a placeholder Auth0 ID and no password or client certificate
+	var dn pgx.NullString
+	if displayName != "" {
+		dn.String = displayName
+		dn.Valid = true
+	}
+	dbQuery := `
+		INSERT INTO users (auth0_id, user_name, password_hash, client_cert, display_name, is_org)
+		VALUES ($1, $2, '', '', $3, true)`
+	commandTag, err := tx.Exec(dbQuery, "org|"+strings.ToLower(orgName), orgName, dn)
+	if err != nil {
+		log.Printf("Creating organisation '%s' failed: %v\n", orgName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when creating organisation '%s'\n", numRows, orgName)
+	}
+
+	// Add the creator as an owner
+	dbQuery = `
+		INSERT INTO org_members (org_id, user_id, role)
+		SELECT (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			), (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			), $3`
+	commandTag, err = tx.Exec(dbQuery, orgName, creator, string(ORG_OWNER))
+	if err != nil {
+		log.Printf("Adding '%s' as owner of new organisation '%s' failed: %v\n", creator, orgName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding '%s' as owner of organisation '%s'\n", numRows,
+			creator, orgName)
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	log.Printf("Organisation created: '%s' Owner: '%s'\n", orgName, creator)
+	return nil
+}
+
+// Returns the ID number for a given user's database.
+func databaseID(dbOwner string, dbFolder string, dbName string) (dbID int, err error) {
+	// Retrieve the database id
+	dbQuery := `
+		SELECT db_id
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1))
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&dbID)
+	if err != nil {
+		log.Printf("Error looking up database id. Owner: '%s', Database: '%s'. Error: %v\n", dbOwner, dbName,
+			err)
+	}
+	return
+}
+
+// Looks up where a database which used to live at the given location has since been transferred to.  If there's no
+// such database, or the logged in user isn't allowed to see it, an empty new owner string is returned.
+func DatabaseRedirect(loggedInUser string, dbOwner string, dbFolder string, dbName string) (newOwner string,
+	newFolder string, newName string, err error) {
+	dbQuery := `
+		SELECT u.user_name, db.folder, db.db_name
+		FROM database_redirects AS r, sqlite_databases AS db, users AS u
+		WHERE r.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND r.folder = $2
+			AND r.db_name = $3
+			AND db.db_id = r.db_id
+			AND db.is_deleted = false
+			AND u.user_id = db.user_id
+			AND (lower(u.user_name) = lower($4) OR ` + privateAccessSQL("db", "$4") + `)`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser).Scan(&newOwner, &newFolder, &newName)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// No redirect for this location
+			return "", "", "", nil
+		}
+		log.Printf("Looking up redirect for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
+	}
+	return
+}
+
+// Return a list of 1) users with public databases, 2) along with the logged in users' most recently modified database
+// (including their private one(s)).
+func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
+	// Retrieve the list of all users with public databases
+	dbQuery := `
+		WITH public_dbs AS (
+			SELECT db_id, last_modified
+			FROM sqlite_databases
+			WHERE public = true
+			AND is_deleted = false
+			ORDER BY last_modified DESC
+		), public_users AS (
+			SELECT DISTINCT ON (db.user_id) db.user_id, db.last_modified
+			FROM public_dbs as pub, sqlite_databases AS db
+			WHERE db.db_id = pub.db_id
+			ORDER BY db.user_id, db.last_modified DESC
+		)
+		SELECT user_name, last_modified
+		FROM public_users AS pu, users
+		WHERE users.user_id = pu.user_id
+		ORDER BY last_modified DESC`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+	list := make(map[string]UserInfo)
+	for rows.Next() {
+		var oneRow UserInfo
+		err = rows.Scan(&oneRow.Username, &oneRow.LastModified)
+		if err != nil {
+			log.Printf("Error list of users with public databases: %v\n", err)
+			return nil, err
+		}
+		list[oneRow.Username] = oneRow
+	}
+
+	// Retrieve the last modified timestamp for the most recent database of the logged in user (if they have any)
 	dbQuery = `
 		WITH u AS (
 			SELECT user_id
@@ -464,10 +1069,13 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, dbOwner string, dbFolder s
 			AND db.db_name = $3
 			AND db.is_deleted = false`
 
-	// If the request is for another users database, ensure we only look up public ones
+	// If the request is for another users database, ensure we only look up public ones and private ones the user has
+	// been given access to
+	args := []interface{}{dbOwner, dbFolder, dbName, commitID}
 	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
 		dbQuery += `
-			AND db.public = true`
+			AND ` + privateAccessSQL("db", "$5")
+		args = append(args, loggedInUser)
 	}
 
 	// Generate a predictable cache key for this functions' metadata.  Probably not sharable with other functions
@@ -486,7 +1094,7 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, dbOwner string, dbFolder s
 
 	// Retrieve the requested database details
 	var defTable, fullDesc, oneLineDesc, sourceURL pgx.NullString
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, commitID).Scan(&DB.Info.DateCreated,
+	err = pdb.QueryRow(dbQuery, args...).Scan(&DB.Info.DateCreated,
 		&DB.Info.RepoModified, &DB.Info.Watchers, &DB.Info.Stars, &DB.Info.Discussions, &DB.Info.MRs,
 		&DB.Info.CommitID,
 		&DB.Info.DBEntry,
@@ -542,15 +1150,82 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, dbOwner string, dbFolder s
 		return err
 	}
 
-	// Cache the database details
-	err = CacheData(mdataCacheKey, DB, Conf.Memcache.DefaultCacheTime)
-	if err != nil {
-		log.Printf("Error when caching page data: %v\n", err)
+	// Cache the database details.  Private databases looked up by collaborators aren't cached, as the cache entry is
+	// shared with everyone else who isn't the owner
+	if DB.Info.Public || strings.ToLower(loggedInUser) == strings.ToLower(dbOwner) {
+		err = CacheData(mdataCacheKey, DB, Conf.Memcache.DefaultCacheTime)
+		if err != nil {
+			log.Printf("Error when caching page data: %v\n", err)
+		}
 	}
 
 	return nil
 }
 
+// Returns the level of access a user has to a database.  The owner has full access, as do the owners of an organisation
+// for its databases.  Collaborators have the access given by their role, members of organisation teams which have been
+// given the database have the access of the team's role, and everyone else can read public databases.
+func DBPermission(loggedInUser string, dbOwner string, dbFolder string, dbName string) (perm Permission, err error) {
+	dbQuery := `
+		WITH usr AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($4)
+		)
+		SELECT db.public, lower(u.user_name) = lower($4), c.role, EXISTS (
+				SELECT 1
+				FROM org_members AS m, usr
+				WHERE m.org_id = db.user_id
+					AND m.user_id = usr.user_id
+					AND m.role = $5
+			), (
+				SELECT array_agg(t.role)
+				FROM org_team_databases AS td, org_teams AS t, org_team_members AS tm, usr
+				WHERE td.db_id = db.db_id
+					AND t.team_id = td.team_id
+					AND tm.team_id = t.team_id
+					AND tm.user_id = usr.user_id
+			)
+		FROM sqlite_databases AS db
+			JOIN users AS u ON u.user_id = db.user_id
+			LEFT JOIN database_collaborators AS c ON c.db_id = db.db_id
+				AND c.user_id = (
+					SELECT user_id
+					FROM usr
+				)
+		WHERE lower(u.user_name) = lower($1)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	var isOrgOwner, isOwner, public bool
+	var role pgx.NullString
+	var teamRoles []string
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, string(ORG_OWNER)).Scan(&public, &isOwner,
+		&role, &isOrgOwner, &teamRoles)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// The database doesn't exist
+			return PERM_NONE, nil
+		}
+		log.Printf("Retrieving permissions of '%s' for database '%s%s%s' failed: %v\n", loggedInUser, dbOwner,
+			dbFolder, dbName, err)
+		return PERM_NONE, err
+	}
+	if (isOwner || isOrgOwner) && loggedInUser != "" {
+		return PERM_OWNER, nil
+	}
+	perm = rolePermission(CollaboratorRole(role.String))
+	for _, r := range teamRoles {
+		if p := rolePermission(CollaboratorRole(r)); p > perm {
+			perm = p
+		}
+	}
+	if perm == PERM_NONE && public {
+		perm = PERM_READ
+	}
+	return
+}
+
 // Returns the star count for a given database.
 func DBStars(dbOwner string, dbFolder string, dbName string) (starCount int, err error) {
 	// Retrieve the updated star count
@@ -619,6 +1294,61 @@ func DefaultCommit(dbOwner string, dbFolder string, dbName string) (string, erro
 	return commitID, nil
 }
 
+// Revokes one of a user's API access tokens.
+func DeleteAPIToken(userName string, tokenID int64) error {
+	dbQuery := `
+		DELETE FROM api_tokens
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND token_id = $2`
+	commandTag, err := pdb.Exec(dbQuery, userName, tokenID)
+	if err != nil {
+		log.Printf("Revoking API token '%d' for user '%s' failed: %v\n", tokenID, userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when revoking API token '%d' for user '%s'\n", numRows,
+			tokenID, userName)
+	}
+	return nil
+}
+
+// Removes a collaborator from a database.
+func DeleteCollaborator(dbOwner string, dbFolder string, dbName string, userName string) error {
+	dbQuery := `
+		DELETE FROM database_collaborators
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($4)
+			)`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, userName)
+	if err != nil {
+		log.Printf("Removing collaborator '%s' from database '%s%s%s' failed: %v\n", userName, dbOwner, dbFolder,
+			dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing collaborator '%s' from database '%s%s%s'\n",
+			numRows, userName, dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
 // Delete a specific comment from a discussion
 func DeleteComment(dbOwner string, dbFolder string, dbName string, discID int, comID int) error {
 	// Begin a transaction
@@ -709,11 +1439,11 @@ func DeleteComment(dbOwner string, dbFolder string, dbName string, discID int, c
 	return nil
 }
 
-// Deletes a database from PostgreSQL.
+// Deletes a database from PostgreSQL.  The database is moved to the trash of its owner rather than being removed
+// straight away, so it can be restored until the retention period for deleted databases has passed.  Its entry in
+// sqlite_databases is kept (under a placeholder name), which keeps its stars, watchers, and position in the fork tree
+// ready for a restore.
 func DeleteDatabase(dbOwner string, dbFolder string, dbName string) error {
-	// TODO: At some point we'll need to figure out a garbage collection approach to remove databases from Minio which
-	// TODO  are no longer pointed to by anything
-
 	// Begin a transaction
 	tx, err := pdb.Begin()
 	if err != nil {
@@ -722,125 +1452,28 @@ func DeleteDatabase(dbOwner string, dbFolder string, dbName string) error {
 	// Set up an automatic transaction roll back if the function exits without committing
 	defer tx.Rollback()
 
-	// Check if there are any forks of this database
+	// Record the details needed to restore the database later on
 	dbQuery := `
-		WITH this_db AS (
-			SELECT db_id
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
-		)
-		SELECT count(*)
-		FROM sqlite_databases AS db, this_db
-		WHERE db.forked_from = this_db.db_id`
-	var numForks int
-	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&numForks)
+		INSERT INTO database_trash (db_id, db_name, public)
+		SELECT db_id, db_name, public
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Retreving fork list failed for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
-		return err
-	}
-	if numForks == 0 {
-		// * There are no forks for this database, so we just remove it's entry from sqlite_databases.  The 'ON DELETE
-		// CASCADE' definition for the database_stars table/field should automatically remove any references to the
-		// now deleted entry *
-
-		// Update the fork count for the root database
-		dbQuery = `
-			WITH root_db AS (
-				SELECT root_database AS id
-				FROM sqlite_databases
-				WHERE user_id = (
-						SELECT user_id
-						FROM users
-						WHERE lower(user_name) = lower($1)
-					)
-					AND folder = $2
-					AND db_name = $3
-			), new_count AS (
-				SELECT count(*) AS forks
-				FROM sqlite_databases AS db, root_db
-				WHERE db.root_database = root_db.id
-				AND db.is_deleted = false
-			)
-			UPDATE sqlite_databases
-			SET forks = new_count.forks - 2
-			FROM new_count, root_db
-			WHERE sqlite_databases.db_id = root_db.id`
-		commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
-		if err != nil {
-			log.Printf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v\n", dbOwner, dbFolder, dbName,
-				err)
-			return err
-		}
-		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf("Wrong number of rows (%v) affected (spot 1) when updating fork count for database '%s%s%s'\n",
-				numRows, dbOwner, dbFolder, dbName)
-		}
-
-		// Do the database deletion in PostgreSQL (needs to come after the above fork count update, else the fork count
-		// won't be able to find the root database id)
-		dbQuery = `
-			DELETE
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3`
-		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
-		if err != nil {
-			log.Printf("Deleting database entry failed for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
-				err)
-			return err
-		}
-		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf(
-				"Wrong number of rows (%v) affected when deleting database '%s%s%s'\n", numRows, dbOwner,
-				dbFolder, dbName)
-		}
-
-		// Commit the transaction
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
-
-		// Log the database deletion
-		log.Printf("Database '%s%s%s' deleted\n", dbOwner, dbFolder, dbName)
-		return nil
-	}
-
-	// * If there are any forks of this database, we need to leave stub/placeholder info for its entry so the fork tree
-	// doesn't go weird.  We also set the "is_deleted" boolean to true for its entry, so our database query functions
-	// know to skip it *
-
-	// Delete all stars referencing the database stub
-	dbQuery = `
-		DELETE FROM database_stars
-		WHERE db_id = (
-			SELECT db_id
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
-			)`
-	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
-	if err != nil {
-		log.Printf("Deleting (forked) database stars failed for database '%s%s%s': %v\n", dbOwner, dbFolder,
-			dbName, err)
+		log.Printf("Moving database '%s%s%s' to the trash failed: %v\n", dbOwner, dbFolder, dbName, err)
 		return err
 	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when moving database '%s%s%s' to the trash\n", numRows,
+			dbOwner, dbFolder, dbName)
+	}
 
 	// Generate a random string to be used in the deleted database's name field, so if the user adds a database with
 	// the deleted one's name then the unique constraint on the database won't reject it
@@ -856,51 +1489,23 @@ func DeleteDatabase(dbOwner string, dbFolder string, dbName string) error {
 				WHERE lower(user_name) = lower($1)
 			)
 			AND folder = $2
-			AND db_name = $3`
+			AND db_name = $3
+			AND is_deleted = false`
 	commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, newName)
 	if err != nil {
-		log.Printf("Deleting (forked) database entry failed for database '%s%s%s': %v\n", dbOwner, dbFolder,
-			dbName, err)
+		log.Printf("Deleting database entry failed for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when deleting (forked) database '%s%s%s'\n", numRows, dbOwner,
+		log.Printf("Wrong number of rows (%v) affected when deleting database '%s%s%s'\n", numRows, dbOwner,
 			dbFolder, dbName)
 	}
 
 	// Update the fork count for the root database
-	dbQuery = `
-		WITH root_db AS (
-			SELECT root_database AS id
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
-		), new_count AS (
-			SELECT count(*) AS forks
-			FROM sqlite_databases AS db, root_db
-			WHERE db.root_database = root_db.id
-			AND db.is_deleted = false
-		)
-		UPDATE sqlite_databases
-		SET forks = new_count.forks - 1
-		FROM new_count, root_db
-		WHERE sqlite_databases.db_id = root_db.id`
-	commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, newName)
+	err = updateForkCount(tx, dbOwner, dbFolder, newName)
 	if err != nil {
-		log.Printf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v\n", dbOwner, dbFolder, dbName,
-			err)
 		return err
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected (spot 2) when updating fork count for database '%s%s%s'\n",
-			numRows, dbOwner, dbFolder, dbName)
-	}
 
 	// Commit the transaction
 	err = tx.Commit()
@@ -909,7 +1514,98 @@ func DeleteDatabase(dbOwner string, dbFolder string, dbName string) error {
 	}
 
 	// Log the database deletion
-	log.Printf("(Forked) database '%s%s%s' deleted\n", dbOwner, dbFolder, dbName)
+	log.Printf("Database '%s%s%s' moved to the trash\n", dbOwner, dbFolder, dbName)
+	return nil
+}
+
+// Returns the details of a database in the trash of the given owner.  If there's no such database, the returned
+// DBName field is empty.
+func DeletedDatabase(dbOwner string, dbID int64) (entry TrashEntry, err error) {
+	dbQuery := `
+		SELECT u.user_name, db.folder, t.db_name, t.public, t.date_deleted
+		FROM database_trash AS t, sqlite_databases AS db, users AS u
+		WHERE t.db_id = $2
+			AND db.db_id = t.db_id
+			AND u.user_id = db.user_id
+			AND lower(u.user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbID).Scan(&entry.Owner, &entry.Folder, &entry.DBName, &entry.Public,
+		&entry.DateDeleted)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// Not in the trash
+			return TrashEntry{}, nil
+		}
+		log.Printf("Retrieving deleted database '%d' of '%s' failed: %v\n", dbID, dbOwner, err)
+		return
+	}
+	entry.DBID = dbID
+	entry.Expires = entry.DateDeleted.AddDate(0, 0, Conf.Trash.Retention)
+	return
+}
+
+// Returns the databases in the trash of a user, including those of organisations they're an owner of.
+func DeletedDatabases(userName string) (list []TrashEntry, err error) {
+	dbQuery := `
+		SELECT u.user_name, db.folder, t.db_id, t.db_name, t.public, t.date_deleted
+		FROM database_trash AS t, sqlite_databases AS db, users AS u
+		WHERE db.db_id = t.db_id
+			AND u.user_id = db.user_id
+			AND (lower(u.user_name) = lower($1) OR db.user_id IN (
+				SELECT org_id
+				FROM org_members
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND role = $2
+				)
+			)
+		ORDER BY t.date_deleted DESC`
+	rows, err := pdb.Query(dbQuery, userName, string(ORG_OWNER))
+	if err != nil {
+		log.Printf("Retrieving deleted databases for user '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TrashEntry
+		err = rows.Scan(&t.Owner, &t.Folder, &t.DBID, &t.DBName, &t.Public, &t.DateDeleted)
+		if err != nil {
+			log.Printf("Error retrieving deleted databases for user '%s': %v\n", userName, err)
+			return
+		}
+		t.Expires = t.DateDeleted.AddDate(0, 0, Conf.Trash.Retention)
+		list = append(list, t)
+	}
+	return
+}
+
+// Removes a discussion label from a database.  It's also removed from any discussions or MRs using it.
+func DeleteDiscussionLabel(dbOwner string, dbFolder string, dbName string, labelID int64) error {
+	dbQuery := `
+		DELETE FROM discussion_labels
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND label_id = $4`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, labelID)
+	if err != nil {
+		log.Printf("Deleting label '%d' from database '%s%s%s' failed: %v\n", labelID, dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return errors.New("Unknown label")
+	}
 	return nil
 }
 
@@ -1039,391 +1735,196 @@ func DeleteLicence(userName string, licenceName string) (err error) {
 	return nil
 }
 
-// Disconnects the PostgreSQL database connection.
-func DisconnectPostgreSQL() {
-	pdb.Close()
-
-	// Log successful disconnection
-	log.Printf("Disconnected from PostgreSQL server: %v:%v\n", Conf.Pg.Server, uint16(Conf.Pg.Port))
-}
-
-// Returns the list of discussions or MRs for a given database.
-// If a non-0 discID value is passed, it will only return the details for that specific discussion/MR.  Otherwise it
-// will return a list of all discussions or MRs for a given database
-// Note - This returns a slice of DiscussionEntry, instead of a map.  We use a slice because it lets us use an ORDER
-//        BY clause in the SQL and preserve the returned order (maps don't preserve order).  If in future we no longer
-//        need to preserve the order, it might be useful to switch to using a map instead since they're often simpler
-//        to work with.
-func Discussions(dbOwner string, dbFolder string, dbName string, discType DiscussionType, discID int) (list []DiscussionEntry, err error) {
+// Removes a milestone from a database.  Any discussions or MRs in it are left without a milestone.
+func DeleteMilestone(dbOwner string, dbFolder string, dbName string, milestoneID int64) error {
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND db.folder = $2
-				AND db.db_name = $3)
-		SELECT disc.disc_id, disc.title, disc.open, disc.date_created, users.user_name, users.email, users.avatar_url,
-			disc.description, last_modified, comment_count, mr_source_db_id, mr_source_db_branch,
-			mr_destination_branch, mr_state, mr_commits
-		FROM discussions AS disc, d, users
-		WHERE disc.db_id = d.db_id
-			AND disc.discussion_type = $4
-			AND disc.creator = users.user_id`
-	if discID != 0 {
-		dbQuery += fmt.Sprintf(`
-			AND disc_id = %d`, discID)
-	}
-	dbQuery += `
-		ORDER BY last_modified DESC`
-	var rows *pgx.Rows
-	rows, err = pdb.Query(dbQuery, dbOwner, dbFolder, dbName, discType)
+		DELETE FROM milestones
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND milestone_id = $4`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, milestoneID)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
-	}
-	for rows.Next() {
-		var av, em, sb, db pgx.NullString
-		var sdb pgx.NullInt64
-		var oneRow DiscussionEntry
-		err = rows.Scan(&oneRow.ID, &oneRow.Title, &oneRow.Open, &oneRow.DateCreated, &oneRow.Creator, &em, &av,
-			&oneRow.Body, &oneRow.LastModified, &oneRow.CommentCount, &sdb, &sb, &db, &oneRow.MRDetails.State,
-			&oneRow.MRDetails.Commits)
-		if err != nil {
-			log.Printf("Error retrieving discussion/MR list for database '%s%s%s': %v\n", dbOwner, dbFolder,
-				dbName, err)
-			rows.Close()
-			return
-		}
-		if av.Valid {
-			oneRow.AvatarURL = av.String
-		} else {
-			// If no avatar URL is presently stored, default to a gravatar based on the users email (if known)
-			if em.Valid {
-				picHash := md5.Sum([]byte(em.String))
-				oneRow.AvatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon&s=30", picHash)
-			}
-		}
-		if discType == MERGE_REQUEST && sdb.Valid {
-			oneRow.MRDetails.SourceDBID = sdb.Int64
-		}
-		if sb.Valid {
-			oneRow.MRDetails.SourceBranch = sb.String
-		}
-		if db.Valid {
-			oneRow.MRDetails.DestBranch = db.String
-		}
-		oneRow.BodyRendered = string(gfm.Markdown([]byte(oneRow.Body)))
-		list = append(list, oneRow)
+		log.Printf("Deleting milestone '%d' from database '%s%s%s' failed: %v\n", milestoneID, dbOwner, dbFolder,
+			dbName, err)
+		return err
 	}
-
-	// For merge requests, turn the source database ID's into full owner/folder/name strings
-	if discType == MERGE_REQUEST {
-		for i, j := range list {
-			// Retrieve the owner/folder/name for a database id
-			dbQuery = `
-				SELECT users.user_name, db.folder, db.db_name
-				FROM sqlite_databases AS db, users
-				WHERE db.db_id = $1
-					AND db.user_id = users.user_id`
-			var o, f, n pgx.NullString
-			err2 := pdb.QueryRow(dbQuery, j.MRDetails.SourceDBID).Scan(&o, &f, &n)
-			if err2 != nil && err2 != pgx.ErrNoRows {
-				log.Printf("Retrieving source database owner/folder/name failed: %v\n", err)
-				return
-			}
-			if o.Valid {
-				list[i].MRDetails.SourceOwner = o.String
-			}
-			if f.Valid {
-				list[i].MRDetails.SourceFolder = f.String
-			}
-			if n.Valid {
-				list[i].MRDetails.SourceDBName = n.String
-			}
-		}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return errors.New("Unknown milestone")
 	}
-
-	rows.Close()
-	return
+	return nil
 }
 
-// Returns the list of comments for a given discussion.
-// If a non-0 comID value is passed, it will only return the details for that specific comment in the discussion.
-// Otherwise it will return a list of all comments for a given discussion
-// Note - This returns a slice instead of a map.  We use a slice because it lets us use an ORDER BY clause in the SQL
-//        and preserve the returned order (maps don't preserve order).  If in future we no longer need to preserve the
-//        order, it might be useful to switch to using a map instead since they're often simpler to work with.
-func DiscussionComments(dbOwner string, dbFolder string, dbName string, discID int, comID int) (list []DiscussionCommentEntry, err error) {
+// Removes a user from an organisation, including from all of the organisation's teams.
+func DeleteOrgMember(orgName string, userName string) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Remove the user from the organisation's teams
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND db.folder = $2
-				AND db.db_name = $3
-		), int AS (
-				SELECT internal_id AS int_id
-				FROM discussions
-				WHERE db_id = (SELECT db_id FROM d)
-				AND disc_id = $4
+		DELETE FROM org_team_members
+		WHERE team_id IN (
+				SELECT team_id
+				FROM org_teams
+				WHERE org_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+							AND is_org = true
+					)
 			)
-		SELECT com.com_id, users.user_name, users.email, users.avatar_url, com.date_created, com.body, com.entry_type
-		FROM discussion_comments AS com, d, users
-		WHERE com.db_id = d.db_id
-			AND com.disc_id = (SELECT int_id FROM int)
-			AND com.commenter = users.user_id`
-	if comID != 0 {
-		dbQuery += fmt.Sprintf(`
-			AND com.com_id = %d`, comID)
-	}
-	dbQuery += `
-		ORDER BY date_created ASC`
-	var rows *pgx.Rows
-	rows, err = pdb.Query(dbQuery, dbOwner, dbFolder, dbName, discID)
+			AND user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)`
+	_, err = tx.Exec(dbQuery, orgName, userName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
+		log.Printf("Removing '%s' from the teams of organisation '%s' failed: %v\n", userName, orgName, err)
+		return err
 	}
-	for rows.Next() {
-		var av, em pgx.NullString
-		var oneRow DiscussionCommentEntry
-		err = rows.Scan(&oneRow.ID, &oneRow.Commenter, &em, &av, &oneRow.DateCreated, &oneRow.Body, &oneRow.EntryType)
-		if err != nil {
-			log.Printf("Error retrieving comment list for database '%s%s%s', discussion '%d': %v\n", dbOwner,
-				dbFolder, dbName, discID, err)
-			rows.Close()
-			return
-		}
-
-		if av.Valid {
-			oneRow.AvatarURL = av.String
-		} else {
-			if em.Valid {
-				picHash := md5.Sum([]byte(em.String))
-				oneRow.AvatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon&s=30", picHash)
-			}
-		}
 
-		oneRow.BodyRendered = string(gfm.Markdown([]byte(oneRow.Body)))
-		list = append(list, oneRow)
+	// Remove the user from the organisation
+	dbQuery = `
+		DELETE FROM org_members
+		WHERE org_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
+			)
+			AND user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)`
+	commandTag, err := tx.Exec(dbQuery, orgName, userName)
+	if err != nil {
+		log.Printf("Removing '%s' from organisation '%s' failed: %v\n", userName, orgName, err)
+		return err
 	}
-	rows.Close()
-	return
-}
-
-// Periodically flushes the database view count from memcache to PostgreSQL
-func FlushViewCount() {
-	type dbEntry struct {
-		Owner  string
-		Folder string
-		Name   string
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing '%s' from organisation '%s'\n", numRows,
+			userName, orgName)
 	}
 
-	// Log the start of the loop
-	log.Printf("Periodic view count flushing loop started.  %d second refresh.\n",
-		Conf.Memcache.ViewCountFlushDelay)
-
-	// Start the endless flush loop
-	var rows *pgx.Rows
-	var err error
-	for true {
-		// Retrieve the list of all public databases
-		dbQuery := `
-			SELECT users.user_name, db.folder, db.db_name
-			FROM sqlite_databases AS db, users
-			WHERE db.public = true
-				AND db.is_deleted = false
-				AND db.user_id = users.user_id`
-		rows, err = pdb.Query(dbQuery)
-		if err != nil {
-			log.Printf("Database query failed: %v\n", err)
-			return
-		}
-		var dbList []dbEntry
-		for rows.Next() {
-			var oneRow dbEntry
-			err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.Name)
-			if err != nil {
-				log.Printf("Error retrieving database list for view count flush thread: %v\n", err)
-				rows.Close()
-				return
-			}
-			dbList = append(dbList, oneRow)
-		}
-		rows.Close()
-
-		// For each public database, retrieve the latest view count from memcache and save it back to PostgreSQL
-		for _, db := range dbList {
-			dbOwner := db.Owner
-			dbFolder := db.Folder
-			dbName := db.Name
-
-			// Retrieve the view count from Memcached
-			newValue, err := GetViewCount(dbOwner, dbFolder, dbName)
-			if err != nil {
-				log.Printf("Error when getting memcached view count for %s%s%s: %s\n", dbOwner, dbFolder, dbName,
-					err.Error())
-				continue
-			}
-
-			// We use a value of -1 to indicate there wasn't an entry in memcache for the database
-			if newValue != -1 {
-				// Update the view count in PostgreSQL
-				dbQuery = `
-					UPDATE sqlite_databases
-					SET page_views = $4
-					WHERE user_id = (
-							SELECT user_id
-							FROM users
-							WHERE lower(user_name) = lower($1)
-						)
-						AND folder = $2
-						AND db_name = $3`
-				commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, newValue)
-				if err != nil {
-					log.Printf("Flushing view count for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
-					continue
-				}
-				if numRows := commandTag.RowsAffected(); numRows != 1 {
-					log.Printf("Wrong number of rows affected (%v) when flushing view count for '%s%s%s'\n",
-						numRows, dbOwner, dbFolder, dbName)
-					continue
-				}
-			}
-		}
-
-		// Wait before running the loop again
-		time.Sleep(Conf.Memcache.ViewCountFlushDelay * time.Second)
-	}
-	return
+	// Commit the transaction
+	return tx.Commit()
 }
 
-// Fork the PostgreSQL entry for a SQLite database from one user to another
-func ForkDatabase(srcOwner string, dbFolder string, dbName string, dstOwner string) (newForkCount int, err error) {
-	// Copy the main database entry
+// Deletes a team from an organisation.
+func DeleteTeam(orgName string, teamName string) error {
 	dbQuery := `
-		WITH dst_u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		)
-		INSERT INTO sqlite_databases (user_id, folder, db_name, public, forks, one_line_description, full_description,
-			branches, contributors, root_database, default_table, source_url, commit_list, branch_heads, tags,
-			default_branch, forked_from)
-		SELECT dst_u.user_id, folder, db_name, public, 0, one_line_description, full_description, branches,
-			contributors, root_database, default_table, source_url, commit_list, branch_heads, tags, default_branch,
-			db_id
-		FROM sqlite_databases, dst_u
-		WHERE sqlite_databases.user_id = (
+		DELETE FROM org_teams
+		WHERE org_id = (
 				SELECT user_id
 				FROM users
-				WHERE lower(user_name) = lower($2)
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
 			)
-			AND folder = $3
-			AND db_name = $4`
-	commandTag, err := pdb.Exec(dbQuery, dstOwner, srcOwner, dbFolder, dbName)
+			AND team_name = $2`
+	commandTag, err := pdb.Exec(dbQuery, orgName, teamName)
 	if err != nil {
-		log.Printf("Forking database '%s%s%s' in PostgreSQL failed: %v\n", srcOwner, dbFolder, dbName, err)
-		return 0, err
+		log.Printf("Deleting team '%s' of organisation '%s' failed: %v\n", teamName, orgName, err)
+		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows affected (%d) when forking main database entry: "+
-			"'%s%s%s' to '%s%s%s'\n", numRows, srcOwner, dbFolder, dbName, dstOwner, dbFolder, dbName)
+		log.Printf("Wrong number of rows (%v) affected when deleting team '%s' of organisation '%s'\n", numRows,
+			teamName, orgName)
 	}
+	return nil
+}
 
-	// Update the fork count for the root database
-	dbQuery = `
-		WITH root_db AS (
-			SELECT root_database AS id
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
-		), new_count AS (
-			SELECT count(*) AS forks
-			FROM sqlite_databases AS db, root_db
-			WHERE db.root_database = root_db.id
-			AND db.is_deleted = false
-		)
-		UPDATE sqlite_databases
-		SET forks = new_count.forks - 1
-		FROM new_count, root_db
-		WHERE sqlite_databases.db_id = root_db.id
-		RETURNING new_count.forks - 1`
-	err = pdb.QueryRow(dbQuery, dstOwner, dbFolder, dbName).Scan(&newForkCount)
+// Takes away an organisation team's access to one of the organisation's databases.
+func DeleteTeamDatabase(orgName string, teamName string, dbFolder string, dbName string) error {
+	dbQuery := `
+		DELETE FROM org_team_databases
+		WHERE team_id = (
+				SELECT team_id
+				FROM org_teams
+				WHERE org_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+							AND is_org = true
+					)
+					AND team_name = $2
+			)
+			AND db_id = (
+				SELECT db.db_id
+				FROM sqlite_databases AS db, users AS u
+				WHERE db.user_id = u.user_id
+					AND lower(u.user_name) = lower($1)
+					AND db.folder = $3
+					AND db.db_name = $4
+					AND db.is_deleted = false
+			)`
+	commandTag, err := pdb.Exec(dbQuery, orgName, teamName, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Updating fork count in PostgreSQL failed: %v\n", err)
-		return 0, err
+		log.Printf("Removing database '%s%s%s' from team '%s' failed: %v\n", orgName, dbFolder, dbName, teamName,
+			err)
+		return err
 	}
-	return newForkCount, nil
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing database '%s%s%s' from team '%s'\n", numRows,
+			orgName, dbFolder, dbName, teamName)
+	}
+	return nil
 }
 
-// Checks if the given database was forked from another, and if so returns that one's owner, folder and database name
-func ForkedFrom(dbOwner string, dbFolder string, dbName string) (forkOwn string, forkFol string, forkDB string,
-	forkDel bool, err error) {
-	// Check if the database was forked from another
-	var dbID, forkedFrom pgx.NullInt64
+// Removes a user from an organisation's team.
+func DeleteTeamMember(orgName string, teamName string, userName string) error {
 	dbQuery := `
-		SELECT db_id, forked_from
-		FROM sqlite_databases
-		WHERE user_id = (
+		DELETE FROM org_team_members
+		WHERE team_id = (
+				SELECT team_id
+				FROM org_teams
+				WHERE org_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+							AND is_org = true
+					)
+					AND team_name = $2
+			)
+			AND user_id = (
 				SELECT user_id
 				FROM users
-				WHERE lower(user_name) = lower($1))
-			AND folder = $2
-			AND db_name = $3`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&dbID, &forkedFrom)
-	if err != nil {
-		log.Printf("Error checking if database was forked from another '%s%s%s'. Error: %v\n", dbOwner,
-			dbFolder, dbName, err)
-		return "", "", "", false, err
-	}
-	if !forkedFrom.Valid {
-		// The database wasn't forked, so return empty strings
-		return "", "", "", false, nil
-	}
-
-	// Return the details of the database this one was forked from
-	dbQuery = `
-		SELECT u.user_name, db.folder, db.db_name, db.is_deleted
-		FROM users AS u, sqlite_databases AS db
-		WHERE db.db_id = $1
-			AND u.user_id = db.user_id`
-	err = pdb.QueryRow(dbQuery, forkedFrom).Scan(&forkOwn, &forkFol, &forkDB, &forkDel)
+				WHERE lower(user_name) = lower($3)
+			)`
+	commandTag, err := pdb.Exec(dbQuery, orgName, teamName, userName)
 	if err != nil {
-		log.Printf("Error retrieving forked database information for '%s%s%s'. Error: %v\n", dbOwner,
-			dbFolder, dbName, err)
-		return "", "", "", false, err
+		log.Printf("Removing '%s' from team '%s' of organisation '%s' failed: %v\n", userName, teamName, orgName,
+			err)
+		return err
 	}
-
-	// If the database this one was forked from has been deleted, indicate that and clear the database name value
-	if forkDel {
-		forkDB = ""
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing '%s' from team '%s' of organisation '%s'\n",
+			numRows, userName, teamName, orgName)
 	}
-	return forkOwn, forkFol, forkDB, forkDel, nil
+	return nil
 }
 
-// Return the parent of a database, if there is one (and it's accessible to the logged in user).  If no parent was
-// found, the returned Owner/Folder/DBName values will be empty strings
-func ForkParent(loggedInUser string, dbOwner string, dbFolder string, dbName string) (parentOwner string,
-	parentFolder string, parentDBName string, err error) {
+// Removes the pending transfer offer (if any) for a database.
+func DeleteTransferOffer(dbOwner string, dbFolder string, dbName string) error {
 	dbQuery := `
-		SELECT users.user_name, db.folder, db.db_name, db.public, db.db_id, db.forked_from, db.is_deleted
-		FROM sqlite_databases AS db, users
-		WHERE db.root_database = (
-				SELECT root_database
+		DELETE FROM database_transfers
+		WHERE db_id = (
+				SELECT db_id
 				FROM sqlite_databases
 				WHERE user_id = (
 						SELECT user_id
@@ -1432,77 +1933,145 @@ func ForkParent(loggedInUser string, dbOwner string, dbFolder string, dbName str
 					)
 					AND folder = $2
 					AND db_name = $3
-				)
-			AND db.user_id = users.user_id
-		ORDER BY db.forked_from NULLS FIRST`
-	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
+					AND is_deleted = false
+			)`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
+		log.Printf("Removing transfer offer for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
 	}
-	defer rows.Close()
-	dbList := make(map[int]ForkEntry)
-	for rows.Next() {
-		var frk pgx.NullInt64
-		var oneRow ForkEntry
-		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.Public, &oneRow.ID, &frk, &oneRow.Deleted)
-		if err != nil {
-			log.Printf("Error retrieving fork parent for '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
-				err)
-			return
-		}
-		if frk.Valid {
-			oneRow.ForkedFrom = int(frk.Int64)
-		}
-		dbList[oneRow.ID] = oneRow
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing transfer offer for database '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
 	}
+	return nil
+}
 
-	// Safety check
-	numResults := len(dbList)
-	if numResults == 0 {
-		err = fmt.Errorf("Empty list returned instead of fork tree.  This shouldn't happen")
+// Removes a database file from Minio, along with its database_files entry, unless it's still used by any commit of any
+// database (including deleted ones).  The database_files entry is locked while this happens.  New uploads of the same
+// file lock it too before storing the file, so a file being uploaded again isn't removed out from under them.
+func DeleteUnusedDatabaseFile(sha string) (removed bool, err error) {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
 		return
 	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
 
-	// Get the ID of the database being called
-	dbID, err := databaseID(dbOwner, dbFolder, dbName)
+	// Lock the entry for the file
+	dbQuery := `
+		SELECT db_sha256
+		FROM database_files
+		WHERE db_sha256 = $1
+		FOR UPDATE`
+	var s string
+	err = tx.QueryRow(dbQuery, sha).Scan(&s)
+	if err == pgx.ErrNoRows {
+		// Nothing to remove
+		return false, nil
+	}
 	if err != nil {
+		log.Printf("Locking the database file entry for '%s' failed: %v\n", sha, err)
 		return
 	}
 
-	// Find the closest (not-deleted) parent for the database
-	dbEntry, ok := dbList[dbID]
-	if !ok {
-		// The database itself wasn't found in the list.  This shouldn't happen
-		err = fmt.Errorf("Internal error when retrieving fork parent info.  This shouldn't happen.")
+	// Check if the file is still in use
+	dbQuery = `
+		SELECT EXISTS (
+			SELECT 1
+			FROM sqlite_databases AS db, jsonb_each(db.commit_list) AS c,
+				jsonb_array_elements(c.value->'tree'->'entries') AS e
+			WHERE e->>'sha256' = $1
+		)`
+	var inUse bool
+	err = tx.QueryRow(dbQuery, sha).Scan(&inUse)
+	if err != nil {
+		log.Printf("Checking if database file '%s' is in use failed: %v\n", sha, err)
 		return
 	}
-	for dbEntry.ForkedFrom != 0 {
-		dbEntry, ok = dbList[dbEntry.ForkedFrom]
-		if !ok {
-			// Parent database entry wasn't found in the list.  This shouldn't happen either
-			err = fmt.Errorf("Internal error when retrieving fork parent info (#2).  This shouldn't happen.")
-			return
-		}
-		if !dbEntry.Deleted {
-			// Found a parent (that's not deleted).  We'll use this and stop looping
-			parentOwner = dbEntry.Owner
-			parentFolder = dbEntry.Folder
-			parentDBName = dbEntry.DBName
-			break
-		}
+	if inUse {
+		return false, nil
 	}
 
-	return
+	// Remove the file, then its entry
+	err = RemoveDatabaseFile(sha)
+	if err != nil {
+		return
+	}
+	dbQuery = `
+		DELETE FROM database_files
+		WHERE db_sha256 = $1`
+	_, err = tx.Exec(dbQuery, sha)
+	if err != nil {
+		log.Printf("Removing database file entry for '%s' failed: %v\n", sha, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return true, nil
 }
 
-// Return the complete fork tree for a given database
-func ForkTree(loggedInUser string, dbOwner string, dbFolder string, dbName string) (outputList []ForkEntry, err error) {
+// Removes the details of an upload session, once it's been finished or has expired.
+func DeleteUploadSession(uploadID string) error {
 	dbQuery := `
-		SELECT users.user_name, db.folder, db.db_name, db.public, db.db_id, db.forked_from, db.is_deleted
-		FROM sqlite_databases AS db, users
-		WHERE db.root_database = (
-				SELECT root_database
+		DELETE FROM upload_sessions
+		WHERE upload_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, uploadID)
+	if err != nil {
+		log.Printf("Removing upload session '%s' failed: %v\n", uploadID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing upload session '%s'\n", numRows, uploadID)
+	}
+	return nil
+}
+
+// Removes a webhook from a database, along with its delivery log.
+func DeleteWebhook(dbOwner string, dbFolder string, dbName string, webhookID int64) error {
+	dbQuery := `
+		DELETE FROM webhooks
+		WHERE webhook_id = $4
+			AND db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1))
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false)`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, webhookID)
+	if err != nil {
+		log.Printf("Deleting webhook '%d' from database '%s%s%s' failed: %v\n", webhookID, dbOwner, dbFolder,
+			dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Unknown webhook '%d'", webhookID)
+	}
+	return nil
+}
+
+// Disconnects the PostgreSQL database connection.
+func DisconnectPostgreSQL() {
+	pdb.Close()
+
+	// Log successful disconnection
+	log.Printf("Disconnected from PostgreSQL server: %v:%v\n", Conf.Pg.Server, uint16(Conf.Pg.Port))
+}
+
+// Returns the discussion labels for a database, in alphabetical order.
+func DiscussionLabels(dbOwner string, dbFolder string, dbName string) (labels []DiscussionLabel, err error) {
+	dbQuery := `
+		SELECT label_id, name, colour, coalesce(description, '')
+		FROM discussion_labels
+		WHERE db_id = (
+				SELECT db_id
 				FROM sqlite_databases
 				WHERE user_id = (
 						SELECT user_id
@@ -1511,1157 +2080,1158 @@ func ForkTree(loggedInUser string, dbOwner string, dbFolder string, dbName strin
 					)
 					AND folder = $2
 					AND db_name = $3
-				)
-			AND db.user_id = users.user_id
-		ORDER BY db.forked_from NULLS FIRST`
+					AND is_deleted = false
+			)
+		ORDER BY lower(name)`
 	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return nil, err
+		log.Printf("Retrieving labels for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
 	}
 	defer rows.Close()
-	var dbList []ForkEntry
 	for rows.Next() {
-		var frk pgx.NullInt64
-		var oneRow ForkEntry
-		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.Public, &oneRow.ID, &frk, &oneRow.Deleted)
+		var l DiscussionLabel
+		err = rows.Scan(&l.ID, &l.Name, &l.Colour, &l.Description)
 		if err != nil {
-			log.Printf("Error retrieving fork list for '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
-				err)
-			return nil, err
-		}
-		if frk.Valid {
-			oneRow.ForkedFrom = int(frk.Int64)
+			log.Printf("Error retrieving labels for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+			return
 		}
-		dbList = append(dbList, oneRow)
+		labels = append(labels, l)
 	}
+	return
+}
 
-	// Safety checks
-	numResults := len(dbList)
-	if numResults == 0 {
-		return nil, errors.New("Empty list returned instead of fork tree.  This shouldn't happen")
-	}
-	if dbList[0].ForkedFrom != 0 {
-		// The first entry has a non-zero forked_from field, indicating it's not the root entry.  That
-		// shouldn't happen, so return an error.
-		return nil, errors.New("Incorrect root entry data in retrieved database list")
+// Returns whether a discussion or merge request is open.
+func DiscussionOpen(dbOwner string, dbFolder string, dbName string, discID int) (open bool, err error) {
+	dbQuery := `
+		SELECT open
+		FROM discussions
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND disc_id = $4`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID).Scan(&open)
+	if err != nil {
+		log.Printf("Retrieving open state of discussion '%d' for '%s%s%s' failed: %v\n", discID, dbOwner,
+			dbFolder, dbName, err)
+		return false, err
 	}
+	return
+}
 
-	// * Process the root entry *
-
-	var iconDepth int
-	var forkTrail []int
-
-	// Set the root database ID
-	rootID := dbList[0].ID
-
-	// Set the icon list for display in the browser
-	dbList[0].IconList = append(dbList[0].IconList, ROOT)
+// Returns the list of discussions or MRs for a given database.
+// If a non-0 discID value is passed, it will only return the details for that specific discussion/MR.  Otherwise it
+// will return a list of all discussions or MRs for a given database
+// Note - This returns a slice of DiscussionEntry, instead of a map.  We use a slice because it lets us use an ORDER
+//        BY clause in the SQL and preserve the returned order (maps don't preserve order).  If in future we no longer
+//        need to preserve the order, it might be useful to switch to using a map instead since they're often simpler
+//        to work with.
+func Discussions(dbOwner string, dbFolder string, dbName string, discType DiscussionType, discID int) (list []DiscussionEntry, err error) {
+	return FilteredDiscussions(dbOwner, dbFolder, dbName, discType, discID, DiscussionFilter{})
+}
 
-	// If the root database is no longer public, then use placeholder details instead
-	if !dbList[0].Public && (strings.ToLower(dbList[0].Owner) != strings.ToLower(loggedInUser)) {
-		dbList[0].DBName = "private database"
+// Fills in the labels, assignees, and milestone for a list of discussions or MRs.
+func discussionTracking(dbOwner string, dbFolder string, dbName string, list []DiscussionEntry) error {
+	if len(list) == 0 {
+		return nil
 	}
-
-	// If the root database is deleted, use a placeholder indicating that instead
-	if dbList[0].Deleted {
-		dbList[0].DBName = "deleted database"
+	ids := make([]int32, len(list))
+	pos := make(map[int]int)
+	for i, j := range list {
+		ids[i] = int32(j.ID)
+		pos[j.ID] = i
+		list[i].Assignees = []string{}
+		list[i].Labels = []DiscussionLabel{}
 	}
 
-	// Append this completed database line to the output list
-	outputList = append(outputList, dbList[0])
-
-	// Append the root database ID to the fork trail
-	forkTrail = append(forkTrail, rootID)
-
-	// Mark the root database entry as processed
-	dbList[0].Processed = true
-
-	// Increment the icon depth
-	iconDepth = 1
-
-	// * Sort the remaining entries for correct display *
-	numUnprocessedEntries := numResults - 1
-	for numUnprocessedEntries > 0 {
-		var forkFound bool
-		outputList, forkTrail, forkFound = nextChild(loggedInUser, &dbList, &outputList, &forkTrail, iconDepth)
-		if forkFound {
-			numUnprocessedEntries--
-			iconDepth++
-
-			// Add stems and branches to the output icon list
-			numOutput := len(outputList)
-
-			myID := outputList[numOutput-1].ID
-			myForkedFrom := outputList[numOutput-1].ForkedFrom
-
-			// Scan through the earlier output list for any sibling entries
-			var siblingFound bool
-			for i := numOutput; i > 0 && siblingFound == false; i-- {
-				thisID := outputList[i-1].ID
-				thisForkedFrom := outputList[i-1].ForkedFrom
-
-				if thisForkedFrom == myForkedFrom && thisID != myID {
-					// Sibling entry found
-					siblingFound = true
-					sibling := outputList[i-1]
-
-					// Change the last sibling icon to a branch icon
-					sibling.IconList[iconDepth-1] = BRANCH
-
-					// Change appropriate spaces to stems in the output icon list
-					for l := numOutput - 1; l > i; l-- {
-						thisEntry := outputList[l-1]
-						if thisEntry.IconList[iconDepth-1] == SPACE {
-							thisEntry.IconList[iconDepth-1] = STEM
-						}
-					}
-				}
-			}
-		} else {
-			// No child was found, so remove an entry from the fork trail then continue looping
-			forkTrail = forkTrail[:len(forkTrail)-1]
-
-			iconDepth--
-		}
-	}
-
-	return outputList, nil
-}
-
-func GetActivityStats() (stats ActivityStats, err error) {
-	// Retrieve a list of which databases are the most starred
+	// Labels
 	dbQuery := `
-		WITH most_starred AS (
-			SELECT s.db_id, COUNT(s.db_id), max(s.date_starred)
-			FROM database_stars AS s, sqlite_databases AS db
-			WHERE s.db_id = db.db_id
-				AND db.public = true
-				AND db.is_deleted = false
-			GROUP BY s.db_id
-			ORDER BY count DESC
-			LIMIT 5
-		)
-		SELECT users.user_name, db.db_name, stars.count
-		FROM most_starred AS stars, sqlite_databases AS db, users
-		WHERE stars.db_id = db.db_id
-			AND users.user_id = db.user_id
-		ORDER BY count DESC, max ASC`
-	starRows, err := pdb.Query(dbQuery)
-	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
-	}
-	defer starRows.Close()
-	for starRows.Next() {
-		var oneRow ActivityRow
-		err = starRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
-		if err != nil {
-			log.Printf("Error retrieving list of most starred databases: %v\n", err)
-			return
-		}
-		stats.Starred = append(stats.Starred, oneRow)
-	}
-
-	// Retrieve a list of which databases are the most forked
-	dbQuery = `
-		SELECT users.user_name, db.db_name, db.forks
-		FROM sqlite_databases AS db, users
-		WHERE db.forks > 0
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.forks DESC, db.last_modified
-		LIMIT 5`
-	forkRows, err := pdb.Query(dbQuery)
+		SELECT m.disc_id, l.label_id, l.name, l.colour, coalesce(l.description, '')
+		FROM discussion_label_map AS m, discussion_labels AS l
+		WHERE m.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND m.disc_id = ANY($4)
+			AND l.label_id = m.label_id
+		ORDER BY lower(l.name)`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName, ids)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
+		log.Printf("Retrieving discussion labels for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName,
+			err)
+		return err
 	}
-	defer forkRows.Close()
-	for forkRows.Next() {
-		var oneRow ActivityRow
-		err = forkRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+	for rows.Next() {
+		var discID int
+		var l DiscussionLabel
+		err = rows.Scan(&discID, &l.ID, &l.Name, &l.Colour, &l.Description)
 		if err != nil {
-			log.Printf("Error retrieving list of most forked databases: %v\n", err)
-			return
+			log.Printf("Error retrieving discussion labels for database '%s%s%s': %v\n", dbOwner, dbFolder,
+				dbName, err)
+			rows.Close()
+			return err
 		}
-		stats.Forked = append(stats.Forked, oneRow)
+		list[pos[discID]].Labels = append(list[pos[discID]].Labels, l)
 	}
+	rows.Close()
 
-	// Retrieve a list of the most recent uploads
+	// Assignees
 	dbQuery = `
-		SELECT user_name, db.db_name, db.last_modified
-		FROM sqlite_databases AS db, users
-		WHERE db.forked_from IS NULL
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.last_modified DESC
-		LIMIT 5`
-	upRows, err := pdb.Query(dbQuery)
+		SELECT a.disc_id, u.user_name
+		FROM discussion_assignees AS a, users AS u
+		WHERE a.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND a.disc_id = ANY($4)
+			AND u.user_id = a.user_id
+		ORDER BY lower(u.user_name)`
+	rows, err = pdb.Query(dbQuery, dbOwner, dbFolder, dbName, ids)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
+		log.Printf("Retrieving discussion assignees for database '%s%s%s' failed: %v\n", dbOwner, dbFolder,
+			dbName, err)
+		return err
 	}
-	defer upRows.Close()
-	for upRows.Next() {
-		var oneRow UploadRow
-		err = upRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.UploadDate)
+	for rows.Next() {
+		var discID int
+		var userName string
+		err = rows.Scan(&discID, &userName)
 		if err != nil {
-			log.Printf("Error retrieving list of most recent uploads: %v\n", err)
-			return
+			log.Printf("Error retrieving discussion assignees for database '%s%s%s': %v\n", dbOwner, dbFolder,
+				dbName, err)
+			rows.Close()
+			return err
 		}
-		stats.Uploads = append(stats.Uploads, oneRow)
+		list[pos[discID]].Assignees = append(list[pos[discID]].Assignees, userName)
 	}
+	rows.Close()
 
-	// Retrieve a list of which databases have been downloaded the most times by someone other than their owner
+	// Milestones
 	dbQuery = `
-		SELECT users.user_name, db.db_name, db.download_count
-		FROM sqlite_databases AS db, users
-		WHERE db.download_count > 0
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.download_count DESC, db.last_modified
-		LIMIT 5`
-	dlRows, err := pdb.Query(dbQuery)
+		SELECT dm.disc_id, ms.milestone_id, ms.title
+		FROM discussion_milestones AS dm, milestones AS ms
+		WHERE dm.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND dm.disc_id = ANY($4)
+			AND ms.milestone_id = dm.milestone_id`
+	rows, err = pdb.Query(dbQuery, dbOwner, dbFolder, dbName, ids)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
+		log.Printf("Retrieving discussion milestones for database '%s%s%s' failed: %v\n", dbOwner, dbFolder,
+			dbName, err)
+		return err
 	}
-	defer dlRows.Close()
-	for dlRows.Next() {
-		var oneRow ActivityRow
-		err = dlRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+	defer rows.Close()
+	for rows.Next() {
+		var discID int
+		var milestoneID int64
+		var title string
+		err = rows.Scan(&discID, &milestoneID, &title)
 		if err != nil {
-			log.Printf("Error retrieving list of most downloaded databases: %v\n", err)
-			return
+			log.Printf("Error retrieving discussion milestones for database '%s%s%s': %v\n", dbOwner, dbFolder,
+				dbName, err)
+			return err
 		}
-		stats.Downloads = append(stats.Downloads, oneRow)
+		list[pos[discID]].Milestone = title
+		list[pos[discID]].MilestoneID = milestoneID
 	}
+	return nil
+}
 
-	// Retrieve the list of databases which have been viewed the most times
-	dbQuery = `
-		SELECT users.user_name, db.db_name, db.page_views
-		FROM sqlite_databases AS db, users
-		WHERE db.page_views > 0
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.page_views DESC, db.last_modified
-		LIMIT 5`
-	viewRows, err := pdb.Query(dbQuery)
+// Returns the type (discussion or merge request) of each discussion ID used by a database.
+func DiscussionTypes(dbOwner string, dbFolder string, dbName string) (types map[int]DiscussionType, err error) {
+	dbQuery := `
+		SELECT disc_id, discussion_type
+		FROM discussions
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		log.Printf("Retrieving discussion types for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
 		return
 	}
-	defer viewRows.Close()
-	for viewRows.Next() {
-		var oneRow ActivityRow
-		err = viewRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+	defer rows.Close()
+	types = make(map[int]DiscussionType)
+	for rows.Next() {
+		var discID int
+		var discType int64
+		err = rows.Scan(&discID, &discType)
 		if err != nil {
-			log.Printf("Error retrieving list of most viewed databases: %v\n", err)
-			return
+			log.Printf("Error retrieving discussion types for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
+				err)
+			return nil, err
 		}
-		stats.Viewed = append(stats.Viewed, oneRow)
+		types[discID] = DiscussionType(discType)
 	}
 	return
 }
 
-// Load the branch heads for a database.
-// TODO: It might be better to have the default branch name be returned as part of this list, by indicating in the list
-// TODO  which of the branches is the default.
-func GetBranches(dbOwner string, dbFolder string, dbName string) (branches map[string]BranchEntry, err error) {
-	dbQuery := `
-		SELECT db.branch_heads
-		FROM sqlite_databases AS db
-		WHERE db.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND db.folder = $2
-			AND db.db_name = $3`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&branches)
-	if err != nil {
-		log.Printf("Error when retrieving branch heads for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
-			err)
-		return nil, err
-	}
-	return branches, nil
-}
-
-// Retrieves the full commit list for a database.
-func GetCommitList(dbOwner string, dbFolder string, dbName string) (map[string]CommitEntry, error) {
+// Returns the list of discussions or MRs for a given database which match a filter, in the order it asks for.  The
+// discID value works the same as for Discussions().
+func FilteredDiscussions(dbOwner string, dbFolder string, dbName string, discType DiscussionType, discID int,
+	filter DiscussionFilter) (list []DiscussionEntry, err error) {
 	dbQuery := `
 		WITH u AS (
 			SELECT user_id
 			FROM users
 			WHERE lower(user_name) = lower($1)
-		)
-		SELECT commit_list as commits
-		FROM sqlite_databases AS db, u
-		WHERE db.user_id = u.user_id
-			AND db.folder = $2
-			AND db.db_name = $3
-			AND db.is_deleted = false`
-	var l map[string]CommitEntry
-	err := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&l)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND db.folder = $2
+				AND db.db_name = $3)
+		SELECT disc.disc_id, disc.title, disc.open, disc.date_created, users.user_name, users.email, users.avatar_url,
+			disc.description, last_modified, comment_count, mr_source_db_id, mr_source_db_branch,
+			mr_destination_branch, mr_state, mr_commits
+		FROM discussions AS disc, d, users
+		WHERE disc.db_id = d.db_id
+			AND disc.discussion_type = $4
+			AND disc.creator = users.user_id`
+	if discID != 0 {
+		dbQuery += fmt.Sprintf(`
+			AND disc_id = %d`, discID)
+	}
+	args := []interface{}{dbOwner, dbFolder, dbName, discType}
+	switch filter.State {
+	case "open":
+		dbQuery += `
+			AND disc.open = true`
+	case "closed":
+		dbQuery += `
+			AND disc.open = false`
+	}
+	if filter.Label != "" {
+		args = append(args, filter.Label)
+		dbQuery += fmt.Sprintf(`
+			AND EXISTS (
+				SELECT 1
+				FROM discussion_label_map AS m, discussion_labels AS l
+				WHERE m.db_id = disc.db_id
+					AND m.disc_id = disc.disc_id
+					AND l.label_id = m.label_id
+					AND lower(l.name) = lower($%d)
+			)`, len(args))
+	}
+	if filter.Assignee != "" {
+		args = append(args, filter.Assignee)
+		dbQuery += fmt.Sprintf(`
+			AND EXISTS (
+				SELECT 1
+				FROM discussion_assignees AS a, users AS au
+				WHERE a.db_id = disc.db_id
+					AND a.disc_id = disc.disc_id
+					AND au.user_id = a.user_id
+					AND lower(au.user_name) = lower($%d)
+			)`, len(args))
+	}
+	if filter.Milestone != "" {
+		args = append(args, filter.Milestone)
+		dbQuery += fmt.Sprintf(`
+			AND EXISTS (
+				SELECT 1
+				FROM discussion_milestones AS dm, milestones AS ms
+				WHERE dm.db_id = disc.db_id
+					AND dm.disc_id = disc.disc_id
+					AND ms.milestone_id = dm.milestone_id
+					AND lower(ms.title) = lower($%d)
+			)`, len(args))
+	}
+	switch filter.Sort {
+	case SORT_CREATED:
+		dbQuery += `
+		ORDER BY disc.date_created DESC`
+	case SORT_COMMENTS:
+		dbQuery += `
+		ORDER BY comment_count DESC, last_modified DESC`
+	default:
+		dbQuery += `
+		ORDER BY last_modified DESC`
+	}
+	// The known discussion IDs are used for linking #123 references in the text
+	refTypes, _ := DiscussionTypes(dbOwner, dbFolder, dbName)
+
+	var rows *pgx.Rows
+	rows, err = pdb.Query(dbQuery, args...)
 	if err != nil {
-		log.Printf("Retrieving commit list for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
-		return map[string]CommitEntry{}, err
+		log.Printf("Database query failed: %v\n", err)
+		return
 	}
-	return l, nil
+	for rows.Next() {
+		var av, em, sb, db pgx.NullString
+		var sdb pgx.NullInt64
+		var oneRow DiscussionEntry
+		err = rows.Scan(&oneRow.ID, &oneRow.Title, &oneRow.Open, &oneRow.DateCreated, &oneRow.Creator, &em, &av,
+			&oneRow.Body, &oneRow.LastModified, &oneRow.CommentCount, &sdb, &sb, &db, &oneRow.MRDetails.State,
+			&oneRow.MRDetails.Commits)
+		if err != nil {
+			log.Printf("Error retrieving discussion/MR list for database '%s%s%s': %v\n", dbOwner, dbFolder,
+				dbName, err)
+			rows.Close()
+			return
+		}
+		if av.Valid {
+			oneRow.AvatarURL = av.String
+		} else {
+			// If no avatar URL is presently stored, default to a gravatar based on the users email (if known)
+			if em.Valid {
+				picHash := md5.Sum([]byte(em.String))
+				oneRow.AvatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon&s=30", picHash)
+			}
+		}
+		if discType == MERGE_REQUEST && sdb.Valid {
+			oneRow.MRDetails.SourceDBID = sdb.Int64
+		}
+		if sb.Valid {
+			oneRow.MRDetails.SourceBranch = sb.String
+		}
+		if db.Valid {
+			oneRow.MRDetails.DestBranch = db.String
+		}
+		list = append(list, oneRow)
+	}
+
+	// Render the bodies together, so the mentioned users are looked up with a single query
+	bodies := make([]string, len(list))
+	for i, j := range list {
+		bodies[i] = j.Body
+	}
+	for i, b := range renderDatabaseMarkdownList(dbOwner, dbFolder, dbName, bodies, refTypes) {
+		list[i].BodyRendered = b
+	}
+
+	// For merge requests, turn the source database ID's into full owner/folder/name strings
+	if discType == MERGE_REQUEST {
+		for i, j := range list {
+			// Retrieve the owner/folder/name for a database id
+			dbQuery = `
+				SELECT users.user_name, db.folder, db.db_name
+				FROM sqlite_databases AS db, users
+				WHERE db.db_id = $1
+					AND db.user_id = users.user_id`
+			var o, f, n pgx.NullString
+			err2 := pdb.QueryRow(dbQuery, j.MRDetails.SourceDBID).Scan(&o, &f, &n)
+			if err2 != nil && err2 != pgx.ErrNoRows {
+				log.Printf("Retrieving source database owner/folder/name failed: %v\n", err)
+				return
+			}
+			if o.Valid {
+				list[i].MRDetails.SourceOwner = o.String
+			}
+			if f.Valid {
+				list[i].MRDetails.SourceFolder = f.String
+			}
+			if n.Valid {
+				list[i].MRDetails.SourceDBName = n.String
+			}
+		}
+	}
+	rows.Close()
+
+	// Add the labels, assignees, and milestones
+	err = discussionTracking(dbOwner, dbFolder, dbName, list)
+	return
 }
 
-// Returns the default branch name for a database.
-func GetDefaultBranchName(dbOwner string, dbFolder string, dbName string) (branchName string, err error) {
+// Returns the list of comments for a given discussion.
+// If a non-0 comID value is passed, it will only return the details for that specific comment in the discussion.
+// Otherwise it will return a list of all comments for a given discussion
+// Note - This returns a slice instead of a map.  We use a slice because it lets us use an ORDER BY clause in the SQL
+//        and preserve the returned order (maps don't preserve order).  If in future we no longer need to preserve the
+//        order, it might be useful to switch to using a map instead since they're often simpler to work with.
+func DiscussionComments(dbOwner string, dbFolder string, dbName string, discID int, comID int) (list []DiscussionCommentEntry, err error) {
 	dbQuery := `
-		SELECT db.default_branch
-		FROM sqlite_databases AS db
-		WHERE db.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND db.folder = $2
+				AND db.db_name = $3
+		), int AS (
+				SELECT internal_id AS int_id
+				FROM discussions
+				WHERE db_id = (SELECT db_id FROM d)
+				AND disc_id = $4
 			)
-			AND db.folder = $2
-			AND db.db_name = $3
-			AND db.is_deleted = false`
-	var b pgx.NullString
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&b)
+		SELECT com.com_id, users.user_name, users.email, users.avatar_url, com.date_created, com.body, com.entry_type,
+			anc.commit_id, anc.original_commit_id, anc.table_name, anc.primary_key, anc.column_name,
+			coalesce(anc.outdated, false)
+		FROM discussion_comments AS com
+			LEFT JOIN discussion_comment_anchors AS anc ON anc.com_id = com.com_id, d, users
+		WHERE com.db_id = d.db_id
+			AND com.disc_id = (SELECT int_id FROM int)
+			AND com.commenter = users.user_id`
+	if comID != 0 {
+		dbQuery += fmt.Sprintf(`
+			AND com.com_id = %d`, comID)
+	}
+	dbQuery += `
+		ORDER BY date_created ASC`
+
+	// The known discussion IDs are used for linking #123 references in the text
+	refTypes, _ := DiscussionTypes(dbOwner, dbFolder, dbName)
+
+	var rows *pgx.Rows
+	rows, err = pdb.Query(dbQuery, dbOwner, dbFolder, dbName, discID)
 	if err != nil {
-		if err != pgx.ErrNoRows {
-			log.Printf("Error when retrieving default branch name for database '%s%s%s': %v\n", dbOwner,
-				dbFolder, dbName, err)
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	for rows.Next() {
+		var av, em, ancCommit, ancOrigCommit, ancTable, ancCol pgx.NullString
+		var ancKey map[string]interface{}
+		var ancOutdated bool
+		var oneRow DiscussionCommentEntry
+		err = rows.Scan(&oneRow.ID, &oneRow.Commenter, &em, &av, &oneRow.DateCreated, &oneRow.Body, &oneRow.EntryType,
+			&ancCommit, &ancOrigCommit, &ancTable, &ancKey, &ancCol, &ancOutdated)
+		if err != nil {
+			log.Printf("Error retrieving comment list for database '%s%s%s', discussion '%d': %v\n", dbOwner,
+				dbFolder, dbName, discID, err)
+			rows.Close()
 			return
+		}
+
+		if av.Valid {
+			oneRow.AvatarURL = av.String
 		} else {
-			log.Printf("No default branch name exists for database '%s%s%s'. This shouldn't happen\n", dbOwner,
-				dbFolder, dbName)
-			return
+			if em.Valid {
+				picHash := md5.Sum([]byte(em.String))
+				oneRow.AvatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon&s=30", picHash)
+			}
 		}
+
+		// Include the data a review comment points to
+		if ancCommit.Valid {
+			oneRow.Anchor = &CommentAnchor{
+				Column:           ancCol.String,
+				CommitID:         ancCommit.String,
+				OriginalCommitID: ancOrigCommit.String,
+				Outdated:         ancOutdated,
+				PrimaryKey:       ancKey,
+				Table:            ancTable.String,
+			}
+		}
+
+		list = append(list, oneRow)
 	}
-	if b.Valid {
-		branchName = b.String
+	rows.Close()
+
+	// Render the bodies together, so the mentioned users are looked up with a single query
+	bodies := make([]string, len(list))
+	for i, j := range list {
+		bodies[i] = j.Body
+	}
+	for i, b := range renderDatabaseMarkdownList(dbOwner, dbFolder, dbName, bodies, refTypes) {
+		list[i].BodyRendered = b
 	}
 	return
 }
 
-// Returns the default table name for a database.
-func GetDefaultTableName(dbOwner string, dbFolder string, dbName string) (tableName string, err error) {
+// Returns the most recent entries in the email queue, unsent ones first.
+func EmailQueueEntries(limit int) (list []EmailQueueEntry, err error) {
 	dbQuery := `
-		SELECT db.default_table
-		FROM sqlite_databases AS db
-		WHERE db.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND db.folder = $2
-			AND db.db_name = $3
-			AND db.is_deleted = false`
-	var t pgx.NullString
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&t)
+		SELECT email_id, mail_to, subject, queued_timestamp, sent, sent_timestamp
+		FROM email_queue
+		ORDER BY sent, queued_timestamp DESC
+		LIMIT $1`
+	rows, err := pdb.Query(dbQuery, limit)
 	if err != nil {
-		if err != pgx.ErrNoRows {
-			log.Printf("Error when retrieving default table name for database '%s%s%s': %v\n", dbOwner,
-				dbFolder, dbName, err)
+		log.Printf("Retrieving email queue entries failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e EmailQueueEntry
+		var sentTimestamp pgx.NullTime
+		err = rows.Scan(&e.ID, &e.MailTo, &e.Subject, &e.QueuedTimestamp, &e.Sent, &sentTimestamp)
+		if err != nil {
+			log.Printf("Error retrieving email queue entries: %v\n", err)
 			return
 		}
-	}
-	if t.Valid {
-		tableName = t.String
+		if sentTimestamp.Valid {
+			e.SentTimestamp = sentTimestamp.Time
+		}
+		list = append(list, e)
 	}
 	return
 }
 
-// Returns the discussion and merge request counts for a database
-// TODO: The only reason this function exists atm, is because we're incorrectly caching the discussion and MR data in
-// TODO  a way that makes invalidating it correctly hard/impossible.  We should redo our memcached approach to solve the
-// TODO  issue properly
-func GetDiscussionAndMRCount(dbOwner string, dbFolder string, dbName string) (discCount int, mrCount int, err error) {
+// Returns the oldest events waiting to be processed into status updates.
+func EventQueueEntries(limit int) (list []EventQueueEntry, err error) {
 	dbQuery := `
-		SELECT db.discussions, db.merge_requests
-		FROM sqlite_databases AS db
-		WHERE db.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND db.folder = $2
-			AND db.db_name = $3
-			AND db.is_deleted = false`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&discCount, &mrCount)
+		SELECT ev.event_id, ev.event_type, ev.event_timestamp, coalesce(u.user_name, ''), coalesce(db.db_name, '')
+		FROM events AS ev
+			LEFT JOIN sqlite_databases AS db ON db.db_id = ev.db_id
+			LEFT JOIN users AS u ON u.user_id = db.user_id
+		ORDER BY ev.event_id
+		LIMIT $1`
+	rows, err := pdb.Query(dbQuery, limit)
 	if err != nil {
-		if err != pgx.ErrNoRows {
-			log.Printf("Error when retrieving discussion and MR count for database '%s%s%s': %v\n", dbOwner,
-				dbFolder, dbName, err)
+		log.Printf("Retrieving event queue entries failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e EventQueueEntry
+		err = rows.Scan(&e.ID, &e.Type, &e.Timestamp, &e.Owner, &e.DBName)
+		if err != nil {
+			log.Printf("Error retrieving event queue entries: %v\n", err)
 			return
-		} else {
-			log.Printf("Database '%s%s%s' not found when attempting to retrieve discussion and MR count. This"+
-				"shouldn't happen\n", dbOwner, dbFolder, dbName)
+		}
+		list = append(list, e)
+	}
+	return
+}
+
+// Returns the correctly capitalised names of the given users which exist, keyed by their lower case name.
+func existingUsers(names []string) (users map[string]string, err error) {
+	dbQuery := `
+		SELECT user_name
+		FROM users
+		WHERE lower(user_name) = ANY($1)`
+	rows, err := pdb.Query(dbQuery, names)
+	if err != nil {
+		log.Printf("Looking up existing users failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	users = make(map[string]string)
+	for rows.Next() {
+		var userName string
+		err = rows.Scan(&userName)
+		if err != nil {
+			log.Printf("Error retrieving existing users: %v\n", err)
 			return
 		}
+		users[strings.ToLower(userName)] = userName
 	}
 	return
 }
 
-// Returns the text for a given licence.
-func GetLicence(userName string, licenceName string) (txt string, format string, err error) {
+// Returns the IDs of the databases which have been in the trash since before the given time.
+func ExpiredTrash(cutOff time.Time) (list []int64, err error) {
 	dbQuery := `
-		SELECT licence_text, file_format
-		FROM database_licences
-		WHERE friendly_name ILIKE $2
-		AND (
-				user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				) OR
-				user_id = (
-					SELECT user_id
-					FROM users
-					WHERE user_name = 'default'
-				)
-			)`
-	err = pdb.QueryRow(dbQuery, userName, licenceName).Scan(&txt, &format)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			// The requested licence text wasn't found
-			return "", "", errors.New("unknown licence")
-		}
-		log.Printf("Error when retrieving licence '%s', user '%s': %v\n", licenceName, userName, err)
-		return "", "", err
-	}
-	return txt, format, nil
-}
-
-// Returns the list of licences available to a user.
-func GetLicences(user string) (map[string]LicenceEntry, error) {
-	dbQuery := `
-		SELECT friendly_name, full_name, lic_sha256, licence_url, file_format, display_order
-		FROM database_licences
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE user_name = 'default'
-			)
-			OR user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)`
-	rows, err := pdb.Query(dbQuery, user)
+		SELECT db_id
+		FROM database_trash
+		WHERE date_deleted < $1`
+	rows, err := pdb.Query(dbQuery, cutOff)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return nil, err
+		log.Printf("Retrieving expired trash entries failed: %v\n", err)
+		return
 	}
 	defer rows.Close()
-	lics := make(map[string]LicenceEntry)
 	for rows.Next() {
-		var name string
-		var oneRow LicenceEntry
-		err = rows.Scan(&name, &oneRow.FullName, &oneRow.Sha256, &oneRow.URL, &oneRow.FileFormat, &oneRow.Order)
+		var id int64
+		err = rows.Scan(&id)
 		if err != nil {
-			log.Printf("Error retrieving licence list: %v\n", err)
-			return nil, err
+			log.Printf("Error retrieving expired trash entries: %v\n", err)
+			return
 		}
-		lics[name] = oneRow
+		list = append(list, id)
 	}
-	return lics, nil
+	return
 }
 
-// Returns the friendly name + licence URL for the licence matching a given sha256.
-// Note - When user defined licence has the same sha256 as a default one we return the user defined licences' friendly
-// name.
-func GetLicenceInfoFromSha256(userName string, sha256 string) (lName string, lURL string, err error) {
-	dbQuery := `
-		SELECT u.user_name, dl.friendly_name, dl.licence_url
-		FROM database_licences AS dl, users AS u
-		WHERE dl.lic_sha256 = $2
-			AND dl.user_id = u.user_id
-			AND (dl.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE user_name = 'default'
-			)
-			OR dl.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			))`
-	rows, err := pdb.Query(dbQuery, userName, sha256)
-	if err != nil {
-		log.Printf("Error when retrieving friendly name for licence sha256 '%s', user '%s': %v\n", sha256,
-			userName, err)
-		return "", "", err
-	}
-	defer rows.Close()
-	type lic struct {
-		Licence string
-		Name    string
-		User    string
+// Periodically flushes the database view count from memcache to PostgreSQL
+func FlushViewCount() {
+	type dbEntry struct {
+		Owner  string
+		Folder string
+		Name   string
 	}
-	var list []lic
-	for rows.Next() {
-		var oneRow lic
-		err = rows.Scan(&oneRow.User, &oneRow.Name, &oneRow.Licence)
+
+	// Log the start of the loop
+	log.Printf("Periodic view count flushing loop started.  %d second refresh.\n",
+		Conf.Memcache.ViewCountFlushDelay)
+
+	// Start the endless flush loop
+	var rows *pgx.Rows
+	var err error
+	for true {
+		// Retrieve the list of all public databases
+		dbQuery := `
+			SELECT users.user_name, db.folder, db.db_name
+			FROM sqlite_databases AS db, users
+			WHERE db.public = true
+				AND db.is_deleted = false
+				AND db.user_id = users.user_id`
+		rows, err = pdb.Query(dbQuery)
 		if err != nil {
-			log.Printf("Error retrieving friendly name for licence sha256 '%s', user: %v\n", sha256, err)
-			return "", "", err
+			log.Printf("Database query failed: %v\n", err)
+			return
 		}
-		list = append(list, oneRow)
-	}
+		var dbList []dbEntry
+		for rows.Next() {
+			var oneRow dbEntry
+			err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.Name)
+			if err != nil {
+				log.Printf("Error retrieving database list for view count flush thread: %v\n", err)
+				rows.Close()
+				return
+			}
+			dbList = append(dbList, oneRow)
+		}
+		rows.Close()
 
-	// Decide what to return based upon the number of licence matches
-	numLics := len(list)
-	switch numLics {
-	case 0:
-		// If there are no matching sha256's, something has gone wrong
-		return "", "", errors.New("No matching licence found, something has gone wrong!")
-	case 1:
-		// If there's only one matching sha256, we return the corresponding licence name + url
-		lName = list[0].Name
-		lURL = list[0].Licence
-		return lName, lURL, nil
-	default:
-		// If more than one name was found for the matching sha256, that seems a bit trickier.  At least one of them
-		// would have to be a user defined licence, so we'll return the first one of those instead of the default
-		// licence name.  This seems to allow users to define their own friendly name's for the default licences which
-		// is probably not a bad thing
-		for _, j := range list {
-			if j.User == userName {
-				lName = j.Name
-				lURL = j.Licence
-				break
+		// For each public database, retrieve the latest view count from memcache and save it back to PostgreSQL
+		for _, db := range dbList {
+			dbOwner := db.Owner
+			dbFolder := db.Folder
+			dbName := db.Name
+
+			// Retrieve the view count from Memcached
+			newValue, err := GetViewCount(dbOwner, dbFolder, dbName)
+			if err != nil {
+				log.Printf("Error when getting memcached view count for %s%s%s: %s\n", dbOwner, dbFolder, dbName,
+					err.Error())
+				continue
+			}
+
+			// We use a value of -1 to indicate there wasn't an entry in memcache for the database
+			if newValue != -1 {
+				// Update the view count in PostgreSQL
+				dbQuery = `
+					UPDATE sqlite_databases
+					SET page_views = $4
+					WHERE user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($1)
+						)
+						AND folder = $2
+						AND db_name = $3`
+				commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, newValue)
+				if err != nil {
+					log.Printf("Flushing view count for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+					continue
+				}
+				if numRows := commandTag.RowsAffected(); numRows != 1 {
+					log.Printf("Wrong number of rows affected (%v) when flushing view count for '%s%s%s'\n",
+						numRows, dbOwner, dbFolder, dbName)
+					continue
+				}
 			}
 		}
-	}
-	if lName == "" {
-		// Multiple licence friendly names were returned, but none of them matched the requesting user.  Something has
-		// gone wrong
-		return "", "", fmt.Errorf("Multiple matching licences found, but belonging to user %s\n", userName)
-	}
 
-	// To get here we must have successfully picked a user defined licence out of several matches.  This seems like
-	// an acceptable scenario
-	return lName, lURL, nil
+		// Wait before running the loop again
+		time.Sleep(Conf.Memcache.ViewCountFlushDelay * time.Second)
+	}
+	return
 }
 
-// Returns the sha256 for a given licence.
-func GetLicenceSha256FromName(userName string, licenceName string) (sha256 string, err error) {
-	dbQuery := `
-		SELECT lic_sha256
-		FROM database_licences
-		WHERE friendly_name = $2
-			AND (user_id = (
-				SELECT user_id
-				FROM users
-				WHERE user_name = 'default'
-			)
-			OR user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			))`
-	err = pdb.QueryRow(dbQuery, userName, licenceName).Scan(&sha256)
+// Fork the PostgreSQL entry for a SQLite database from one user to another
+func ForkDatabase(srcOwner string, dbFolder string, dbName string, dstOwner string) (newForkCount int, err error) {
+	// Make sure the fork fits within the limits of the destination owner
+	err = CheckDatabaseQuota(dstOwner)
 	if err != nil {
-		log.Printf("Error when retrieving sha256 for licence '%s', user '%s' from database: %v\n", licenceName,
-			userName, err)
-		return "", err
+		return 0, err
 	}
-	if sha256 == "" {
-		// The requested licence wasn't found
-		return "", errors.New("Licence not found")
+	commitList, err := GetCommitList(srcOwner, dbFolder, dbName)
+	if err != nil {
+		return 0, err
+	}
+	var commits []CommitEntry
+	for _, c := range commitList {
+		commits = append(commits, c)
+	}
+	err = CheckStorageQuota(dstOwner, CommitDatabaseFiles(commits))
+	if err != nil {
+		return 0, err
 	}
-	return sha256, nil
-}
 
-// Retrieve the list of releases for a database.
-func GetReleases(dbOwner string, dbFolder string, dbName string) (releases map[string]ReleaseEntry, err error) {
+	// Copy the main database entry
 	dbQuery := `
-		SELECT release_list
-		FROM sqlite_databases
-		WHERE user_id = (
+		WITH dst_u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		)
+		INSERT INTO sqlite_databases (user_id, folder, db_name, public, forks, one_line_description, full_description,
+			branches, contributors, root_database, default_table, source_url, commit_list, branch_heads, tags,
+			default_branch, forked_from)
+		SELECT dst_u.user_id, folder, db_name, public, 0, one_line_description, full_description, branches,
+			contributors, root_database, default_table, source_url, commit_list, branch_heads, tags, default_branch,
+			db_id
+		FROM sqlite_databases, dst_u
+		WHERE sqlite_databases.user_id = (
 				SELECT user_id
 				FROM users
-				WHERE lower(user_name) = lower($1)
+				WHERE lower(user_name) = lower($2)
 			)
-			AND folder = $2
-			AND db_name = $3`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&releases)
+			AND folder = $3
+			AND db_name = $4`
+	commandTag, err := pdb.Exec(dbQuery, dstOwner, srcOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Error when retrieving releases for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
-		return nil, err
+		log.Printf("Forking database '%s%s%s' in PostgreSQL failed: %v\n", srcOwner, dbFolder, dbName, err)
+		return 0, err
 	}
-	if releases == nil {
-		// If there aren't any releases yet, return an empty set instead of nil
-		releases = make(map[string]ReleaseEntry)
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows affected (%d) when forking main database entry: "+
+			"'%s%s%s' to '%s%s%s'\n", numRows, srcOwner, dbFolder, dbName, dstOwner, dbFolder, dbName)
 	}
-	return releases, nil
+
+	// Update the fork count for the root database
+	dbQuery = `
+		WITH root_db AS (
+			SELECT root_database AS id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+		), new_count AS (
+			SELECT count(*) AS forks
+			FROM sqlite_databases AS db, root_db
+			WHERE db.root_database = root_db.id
+			AND db.is_deleted = false
+		)
+		UPDATE sqlite_databases
+		SET forks = new_count.forks - 1
+		FROM new_count, root_db
+		WHERE sqlite_databases.db_id = root_db.id
+		RETURNING new_count.forks - 1`
+	err = pdb.QueryRow(dbQuery, dstOwner, dbFolder, dbName).Scan(&newForkCount)
+	if err != nil {
+		log.Printf("Updating fork count in PostgreSQL failed: %v\n", err)
+		return 0, err
+	}
+	return newForkCount, nil
 }
 
-// Retrieve the tags for a database.
-func GetTags(dbOwner string, dbFolder string, dbName string) (tags map[string]TagEntry, err error) {
+// Checks if the given database was forked from another, and if so returns that one's owner, folder and database name
+func ForkedFrom(dbOwner string, dbFolder string, dbName string) (forkOwn string, forkFol string, forkDB string,
+	forkDel bool, err error) {
+	// Check if the database was forked from another
+	var dbID, forkedFrom pgx.NullInt64
 	dbQuery := `
-		SELECT tag_list
+		SELECT db_id, forked_from
 		FROM sqlite_databases
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
+				WHERE lower(user_name) = lower($1))
 			AND folder = $2
 			AND db_name = $3`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&tags)
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&dbID, &forkedFrom)
 	if err != nil {
-		log.Printf("Error when retrieving tags for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
-		return nil, err
+		log.Printf("Error checking if database was forked from another '%s%s%s'. Error: %v\n", dbOwner,
+			dbFolder, dbName, err)
+		return "", "", "", false, err
 	}
-	if tags == nil {
-		// If there aren't any tags yet, return an empty set instead of nil
-		tags = make(map[string]TagEntry)
+	if !forkedFrom.Valid {
+		// The database wasn't forked, so return empty strings
+		return "", "", "", false, nil
 	}
-	return tags, nil
-}
 
-// Returns the username associated with an email address.
-func GetUsernameFromEmail(email string) (userName string, avatarURL string, err error) {
-	dbQuery := `
-		SELECT user_name, avatar_url
-		FROM users
-		WHERE email = $1`
-	var av pgx.NullString
-	err = pdb.QueryRow(dbQuery, email).Scan(&userName, &av)
+	// Return the details of the database this one was forked from
+	dbQuery = `
+		SELECT u.user_name, db.folder, db.db_name, db.is_deleted
+		FROM users AS u, sqlite_databases AS db
+		WHERE db.db_id = $1
+			AND u.user_id = db.user_id`
+	err = pdb.QueryRow(dbQuery, forkedFrom).Scan(&forkOwn, &forkFol, &forkDB, &forkDel)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			// No matching username of the email
-			err = nil
-			return
-		}
-		log.Printf("Looking up username for email address '%s' failed: %v\n", email, err)
-		return
+		log.Printf("Error retrieving forked database information for '%s%s%s'. Error: %v\n", dbOwner,
+			dbFolder, dbName, err)
+		return "", "", "", false, err
 	}
 
-	// If no avatar URL is presently stored, default to a gravatar based on the users email (if known)
-	if !av.Valid {
-		picHash := md5.Sum([]byte(email))
-		avatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon", picHash)
-	} else {
-		avatarURL = av.String
+	// If the database this one was forked from has been deleted, indicate that and clear the database name value
+	if forkDel {
+		forkDB = ""
 	}
-	return
+	return forkOwn, forkFol, forkDB, forkDel, nil
 }
 
-// Retrieves a saved set of visualisation query results
-func GetVisualisationData(dbOwner string, dbFolder string, dbName string, commitID string, hash string) (data []VisRowV1, ok bool, err error) {
+// Return the parent of a database, if there is one (and it's accessible to the logged in user).  If no parent was
+// found, the returned Owner/Folder/DBName values will be empty strings
+func ForkParent(loggedInUser string, dbOwner string, dbFolder string, dbName string) (parentOwner string,
+	parentFolder string, parentDBName string, err error) {
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND folder = $2
-				AND db_name = $3
-		)
-		SELECT results
-		FROM vis_result_cache as vis_cache, u, d
-		WHERE vis_cache.db_id = d.db_id
-			AND vis_cache.user_id = u.user_id
-			AND vis_cache.commit_id = $4
-			AND vis_cache.hash = $5`
-	e := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, commitID, hash).Scan(&data)
-	if e != nil {
-		if e == pgx.ErrNoRows {
-			// There weren't any saved parameters for this database visualisation
+		SELECT users.user_name, db.folder, db.db_name, db.public, db.db_id, db.forked_from, db.is_deleted
+		FROM sqlite_databases AS db, users
+		WHERE db.root_database = (
+				SELECT root_database
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+				)
+			AND db.user_id = users.user_id
+		ORDER BY db.forked_from NULLS FIRST`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	dbList := make(map[int]ForkEntry)
+	for rows.Next() {
+		var frk pgx.NullInt64
+		var oneRow ForkEntry
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.Public, &oneRow.ID, &frk, &oneRow.Deleted)
+		if err != nil {
+			log.Printf("Error retrieving fork parent for '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
+				err)
 			return
 		}
+		if frk.Valid {
+			oneRow.ForkedFrom = int(frk.Int64)
+		}
+		dbList[oneRow.ID] = oneRow
+	}
 
-		// A real database error occurred
-		err = e
-		log.Printf("Checking if a database exists failed: %v\n", e)
+	// Safety check
+	numResults := len(dbList)
+	if numResults == 0 {
+		err = fmt.Errorf("Empty list returned instead of fork tree.  This shouldn't happen")
 		return
 	}
 
-	// Data was successfully retrieved
-	ok = true
-	return
-}
+	// Get the ID of the database being called
+	dbID, err := databaseID(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
 
-// Retrieves a saved set of visualisation parameters
-func GetVisualisationParams(dbOwner string, dbFolder string, dbName string, visName string) (params VisParamsV1, ok bool, err error) {
-	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND folder = $2
-				AND db_name = $3
-		)
-		SELECT parameters
-		FROM vis_params as vis, u, d
-		WHERE vis.db_id = d.db_id
-			AND vis.user_id = u.user_id
-			AND vis.name = $4`
-	e := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, visName).Scan(&params)
-	if e != nil {
-		if e == pgx.ErrNoRows {
-			// There weren't any saved parameters for this database visualisation
+	// Find the closest (not-deleted) parent for the database
+	dbEntry, ok := dbList[dbID]
+	if !ok {
+		// The database itself wasn't found in the list.  This shouldn't happen
+		err = fmt.Errorf("Internal error when retrieving fork parent info.  This shouldn't happen.")
+		return
+	}
+	for dbEntry.ForkedFrom != 0 {
+		dbEntry, ok = dbList[dbEntry.ForkedFrom]
+		if !ok {
+			// Parent database entry wasn't found in the list.  This shouldn't happen either
+			err = fmt.Errorf("Internal error when retrieving fork parent info (#2).  This shouldn't happen.")
 			return
 		}
-
-		// A real database error occurred
-		err = e
-		log.Printf("Retrieving visualisation parameters for '%s%s%s', visualisation '%s' failed: %v\n", dbOwner, dbFolder, dbName, visName, e)
-		return
+		if !dbEntry.Deleted {
+			// Found a parent (that's not deleted).  We'll use this and stop looping
+			parentOwner = dbEntry.Owner
+			parentFolder = dbEntry.Folder
+			parentDBName = dbEntry.DBName
+			break
+		}
 	}
 
-	// Parameters were successfully retrieved
-	ok = true
 	return
 }
 
-// Increments the download count for a database
-func IncrementDownloadCount(dbOwner string, dbFolder string, dbName string) error {
+// Return the complete fork tree for a given database
+func ForkTree(loggedInUser string, dbOwner string, dbFolder string, dbName string) (outputList []ForkEntry, err error) {
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET download_count = download_count + 1
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName)
-	if err != nil {
-		log.Printf("Increment download count for '%s%s%s' failed: %v\n", dbOwner, dbFolder,
-			dbName, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when incrementing download count for '%s%s%s'\n",
-			numRows, dbOwner, dbFolder, dbName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
-	}
-	return nil
-}
-
-// Create a download log entry
-func LogDownload(dbOwner string, dbFolder string, dbName string, loggedInUser string, ipAddr string, serverSw string,
-	userAgent string, downloadDate time.Time, sha string) error {
-	// If the downloader isn't a logged in user, use a NULL value for that column
-	var downloader pgx.NullString
-	if loggedInUser != "" {
-		downloader.String = loggedInUser
-		downloader.Valid = true
-	}
-
-	// Store the download details
-	dbQuery := `
-		WITH d AS (
-			SELECT db.db_id, db.folder, db.db_name
-			FROM sqlite_databases AS db
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
+		SELECT users.user_name, db.folder, db.db_name, db.public, db.db_id, db.forked_from, db.is_deleted
+		FROM sqlite_databases AS db, users
+		WHERE db.root_database = (
+				SELECT root_database
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
 				)
-				AND db.folder = $2
-				AND db.db_name = $3
-		)
-		INSERT INTO database_downloads (db_id, user_id, ip_addr, server_sw, user_agent, download_date, db_sha256)
-		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, downloader, ipAddr, serverSw, userAgent,
-		downloadDate, sha)
+			AND db.user_id = users.user_id
+		ORDER BY db.forked_from NULLS FIRST`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Storing record of download '%s%s%s', sha '%s' by '%s' failed: %v\n", dbOwner, dbFolder,
-			dbName, sha, downloader, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected while storing download record for '%s%s%s'\n", numRows,
-			dbOwner, dbFolder, dbName)
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
 	}
-	return nil
-}
-
-// Create an upload log entry
-func LogUpload(dbOwner string, dbFolder string, dbName string, loggedInUser string, ipAddr string, serverSw string,
-	userAgent string, uploadDate time.Time, sha string) error {
-	// If the uploader isn't a logged in user, use a NULL value for that column
-	var uploader pgx.NullString
-	if loggedInUser != "" {
-		uploader.String = loggedInUser
-		uploader.Valid = true
+	defer rows.Close()
+	var dbList []ForkEntry
+	for rows.Next() {
+		var frk pgx.NullInt64
+		var oneRow ForkEntry
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.Public, &oneRow.ID, &frk, &oneRow.Deleted)
+		if err != nil {
+			log.Printf("Error retrieving fork list for '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
+				err)
+			return nil, err
+		}
+		if frk.Valid {
+			oneRow.ForkedFrom = int(frk.Int64)
+		}
+		dbList = append(dbList, oneRow)
 	}
 
-	// Store the upload details
-	dbQuery := `
-		WITH d AS (
-			SELECT db.db_id, db.folder, db.db_name
-			FROM sqlite_databases AS db
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND db.folder = $2
-				AND db.db_name = $3
-		)
-		INSERT INTO database_uploads (db_id, user_id, ip_addr, server_sw, user_agent, upload_date, db_sha256)
-		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, uploader, ipAddr, serverSw, userAgent,
-		uploadDate, sha)
-	if err != nil {
-		log.Printf("Storing record of upload '%s%s%s', sha '%s' by '%s' failed: %v\n", dbOwner, dbFolder,
-			dbName, sha, uploader, err)
-		return err
+	// Safety checks
+	numResults := len(dbList)
+	if numResults == 0 {
+		return nil, errors.New("Empty list returned instead of fork tree.  This shouldn't happen")
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected while storing upload record for '%s%s%s'\n", numRows,
-			dbOwner, dbFolder, dbName)
+	if dbList[0].ForkedFrom != 0 {
+		// The first entry has a non-zero forked_from field, indicating it's not the root entry.  That
+		// shouldn't happen, so return an error.
+		return nil, errors.New("Incorrect root entry data in retrieved database list")
 	}
-	return nil
-}
 
-// Return the Minio bucket and ID for a given database. dbOwner, dbFolder, & dbName are from owner/folder/database URL
-// fragment, // loggedInUser is the name for the currently logged in user, for access permission check.  Use an empty
-// string ("") as the loggedInUser parameter if the true value isn't set or known.
-// If the requested database doesn't exist, or the loggedInUser doesn't have access to it, then an error will be
-// returned.
-func MinioLocation(dbOwner string, dbFolder string, dbName string, commitID string, loggedInUser string) (minioBucket string,
-	minioID string, lastModified time.Time, err error) {
+	// * Process the root entry *
 
-	// TODO: This will likely need updating to query the "database_files" table to retrieve the Minio server name
+	var iconDepth int
+	var forkTrail []int
 
-	// If no commit was provided, we grab the default one
-	if commitID == "" {
-		commitID, err = DefaultCommit(dbOwner, dbFolder, dbName)
-		if err != nil {
-			return // Bucket and ID are still the initial default empty string
-		}
-	}
+	// Set the root database ID
+	rootID := dbList[0].ID
 
-	// Retrieve the sha256 and last modified date for the requested commit's database file
-	var dbQuery string
-	dbQuery = `
-		SELECT commit_list->$4::text->'tree'->'entries'->0->'sha256' AS sha256,
-			commit_list->$4::text->'tree'->'entries'->0->'last_modified' AS last_modified
-		FROM sqlite_databases AS db
-		WHERE db.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND db.folder = $2
-			AND db.db_name = $3
-			AND db.is_deleted = false`
+	// Set the icon list for display in the browser
+	dbList[0].IconList = append(dbList[0].IconList, ROOT)
 
-	// If the request is for another users database, it needs to be a public one
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
-		dbQuery += `
-				AND db.public = true`
+	// If the root database is no longer public, then use placeholder details instead
+	if !dbList[0].Public && (strings.ToLower(dbList[0].Owner) != strings.ToLower(loggedInUser)) {
+		dbList[0].DBName = "private database"
 	}
 
-	var sha, mod string
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, commitID).Scan(&sha, &mod)
-	if err != nil {
-		log.Printf("Error retrieving MinioID for %s/%s version %v: %v\n", dbOwner, dbName, commitID, err)
-		return // Bucket and ID are still the initial default empty string
+	// If the root database is deleted, use a placeholder indicating that instead
+	if dbList[0].Deleted {
+		dbList[0].DBName = "deleted database"
 	}
 
-	if sha == "" {
-		// The requested database doesn't exist, or the logged in user doesn't have access to it
-		err = fmt.Errorf("The requested database wasn't found")
-		return // Bucket and ID are still the initial default empty string
-	}
+	// Append this completed database line to the output list
+	outputList = append(outputList, dbList[0])
 
-	lastModified, err = time.Parse(time.RFC3339, mod)
-	if err != nil {
-		return // Bucket and ID are still the initial default empty string
-	}
+	// Append the root database ID to the fork trail
+	forkTrail = append(forkTrail, rootID)
 
-	minioBucket = sha[:MinioFolderChars]
-	minioID = sha[MinioFolderChars:]
-	return
-}
+	// Mark the root database entry as processed
+	dbList[0].Processed = true
 
-// Adds an event entry to PostgreSQL
-func NewEvent(details EventDetails) (err error) {
-	dbQuery := `
-		WITH d AS (
-			SELECT db_id
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
-				AND is_deleted = false
-		)
-		INSERT INTO events (db_id, event_type, event_data)
-		VALUES ((SELECT db_id FROM d), $4, $5)`
-	_, err = pdb.Exec(dbQuery, details.Owner, details.Folder, details.DBName, details.Type, details)
-	if err != nil {
-		return err
-	}
-	return
-}
+	// Increment the icon depth
+	iconDepth = 1
 
-// Return the user's preference for maximum number of SQLite rows to display.
-func PrefUserMaxRows(loggedInUser string) int {
-	// Retrieve the user preference data
-	dbQuery := `
-		SELECT pref_max_rows
-		FROM users
-		WHERE user_id = (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1))`
-	var maxRows int
-	err := pdb.QueryRow(dbQuery, loggedInUser).Scan(&maxRows)
-	if err != nil {
-		log.Printf("Error retrieving user '%s' preference data: %v\n", loggedInUser, err)
-		return DefaultNumDisplayRows // Use the default value
-	}
+	// * Sort the remaining entries for correct display *
+	numUnprocessedEntries := numResults - 1
+	for numUnprocessedEntries > 0 {
+		var forkFound bool
+		outputList, forkTrail, forkFound = nextChild(loggedInUser, &dbList, &outputList, &forkTrail, iconDepth)
+		if forkFound {
+			numUnprocessedEntries--
+			iconDepth++
 
-	return maxRows
-}
+			// Add stems and branches to the output icon list
+			numOutput := len(outputList)
 
-// Rename a SQLite database.
-func RenameDatabase(userName string, dbFolder string, dbName string, newName string) error {
-	// Save the database settings
-	dbQuery := `
-		UPDATE sqlite_databases
-		SET db_name = $4
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, userName, dbFolder, dbName, newName)
-	if err != nil {
-		log.Printf("Renaming database '%s%s%s' failed: %v\n", userName, dbFolder, dbName, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when renaming '%s%s%s' to '%s%s%s'\n",
-			numRows, userName, dbFolder, dbName, userName, dbFolder, newName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
-	}
+			myID := outputList[numOutput-1].ID
+			myForkedFrom := outputList[numOutput-1].ForkedFrom
 
-	// Log the rename
-	log.Printf("Database renamed from '%s%s%s' to '%s%s%s'\n", userName, dbFolder, dbName, userName, dbFolder,
-		newName)
+			// Scan through the earlier output list for any sibling entries
+			var siblingFound bool
+			for i := numOutput; i > 0 && siblingFound == false; i-- {
+				thisID := outputList[i-1].ID
+				thisForkedFrom := outputList[i-1].ForkedFrom
 
-	return nil
-}
+				if thisForkedFrom == myForkedFrom && thisID != myID {
+					// Sibling entry found
+					siblingFound = true
+					sibling := outputList[i-1]
 
-// Saves updated database settings to PostgreSQL.
-func SaveDBSettings(userName string, dbFolder string, dbName string, oneLineDesc string, fullDesc string,
-	defaultTable string, public bool, sourceURL string, defaultBranch string) error {
-	// Check for values which should be NULL
-	var nullable1LineDesc, nullableFullDesc, nullableSourceURL pgx.NullString
-	if oneLineDesc == "" {
-		nullable1LineDesc.Valid = false
-	} else {
-		nullable1LineDesc.String = oneLineDesc
-		nullable1LineDesc.Valid = true
-	}
-	if fullDesc == "" {
-		nullableFullDesc.Valid = false
-	} else {
-		nullableFullDesc.String = fullDesc
-		nullableFullDesc.Valid = true
-	}
-	if sourceURL == "" {
-		nullableSourceURL.Valid = false
-	} else {
-		nullableSourceURL.String = sourceURL
-		nullableSourceURL.Valid = true
-	}
+					// Change the last sibling icon to a branch icon
+					sibling.IconList[iconDepth-1] = BRANCH
 
-	// Save the database settings
-	SQLQuery := `
-		UPDATE sqlite_databases
-		SET one_line_description = $4, full_description = $5, default_table = $6, public = $7, source_url = $8,
-			default_branch = $9
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(SQLQuery, userName, dbFolder, dbName, nullable1LineDesc, nullableFullDesc, defaultTable,
-		public, nullableSourceURL, defaultBranch)
-	if err != nil {
-		log.Printf("Updating description for database '%s%s%s' failed: %v\n", userName, dbFolder,
-			dbName, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when updating description for '%s%s%s'\n",
-			numRows, userName, dbFolder, dbName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
-	}
+					// Change appropriate spaces to stems in the output icon list
+					for l := numOutput - 1; l > i; l-- {
+						thisEntry := outputList[l-1]
+						if thisEntry.IconList[iconDepth-1] == SPACE {
+							thisEntry.IconList[iconDepth-1] = STEM
+						}
+					}
+				}
+			}
+		} else {
+			// No child was found, so remove an entry from the fork trail then continue looping
+			forkTrail = forkTrail[:len(forkTrail)-1]
 
-	// Invalidate the old memcached entry for the database
-	err = InvalidateCacheEntry(userName, userName, dbFolder, dbName, "") // Empty string indicates "for all versions"
-	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
-		return err
+			iconDepth--
+		}
 	}
 
-	return nil
+	return outputList, nil
 }
 
-// Sends status update emails to people watching databases
-func SendEmails() {
-	// Create Hectane email queue
-	cfg := &queue.Config{
-		Directory:              Conf.Event.EmailQueueDir,
-		DisableSSLVerification: true,
-	}
-	q, err := queue.NewQueue(cfg)
+func GetActivityStats() (stats ActivityStats, err error) {
+	// Retrieve a list of which databases are the most starred
+	dbQuery := `
+		WITH most_starred AS (
+			SELECT s.db_id, COUNT(s.db_id), max(s.date_starred)
+			FROM database_stars AS s, sqlite_databases AS db
+			WHERE s.db_id = db.db_id
+				AND db.public = true
+				AND db.is_deleted = false
+			GROUP BY s.db_id
+			ORDER BY count DESC
+			LIMIT 5
+		)
+		SELECT users.user_name, db.db_name, stars.count
+		FROM most_starred AS stars, sqlite_databases AS db, users
+		WHERE stars.db_id = db.db_id
+			AND users.user_id = db.user_id
+		ORDER BY count DESC, max ASC`
+	starRows, err := pdb.Query(dbQuery)
 	if err != nil {
-		log.Printf("Couldn't start Hectane queue: %s", err.Error())
+		log.Printf("Database query failed: %v\n", err)
 		return
 	}
-	log.Printf("Created Hectane email queue in '%s'.  Queue processing loop refreshes every %d seconds",
-		Conf.Event.EmailQueueDir, Conf.Event.EmailQueueProcessingDelay)
-
-	for {
-		// Retrieve unsent emails from the email_queue
-		type eml struct {
-			Address string
-			Body    string
-			ID      int64
-			Subject string
-		}
-		var emailList []eml
-		dbQuery := `
-				SELECT email_id, mail_to, subject, body
-				FROM email_queue
-				WHERE sent = false`
-		rows, err := pdb.Query(dbQuery)
+	defer starRows.Close()
+	for starRows.Next() {
+		var oneRow ActivityRow
+		err = starRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
 		if err != nil {
-			log.Printf("Database query failed: %v", err.Error())
-			return // Abort, as we don't want to continuously resend the same emails
-		}
-		for rows.Next() {
-			var oneRow eml
-			err = rows.Scan(&oneRow.ID, &oneRow.Address, &oneRow.Subject, &oneRow.Body)
-			if err != nil {
-				log.Printf("Error retrieving queued emails: %v", err.Error())
-				rows.Close()
-				return // Abort, as we don't want to continuously resend the same emails
-			}
-			emailList = append(emailList, oneRow)
+			log.Printf("Error retrieving list of most starred databases: %v\n", err)
+			return
 		}
-		rows.Close()
-
-		// Send emails
-		for _, j := range emailList {
-			e := &email.Email{
-				From:    "updates@dbhub.io",
-				To:      []string{j.Address},
-				Subject: j.Subject,
-				Text:    j.Body,
-			}
-			msgs, err := e.Messages(q.Storage)
-			if err != nil {
-				log.Printf("Queuing email in Hectane failed: %v", err.Error())
-				return // Abort, as we don't want to continuously resend the same emails
-			}
-			for _, m := range msgs {
-				q.Deliver(m)
-			}
+		stats.Starred = append(stats.Starred, oneRow)
+	}
 
-			// Mark message as sent
-			dbQuery := `
-				UPDATE email_queue
-				SET sent = true, sent_timestamp = now()
-				WHERE email_id = $1`
-			commandTag, err := pdb.Exec(dbQuery, j.ID)
-			if err != nil {
-				log.Printf("Changing email status to sent failed for email '%v': '%v'", j.ID, err.Error())
-				return // Abort, as we don't want to continuously resend the same emails
-			}
-			if numRows := commandTag.RowsAffected(); numRows != 1 {
-				log.Printf("Wrong # of rows (%v) affected when changing email status to sent for email '%v'",
-					numRows, j.ID)
-			}
+	// Retrieve a list of which databases are the most forked
+	dbQuery = `
+		SELECT users.user_name, db.db_name, db.forks
+		FROM sqlite_databases AS db, users
+		WHERE db.forks > 0
+			AND db.public = true
+			AND db.is_deleted = false
+			AND db.user_id = users.user_id
+		ORDER BY db.forks DESC, db.last_modified
+		LIMIT 5`
+	forkRows, err := pdb.Query(dbQuery)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer forkRows.Close()
+	for forkRows.Next() {
+		var oneRow ActivityRow
+		err = forkRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+		if err != nil {
+			log.Printf("Error retrieving list of most forked databases: %v\n", err)
+			return
 		}
+		stats.Forked = append(stats.Forked, oneRow)
+	}
 
-		// Pause before running the loop again
-		time.Sleep(Conf.Event.EmailQueueProcessingDelay * time.Second)
+	// Retrieve a list of the most recent uploads
+	stats.Uploads, err = RecentUploads(5)
+	if err != nil {
+		return
 	}
-}
 
-// Stores a certificate for a given client.
-func SetClientCert(newCert []byte, userName string) error {
-	SQLQuery := `
-		UPDATE users
-		SET client_cert = $1
-		WHERE lower(user_name) = lower($2)`
-	commandTag, err := pdb.Exec(SQLQuery, newCert, userName)
+	// Retrieve a list of which databases have been downloaded the most times by someone other than their owner
+	dbQuery = `
+		SELECT users.user_name, db.db_name, db.download_count
+		FROM sqlite_databases AS db, users
+		WHERE db.download_count > 0
+			AND db.public = true
+			AND db.is_deleted = false
+			AND db.user_id = users.user_id
+		ORDER BY db.download_count DESC, db.last_modified
+		LIMIT 5`
+	dlRows, err := pdb.Query(dbQuery)
 	if err != nil {
-		log.Printf("Updating client certificate for '%s' failed: %v\n", userName, err)
-		return err
+		log.Printf("Database query failed: %v\n", err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when storing client cert for '%s'\n",
-			numRows, userName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+	defer dlRows.Close()
+	for dlRows.Next() {
+		var oneRow ActivityRow
+		err = dlRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+		if err != nil {
+			log.Printf("Error retrieving list of most downloaded databases: %v\n", err)
+			return
+		}
+		stats.Downloads = append(stats.Downloads, oneRow)
 	}
 
-	return nil
-}
-
-// Sets the user's preference for maximum number of SQLite rows to display.
-func SetUserPreferences(userName string, maxRows int, displayName string, email string) error {
-	dbQuery := `
-		UPDATE users
-		SET pref_max_rows = $2, display_name = $3, email = $4
-		WHERE lower(user_name) = lower($1)`
-	commandTag, err := pdb.Exec(dbQuery, userName, maxRows, displayName, email)
+	// Retrieve the list of databases which have been viewed the most times
+	dbQuery = `
+		SELECT users.user_name, db.db_name, db.page_views
+		FROM sqlite_databases AS db, users
+		WHERE db.page_views > 0
+			AND db.public = true
+			AND db.is_deleted = false
+			AND db.user_id = users.user_id
+		ORDER BY db.page_views DESC, db.last_modified
+		LIMIT 5`
+	viewRows, err := pdb.Query(dbQuery)
 	if err != nil {
-		log.Printf("Updating user preferences failed for user '%s'. Error: '%v'\n", userName, err)
-		return err
+		log.Printf("Database query failed: %v\n", err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows (%v) affected when updating user preferences. User: '%s'\n", numRows,
-			userName)
+	defer viewRows.Close()
+	for viewRows.Next() {
+		var oneRow ActivityRow
+		err = viewRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+		if err != nil {
+			log.Printf("Error retrieving list of most viewed databases: %v\n", err)
+			return
+		}
+		stats.Viewed = append(stats.Viewed, oneRow)
 	}
-	return nil
+	return
 }
 
-// Retrieve the latest social stats for a given database.
-func SocialStats(dbOwner string, dbFolder string, dbName string) (wa int, st int, fo int, err error) {
-
-	// TODO: Implement caching of these stats
-
-	// Retrieve latest star count
+// Retrieve the branch protection settings for a database.
+func GetBranchProtection(dbOwner string, dbFolder string, dbName string) (protection map[string]BranchProtectionEntry,
+	err error) {
 	dbQuery := `
-		SELECT stars
+		SELECT branch_protection
 		FROM sqlite_databases
 		WHERE user_id = (
 				SELECT user_id
@@ -2670,944 +3240,3755 @@ func SocialStats(dbOwner string, dbFolder string, dbName string) (wa int, st int
 			)
 			AND folder = $2
 			AND db_name = $3`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&st)
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&protection)
 	if err != nil {
-		log.Printf("Error retrieving star count for '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
-		return -1, -1, -1, err
+		log.Printf("Error when retrieving branch protection settings for database '%s%s%s': %v\n", dbOwner,
+			dbFolder, dbName, err)
+		return nil, err
 	}
+	if protection == nil {
+		// If no branches are protected yet, return an empty set instead of nil
+		protection = make(map[string]BranchProtectionEntry)
+	}
+	return protection, nil
+}
 
-	// Retrieve latest fork count
-	dbQuery = `
-		SELECT forks
-		FROM sqlite_databases
-		WHERE db_id = (
-				SELECT root_database
-				FROM sqlite_databases
-				WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-					)
-			AND folder = $2
-			AND db_name = $3)`
-	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&fo)
+// Load the branch heads for a database.
+// TODO: It might be better to have the default branch name be returned as part of this list, by indicating in the list
+// TODO  which of the branches is the default.
+func GetBranches(dbOwner string, dbFolder string, dbName string) (branches map[string]BranchEntry, err error) {
+	dbQuery := `
+		SELECT db.branch_heads
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&branches)
 	if err != nil {
-		log.Printf("Error retrieving fork count for '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
-		return -1, -1, -1, err
+		log.Printf("Error when retrieving branch heads for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
+			err)
+		return nil, err
 	}
+	return branches, nil
+}
 
-	// TODO: Implement watchers
-	return 0, st, fo, nil
+// Retrieves the full commit list for a database.
+func GetCommitList(dbOwner string, dbFolder string, dbName string) (map[string]CommitEntry, error) {
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		)
+		SELECT commit_list as commits
+		FROM sqlite_databases AS db, u
+		WHERE db.user_id = u.user_id
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	var l map[string]CommitEntry
+	err := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&l)
+	if err != nil {
+		log.Printf("Retrieving commit list for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return map[string]CommitEntry{}, err
+	}
+	return l, nil
 }
 
-// Retrieve the list of outstanding status updates for a user
-func StatusUpdates(loggedInUser string) (statusUpdates map[string][]StatusUpdateEntry, err error) {
+// Returns the default branch name for a database.
+func GetDefaultBranchName(dbOwner string, dbFolder string, dbName string) (branchName string, err error) {
 	dbQuery := `
-		SELECT status_updates
-		FROM users
-		WHERE user_name = $1`
-	err = pdb.QueryRow(dbQuery, loggedInUser).Scan(&statusUpdates)
+		SELECT db.default_branch
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	var b pgx.NullString
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&b)
 	if err != nil {
-		log.Printf("Error retrieving status updates list for user '%s': %v", loggedInUser, err)
-		return
+		if err != pgx.ErrNoRows {
+			log.Printf("Error when retrieving default branch name for database '%s%s%s': %v\n", dbOwner,
+				dbFolder, dbName, err)
+			return
+		} else {
+			log.Printf("No default branch name exists for database '%s%s%s'. This shouldn't happen\n", dbOwner,
+				dbFolder, dbName)
+			return
+		}
+	}
+	if b.Valid {
+		branchName = b.String
 	}
 	return
 }
 
-// Periodically generates status updates (alert emails TBD) from the event queue
-func StatusUpdatesLoop() {
-	// Ensure a warning message is displayed on the console if the status update loop exits
-	defer func() {
-		log.Printf("WARN: Status update loop exited")
-	}()
-
-	// Log the start of the loop
-	log.Printf("Status update processing loop started.  %d second refresh.", Conf.Event.Delay)
-
-	// Start the endless status update processing loop
-	var rows *pgx.Rows
-	var err error
-	type evEntry struct {
-		dbID      int64
-		details   EventDetails
-		eType     EventType
-		eventID   int64
-		timeStamp time.Time
-	}
-	for {
-		// Begin a transaction
-		var tx *pgx.Tx
-		tx, err = pdb.Begin()
-		if err != nil {
-			log.Printf("Couldn't begin database transaction for status update processing loop: %s", err.Error())
-			continue
+// Returns the default table name for a database.
+func GetDefaultTableName(dbOwner string, dbFolder string, dbName string) (tableName string, err error) {
+	dbQuery := `
+		SELECT db.default_table
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	var t pgx.NullString
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&t)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Printf("Error when retrieving default table name for database '%s%s%s': %v\n", dbOwner,
+				dbFolder, dbName, err)
+			return
 		}
+	}
+	if t.Valid {
+		tableName = t.String
+	}
+	return
+}
 
-		// Retrieve the list of outstanding events
-		// NOTE - We gather the db_id here instead of dbOwner/dbFolder/dbName as it should be faster for PG to deal
-		//        with when generating the watcher list
-		dbQuery := `
-			SELECT event_id, event_timestamp, db_id, event_type, event_data
-			FROM events
-			ORDER BY event_id ASC`
-		rows, err = tx.Query(dbQuery)
-		if err != nil {
-			log.Printf("Generating status update event list failed: %v\n", err)
-			tx.Rollback()
-			continue
+// Returns the discussion and merge request counts for a database
+// TODO: The only reason this function exists atm, is because we're incorrectly caching the discussion and MR data in
+// TODO  a way that makes invalidating it correctly hard/impossible.  We should redo our memcached approach to solve the
+// TODO  issue properly
+func GetDiscussionAndMRCount(dbOwner string, dbFolder string, dbName string) (discCount int, mrCount int, err error) {
+	dbQuery := `
+		SELECT db.discussions, db.merge_requests
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&discCount, &mrCount)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			log.Printf("Error when retrieving discussion and MR count for database '%s%s%s': %v\n", dbOwner,
+				dbFolder, dbName, err)
+			return
+		} else {
+			log.Printf("Database '%s%s%s' not found when attempting to retrieve discussion and MR count. This"+
+				"shouldn't happen\n", dbOwner, dbFolder, dbName)
+			return
 		}
-		evList := make(map[int64]evEntry)
-		for rows.Next() {
-			var ev evEntry
-			err = rows.Scan(&ev.eventID, &ev.timeStamp, &ev.dbID, &ev.eType, &ev.details)
-			if err != nil {
-				log.Printf("Error retrieving event list for status updates thread: %v\n", err)
-				rows.Close()
-				tx.Rollback()
-				continue
-			}
-			evList[ev.eventID] = ev
+	}
+	return
+}
+
+// Returns the text for a given licence.
+func GetLicence(userName string, licenceName string) (txt string, format string, err error) {
+	dbQuery := `
+		SELECT licence_text, file_format
+		FROM database_licences
+		WHERE friendly_name ILIKE $2
+		AND (
+				user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				) OR
+				user_id = (
+					SELECT user_id
+					FROM users
+					WHERE user_name = 'default'
+				)
+			)`
+	err = pdb.QueryRow(dbQuery, userName, licenceName).Scan(&txt, &format)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// The requested licence text wasn't found
+			return "", "", errors.New("unknown licence")
 		}
-		rows.Close()
+		log.Printf("Error when retrieving licence '%s', user '%s': %v\n", licenceName, userName, err)
+		return "", "", err
+	}
+	return txt, format, nil
+}
 
-		// For each event, add a status update to the status_updates list for each watcher it's for
-		for id, ev := range evList {
-			// Retrieve the list of watchers for the database the event occurred on
-			dbQuery := `
+// Returns the list of licences available to a user.
+func GetLicences(user string) (map[string]LicenceEntry, error) {
+	dbQuery := `
+		SELECT friendly_name, full_name, lic_sha256, licence_url, file_format, display_order
+		FROM database_licences
+		WHERE user_id = (
 				SELECT user_id
-				FROM watchers
-				WHERE db_id = $1`
-			rows, err = tx.Query(dbQuery, ev.dbID)
-			if err != nil {
-				log.Printf("Database query failed: %v\n", err)
-				tx.Rollback()
-				continue
-			}
-			var users []int64
-			for rows.Next() {
-				var user int64
-				err = rows.Scan(&user)
-				if err != nil {
-					log.Printf("Error retrieving user list for status updates thread: %v\n", err)
-					rows.Close()
-					tx.Rollback()
-					continue
-				}
-				users = append(users, user)
-			}
-			rows.Close()
-
+				FROM users
+				WHERE user_name = 'default'
+			)
+			OR user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	rows, err := pdb.Query(dbQuery, user)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+	lics := make(map[string]LicenceEntry)
+	for rows.Next() {
+		var name string
+		var oneRow LicenceEntry
+		err = rows.Scan(&name, &oneRow.FullName, &oneRow.Sha256, &oneRow.URL, &oneRow.FileFormat, &oneRow.Order)
+		if err != nil {
+			log.Printf("Error retrieving licence list: %v\n", err)
+			return nil, err
+		}
+		lics[name] = oneRow
+	}
+	return lics, nil
+}
+
+// Returns the friendly name + licence URL for the licence matching a given sha256.
+// Note - When user defined licence has the same sha256 as a default one we return the user defined licences' friendly
+// name.
+func GetLicenceInfoFromSha256(userName string, sha256 string) (lName string, lURL string, err error) {
+	dbQuery := `
+		SELECT u.user_name, dl.friendly_name, dl.licence_url
+		FROM database_licences AS dl, users AS u
+		WHERE dl.lic_sha256 = $2
+			AND dl.user_id = u.user_id
+			AND (dl.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE user_name = 'default'
+			)
+			OR dl.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			))`
+	rows, err := pdb.Query(dbQuery, userName, sha256)
+	if err != nil {
+		log.Printf("Error when retrieving friendly name for licence sha256 '%s', user '%s': %v\n", sha256,
+			userName, err)
+		return "", "", err
+	}
+	defer rows.Close()
+	type lic struct {
+		Licence string
+		Name    string
+		User    string
+	}
+	var list []lic
+	for rows.Next() {
+		var oneRow lic
+		err = rows.Scan(&oneRow.User, &oneRow.Name, &oneRow.Licence)
+		if err != nil {
+			log.Printf("Error retrieving friendly name for licence sha256 '%s', user: %v\n", sha256, err)
+			return "", "", err
+		}
+		list = append(list, oneRow)
+	}
+
+	// Decide what to return based upon the number of licence matches
+	numLics := len(list)
+	switch numLics {
+	case 0:
+		// If there are no matching sha256's, something has gone wrong
+		return "", "", errors.New("No matching licence found, something has gone wrong!")
+	case 1:
+		// If there's only one matching sha256, we return the corresponding licence name + url
+		lName = list[0].Name
+		lURL = list[0].Licence
+		return lName, lURL, nil
+	default:
+		// If more than one name was found for the matching sha256, that seems a bit trickier.  At least one of them
+		// would have to be a user defined licence, so we'll return the first one of those instead of the default
+		// licence name.  This seems to allow users to define their own friendly name's for the default licences which
+		// is probably not a bad thing
+		for _, j := range list {
+			if j.User == userName {
+				lName = j.Name
+				lURL = j.Licence
+				break
+			}
+		}
+	}
+	if lName == "" {
+		// Multiple licence friendly names were returned, but none of them matched the requesting user.  Something has
+		// gone wrong
+		return "", "", fmt.Errorf("Multiple matching licences found, but belonging to user %s\n", userName)
+	}
+
+	// To get here we must have successfully picked a user defined licence out of several matches.  This seems like
+	// an acceptable scenario
+	return lName, lURL, nil
+}
+
+// Returns the sha256 for a given licence.
+func GetLicenceSha256FromName(userName string, licenceName string) (sha256 string, err error) {
+	dbQuery := `
+		SELECT lic_sha256
+		FROM database_licences
+		WHERE friendly_name = $2
+			AND (user_id = (
+				SELECT user_id
+				FROM users
+				WHERE user_name = 'default'
+			)
+			OR user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			))`
+	err = pdb.QueryRow(dbQuery, userName, licenceName).Scan(&sha256)
+	if err != nil {
+		log.Printf("Error when retrieving sha256 for licence '%s', user '%s' from database: %v\n", licenceName,
+			userName, err)
+		return "", err
+	}
+	if sha256 == "" {
+		// The requested licence wasn't found
+		return "", errors.New("Licence not found")
+	}
+	return sha256, nil
+}
+
+// Retrieve the merge policy for a database.
+func GetMergePolicy(dbOwner string, dbFolder string, dbName string) (policy MergePolicy, err error) {
+	dbQuery := `
+		SELECT merge_policy
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&policy)
+	if err != nil {
+		log.Printf("Error when retrieving merge policy for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+		return MergePolicy{}, err
+	}
+	if policy.Reviewers == nil {
+		policy.Reviewers = []string{}
+	}
+	if policy.Teams == nil {
+		policy.Teams = []string{}
+	}
+	return policy, nil
+}
+
+// Retrieve the list of releases for a database.
+func GetReleases(dbOwner string, dbFolder string, dbName string) (releases map[string]ReleaseEntry, err error) {
+	dbQuery := `
+		SELECT release_list
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&releases)
+	if err != nil {
+		log.Printf("Error when retrieving releases for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+		return nil, err
+	}
+	if releases == nil {
+		// If there aren't any releases yet, return an empty set instead of nil
+		releases = make(map[string]ReleaseEntry)
+	}
+	return releases, nil
+}
+
+// Retrieve the SQL assertions run as status checks for the merge requests of a database.
+func GetSQLAssertions(dbOwner string, dbFolder string, dbName string) (assertions []SQLAssertion, err error) {
+	dbQuery := `
+		SELECT sql_assertions
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&assertions)
+	if err != nil {
+		log.Printf("Error when retrieving SQL assertions for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
+			err)
+		return nil, err
+	}
+	if assertions == nil {
+		assertions = []SQLAssertion{}
+	}
+	return assertions, nil
+}
+
+// Retrieve the tags for a database.
+func GetTags(dbOwner string, dbFolder string, dbName string) (tags map[string]TagEntry, err error) {
+	dbQuery := `
+		SELECT tag_list
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&tags)
+	if err != nil {
+		log.Printf("Error when retrieving tags for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+		return nil, err
+	}
+	if tags == nil {
+		// If there aren't any tags yet, return an empty set instead of nil
+		tags = make(map[string]TagEntry)
+	}
+	return tags, nil
+}
+
+// Retrieves the details of an in progress upload.
+func GetUploadSession(userName string, uploadID string) (upload UploadSession, err error) {
+	dbQuery := `
+		SELECT up.upload_id, users.user_name, owner.user_name, up.folder, up.db_name, up.total_size,
+			up.received_size, up.hash_state, up.form_values, up.date_created, up.last_modified
+		FROM upload_sessions AS up, users, users AS owner
+		WHERE up.upload_id = $2
+			AND up.user_id = users.user_id
+			AND up.db_owner_id = owner.user_id
+			AND lower(users.user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName, uploadID).Scan(&upload.ID, &upload.Owner, &upload.DBOwner, &upload.Folder,
+		&upload.DBName, &upload.Size, &upload.Received, &upload.HashState, &upload.FormValues, &upload.DateCreated,
+		&upload.LastModified)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return UploadSession{}, ErrUploadNotFound
+		}
+		log.Printf("Error when retrieving upload session '%s' for user '%s': %v\n", uploadID, userName, err)
+		return UploadSession{}, err
+	}
+	return
+}
+
+// Returns the username associated with an email address.
+func GetUsernameFromEmail(email string) (userName string, avatarURL string, err error) {
+	dbQuery := `
+		SELECT user_name, avatar_url
+		FROM users
+		WHERE email = $1`
+	var av pgx.NullString
+	err = pdb.QueryRow(dbQuery, email).Scan(&userName, &av)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// No matching username of the email
+			err = nil
+			return
+		}
+		log.Printf("Looking up username for email address '%s' failed: %v\n", email, err)
+		return
+	}
+
+	// If no avatar URL is presently stored, default to a gravatar based on the users email (if known)
+	if !av.Valid {
+		picHash := md5.Sum([]byte(email))
+		avatarURL = fmt.Sprintf("https://www.gravatar.com/avatar/%x?d=identicon", picHash)
+	} else {
+		avatarURL = av.String
+	}
+	return
+}
+
+// Retrieves a saved set of visualisation query results
+func GetVisualisationData(dbOwner string, dbFolder string, dbName string, commitID string, hash string) (data []VisRowV1, ok bool, err error) {
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND folder = $2
+				AND db_name = $3
+		)
+		SELECT results
+		FROM vis_result_cache as vis_cache, u, d
+		WHERE vis_cache.db_id = d.db_id
+			AND vis_cache.user_id = u.user_id
+			AND vis_cache.commit_id = $4
+			AND vis_cache.hash = $5`
+	e := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, commitID, hash).Scan(&data)
+	if e != nil {
+		if e == pgx.ErrNoRows {
+			// There weren't any saved parameters for this database visualisation
+			return
+		}
+
+		// A real database error occurred
+		err = e
+		log.Printf("Checking if a database exists failed: %v\n", e)
+		return
+	}
+
+	// Data was successfully retrieved
+	ok = true
+	return
+}
+
+// Retrieves a saved set of visualisation parameters
+func GetVisualisationParams(dbOwner string, dbFolder string, dbName string, visName string) (params VisParamsV1, ok bool, err error) {
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND folder = $2
+				AND db_name = $3
+		)
+		SELECT parameters
+		FROM vis_params as vis, u, d
+		WHERE vis.db_id = d.db_id
+			AND vis.user_id = u.user_id
+			AND vis.name = $4`
+	e := pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, visName).Scan(&params)
+	if e != nil {
+		if e == pgx.ErrNoRows {
+			// There weren't any saved parameters for this database visualisation
+			return
+		}
+
+		// A real database error occurred
+		err = e
+		log.Printf("Retrieving visualisation parameters for '%s%s%s', visualisation '%s' failed: %v\n", dbOwner, dbFolder, dbName, visName, e)
+		return
+	}
+
+	// Parameters were successfully retrieved
+	ok = true
+	return
+}
+
+// Increments the download count for a database
+func IncrementDownloadCount(dbOwner string, dbFolder string, dbName string) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET download_count = download_count + 1
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Increment download count for '%s%s%s' failed: %v\n", dbOwner, dbFolder,
+			dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when incrementing download count for '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
+		log.Printf(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Create a download log entry
+func LogDownload(dbOwner string, dbFolder string, dbName string, loggedInUser string, ipAddr string, serverSw string,
+	userAgent string, downloadDate time.Time, sha string) error {
+	// If the downloader isn't a logged in user, use a NULL value for that column
+	var downloader pgx.NullString
+	if loggedInUser != "" {
+		downloader.String = loggedInUser
+		downloader.Valid = true
+	}
+
+	// Store the download details
+	dbQuery := `
+		WITH d AS (
+			SELECT db.db_id, db.folder, db.db_name
+			FROM sqlite_databases AS db
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+		)
+		INSERT INTO database_downloads (db_id, user_id, ip_addr, server_sw, user_agent, download_date, db_sha256)
+		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, downloader, ipAddr, serverSw, userAgent,
+		downloadDate, sha)
+	if err != nil {
+		log.Printf("Storing record of download '%s%s%s', sha '%s' by '%s' failed: %v\n", dbOwner, dbFolder,
+			dbName, sha, downloader.String, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected while storing download record for '%s%s%s'\n", numRows,
+			dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Create an upload log entry
+func LogUpload(dbOwner string, dbFolder string, dbName string, loggedInUser string, ipAddr string, serverSw string,
+	userAgent string, uploadDate time.Time, sha string) error {
+	// If the uploader isn't a logged in user, use a NULL value for that column
+	var uploader pgx.NullString
+	if loggedInUser != "" {
+		uploader.String = loggedInUser
+		uploader.Valid = true
+	}
+
+	// Store the upload details
+	dbQuery := `
+		WITH d AS (
+			SELECT db.db_id, db.folder, db.db_name
+			FROM sqlite_databases AS db
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+		)
+		INSERT INTO database_uploads (db_id, user_id, ip_addr, server_sw, user_agent, upload_date, db_sha256)
+		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, uploader, ipAddr, serverSw, userAgent,
+		uploadDate, sha)
+	if err != nil {
+		log.Printf("Storing record of upload '%s%s%s', sha '%s' by '%s' failed: %v\n", dbOwner, dbFolder,
+			dbName, sha, uploader.String, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected while storing upload record for '%s%s%s'\n", numRows,
+			dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Returns the reviews of a merge request, in the order they were given.
+func MergeRequestReviews(dbOwner string, dbFolder string, dbName string, discID int) (list []MergeRequestReview,
+	err error) {
+	dbQuery := `
+		SELECT u.user_name, coalesce(u.avatar_url, ''), rev.state, rev.commit_id, rev.date_reviewed
+		FROM merge_request_reviews AS rev, users AS u
+		WHERE rev.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND rev.disc_id = $4
+			AND rev.user_id = u.user_id
+		ORDER BY rev.date_reviewed`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName, discID)
+	if err != nil {
+		log.Printf("Retrieving reviews for database '%s%s%s', MR '%d' failed: %v\n", dbOwner, dbFolder, dbName,
+			discID, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rev MergeRequestReview
+		var state string
+		err = rows.Scan(&rev.User, &rev.AvatarURL, &state, &rev.CommitID, &rev.DateReviewed)
+		if err != nil {
+			log.Printf("Error retrieving reviews for database '%s%s%s', MR '%d': %v\n", dbOwner, dbFolder, dbName,
+				discID, err)
+			return nil, err
+		}
+		if rev.AvatarURL != "" {
+			rev.AvatarURL += "&s=18"
+		}
+		rev.State = ReviewState(state)
+		list = append(list, rev)
+	}
+	return
+}
+
+// Returns the milestones for a database.  Open milestones are listed first, then by due date.
+func Milestones(dbOwner string, dbFolder string, dbName string) (list []Milestone, err error) {
+	dbQuery := `
+		SELECT milestone_id, title, coalesce(description, ''), due_date, coalesce(tag_name, ''),
+			coalesce(release_name, ''), open
+		FROM milestones
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+		ORDER BY open DESC, due_date ASC NULLS LAST, lower(title)`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Retrieving milestones for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Milestone
+		var due pgx.NullTime
+		err = rows.Scan(&m.ID, &m.Title, &m.Description, &due, &m.Tag, &m.Release, &m.Open)
+		if err != nil {
+			log.Printf("Error retrieving milestones for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+			return
+		}
+		if due.Valid {
+			m.DueDate = due.Time
+		}
+		list = append(list, m)
+	}
+	return
+}
+
+// Return the Minio bucket and ID for a given database. dbOwner, dbFolder, & dbName are from owner/folder/database URL
+// fragment, // loggedInUser is the name for the currently logged in user, for access permission check.  Use an empty
+// string ("") as the loggedInUser parameter if the true value isn't set or known.
+// If the requested database doesn't exist, or the loggedInUser doesn't have access to it, then an error will be
+// returned.
+func MinioLocation(dbOwner string, dbFolder string, dbName string, commitID string, loggedInUser string) (minioBucket string,
+	minioID string, lastModified time.Time, err error) {
+
+	// TODO: This will likely need updating to query the "database_files" table to retrieve the Minio server name
+
+	// If no commit was provided, we grab the default one
+	if commitID == "" {
+		commitID, err = DefaultCommit(dbOwner, dbFolder, dbName)
+		if err != nil {
+			return // Bucket and ID are still the initial default empty string
+		}
+	}
+
+	// Retrieve the sha256 and last modified date for the requested commit's database file
+	var dbQuery string
+	dbQuery = `
+		SELECT commit_list->$4::text->'tree'->'entries'->0->'sha256' AS sha256,
+			commit_list->$4::text->'tree'->'entries'->0->'last_modified' AS last_modified
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+
+	// If the request is for another users database, it needs to be a public one, or a private one they've been given
+	// access to
+	args := []interface{}{dbOwner, dbFolder, dbName, commitID}
+	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
+		dbQuery += `
+				AND ` + privateAccessSQL("db", "$5")
+		args = append(args, loggedInUser)
+	}
+
+	var sha, mod string
+	err = pdb.QueryRow(dbQuery, args...).Scan(&sha, &mod)
+	if err != nil {
+		log.Printf("Error retrieving MinioID for %s/%s version %v: %v\n", dbOwner, dbName, commitID, err)
+		return // Bucket and ID are still the initial default empty string
+	}
+
+	if sha == "" {
+		// The requested database doesn't exist, or the logged in user doesn't have access to it
+		err = fmt.Errorf("The requested database wasn't found")
+		return // Bucket and ID are still the initial default empty string
+	}
+
+	lastModified, err = time.Parse(time.RFC3339, mod)
+	if err != nil {
+		return // Bucket and ID are still the initial default empty string
+	}
+
+	minioBucket = sha[:MinioFolderChars]
+	minioID = sha[MinioFolderChars:]
+	return
+}
+
+// Moves a review comment anchor to a different commit.  Once an anchor is outdated it stays that way.
+func moveCommentAnchor(comID int64, commitID string, outdated bool) error {
+	dbQuery := `
+		UPDATE discussion_comment_anchors
+		SET commit_id = $2, outdated = outdated OR $3
+		WHERE com_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, comID, commitID, outdated)
+	if err != nil {
+		log.Printf("Moving the anchor for comment '%d' to commit '%s' failed: %v\n", comID, commitID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when moving the anchor for comment '%d'\n", numRows, comID)
+	}
+	return nil
+}
+
+// Adds an event entry to PostgreSQL
+func NewEvent(details EventDetails) (err error) {
+	dbQuery := `
+		WITH d AS (
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		)
+		INSERT INTO events (db_id, event_type, event_data)
+		VALUES ((SELECT db_id FROM d), $4, $5)`
+	_, err = pdb.Exec(dbQuery, details.Owner, details.Folder, details.DBName, details.Type, details)
+	if err != nil {
+		return err
+	}
+
+	// Let the status update processing loop know there's a new event to process
+	notifyNewEvents()
+	return
+}
+
+// Returns the number of uploads a user has in progress, and the total size of the uploads in progress for databases
+// of the given owner.
+func OpenUploads(userName string, dbOwner string) (userUploads int, ownerBytes int64, err error) {
+	dbQuery := `
+		SELECT (
+				SELECT count(*)
+				FROM upload_sessions
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1))
+			), (
+				SELECT coalesce(sum(total_size), 0)::bigint
+				FROM upload_sessions
+				WHERE db_owner_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($2))
+			)`
+	err = pdb.QueryRow(dbQuery, userName, dbOwner).Scan(&userUploads, &ownerBytes)
+	if err != nil {
+		log.Printf("Retrieving the uploads in progress for user '%s' failed: %v\n", userName, err)
+	}
+	return
+}
+
+// Returns the members of an organisation.
+func OrgMembers(orgName string) (list []OrgMemberEntry, err error) {
+	dbQuery := `
+		SELECT u.user_name, u.display_name, u.avatar_url, m.role, m.date_joined
+		FROM org_members AS m, users AS u
+		WHERE m.org_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
+			)
+			AND m.user_id = u.user_id
+		ORDER BY lower(u.user_name)`
+	rows, err := pdb.Query(dbQuery, orgName)
+	if err != nil {
+		log.Printf("Retrieving members of organisation '%s' failed: %v\n", orgName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m OrgMemberEntry
+		var avatarURL, displayName pgx.NullString
+		var role string
+		err = rows.Scan(&m.UserName, &displayName, &avatarURL, &role, &m.DateJoined)
+		if err != nil {
+			log.Printf("Error retrieving members of organisation '%s': %v\n", orgName, err)
+			return
+		}
+		m.AvatarURL = avatarURL.String
+		m.DisplayName = displayName.String
+		m.Role = OrgRole(role)
+		list = append(list, m)
+	}
+	return
+}
+
+// Returns the level of access a user has to an organisation itself, such as for managing it or creating new databases
+// in it.  Owners of the organisation get owner level access, other members get read access raised to the highest role
+// of their teams.  Access to the existing databases of the organisation comes from DBPermission() instead, as teams
+// only have access to the databases they've been given.
+func OrgPermission(loggedInUser string, orgName string) (perm Permission, err error) {
+	if loggedInUser == "" {
+		return PERM_NONE, nil
+	}
+	dbQuery := `
+		SELECT m.role, t.role
+		FROM org_members AS m
+			LEFT JOIN org_team_members AS tm ON tm.user_id = m.user_id
+			LEFT JOIN org_teams AS t ON t.team_id = tm.team_id
+				AND t.org_id = m.org_id
+		WHERE m.org_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
+			)
+			AND m.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)`
+	rows, err := pdb.Query(dbQuery, orgName, loggedInUser)
+	if err != nil {
+		log.Printf("Retrieving permissions of '%s' for organisation '%s' failed: %v\n", loggedInUser, orgName, err)
+		return PERM_NONE, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var memberRole string
+		var teamRole pgx.NullString
+		err = rows.Scan(&memberRole, &teamRole)
+		if err != nil {
+			log.Printf("Error retrieving permissions of '%s' for organisation '%s': %v\n", loggedInUser, orgName,
+				err)
+			return PERM_NONE, err
+		}
+		if OrgRole(memberRole) == ORG_OWNER {
+			return PERM_OWNER, nil
+		}
+		if perm < PERM_READ {
+			perm = PERM_READ
+		}
+		if p := rolePermission(CollaboratorRole(teamRole.String)); p > perm {
+			perm = p
+		}
+	}
+	return
+}
+
+// Returns the teams of an organisation, along with their members and the databases they've been given.
+func OrgTeams(orgName string) (list []TeamEntry, err error) {
+	dbQuery := `
+		SELECT t.team_name, t.description, t.role, (
+				SELECT array_agg(u.user_name ORDER BY lower(u.user_name))
+				FROM org_team_members AS tm, users AS u
+				WHERE tm.team_id = t.team_id
+					AND tm.user_id = u.user_id
+			), (
+				SELECT array_agg(db.db_name ORDER BY lower(db.db_name))
+				FROM org_team_databases AS td, sqlite_databases AS db
+				WHERE td.team_id = t.team_id
+					AND td.db_id = db.db_id
+					AND db.is_deleted = false
+			)
+		FROM org_teams AS t
+		WHERE t.org_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
+			)
+		ORDER BY lower(t.team_name)`
+	rows, err := pdb.Query(dbQuery, orgName)
+	if err != nil {
+		log.Printf("Retrieving teams of organisation '%s' failed: %v\n", orgName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TeamEntry
+		var desc pgx.NullString
+		var role string
+		err = rows.Scan(&t.Name, &desc, &role, &t.Members, &t.Databases)
+		if err != nil {
+			log.Printf("Error retrieving teams of organisation '%s': %v\n", orgName, err)
+			return
+		}
+		if t.Members == nil {
+			t.Members = []string{}
+		}
+		if t.Databases == nil {
+			t.Databases = []string{}
+		}
+		t.Description = desc.String
+		t.Role = CollaboratorRole(role)
+		list = append(list, t)
+	}
+	return
+}
+
+// Returns the daily and weekly digests which are due to be sent.  A digest is due once its oldest event has been
+// waiting for a full day or week.
+func pendingDigests() (list []digestJob, err error) {
+	dbQuery := `
+		WITH due AS (
+			SELECT user_id, frequency
+			FROM digest_queue
+			GROUP BY user_id, frequency
+			HAVING min(date_created) <= now() - CASE WHEN frequency = 'weekly'
+				THEN interval '7 days' ELSE interval '1 day' END
+		)
+		SELECT q.digest_id, q.user_id, u.user_name, coalesce(u.email, ''), q.frequency, q.summary,
+			coalesce(q.title, ''), q.url, o.user_name, db.folder, db.db_name
+		FROM digest_queue AS q, due, users AS u, sqlite_databases AS db, users AS o
+		WHERE q.user_id = due.user_id
+			AND q.frequency = due.frequency
+			AND u.user_id = q.user_id
+			AND db.db_id = q.db_id
+			AND o.user_id = db.user_id
+		ORDER BY q.user_id, q.frequency, o.user_name, db.folder, db.db_name, q.digest_id`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		log.Printf("Retrieving pending digests failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i digestItem
+		var j digestJob
+		var freq, owner, folder, name string
+		err = rows.Scan(&i.ID, &j.UserID, &j.UserName, &j.Email, &freq, &i.Summary, &i.Title, &i.URL, &owner,
+			&folder, &name)
+		if err != nil {
+			log.Printf("Error retrieving pending digests: %v\n", err)
+			return
+		}
+		i.DBName = fmt.Sprintf("%s%s%s", owner, folder, name)
+		j.Frequency = NotifyFrequency(freq)
+
+		// The rows are ordered by user and frequency, so each digest is a run of consecutive rows
+		if n := len(list); n > 0 && list[n-1].UserID == j.UserID && list[n-1].Frequency == j.Frequency {
+			list[n-1].Items = append(list[n-1].Items, i)
+			continue
+		}
+		j.Items = []digestItem{i}
+		list = append(list, j)
+	}
+	return
+}
+
+// Return the user's preference for maximum number of SQLite rows to display.
+func PrefUserMaxRows(loggedInUser string) int {
+	// Retrieve the user preference data
+	dbQuery := `
+		SELECT pref_max_rows
+		FROM users
+		WHERE user_id = (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1))`
+	var maxRows int
+	err := pdb.QueryRow(dbQuery, loggedInUser).Scan(&maxRows)
+	if err != nil {
+		log.Printf("Error retrieving user '%s' preference data: %v\n", loggedInUser, err)
+		return DefaultNumDisplayRows // Use the default value
+	}
+
+	return maxRows
+}
+
+// Permanently removes a database from the trash.  If other databases were forked from it, a stub entry is kept so the
+// fork tree stays intact, otherwise its entry is deleted completely.  The sha256s of the database files used by its
+// commits are returned, so they can be garbage collected from Minio.
+func PurgeDatabase(dbID int64) (shas []string, err error) {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Retrieve the commit list and number of forks for the database.  The database is locked until the purge is done,
+	// so it can't be restored in the meantime
+	dbQuery := `
+		SELECT db.commit_list, (
+				SELECT count(*)
+				FROM sqlite_databases AS forks
+				WHERE forks.forked_from = db.db_id
+			)
+		FROM sqlite_databases AS db
+		WHERE db.db_id = $1
+			AND db.is_deleted = true
+		FOR UPDATE OF db`
+	var commitList map[string]CommitEntry
+	var numForks int
+	err = tx.QueryRow(dbQuery, dbID).Scan(&commitList, &numForks)
+	if err != nil {
+		log.Printf("Retrieving details of deleted database '%d' failed: %v\n", dbID, err)
+		return
+	}
+
+	if numForks > 0 {
+		// Keep the stub entry for the fork tree, it just can't be restored any more
+		dbQuery = `
+			DELETE FROM database_trash
+			WHERE db_id = $1`
+	} else {
+		// The 'ON DELETE CASCADE' definitions remove the trash entry, stars, watchers, and everything else
+		// referencing the database
+		dbQuery = `
+			DELETE FROM sqlite_databases
+			WHERE db_id = $1
+				AND is_deleted = true`
+	}
+	commandTag, err := tx.Exec(dbQuery, dbID)
+	if err != nil {
+		log.Printf("Purging deleted database '%d' failed: %v\n", dbID, err)
+		return
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when purging deleted database '%d'\n", numRows, dbID)
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	// Gather the database files used by the commits
+	if numForks == 0 {
+		found := make(map[string]struct{})
+		for _, c := range commitList {
+			for _, e := range c.Tree.Entries {
+				if e.EntryType == DATABASE && e.Sha256 != "" {
+					found[e.Sha256] = struct{}{}
+				}
+			}
+		}
+		for sha := range found {
+			shas = append(shas, sha)
+		}
+	}
+	log.Printf("Deleted database '%d' purged from the trash\n", dbID)
+	return
+}
+
+// Returns the most recently updated public databases, excluding forks.
+func RecentUploads(limit int) (list []UploadRow, err error) {
+	dbQuery := `
+		SELECT user_name, db.db_name, db.last_modified
+		FROM sqlite_databases AS db, users
+		WHERE db.forked_from IS NULL
+			AND db.public = true
+			AND db.is_deleted = false
+			AND db.user_id = users.user_id
+		ORDER BY db.last_modified DESC
+		LIMIT $1`
+	rows, err := pdb.Query(dbQuery, limit)
+	if err != nil {
+		log.Printf("Database query failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow UploadRow
+		err = rows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.UploadDate)
+		if err != nil {
+			log.Printf("Error retrieving list of most recent uploads: %v\n", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Queues a fresh delivery of an earlier webhook payload.
+func RedeliverWebhook(dbOwner string, dbFolder string, dbName string, deliveryID int64) error {
+	dbQuery := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+		SELECT del.webhook_id, del.event_type, del.payload
+		FROM webhook_deliveries AS del, webhooks AS hook
+		WHERE del.delivery_id = $4
+			AND del.webhook_id = hook.webhook_id
+			AND hook.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1))
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false)`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, deliveryID)
+	if err != nil {
+		log.Printf("Queuing redelivery of webhook delivery '%d' for database '%s%s%s' failed: %v\n", deliveryID,
+			dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Unknown webhook delivery '%d'", deliveryID)
+	}
+	return nil
+}
+
+// Rename a SQLite database.
+func RenameDatabase(userName string, dbFolder string, dbName string, newName string) error {
+	// Save the database settings
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET db_name = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, userName, dbFolder, dbName, newName)
+	if err != nil {
+		log.Printf("Renaming database '%s%s%s' failed: %v\n", userName, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when renaming '%s%s%s' to '%s%s%s'\n",
+			numRows, userName, dbFolder, dbName, userName, dbFolder, newName)
+		log.Printf(errMsg)
+		return errors.New(errMsg)
+	}
+
+	// Log the rename
+	log.Printf("Database renamed from '%s%s%s' to '%s%s%s'\n", userName, dbFolder, dbName, userName, dbFolder,
+		newName)
+
+	return nil
+}
+
+// Renames a user or organisation.  Databases are linked to users by ID, so they move with the new name.
+func RenameUser(oldName string, newName string) error {
+	dbQuery := `
+		UPDATE users
+		SET user_name = $2, auth0_id = CASE WHEN is_org THEN 'org|' || lower($2) ELSE auth0_id END
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, oldName, newName)
+	if err != nil {
+		log.Printf("Renaming user '%s' to '%s' failed: %v\n", oldName, newName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when renaming user '%s' to '%s'\n", numRows, oldName,
+			newName)
+	}
+	log.Printf("User '%s' renamed to '%s'\n", oldName, newName)
+	return nil
+}
+
+// Invalidates all of the existing client certificates for a user.  Only certificates generated after this point are
+// accepted by the DB4S end point.
+func ResetClientCerts(userName string) error {
+	dbQuery := `
+		UPDATE users
+		SET certs_valid_from = now()
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		log.Printf("Resetting client certificates for user '%s' failed: %v\n", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when resetting client certificates for user '%s'\n",
+			numRows, userName)
+	}
+	return nil
+}
+
+// Restores a database from the trash of its owner, under its original name.  As its entry in sqlite_databases was
+// kept, its stars, watchers, and position in the fork tree come back with it.
+func RestoreDatabase(dbOwner string, dbID int64) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Put the original name and visibility of the database back
+	dbQuery := `
+		UPDATE sqlite_databases AS db
+		SET is_deleted = false, db_name = t.db_name, public = t.public
+		FROM database_trash AS t
+		WHERE db.db_id = $2
+			AND t.db_id = db.db_id
+			AND db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+		RETURNING db.folder, db.db_name`
+	var dbFolder, dbName string
+	err = tx.QueryRow(dbQuery, dbOwner, dbID).Scan(&dbFolder, &dbName)
+	if err != nil {
+		log.Printf("Restoring deleted database '%d' of '%s' failed: %v\n", dbID, dbOwner, err)
+		return err
+	}
+
+	// Remove the trash entry
+	dbQuery = `
+		DELETE FROM database_trash
+		WHERE db_id = $1`
+	commandTag, err := tx.Exec(dbQuery, dbID)
+	if err != nil {
+		log.Printf("Removing trash entry for database '%d' failed: %v\n", dbID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when removing trash entry for database '%d'\n", numRows,
+			dbID)
+	}
+
+	// Update the fork count for the root database
+	err = updateForkCount(tx, dbOwner, dbFolder, dbName)
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	log.Printf("Database '%s%s%s' restored from the trash\n", dbOwner, dbFolder, dbName)
+	return nil
+}
+
+// Returns the SQL condition for a database being visible to a user.  As well as public databases, they can see private
+// ones they're a collaborator on, ones owned by an organisation they're an owner of, and ones given to any of their
+// organisation teams.  The table name (or alias) of the databases, and the query parameter holding the user name, are
+// filled in from the arguments.
+func privateAccessSQL(dbTable string, userParam string) string {
+	return fmt.Sprintf(`(%[1]s.public = true OR %[1]s.db_id IN (
+				SELECT db_id
+				FROM database_collaborators
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower(%[2]s)
+					)
+				) OR %[1]s.user_id IN (
+				SELECT org_id
+				FROM org_members
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower(%[2]s)
+					)
+					AND role = '%[3]s'
+				) OR %[1]s.db_id IN (
+				SELECT td.db_id
+				FROM org_team_databases AS td, org_team_members AS tm
+				WHERE tm.team_id = td.team_id
+					AND tm.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower(%[2]s)
+					)
+				)
+			)`, dbTable, userParam, ORG_OWNER)
+}
+
+// Converts a collaborator or team role into the level of database access it grants.
+func rolePermission(role CollaboratorRole) Permission {
+	switch role {
+	case COLLAB_ADMIN:
+		return PERM_ADMIN
+	case COLLAB_WRITE:
+		return PERM_WRITE
+	case COLLAB_READ:
+		return PERM_READ
+	}
+	return PERM_NONE
+}
+
+// Saves updated database settings to PostgreSQL.
+func SaveDBSettings(userName string, dbFolder string, dbName string, oneLineDesc string, fullDesc string,
+	defaultTable string, public bool, sourceURL string, defaultBranch string) error {
+	// Check for values which should be NULL
+	var nullable1LineDesc, nullableFullDesc, nullableSourceURL pgx.NullString
+	if oneLineDesc == "" {
+		nullable1LineDesc.Valid = false
+	} else {
+		nullable1LineDesc.String = oneLineDesc
+		nullable1LineDesc.Valid = true
+	}
+	if fullDesc == "" {
+		nullableFullDesc.Valid = false
+	} else {
+		nullableFullDesc.String = fullDesc
+		nullableFullDesc.Valid = true
+	}
+	if sourceURL == "" {
+		nullableSourceURL.Valid = false
+	} else {
+		nullableSourceURL.String = sourceURL
+		nullableSourceURL.Valid = true
+	}
+
+	// Save the database settings
+	SQLQuery := `
+		UPDATE sqlite_databases
+		SET one_line_description = $4, full_description = $5, default_table = $6, public = $7, source_url = $8,
+			default_branch = $9
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(SQLQuery, userName, dbFolder, dbName, nullable1LineDesc, nullableFullDesc, defaultTable,
+		public, nullableSourceURL, defaultBranch)
+	if err != nil {
+		log.Printf("Updating description for database '%s%s%s' failed: %v\n", userName, dbFolder,
+			dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when updating description for '%s%s%s'\n",
+			numRows, userName, dbFolder, dbName)
+		log.Printf(errMsg)
+		return errors.New(errMsg)
+	}
+
+	// Invalidate the old memcached entry for the database
+	err = InvalidateCacheEntry(userName, userName, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Stores a certificate for a given client.
+func SetClientCert(newCert []byte, userName string) error {
+	SQLQuery := `
+		UPDATE users
+		SET client_cert = $1
+		WHERE lower(user_name) = lower($2)`
+	commandTag, err := pdb.Exec(SQLQuery, newCert, userName)
+	if err != nil {
+		log.Printf("Updating client certificate for '%s' failed: %v\n", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when storing client cert for '%s'\n",
+			numRows, userName)
+		log.Printf(errMsg)
+		return errors.New(errMsg)
+	}
+
+	return nil
+}
+
+// Makes a database private.
+func SetDatabasePrivate(dbOwner string, dbFolder string, dbName string) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET public = false
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Making database '%s%s%s' private failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when making database '%s%s%s' private\n", numRows,
+			dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Disables or re-enables a user account.  Disabled users can't log in, use the API, or connect from DB4S.
+func SetUserDisabled(userName string, disabled bool) error {
+	dbQuery := `
+		UPDATE users
+		SET disabled = $2
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, disabled)
+	if err != nil {
+		log.Printf("Changing disabled status of user '%s' failed: %v\n", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when changing disabled status of user '%s'\n", numRows,
+			userName)
+	}
+	return nil
+}
+
+// Sets the user's preference for maximum number of SQLite rows to display.
+func SetUserPreferences(userName string, maxRows int, displayName string, email string) error {
+	dbQuery := `
+		UPDATE users
+		SET pref_max_rows = $2, display_name = $3, email = $4
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, maxRows, displayName, email)
+	if err != nil {
+		log.Printf("Updating user preferences failed for user '%s'. Error: '%v'\n", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong # of rows (%v) affected when updating user preferences. User: '%s'\n", numRows,
+			userName)
+	}
+	return nil
+}
+
+// Sets the limits for a user, overriding the server configuration.  Zero values remove the override.
+func SetUserQuotas(userName string, q UserQuota) error {
+	var maxUpload, maxStorage, maxDBs pgx.NullInt64
+	if q.MaxUploadSize > 0 {
+		maxUpload = pgx.NullInt64{Int64: q.MaxUploadSize, Valid: true}
+	}
+	if q.MaxStorage > 0 {
+		maxStorage = pgx.NullInt64{Int64: q.MaxStorage, Valid: true}
+	}
+	if q.MaxDatabases > 0 {
+		maxDBs = pgx.NullInt64{Int64: int64(q.MaxDatabases), Valid: true}
+	}
+	dbQuery := `
+		UPDATE users
+		SET max_upload_size = $2, max_storage = $3, max_databases = $4
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, maxUpload, maxStorage, maxDBs)
+	if err != nil {
+		log.Printf("Setting limits for user '%s' failed: %v\n", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when setting limits for user '%s'\n", numRows, userName)
+	}
+	return nil
+}
+
+// Retrieve the latest social stats for a given database.
+func SocialStats(dbOwner string, dbFolder string, dbName string) (wa int, st int, fo int, err error) {
+
+	// TODO: Implement caching of these stats
+
+	// Retrieve latest star count
+	dbQuery := `
+		SELECT stars
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&st)
+	if err != nil {
+		log.Printf("Error retrieving star count for '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+		return -1, -1, -1, err
+	}
+
+	// Retrieve latest fork count
+	dbQuery = `
+		SELECT forks
+		FROM sqlite_databases
+		WHERE db_id = (
+				SELECT root_database
+				FROM sqlite_databases
+				WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+					)
+			AND folder = $2
+			AND db_name = $3)`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&fo)
+	if err != nil {
+		log.Printf("Error retrieving fork count for '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+		return -1, -1, -1, err
+	}
+
+	// TODO: Implement watchers
+	return 0, st, fo, nil
+}
+
+// Returns the IDs of upload sessions which haven't been added to in the given amount of time.
+func StaleUploadSessions(age time.Duration) (uploads []string, err error) {
+	dbQuery := `
+		SELECT upload_id
+		FROM upload_sessions
+		WHERE last_modified < now() - $1::interval`
+	rows, err := pdb.Query(dbQuery, fmt.Sprintf("%d seconds", int64(age.Seconds())))
+	if err != nil {
+		log.Printf("Retrieving the list of stale upload sessions failed: %v\n", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Printf("Error retrieving stale upload session list: %v\n", err)
+			return
+		}
+		uploads = append(uploads, id)
+	}
+	return
+}
+
+// Retrieve the list of outstanding status updates for a user
+func StatusUpdates(loggedInUser string) (statusUpdates map[string][]StatusUpdateEntry, err error) {
+	dbQuery := `
+		SELECT status_updates
+		FROM users
+		WHERE user_name = $1`
+	err = pdb.QueryRow(dbQuery, loggedInUser).Scan(&statusUpdates)
+	if err != nil {
+		log.Printf("Error retrieving status updates list for user '%s': %v", loggedInUser, err)
+		return
+	}
+	return
+}
+
+// Periodically generates status updates (alert emails TBD) from the event queue
+func StatusUpdatesLoop() {
+	// Ensure a warning message is displayed on the console if the status update loop exits
+	defer func() {
+		log.Printf("WARN: Status update loop exited")
+	}()
+
+	// Log the start of the loop
+	log.Printf("Status update processing loop started.  Runs on new events, or every %d seconds.", Conf.Event.Delay)
+
+	// Start the endless status update processing loop
+	var rows *pgx.Rows
+	var err error
+	type evEntry struct {
+		dbID      int64
+		details   EventDetails
+		eType     EventType
+		eventID   int64
+		timeStamp time.Time
+	}
+	for {
+		// Begin a transaction
+		var tx *pgx.Tx
+		tx, err = pdb.Begin()
+		if err != nil {
+			log.Printf("Couldn't begin database transaction for status update processing loop: %s", err.Error())
+			continue
+		}
+
+		// Retrieve the list of outstanding events.  They're locked until the transaction ends, with events already
+		// locked by another server skipped, so each event is only processed once when several servers are running
+		// NOTE - We gather the db_id here instead of dbOwner/dbFolder/dbName as it should be faster for PG to deal
+		//        with when generating the watcher list
+		dbQuery := `
+			SELECT event_id, event_timestamp, db_id, event_type, event_data
+			FROM events
+			ORDER BY event_id ASC
+			FOR UPDATE SKIP LOCKED`
+		rows, err = tx.Query(dbQuery)
+		if err != nil {
+			log.Printf("Generating status update event list failed: %v\n", err)
+			tx.Rollback()
+			continue
+		}
+		evList := make(map[int64]evEntry)
+		for rows.Next() {
+			var ev evEntry
+			err = rows.Scan(&ev.eventID, &ev.timeStamp, &ev.dbID, &ev.eType, &ev.details)
+			if err != nil {
+				log.Printf("Error retrieving event list for status updates thread: %v\n", err)
+				rows.Close()
+				tx.Rollback()
+				continue
+			}
+			evList[ev.eventID] = ev
+		}
+		rows.Close()
+
+		// For each event, add a status update to the status_updates list for each watcher it's for
+		for id, ev := range evList {
+			// Retrieve the list of users the event is for, along with their notification settings for the database.
+			// Users without settings for the database use their defaults.  Mentions only go to the mentioned user.
+			// Other events go to the watchers of the database, except for those who've unsubscribed from the
+			// discussion or MR the event is about, and to the other users subscribed to it
+			dbQuery := `
+				SELECT u.user_id, coalesce(w.notify_frequency, u.notify_frequency),
+					CASE WHEN w.notify_frequency IS NULL THEN u.notify_events ELSE w.notify_events END
+				FROM users AS u
+					LEFT JOIN watchers AS w ON w.user_id = u.user_id AND w.db_id = $1
+					LEFT JOIN discussion_subscriptions AS s
+						ON s.user_id = u.user_id AND s.db_id = $1 AND s.disc_id = $2`
+			if ev.details.Type == EVENT_MENTION {
+				dbQuery += `
+				WHERE lower(u.user_name) = lower($3)`
+				rows, err = tx.Query(dbQuery, ev.dbID, ev.details.DiscID, ev.details.Mentioned)
+			} else {
+				dbQuery += `
+				WHERE u.user_id IN (
+						SELECT user_id
+						FROM watchers
+						WHERE db_id = $1
+						UNION
+						SELECT user_id
+						FROM discussion_subscriptions
+						WHERE db_id = $1
+							AND disc_id = $2
+					)
+					AND ((w.user_id IS NOT NULL AND s.subscribed IS DISTINCT FROM false)
+						OR (s.subscribed = true AND lower(u.user_name) <> lower($3)))`
+				rows, err = tx.Query(dbQuery, ev.dbID, ev.details.DiscID, ev.details.UserName)
+			}
+			if err != nil {
+				log.Printf("Database query failed: %v\n", err)
+				tx.Rollback()
+				continue
+			}
+			type watcher struct {
+				events    []int32
+				frequency NotifyFrequency
+				id        int64
+			}
+			var users []watcher
+			for rows.Next() {
+				var user watcher
+				var freq string
+				err = rows.Scan(&user.id, &freq, &user.events)
+				if err != nil {
+					log.Printf("Error retrieving user list for status updates thread: %v\n", err)
+					rows.Close()
+					tx.Rollback()
+					continue
+				}
+				user.frequency = NotifyFrequency(freq)
+				users = append(users, user)
+			}
+			rows.Close()
+
 			// For each watcher, add the new status update to their existing list
 			// TODO: It might be better to store this list in Memcached instead of hitting the database like this
-			for _, u := range users {
+			for _, wt := range users {
+				// Skip watchers who have filtered out this type of event
+				if !notifyWanted(wt.events, ev.details.Type) {
+					continue
+				}
+				u := wt.id
+
 				// Retrieve the current status updates list for the user
 				var eml pgx.NullString
 				dbQuery := `
 					SELECT user_name, email, status_updates
 					FROM users
-					WHERE user_id = $1`
-				userEvents := make(map[string][]StatusUpdateEntry)
-				var userName string
-				err := tx.QueryRow(dbQuery, u).Scan(&userName, &eml, &userEvents)
-				if err != nil {
-					log.Printf("Database query failed: %v\n", err)
-					tx.Rollback()
-					continue
-				}
+					WHERE user_id = $1`
+				userEvents := make(map[string][]StatusUpdateEntry)
+				var userName string
+				err := tx.QueryRow(dbQuery, u).Scan(&userName, &eml, &userEvents)
+				if err != nil {
+					log.Printf("Database query failed: %v\n", err)
+					tx.Rollback()
+					continue
+				}
+
+				// * Add the new event to the users status updates list *
+
+				// Group the status updates by database, and coalesce multiple updates for the same discussion or MR
+				// into a single entry (keeping the most recent one of each)
+				dbName := fmt.Sprintf("%s%s%s", ev.details.Owner, ev.details.Folder, ev.details.DBName)
+				var a StatusUpdateEntry
+				lst, ok := userEvents[dbName]
+				if ev.details.Type == EVENT_NEW_DISCUSSION || ev.details.Type == EVENT_NEW_MERGE_REQUEST ||
+					ev.details.Type == EVENT_NEW_COMMENT || ev.details.Type == EVENT_MERGE_REQUEST_MERGED ||
+					ev.details.Type == EVENT_MERGE_REQUEST_CLOSED || ev.details.Type == EVENT_MENTION {
+					if ok {
+						// Check if an entry already exists for the discussion/MR/comment
+						for i, j := range lst {
+							if j.DiscID == ev.details.DiscID {
+								// Yes, there's already an existing entry for the discussion/MR/comment so delete the old entry
+								lst = append(lst[:i], lst[i+1:]...) // Delete the old element
+							}
+						}
+					}
+				}
+				// Commits to the same branch, new forks, and new stars are also coalesced, as busy databases can
+				// generate a lot of them.  Their URLs point to the commit list for the branch, or the fork or star
+				// list for the database, so they're matched on that
+				if ok && (ev.details.Type == EVENT_NEW_COMMIT || ev.details.Type == EVENT_NEW_FORK ||
+					ev.details.Type == EVENT_NEW_STAR) {
+					var kept []StatusUpdateEntry
+					for _, j := range lst {
+						if j.DiscID != 0 || j.URL != ev.details.URL {
+							kept = append(kept, j)
+						}
+					}
+					lst = kept
+				}
+
+				// Add the new entry
+				a.DiscID = ev.details.DiscID
+				a.Title = ev.details.Title
+				a.URL = ev.details.URL
+				lst = append(lst, a)
+				userEvents[dbName] = lst
+
+				// Save the updated list for the user back to PG
+				dbQuery = `
+					UPDATE users
+					SET status_updates = $2
+					WHERE user_id = $1`
+				commandTag, err := tx.Exec(dbQuery, u, userEvents)
+				if err != nil {
+					log.Printf("Adding status update for database ID '%d' to user '%d' failed: %v", ev.dbID,
+						u, err)
+					tx.Rollback()
+					continue
+				}
+				if numRows := commandTag.RowsAffected(); numRows != 1 {
+					log.Printf("Wrong number of rows affected (%v) when adding status update for database ID "+
+						"'%d' to user '%d'", numRows, ev.dbID, u)
+					tx.Rollback()
+					continue
+				}
+
+				// Count the number of status updates for the user, to be displayed in the webUI header row
+				var numUpdates int
+				for _, i := range userEvents {
+					numUpdates += len(i)
+				}
+
+				// Add an entry to memcached for the user, indicating they have outstanding status updates available
+				err = SetUserStatusUpdates(userName, numUpdates)
+				if err != nil {
+					log.Printf("Error when updating user status updates # in memcached: %v", err)
+					continue
+				}
+				PublishLiveUpdate(LiveUpdate{Count: numUpdates, Type: LIVE_STATUS_UPDATES, User: userName})
+
+				// Send the notification by email straight away, or add it to the next digest for the user
+				if !eml.Valid || wt.frequency == NOTIFY_WEBUI {
+					continue
+				}
+				unsubURL := UnsubscribeURL(userName, ev.dbID)
+				summary, msg, htmlMsg, err := renderEventEmail(ev.details, unsubURL)
+				if err != nil {
+					log.Printf("Rendering the email for event '%d' failed: %v\n", id, err)
+					continue
+				}
+				if wt.frequency == NOTIFY_IMMEDIATE {
+					// TODO: Check if the email is username@thisserver, which indicates a non-functional email address
+					dbQuery = `
+						INSERT INTO email_queue (mail_to, subject, body, html_body, unsubscribe_url)
+						VALUES ($1, $2, $3, $4, $5)`
+					commandTag, err = tx.Exec(dbQuery, eml.String, "DBHub.io: "+summary, msg, htmlMsg, unsubURL)
+					if err != nil {
+						log.Printf("Adding status update to email queue for user '%d' failed: %v", u, err)
+						tx.Rollback()
+						continue
+					}
+					if numRows := commandTag.RowsAffected(); numRows != 1 {
+						log.Printf("Wrong number of rows affected (%v) when adding status update to email"+
+							"queue for user '%d'", numRows, u)
+						tx.Rollback()
+						continue
+					}
+				}
+				if wt.frequency == NOTIFY_DAILY || wt.frequency == NOTIFY_WEEKLY {
+					dbQuery = `
+						INSERT INTO digest_queue (user_id, db_id, frequency, summary, title, url)
+						VALUES ($1, $2, $3, $4, $5, $6)`
+					commandTag, err = tx.Exec(dbQuery, u, ev.dbID, string(wt.frequency), summary, ev.details.Title,
+						ev.details.URL)
+					if err != nil {
+						log.Printf("Adding status update to digest queue for user '%d' failed: %v", u, err)
+						tx.Rollback()
+						continue
+					}
+					if numRows := commandTag.RowsAffected(); numRows != 1 {
+						log.Printf("Wrong number of rows affected (%v) when adding status update to digest "+
+							"queue for user '%d'", numRows, u)
+						tx.Rollback()
+						continue
+					}
+				}
+			}
+
+			// Queue a delivery of the event for each webhook on the database which wants it
+			details := ev.details
+			details.ID = strconv.FormatInt(id, 10)
+			details.Timestamp = ev.timeStamp
+			payload := WebhookPayload{
+				Details: details,
+				Event:   EventNames[ev.details.Type],
+			}
+			dbQuery = `
+				INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+				SELECT webhook_id, $2, $3
+				FROM webhooks
+				WHERE db_id = $1
+					AND (array_length(event_types, 1) IS NULL OR $2 = ANY(event_types))`
+			_, err = tx.Exec(dbQuery, ev.dbID, int32(ev.details.Type), payload)
+			if err != nil {
+				log.Printf("Queuing webhook deliveries for event ID '%d' failed: %v", id, err)
+				continue
+			}
+
+			// Remove the processed event from PG
+			dbQuery = `
+				DELETE FROM events
+				WHERE event_id = $1`
+			commandTag, err := tx.Exec(dbQuery, id)
+			if err != nil {
+				log.Printf("Removing event ID '%d' failed: %v", id, err)
+				continue
+			}
+			if numRows := commandTag.RowsAffected(); numRows != 1 {
+				log.Printf("Wrong number of rows affected (%v) when removing event ID '%d'", numRows, id)
+				continue
+			}
+		}
+
+		// Commit the transaction
+		err = tx.Commit()
+		if err != nil {
+			log.Printf("Could not commit transaction when processing status updates: %v", err.Error())
+			continue
+		}
+
+		// Wait for new events before running the loop again
+		waitForEvents(Conf.Event.Delay * time.Second)
+	}
+}
+
+// Stores a new API access token for a user, returning the ID for it.  Only the sha256 of the token is kept.
+func StoreAPIToken(userName string, name string, tokenHash string, scopes []APITokenScope) (tokenID int64, err error) {
+	dbQuery := `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes)
+		SELECT (SELECT user_id FROM users WHERE lower(user_name) = lower($1)), $2, $3, $4
+		RETURNING token_id`
+	err = pdb.QueryRow(dbQuery, userName, name, tokenHash, scopes).Scan(&tokenID)
+	if err != nil {
+		log.Printf("Storing API token '%s' for user '%s' failed: %v\n", name, userName, err)
+	}
+	return
+}
+
+// Adds an entry to the audit log.
+func StoreAuditEntry(e AuditEntry) error {
+	var actor, owner, folder, dbName pgx.NullString
+	if e.Actor != "" {
+		actor = pgx.NullString{String: e.Actor, Valid: true}
+	}
+	if e.Owner != "" {
+		owner = pgx.NullString{String: e.Owner, Valid: true}
+	}
+	if e.Folder != "" {
+		folder = pgx.NullString{String: e.Folder, Valid: true}
+	}
+	if e.DBName != "" {
+		dbName = pgx.NullString{String: e.DBName, Valid: true}
+	}
+	details := struct {
+		After  interface{} `json:"after,omitempty"`
+		Before interface{} `json:"before,omitempty"`
+	}{e.After, e.Before}
+	dbQuery := `
+		INSERT INTO audit_log (actor, ip_addr, server_sw, db_id, owner, folder, db_name, action, details)
+		SELECT $1, $2, $3, (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($4))
+					AND folder = $5
+					AND db_name = $6
+					AND is_deleted = false
+			), $4, $5, $6, $7, $8`
+	commandTag, err := pdb.Exec(dbQuery, actor, e.IPAddr, e.ServerSw, owner, folder, dbName, string(e.Action),
+		details)
+	if err != nil {
+		log.Printf("Storing audit log entry '%s' for '%s' failed: %v\n", e.Action, e.Actor, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when storing audit log entry '%s' for '%s'\n", numRows,
+			e.Action, e.Actor)
+	}
+	return nil
+}
+
+// Updates the branches list for a database.
+func StoreBranches(dbOwner string, dbFolder string, dbName string, branches map[string]BranchEntry) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET branch_heads = $4, branches = $5
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, branches, len(branches))
+	if err != nil {
+		log.Printf("Updating branch heads for database '%s%s%s' to '%v' failed: %v\n", dbOwner, dbFolder,
+			dbName, branches, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf(
+			"Wrong number of rows (%v) affected when updating branch heads for database '%s%s%s' to '%v'\n",
+			numRows, dbOwner, dbFolder, dbName, branches)
+	}
+	return nil
+}
+
+// Updates the branch protection settings for a database.
+func StoreBranchProtection(dbOwner string, dbFolder string, dbName string,
+	protection map[string]BranchProtectionEntry) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET branch_protection = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, protection)
+	if err != nil {
+		log.Printf("Updating branch protection settings for database '%s%s%s' failed: %v\n", dbOwner, dbFolder,
+			dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf(
+			"Wrong number of rows (%v) affected when updating branch protection settings for database '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Adds a collaborator to a database, or changes the role of an existing one.
+func StoreCollaborator(dbOwner string, dbFolder string, dbName string, userName string, role CollaboratorRole) error {
+	dbQuery := `
+		INSERT INTO database_collaborators (db_id, user_id, role)
+		SELECT (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			), (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($4)
+			), $5
+		ON CONFLICT (db_id, user_id)
+			DO UPDATE SET role = $5`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, userName, string(role))
+	if err != nil {
+		log.Printf("Storing collaborator '%s' for database '%s%s%s' failed: %v\n", userName, dbOwner, dbFolder,
+			dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when storing collaborator '%s' for database '%s%s%s'\n",
+			numRows, userName, dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Adds a comment to a discussion.
+func StoreComment(dbOwner string, dbFolder string, dbName string, commenter string, discID int, comText string,
+	discClose bool, mrState MergeRequestState, anchor *CommentAnchor) error {
+	return storeComment(dbOwner, dbFolder, dbName, commenter, discID, comText, discClose, mrState, anchor, false)
+}
+
+// Does the work for StoreComment().  When canWrite is true, the caller has already made sure the commenter has write
+// access to the database, which also allows them to close or reopen the discussion.
+func storeComment(dbOwner string, dbFolder string, dbName string, commenter string, discID int, comText string,
+	discClose bool, mrState MergeRequestState, anchor *CommentAnchor, canWrite bool) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Get the current details for the discussion or MR
+	var discCreator string
+	var discState bool
+	var discType int64
+	var discTitle string
+	dbQuery := `
+		SELECT disc.open, u.user_name, disc.discussion_type, disc.title
+		FROM discussions AS disc, users AS u
+		WHERE disc.db_id = (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND disc.disc_id = $4
+			AND disc.creator = u.user_id`
+	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID).Scan(&discState, &discCreator, &discType, &discTitle)
+	if err != nil {
+		log.Printf("Error retrieving current open state for '%s%s%s', discussion '%d': %v\n", dbOwner,
+			dbFolder, dbName, discID, err)
+		return err
+	}
+
+	// If the discussion is to be closed or reopened, ensure the person doing so is either the database owner or the
+	// person who started the discussion
+	if discClose == true && !canWrite {
+		if (strings.ToLower(commenter) != strings.ToLower(dbOwner)) && (strings.ToLower(commenter) != strings.ToLower(discCreator)) {
+			return errors.New("Not authorised")
+		}
+	}
+
+	// If comment text was provided, insert it into the database
+	var commandTag pgx.CommandTag
+	var comID int64
+	if comText != "" {
+		dbQuery = `
+			WITH d AS (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			), int AS (
+				SELECT internal_id AS int_id
+				FROM discussions
+				WHERE db_id = (SELECT db_id FROM d)
+				AND disc_id = $5
+			)
+			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
+			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, 'txt'
+			RETURNING com_id`
+		err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, commenter, discID, comText).Scan(&comID)
+		if err != nil {
+			log.Printf("Adding comment for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner, dbFolder,
+				dbName, discID, err)
+			return err
+		}
+
+		// If this is a review comment, store the data it points to
+		if anchor != nil {
+			if discType != int64(MERGE_REQUEST) {
+				return errors.New("Only merge request comments can point to data")
+			}
+			dbQuery = `
+				INSERT INTO discussion_comment_anchors (com_id, db_id, commit_id, original_commit_id, table_name,
+					primary_key, column_name)
+				SELECT com_id, db_id, $2, $2, $3, $4, nullif($5, '')
+				FROM discussion_comments
+				WHERE com_id = $1`
+			commandTag, err = tx.Exec(dbQuery, comID, anchor.CommitID, anchor.Table, anchor.PrimaryKey,
+				anchor.Column)
+			if err != nil {
+				log.Printf("Storing the anchor for comment '%d' on database '%s%s%s' failed: %v\n", comID, dbOwner,
+					dbFolder, dbName, err)
+				return err
+			}
+			if numRows := commandTag.RowsAffected(); numRows != 1 {
+				log.Printf("Wrong number of rows (%v) affected when storing the anchor for comment '%d'\n", numRows,
+					comID)
+			}
+		}
+	}
+
+	// If the discussion is to be closed or reopened, insert a close or reopen record as appropriate
+	if discClose == true {
+		var eventTxt, eventType string
+		if discState {
+			// Discussion is open, so a close event should be inserted
+			eventTxt = "close"
+			eventType = "cls"
+		} else {
+			// Discussion is closed, so a re-open event should be inserted
+			eventTxt = "reopen"
+			eventType = "rop"
+		}
+
+		// Insert the appropriate close or reopen record
+		dbQuery = `
+			WITH d AS (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			), int AS (
+				SELECT internal_id AS int_id
+				FROM discussions
+				WHERE db_id = (SELECT db_id FROM d)
+				AND disc_id = $5
+			)
+			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
+			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, $7`
+		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, commenter, discID, eventTxt, eventType)
+		if err != nil {
+			log.Printf("Adding comment for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner, dbFolder,
+				dbName, discID, err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			log.Printf(
+				"Wrong number of rows (%v) affected when adding a comment to database '%s%s%s', discussion '%d'\n",
+				numRows, dbOwner, dbFolder, dbName, discID)
+		}
+	}
+
+	// Update the merge request state for MR's being closed
+	if discClose == true && discType == MERGE_REQUEST {
+		dbQuery = `
+			UPDATE discussions
+			SET mr_state = $5
+			WHERE db_id = (
+					SELECT db.db_id
+					FROM sqlite_databases AS db
+					WHERE db.user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($1)
+						)
+						AND folder = $2
+						AND db_name = $3
+				)
+				AND disc_id = $4`
+		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID, mrState)
+		if err != nil {
+			log.Printf("Updating MR state for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner,
+				dbFolder, dbName, discID, err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			log.Printf(
+				"Wrong number of rows (%v) affected when updating MR state for database '%s%s%s', discussion '%d'\n",
+				numRows, dbOwner, dbFolder, dbName, discID)
+		}
+	}
+
+	// Update the last_modified date for the parent discussion
+	dbQuery = `
+		UPDATE discussions
+		SET last_modified = now()`
+	if discClose == true {
+		if discState {
+			// Discussion is open, so set it to closed
+			dbQuery += `, open = false`
+		} else {
+			// Discussion is closed, so set it to open
+			dbQuery += `, open = true`
+		}
+	}
+	if comText != "" {
+		dbQuery += `, comment_count = comment_count + 1`
+	}
+	dbQuery += `
+		WHERE db_id = (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND disc_id = $4`
+	commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID)
+	if err != nil {
+		log.Printf("Updating last modified date for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner,
+			dbFolder, dbName, discID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf(
+			"Wrong number of rows (%v) affected when updating last_modified date for database '%s%s%s', discussion '%d'\n",
+			numRows, dbOwner, dbFolder, dbName, discID)
+	}
+
+	// Update the open discussion and MR counters for the database
+	dbQuery = `
+		WITH d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db
+			WHERE db.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+		)
+		UPDATE sqlite_databases
+		SET discussions = (
+				SELECT count(disc.*)
+				FROM discussions AS disc, d
+				WHERE disc.db_id = d.db_id
+					AND open = true
+					AND discussion_type = 0
+			),
+			merge_requests = (
+				SELECT count(disc.*)
+				FROM discussions AS disc, d
+				WHERE disc.db_id = d.db_id
+					AND open = true
+					AND discussion_type = 1
+			)
+		WHERE db_id = (SELECT db_id FROM d)`
+	commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Updating discussion count for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName,
+			err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf(
+			"Wrong number of rows (%v) affected when updating discussion count for database '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
+	}
+
+	// If comment text was provided, generate an event about the new comment
+	if comText != "" {
+		var commentURL string
+		if discType == MERGE_REQUEST {
+			commentURL = fmt.Sprintf("/merge/%s%s%s?id=%d#c%d", url.PathEscape(dbOwner), dbFolder,
+				url.PathEscape(dbName), discID, comID)
+		} else {
+			commentURL = fmt.Sprintf("/discuss/%s%s%s?id=%d#c%d", url.PathEscape(dbOwner), dbFolder,
+				url.PathEscape(dbName), discID, comID)
+		}
+		details := EventDetails{
+			DBName:   dbName,
+			DiscID:   discID,
+			Folder:   dbFolder,
+			Owner:    dbOwner,
+			Type:     EVENT_NEW_COMMENT,
+			Title:    discTitle,
+			URL:      commentURL,
+			UserName: commenter,
+		}
+		err = NewEvent(details)
+		if err != nil {
+			log.Printf("Error when creating a new event: %s\n", err.Error())
+			return err
+		}
+
+		// Notify anyone mentioned in the comment
+		err = storeMentions(tx, dbOwner, dbFolder, dbName, discID, commenter, comText, "", discTitle, commentURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Subscribe the commenter to the discussion, so they're notified of later comments
+	err = subscribeDiscussion(tx, dbOwner, dbFolder, dbName, discID, commenter)
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Let anyone viewing the discussion know about the new comment and state change.  Closing and reopening adds an
+	// entry to the comment list too
+	u := LiveUpdate{DBName: dbName, DiscID: discID, Folder: dbFolder, Owner: dbOwner, Type: LIVE_COMMENTS}
+	if comText != "" || discClose {
+		PublishLiveUpdate(u)
+	}
+	if discClose {
+		u.Type = LIVE_DISCUSSION
+		PublishLiveUpdate(u)
+	}
+	return nil
+}
+
+// Updates the commit list for a database.
+func StoreCommits(dbOwner string, dbFolder string, dbName string, commitList map[string]CommitEntry) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET commit_list = $4, last_modified = now()
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, commitList)
+	if err != nil {
+		log.Printf("Updating commit list for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf(
+			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s'\n", numRows,
+			dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Adds or updates the state of a status check for a commit.  An empty creator is used for the built in runners.
+func StoreCommitStatus(dbOwner string, dbFolder string, dbName string, creator string, s CommitStatus) error {
+	dbQuery := `
+		INSERT INTO commit_statuses (db_id, commit_id, context, state, description, target_url, user_id)
+		SELECT db.db_id, $4, $5, $6, nullif($7, ''), nullif($8, ''), (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($9)
+			)
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+		ON CONFLICT (db_id, commit_id, context)
+			DO UPDATE SET state = $6, description = nullif($7, ''), target_url = nullif($8, ''), user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($9)
+				), date_updated = now()`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, s.CommitID, s.Context, string(s.State),
+		s.Description, s.TargetURL, creator)
+	if err != nil {
+		log.Printf("Storing status check '%s' for commit '%s' of database '%s%s%s' failed: %v\n", s.Context,
+			s.CommitID, dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when storing status check '%s' for database '%s%s%s'\n",
+			numRows, s.Context, dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Stores database details in PostgreSQL, and the database data itself in Minio.  For existing databases, only the
+// given branch is updated, and only if its head is still at prevHead (or for new branches, if it still doesn't exist).
+// Otherwise ErrBranchMoved is returned, and nothing is changed.
+func StoreDatabase(dbOwner string, dbFolder string, dbName string, branches map[string]BranchEntry, c CommitEntry,
+	pub bool, buf *os.File, sha string, dbSize int64, oneLineDesc string, fullDesc string, createDefBranch bool,
+	branchName string, prevHead string, sourceURL string) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Add (or lock) the database_files entry for the file before storing it, so the trash purge can't remove the file
+	// until the commit using it has been stored
+	dbQuery := `
+		INSERT INTO database_files (db_sha256, minio_server, minio_folder, minio_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (db_sha256)
+			DO UPDATE
+			SET db_sha256 = excluded.db_sha256`
+	_, err = tx.Exec(dbQuery, sha, Conf.Minio.Server, sha[:MinioFolderChars], sha[MinioFolderChars:])
+	if err != nil {
+		log.Printf("Storing database file entry for '%s' failed: %v\n", sha, err)
+		return err
+	}
+
+	// Store the database file
+	err = StoreDatabaseFile(buf, sha, dbSize)
+	if err != nil {
+		return err
+	}
+
+	// Check for values which should be NULL
+	var nullable1LineDesc, nullableFullDesc pgx.NullString
+	if oneLineDesc == "" {
+		nullable1LineDesc.Valid = false
+	} else {
+		nullable1LineDesc.String = oneLineDesc
+		nullable1LineDesc.Valid = true
+	}
+	if fullDesc == "" {
+		nullableFullDesc.Valid = false
+	} else {
+		nullableFullDesc.String = fullDesc
+		nullableFullDesc.Valid = true
+	}
+
+	// Store the database metadata
+	cMap := map[string]CommitEntry{c.ID: c}
+	var commandTag pgx.CommandTag
+	dbQuery = `
+		WITH root AS (
+			SELECT nextval('sqlite_databases_db_id_seq') AS val
+		)
+		INSERT INTO sqlite_databases (user_id, db_id, folder, db_name, public, one_line_description, full_description,
+			branch_heads, root_database, commit_list`
+	if sourceURL != "" {
+		dbQuery += `, source_url`
+	}
+	dbQuery +=
+		`)
+		SELECT (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)), (SELECT val FROM root), $2, $3, $4, $5, $6, $8, (SELECT val FROM root), $7`
+	if sourceURL != "" {
+		dbQuery += `, $11`
+	}
+	dbQuery += `
+		ON CONFLICT (user_id, folder, db_name)
+			DO UPDATE
+			SET commit_list = sqlite_databases.commit_list || $7,
+				branch_heads = sqlite_databases.branch_heads || jsonb_build_object($9::text, $8::jsonb -> $9::text),
+				last_modified = now()`
+	if sourceURL != "" {
+		dbQuery += `,
+				source_url = $11`
+	}
+	dbQuery += `
+			WHERE sqlite_databases.branch_heads -> $9::text ->> 'commit' IS NOT DISTINCT FROM nullif($10::text, '')`
+	if sourceURL != "" {
+		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, pub, nullable1LineDesc, nullableFullDesc,
+			cMap, branches, branchName, prevHead, sourceURL)
+	} else {
+		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, pub, nullable1LineDesc, nullableFullDesc,
+			cMap, branches, branchName, prevHead)
+	}
+	if err != nil {
+		log.Printf("Storing database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Head of branch '%s' of database '%s%s%s' moved while storing a new commit\n", branchName,
+			dbOwner, dbFolder, dbName)
+		return ErrBranchMoved
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if createDefBranch {
+		err = StoreDefaultBranchName(dbOwner, dbFolder, dbName, branchName)
+		if err != nil {
+			log.Printf("Storing default branch '%s' name for '%s%s%s' failed: %v\n", branchName, dbOwner,
+				dbFolder, dbName, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds an entry to the database_files table for a database file stored in Minio.
+func StoreDatabaseFileEntry(sha string) error {
+	dbQuery := `
+		INSERT INTO database_files (db_sha256, minio_server, minio_folder, minio_id)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM database_files
+			WHERE db_sha256 = $1
+		)`
+	commandTag, err := pdb.Exec(dbQuery, sha, Conf.Minio.Server, sha[:MinioFolderChars], sha[MinioFolderChars:])
+	if err != nil {
+		log.Printf("Storing database file entry for '%s' failed: %v\n", sha, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows > 1 {
+		log.Printf("Wrong number of rows (%v) affected while storing database file entry for '%s'\n", numRows,
+			sha)
+	}
+	return nil
+}
+
+// Stores the default branch name for a database.
+func StoreDefaultBranchName(dbOwner string, folder string, dbName string, branchName string) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET default_branch = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, folder, dbName, branchName)
+	if err != nil {
+		log.Printf("Changing default branch for database '%v' to '%v' failed: %v\n", dbName, branchName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected during update: database: %v, new branch name: '%v'\n",
+			numRows, dbName, branchName)
+	}
+	return nil
+}
+
+// Stores the default table name for a database.
+func StoreDefaultTableName(dbOwner string, folder string, dbName string, tableName string) error {
+	var t pgx.NullString
+	if tableName != "" {
+		t.String = tableName
+		t.Valid = true
+	}
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET default_table = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, folder, dbName, t)
+	if err != nil {
+		log.Printf("Changing default table for database '%v' to '%v' failed: %v\n", dbName, tableName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected during update: database: %v, new table name: '%v'\n",
+			numRows, dbName, tableName)
+	}
+	return nil
+}
 
-				// * Add the new event to the users status updates list *
+// Adds a digest email to the outgoing email queue, and removes its events from the digest queue.  If the user no
+// longer has an email address, the events are removed without sending anything.  As every server runs the digest
+// loop, nothing is stored when some of the events have already been removed by another server's copy of the digest.
+func storeDigest(j digestJob, subject string, body string, htmlBody string, unsubURL string) error {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
 
-				// Group the status updates by database, and coalesce multiple updates for the same discussion or MR
-				// into a single entry (keeping the most recent one of each)
-				dbName := fmt.Sprintf("%s%s%s", ev.details.Owner, ev.details.Folder, ev.details.DBName)
-				var a StatusUpdateEntry
-				lst, ok := userEvents[dbName]
-				if ev.details.Type == EVENT_NEW_DISCUSSION || ev.details.Type == EVENT_NEW_MERGE_REQUEST || ev.details.Type == EVENT_NEW_COMMENT {
-					if ok {
-						// Check if an entry already exists for the discussion/MR/comment
-						for i, j := range lst {
-							if j.DiscID == ev.details.DiscID {
-								// Yes, there's already an existing entry for the discussion/MR/comment so delete the old entry
-								lst = append(lst[:i], lst[i+1:]...) // Delete the old element
-							}
-						}
-					}
-				}
-				// Add the new entry
-				a.DiscID = ev.details.DiscID
-				a.Title = ev.details.Title
-				a.URL = ev.details.URL
-				lst = append(lst, a)
-				userEvents[dbName] = lst
+	if j.Email != "" {
+		dbQuery := `
+			INSERT INTO email_queue (mail_to, subject, body, html_body, unsubscribe_url)
+			VALUES ($1, $2, $3, $4, $5)`
+		commandTag, err := tx.Exec(dbQuery, j.Email, subject, body, htmlBody, unsubURL)
+		if err != nil {
+			log.Printf("Adding digest to email queue for user '%s' failed: %v\n", j.UserName, err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			log.Printf("Wrong number of rows (%v) affected when adding digest to email queue for user '%s'\n",
+				numRows, j.UserName)
+		}
+	}
 
-				// Save the updated list for the user back to PG
-				dbQuery = `
-					UPDATE users
-					SET status_updates = $2
-					WHERE user_id = $1`
-				commandTag, err := tx.Exec(dbQuery, u, userEvents)
-				if err != nil {
-					log.Printf("Adding status update for database ID '%d' to user '%s' failed: %v", ev.dbID,
-						u, err)
-					tx.Rollback()
-					continue
-				}
-				if numRows := commandTag.RowsAffected(); numRows != 1 {
-					log.Printf("Wrong number of rows affected (%v) when adding status update for database ID "+
-						"'%d' to user '%s'", numRows, ev.dbID, u)
-					tx.Rollback()
-					continue
-				}
+	// Remove the events included in the digest.  If another server has already sent a digest with any of them, this
+	// one is dropped rather than being sent a second time
+	var ids []int64
+	for _, i := range j.Items {
+		ids = append(ids, i.ID)
+	}
+	dbQuery := `
+		DELETE FROM digest_queue
+		WHERE digest_id = ANY($1)`
+	commandTag, err := tx.Exec(dbQuery, ids)
+	if err != nil {
+		log.Printf("Removing sent digest entries for user '%s' failed: %v\n", j.UserName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != int64(len(ids)) {
+		log.Printf("The %s digest for user '%s' was already sent by another server\n", j.Frequency, j.UserName)
+		return nil
+	}
+	return tx.Commit()
+}
 
-				// Count the number of status updates for the user, to be displayed in the webUI header row
-				var numUpdates int
-				for _, i := range userEvents {
-					numUpdates += len(i)
-				}
+// Stores a new discussion for a database.
+func StoreDiscussion(dbOwner string, dbFolder string, dbName string, loggedInUser string, title string, text string,
+	discType DiscussionType, mr MergeRequestEntry) (newID int, err error) {
 
-				// Add an entry to memcached for the user, indicating they have outstanding status updates available
-				err = SetUserStatusUpdates(userName, numUpdates)
-				if err != nil {
-					log.Printf("Error when updating user status updates # in memcached: %v", err)
-					continue
-				}
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
 
-				// TODO: Add a email for the status notification to the outgoing email queue
-				var msg, subj string
-				switch ev.details.Type {
-				case EVENT_NEW_DISCUSSION:
-					msg = fmt.Sprintf("A new discussion has been created for %s%s%s.\n\nVisit https://%s%s "+
-						"for the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf.Web.ServerName,
-						ev.details.URL)
-					subj = fmt.Sprintf("DBHub.io: New discussion created on %s%s%s", ev.details.Owner,
-						ev.details.Folder, ev.details.DBName)
-				case EVENT_NEW_MERGE_REQUEST:
-					msg = fmt.Sprintf("A new merge request has been created for %s%s%s.\n\nVisit https://%s%s "+
-						"for the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf.Web.ServerName,
-						ev.details.URL)
-					subj = fmt.Sprintf("DBHub.io: New merge request created on %s%s%s", ev.details.Owner,
-						ev.details.Folder, ev.details.DBName)
-				case EVENT_NEW_COMMENT:
-					msg = fmt.Sprintf("A new comment has been created for %s%s%s.\n\nVisit https://%s%s for "+
-						"the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf.Web.ServerName,
-						ev.details.URL)
-					subj = fmt.Sprintf("DBHub.io: New comment on %s%s%s", ev.details.Owner, ev.details.Folder,
-						ev.details.DBName)
-				default:
-					log.Printf("Unknown message type when creating email message")
-				}
-				if eml.Valid {
-					// TODO: Check if the email is username@thisserver, which indicates a non-functional email address
-					dbQuery = `
-						INSERT INTO email_queue (mail_to, subject, body)
-						VALUES ($1, $2, $3)`
-					commandTag, err = tx.Exec(dbQuery, eml.String, subj, msg)
-					if err != nil {
-						log.Printf("Adding status update to email queue for user '%s' failed: %v", u, err)
-						tx.Rollback()
-						continue
-					}
-					if numRows := commandTag.RowsAffected(); numRows != 1 {
-						log.Printf("Wrong number of rows affected (%v) when adding status update to email"+
-							"queue for user '%s'", numRows, u)
-						tx.Rollback()
-						continue
-					}
-				}
-			}
+	// Add the discussion details to PostgreSQL
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND db.folder = $2
+				AND db.db_name = $3
+		), next_id AS (
+			SELECT coalesce(max(disc.disc_id), 0) + 1 AS id
+			FROM discussions AS disc, d
+			WHERE disc.db_id = d.db_id
+		)
+		INSERT INTO discussions (db_id, disc_id, creator, title, description, open, discussion_type`
+	if discType == MERGE_REQUEST {
+		dbQuery += `, mr_source_db_id, mr_source_db_branch, mr_destination_branch, mr_commits`
+	}
+	dbQuery += `
+			)
+		SELECT (SELECT db_id FROM d),
+			(SELECT id FROM next_id),
+			(SELECT user_id FROM users WHERE lower(user_name) = lower($4)),
+			$5,
+			$6,
+			true,
+			$7`
+	if discType == MERGE_REQUEST {
+		dbQuery += `,(
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($8))
+			AND folder = $9
+			AND db_name = $10
+			AND is_deleted = false
+		), $11, $12, $13`
+	}
+	dbQuery += `
+		RETURNING (SELECT id FROM next_id)`
+	if discType == MERGE_REQUEST {
+		err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, title, text, discType, mr.SourceOwner,
+			mr.SourceFolder, mr.SourceDBName, mr.SourceBranch, mr.DestBranch, mr.Commits).Scan(&newID)
+	} else {
+		err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, title, text, discType).Scan(&newID)
+	}
+	if err != nil {
+		log.Printf("Adding new discussion or merge request '%s' for '%s%s%s' failed: %v\n", title, dbOwner,
+			dbFolder, dbName, err)
+		return
+	}
 
-			// Remove the processed event from PG
-			dbQuery = `
-				DELETE FROM events
-				WHERE event_id = $1`
-			commandTag, err := tx.Exec(dbQuery, id)
-			if err != nil {
-				log.Printf("Removing event ID '%d' failed: %v", id, err)
-				continue
-			}
-			if numRows := commandTag.RowsAffected(); numRows != 1 {
-				log.Printf("Wrong number of rows affected (%v) when removing event ID '%d'", numRows, id)
-				continue
-			}
-		}
+	// Increment the discussion or merge request counter for the database
+	dbQuery = `
+		UPDATE sqlite_databases`
+	if discType == DISCUSSION {
+		dbQuery += `
+			SET discussions = discussions + 1`
+	} else {
+		dbQuery += `
+			SET merge_requests = merge_requests + 1`
+	}
+	dbQuery += `
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Updating discussion counter for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when updating discussion counter for '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
+	}
 
-		// Commit the transaction
-		err = tx.Commit()
-		if err != nil {
-			log.Printf("Could not commit transaction when processing status updates: %v", err.Error())
-			continue
-		}
+	// Subscribe the creator to the new discussion or merge request, and notify anyone mentioned in it
+	err = subscribeDiscussion(tx, dbOwner, dbFolder, dbName, newID, loggedInUser)
+	if err != nil {
+		return
+	}
+	discURL := fmt.Sprintf("/discuss/%s%s%s?id=%d", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName), newID)
+	if discType == MERGE_REQUEST {
+		discURL = fmt.Sprintf("/merge/%s%s%s?id=%d", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName), newID)
+	}
+	err = storeMentions(tx, dbOwner, dbFolder, dbName, newID, loggedInUser, text, "", title, discURL)
+	if err != nil {
+		return
+	}
 
-		// Wait before running the loop again
-		time.Sleep(Conf.Event.Delay * time.Second)
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// Adds a discussion label to a database, or updates an existing one when the label has an ID.  Returns the ID of the
+// label.
+func StoreDiscussionLabel(dbOwner string, dbFolder string, dbName string, label DiscussionLabel) (labelID int64,
+	err error) {
+	var desc pgx.NullString
+	if label.Description != "" {
+		desc = pgx.NullString{String: label.Description, Valid: true}
+	}
+	var dbQuery string
+	if label.ID == 0 {
+		dbQuery = `
+			INSERT INTO discussion_labels (db_id, name, colour, description)
+			SELECT (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			), $4, $5, $6
+			RETURNING label_id`
+		err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, label.Name, label.Colour, desc).Scan(&labelID)
+	} else {
+		dbQuery = `
+			UPDATE discussion_labels
+			SET name = $4, colour = $5, description = $6
+			WHERE db_id = (
+					SELECT db_id
+					FROM sqlite_databases
+					WHERE user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($1)
+						)
+						AND folder = $2
+						AND db_name = $3
+						AND is_deleted = false
+				)
+				AND label_id = $7
+			RETURNING label_id`
+		err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, label.Name, label.Colour, desc, label.ID).Scan(&labelID)
+		if err == pgx.ErrNoRows {
+			return 0, errors.New("Unknown label")
+		}
+	}
+	if err != nil {
+		log.Printf("Saving label '%s' for database '%s%s%s' failed: %v\n", label.Name, dbOwner, dbFolder, dbName,
+			err)
 	}
 	return
 }
 
-// Updates the branches list for a database.
-func StoreBranches(dbOwner string, dbFolder string, dbName string, branches map[string]BranchEntry) error {
+// Subscribes or unsubscribes a user from a discussion or merge request.
+func StoreDiscussionSubscription(loggedInUser string, dbOwner string, dbFolder string, dbName string, discID int,
+	subscribed bool) error {
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET branch_heads = $4, branches = $5
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-				)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, branches, len(branches))
+		INSERT INTO discussion_subscriptions (db_id, disc_id, user_id, subscribed)
+		SELECT disc.db_id, disc.disc_id, (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6
+		FROM discussions AS disc
+		WHERE disc.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND disc.disc_id = $5
+		ON CONFLICT (db_id, disc_id, user_id)
+			DO UPDATE SET subscribed = $6, date_subscribed = now()`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, discID, subscribed)
 	if err != nil {
-		log.Printf("Updating branch heads for database '%s%s%s' to '%v' failed: %v\n", dbOwner, dbFolder,
-			dbName, branches, err)
+		log.Printf("Changing subscription of user '%s' to discussion '%d' of '%s%s%s' failed: %v\n", loggedInUser,
+			discID, dbOwner, dbFolder, dbName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating branch heads for database '%s%s%s' to '%v'\n",
-			numRows, dbOwner, dbFolder, dbName, branches)
+		return fmt.Errorf("Unknown discussion ID")
 	}
 	return nil
 }
 
-// Adds a comment to a discussion.
-func StoreComment(dbOwner string, dbFolder string, dbName string, commenter string, discID int, comText string,
-	discClose bool, mrState MergeRequestState) error {
-	// Begin a transaction
+// Sets the labels, assignees, and milestone of a discussion or MR, replacing the existing ones.  A milestoneID of 0
+// means no milestone.  The labels and milestone must belong to the same database, and the assignees need to be able
+// to see it.  Problems with the values given are returned as a DiscussionTrackingError.
+func StoreDiscussionTracking(dbOwner string, dbFolder string, dbName string, discID int, labelIDs []int64,
+	assignees []string, milestoneID int64) error {
 	tx, err := pdb.Begin()
 	if err != nil {
 		return err
 	}
-	// Set up an automatic transaction roll back if the function exits without committing
 	defer tx.Rollback()
 
-	// Get the current details for the discussion or MR
-	var discCreator string
-	var discState bool
-	var discType int64
-	var discTitle string
+	// Look up the database, and make sure the discussion exists
+	var dbID int64
 	dbQuery := `
-		SELECT disc.open, u.user_name, disc.discussion_type, disc.title
-		FROM discussions AS disc, users AS u
-		WHERE disc.db_id = (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
+		SELECT db_id
+		FROM discussions
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
 						SELECT user_id
 						FROM users
 						WHERE lower(user_name) = lower($1)
 					)
 					AND folder = $2
 					AND db_name = $3
+					AND is_deleted = false
 			)
-			AND disc.disc_id = $4
-			AND disc.creator = u.user_id`
-	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID).Scan(&discState, &discCreator, &discType, &discTitle)
+			AND disc_id = $4`
+	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID).Scan(&dbID)
+	if err == pgx.ErrNoRows {
+		return DiscussionTrackingError("Unknown discussion ID")
+	}
 	if err != nil {
-		log.Printf("Error retrieving current open state for '%s%s%s', discussion '%d': %v\n", dbOwner,
-			dbFolder, dbName, discID, err)
+		log.Printf("Looking up discussion '%d' of '%s%s%s' failed: %v\n", discID, dbOwner, dbFolder, dbName, err)
 		return err
 	}
 
-	// If the discussion is to be closed or reopened, ensure the person doing so is either the database owner or the
-	// person who started the discussion
-	if discClose == true {
-		if (strings.ToLower(commenter) != strings.ToLower(dbOwner)) && (strings.ToLower(commenter) != strings.ToLower(discCreator)) {
-			return errors.New("Not authorised")
+	// Labels
+	dbQuery = `
+		DELETE FROM discussion_label_map
+		WHERE db_id = $1
+			AND disc_id = $2`
+	_, err = tx.Exec(dbQuery, dbID, discID)
+	if err != nil {
+		log.Printf("Removing labels from discussion '%d' of '%s%s%s' failed: %v\n", discID, dbOwner, dbFolder,
+			dbName, err)
+		return err
+	}
+	for _, l := range labelIDs {
+		dbQuery = `
+			INSERT INTO discussion_label_map (db_id, disc_id, label_id)
+			SELECT db_id, $2, label_id
+			FROM discussion_labels
+			WHERE db_id = $1
+				AND label_id = $3
+			ON CONFLICT DO NOTHING`
+		_, err = tx.Exec(dbQuery, dbID, discID, l)
+		if err != nil {
+			log.Printf("Adding label '%d' to discussion '%d' of '%s%s%s' failed: %v\n", l, discID, dbOwner,
+				dbFolder, dbName, err)
+			return err
 		}
 	}
 
-	// If comment text was provided, insert it into the database
-	var commandTag pgx.CommandTag
-	var comID int64
-	if comText != "" {
+	// Assignees.  Unknown user names are reported together, rather than one at a time
+	var unknown []string
+	for _, a := range assignees {
+		exists, err := CheckUserExists(a)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			unknown = append(unknown, a)
+		}
+	}
+	if len(unknown) > 0 {
+		return DiscussionTrackingError(fmt.Sprintf("Unknown assignee(s): '%s'", strings.Join(unknown, "', '")))
+	}
+	dbQuery = `
+		DELETE FROM discussion_assignees
+		WHERE db_id = $1
+			AND disc_id = $2`
+	_, err = tx.Exec(dbQuery, dbID, discID)
+	if err != nil {
+		log.Printf("Removing assignees from discussion '%d' of '%s%s%s' failed: %v\n", discID, dbOwner,
+			dbFolder, dbName, err)
+		return err
+	}
+	for _, a := range assignees {
+		exists, err := CheckDBExists(a, dbOwner, dbFolder, dbName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return DiscussionTrackingError(fmt.Sprintf("User '%s' can't see this database, so can't be assigned",
+				a))
+		}
 		dbQuery = `
-			WITH d AS (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
+			INSERT INTO discussion_assignees (db_id, disc_id, user_id)
+			SELECT $1, $2, user_id
+			FROM users
+			WHERE lower(user_name) = lower($3)
+			ON CONFLICT DO NOTHING`
+		_, err = tx.Exec(dbQuery, dbID, discID, a)
+		if err != nil {
+			log.Printf("Assigning user '%s' to discussion '%d' of '%s%s%s' failed: %v\n", a, discID, dbOwner,
+				dbFolder, dbName, err)
+			return err
+		}
+	}
+
+	// Milestone
+	dbQuery = `
+		DELETE FROM discussion_milestones
+		WHERE db_id = $1
+			AND disc_id = $2`
+	_, err = tx.Exec(dbQuery, dbID, discID)
+	if err != nil {
+		log.Printf("Removing milestone from discussion '%d' of '%s%s%s' failed: %v\n", discID, dbOwner,
+			dbFolder, dbName, err)
+		return err
+	}
+	if milestoneID != 0 {
+		dbQuery = `
+			INSERT INTO discussion_milestones (db_id, disc_id, milestone_id)
+			SELECT db_id, $2, milestone_id
+			FROM milestones
+			WHERE db_id = $1
+				AND milestone_id = $3`
+		commandTag, err := tx.Exec(dbQuery, dbID, discID, milestoneID)
+		if err != nil {
+			log.Printf("Setting milestone '%d' for discussion '%d' of '%s%s%s' failed: %v\n", milestoneID,
+				discID, dbOwner, dbFolder, dbName, err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			return DiscussionTrackingError("Unknown milestone")
+		}
+	}
+	return tx.Commit()
+}
+
+// Records the result of an email delivery attempt.
+func storeEmailAttempt(emailID int64, sent bool, attempts int, nextAttempt time.Time, errMsg string) error {
+	var e pgx.NullString
+	if errMsg != "" {
+		e.String = errMsg
+		e.Valid = true
+	}
+	dbQuery := `
+		UPDATE email_queue
+		SET sent = $2, sent_timestamp = CASE WHEN $2 THEN now() END, attempts = $3, next_attempt = $4,
+			last_error = $5
+		WHERE email_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, emailID, sent, attempts, nextAttempt, e)
+	if err != nil {
+		log.Printf("Recording delivery attempt for email '%d' failed: %v\n", emailID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when recording delivery attempt for email '%d'\n", numRows,
+			emailID)
+	}
+	return nil
+}
+
+// Store a licence.
+func StoreLicence(userName string, licenceName string, txt []byte, url string, orderNum int, fullName string,
+	fileFormat string) error {
+	// Store the licence in PostgreSQL
+	sha := sha256.Sum256(txt)
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		)
+		INSERT INTO database_licences (user_id, friendly_name, lic_sha256, licence_text, licence_url, display_order,
+			full_name, file_format)
+		SELECT (SELECT user_id FROM u), $2, $3, $4, $5, $6, $7, $8
+		ON CONFLICT (user_id, friendly_name)
+			DO UPDATE
+			SET friendly_name = $2,
+				lic_sha256 = $3,
+				licence_text = $4,
+				licence_url = $5,
+				user_id = (SELECT user_id FROM u),
+				display_order = $6,
+				full_name = $7,
+				file_format = $8`
+	commandTag, err := pdb.Exec(dbQuery, userName, licenceName, hex.EncodeToString(sha[:]), txt, url, orderNum,
+		fullName, fileFormat)
+	if err != nil {
+		log.Printf("Inserting licence '%v' in database failed: %v\n", licenceName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when storing licence '%v'\n", numRows, licenceName)
+	}
+	return nil
+}
+
+// Subscribes the users mentioned in the text of a discussion or comment to it, and generates an event for each of them
+// so they're notified.  Mentions of users who can't see the database are ignored.  When the text has been edited, the
+// users already mentioned in the old text are skipped, so they aren't notified twice.
+func storeMentions(tx *pgx.Tx, dbOwner string, dbFolder string, dbName string, discID int, author string, text string,
+	oldText string, title string, eventURL string) error {
+	names := ParseMentions(text)
+	if len(names) == 0 {
+		return nil
+	}
+	users, err := existingUsers(names)
+	if err != nil {
+		return err
+	}
+	oldNames := make(map[string]bool)
+	for _, n := range ParseMentions(oldText) {
+		oldNames[n] = true
+	}
+	for _, n := range names {
+		userName, ok := users[n]
+		if !ok || oldNames[n] || n == strings.ToLower(author) {
+			continue
+		}
+		exists, err := CheckDBExists(userName, dbOwner, dbFolder, dbName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		err = subscribeDiscussion(tx, dbOwner, dbFolder, dbName, discID, userName)
+		if err != nil {
+			return err
+		}
+		details := EventDetails{
+			DBName:    dbName,
+			DiscID:    discID,
+			Folder:    dbFolder,
+			Mentioned: userName,
+			Owner:     dbOwner,
+			Title:     title,
+			Type:      EVENT_MENTION,
+			URL:       eventURL,
+			UserName:  author,
+		}
+		err = NewEvent(details)
+		if err != nil {
+			log.Printf("Error when creating a new event: %s\n", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// Updates the merge policy for a database.
+func StoreMergePolicy(dbOwner string, dbFolder string, dbName string, policy MergePolicy) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET merge_policy = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, policy)
+	if err != nil {
+		log.Printf("Updating merge policy for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when updating merge policy for database '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Records the review of a merge request by a user, replacing any earlier review they gave.  The commit ID is the
+// head of the merge request at the time of the review, so approvals can be dismissed when new commits turn up.
+func StoreMergeRequestReview(dbOwner string, dbFolder string, dbName string, discID int, reviewer string,
+	state ReviewState, commitID string) error {
+	dbQuery := `
+		INSERT INTO merge_request_reviews (db_id, disc_id, user_id, state, commit_id)
+		SELECT disc.db_id, disc.disc_id, (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, $7
+		FROM discussions AS disc
+		WHERE disc.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
 						SELECT user_id
 						FROM users
 						WHERE lower(user_name) = lower($1)
 					)
 					AND folder = $2
 					AND db_name = $3
-			), int AS (
-				SELECT internal_id AS int_id
-				FROM discussions
-				WHERE db_id = (SELECT db_id FROM d)
-				AND disc_id = $5
+					AND is_deleted = false
 			)
-			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
-			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, 'txt'
-			RETURNING com_id`
-		err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, commenter, discID, comText).Scan(&comID)
-		if err != nil {
-			log.Printf("Adding comment for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner, dbFolder,
-				dbName, discID, err)
-			return err
-		}
+			AND disc.disc_id = $5
+			AND disc.discussion_type = 1
+		ON CONFLICT (db_id, disc_id, user_id)
+			DO UPDATE SET state = $6, commit_id = $7, date_reviewed = now()`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, reviewer, discID, string(state), commitID)
+	if err != nil {
+		log.Printf("Storing review by '%s' of MR '%d' for database '%s%s%s' failed: %v\n", reviewer, discID,
+			dbOwner, dbFolder, dbName, err)
+		return err
 	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Unknown merge request ID")
+	}
+	return nil
+}
 
-	// If the discussion is to be closed or reopened, insert a close or reopen record as appropriate
-	if discClose == true {
-		var eventTxt, eventType string
-		if discState {
-			// Discussion is open, so a close event should be inserted
-			eventTxt = "close"
-			eventType = "cls"
-		} else {
-			// Discussion is closed, so a re-open event should be inserted
-			eventTxt = "reopen"
-			eventType = "rop"
-		}
-
-		// Insert the appropriate close or reopen record
+// Adds a milestone to a database, or updates an existing one when the milestone has an ID.  Returns the ID of the
+// milestone.
+func StoreMilestone(dbOwner string, dbFolder string, dbName string, m Milestone) (milestoneID int64, err error) {
+	var desc, tag, rel pgx.NullString
+	var due pgx.NullTime
+	if m.Description != "" {
+		desc = pgx.NullString{String: m.Description, Valid: true}
+	}
+	if m.Tag != "" {
+		tag = pgx.NullString{String: m.Tag, Valid: true}
+	}
+	if m.Release != "" {
+		rel = pgx.NullString{String: m.Release, Valid: true}
+	}
+	if !m.DueDate.IsZero() {
+		due = pgx.NullTime{Time: m.DueDate, Valid: true}
+	}
+	var dbQuery string
+	if m.ID == 0 {
 		dbQuery = `
-			WITH d AS (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
+			INSERT INTO milestones (db_id, title, description, due_date, tag_name, release_name, open)
+			SELECT (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
 						SELECT user_id
 						FROM users
 						WHERE lower(user_name) = lower($1)
 					)
 					AND folder = $2
 					AND db_name = $3
-			), int AS (
-				SELECT internal_id AS int_id
-				FROM discussions
-				WHERE db_id = (SELECT db_id FROM d)
-				AND disc_id = $5
-			)
-			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
-			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, $7`
-		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, commenter, discID, eventTxt, eventType)
-		if err != nil {
-			log.Printf("Adding comment for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner, dbFolder,
-				dbName, discID, err)
-			return err
-		}
-		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf(
-				"Wrong number of rows (%v) affected when adding a comment to database '%s%s%s', discussion '%d'\n",
-				numRows, dbOwner, dbFolder, dbName, discID)
-		}
-	}
-
-	// Update the merge request state for MR's being closed
-	if discClose == true && discType == MERGE_REQUEST {
+					AND is_deleted = false
+			), $4, $5, $6, $7, $8, $9
+			RETURNING milestone_id`
+		err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, m.Title, desc, due, tag, rel, m.Open).Scan(&milestoneID)
+	} else {
 		dbQuery = `
-			UPDATE discussions
-			SET mr_state = $5
+			UPDATE milestones
+			SET title = $4, description = $5, due_date = $6, tag_name = $7, release_name = $8, open = $9
 			WHERE db_id = (
-					SELECT db.db_id
-					FROM sqlite_databases AS db
-					WHERE db.user_id = (
+					SELECT db_id
+					FROM sqlite_databases
+					WHERE user_id = (
 							SELECT user_id
 							FROM users
 							WHERE lower(user_name) = lower($1)
 						)
 						AND folder = $2
 						AND db_name = $3
+						AND is_deleted = false
 				)
-				AND disc_id = $4`
-		commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID, mrState)
-		if err != nil {
-			log.Printf("Updating MR state for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner,
-				dbFolder, dbName, discID, err)
-			return err
-		}
-		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf(
-				"Wrong number of rows (%v) affected when updating MR state for database '%s%s%s', discussion '%d'\n",
-				numRows, dbOwner, dbFolder, dbName, discID)
-		}
-	}
-
-	// Update the last_modified date for the parent discussion
-	dbQuery = `
-		UPDATE discussions
-		SET last_modified = now()`
-	if discClose == true {
-		if discState {
-			// Discussion is open, so set it to closed
-			dbQuery += `, open = false`
-		} else {
-			// Discussion is closed, so set it to open
-			dbQuery += `, open = true`
+				AND milestone_id = $10
+			RETURNING milestone_id`
+		err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, m.Title, desc, due, tag, rel, m.Open,
+			m.ID).Scan(&milestoneID)
+		if err == pgx.ErrNoRows {
+			return 0, errors.New("Unknown milestone")
 		}
 	}
-	if comText != "" {
-		dbQuery += `, comment_count = comment_count + 1`
-	}
-	dbQuery += `
-		WHERE db_id = (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
-						SELECT user_id
-						FROM users
-						WHERE lower(user_name) = lower($1)
-					)
-					AND folder = $2
-					AND db_name = $3
-			)
-			AND disc_id = $4`
-	commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID)
 	if err != nil {
-		log.Printf("Updating last modified date for database '%s%s%s', discussion '%d' failed: %v\n", dbOwner,
-			dbFolder, dbName, discID, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating last_modified date for database '%s%s%s', discussion '%d'\n",
-			numRows, dbOwner, dbFolder, dbName, discID)
+		log.Printf("Saving milestone '%s' for database '%s%s%s' failed: %v\n", m.Title, dbOwner, dbFolder, dbName,
+			err)
 	}
+	return
+}
 
-	// Update the open discussion and MR counters for the database
-	dbQuery = `
-		WITH d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db
-			WHERE db.user_id = (
+// Saves the notification settings of a user.  If no database is given, the defaults for the user are saved.
+// Otherwise the settings are for a database they're watching, and an empty frequency means it uses their defaults.
+func StoreNotifySettings(userName string, dbOwner string, dbFolder string, dbName string, freq NotifyFrequency,
+	events []EventType) error {
+	eventList := []int32{}
+	for _, e := range events {
+		eventList = append(eventList, int32(e))
+	}
+	var dbQuery string
+	var args []interface{}
+	if dbName == "" {
+		dbQuery = `
+			UPDATE users
+			SET notify_frequency = $2, notify_events = $3
+			WHERE lower(user_name) = lower($1)`
+		args = []interface{}{userName, string(freq), eventList}
+	} else {
+		var f pgx.NullString
+		if freq != "" {
+			f.String = string(freq)
+			f.Valid = true
+		}
+		dbQuery = `
+			UPDATE watchers
+			SET notify_frequency = $5, notify_events = $6
+			WHERE user_id = (
 					SELECT user_id
 					FROM users
 					WHERE lower(user_name) = lower($1)
 				)
-				AND folder = $2
-				AND db_name = $3
-		)
-		UPDATE sqlite_databases
-		SET discussions = (
-				SELECT count(disc.*)
-				FROM discussions AS disc, d
-				WHERE disc.db_id = d.db_id
-					AND open = true
-					AND discussion_type = 0
-			),
-			merge_requests = (
-				SELECT count(disc.*)
-				FROM discussions AS disc, d
-				WHERE disc.db_id = d.db_id
-					AND open = true
-					AND discussion_type = 1
-			)
-		WHERE db_id = (SELECT db_id FROM d)`
-	commandTag, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+				AND db_id = (
+					SELECT db_id
+					FROM sqlite_databases
+					WHERE user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($2)
+						)
+						AND folder = $3
+						AND db_name = $4
+						AND is_deleted = false
+				)`
+		args = []interface{}{userName, dbOwner, dbFolder, dbName, f, eventList}
+	}
+	commandTag, err := pdb.Exec(dbQuery, args...)
 	if err != nil {
-		log.Printf("Updating discussion count for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName,
-			err)
+		log.Printf("Saving notification settings for user '%s' failed: %v\n", userName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating discussion count for database '%s%s%s'\n",
-			numRows, dbOwner, dbFolder, dbName)
-	}
-
-	// If comment text was provided, generate an event about the new comment
-	if comText != "" {
-		var commentURL string
-		if discType == MERGE_REQUEST {
-			commentURL = fmt.Sprintf("/merge/%s%s%s?id=%d#c%d", url.PathEscape(dbOwner), dbFolder,
-				url.PathEscape(dbName), discID, comID)
-		} else {
-			commentURL = fmt.Sprintf("/discuss/%s%s%s?id=%d#c%d", url.PathEscape(dbOwner), dbFolder,
-				url.PathEscape(dbName), discID, comID)
-		}
-		details := EventDetails{
-			DBName:   dbName,
-			DiscID:   discID,
-			Folder:   dbFolder,
-			Owner:    dbOwner,
-			Type:     EVENT_NEW_COMMENT,
-			Title:    discTitle,
-			URL:      commentURL,
-			UserName: commenter,
-		}
-		err = NewEvent(details)
-		if err != nil {
-			log.Printf("Error when creating a new event: %s\n", err.Error())
-			return err
+		if dbName != "" {
+			return fmt.Errorf("You're not watching '%s%s%s'", dbOwner, dbFolder, dbName)
 		}
+		log.Printf("Wrong number of rows (%v) affected when saving notification settings for user '%s'\n",
+			numRows, userName)
 	}
+	return nil
+}
 
-	// Commit the transaction
-	err = tx.Commit()
+// Adds a user to an organisation, or changes their role if they're already a member.
+func StoreOrgMember(orgName string, userName string, role OrgRole) error {
+	dbQuery := `
+		INSERT INTO org_members (org_id, user_id, role)
+		SELECT (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
+			), (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+					AND is_org = false
+			), $3
+		ON CONFLICT (org_id, user_id)
+			DO UPDATE SET role = $3`
+	commandTag, err := pdb.Exec(dbQuery, orgName, userName, string(role))
 	if err != nil {
+		log.Printf("Storing member '%s' of organisation '%s' failed: %v\n", userName, orgName, err)
 		return err
 	}
-
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when storing member '%s' of organisation '%s'\n", numRows,
+			userName, orgName)
+	}
 	return nil
 }
 
-// Updates the commit list for a database.
-func StoreCommits(dbOwner string, dbFolder string, dbName string, commitList map[string]CommitEntry) error {
+// Store the releases for a database.
+func StoreReleases(dbOwner string, dbFolder string, dbName string, releases map[string]ReleaseEntry) error {
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET commit_list = $4, last_modified = now()
+		SET release_list = $4, release_count = $5
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
-				)
+			)
 			AND folder = $2
 			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, commitList)
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, releases, len(releases))
 	if err != nil {
-		log.Printf("Updating commit list for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		log.Printf("Storing releases for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s'\n", numRows,
+		log.Printf("Wrong number of rows (%v) affected when storing releases for database: '%s%s%s'\n", numRows,
 			dbOwner, dbFolder, dbName)
 	}
 	return nil
 }
 
-// Stores database details in PostgreSQL, and the database data itself in Minio.
-func StoreDatabase(dbOwner string, dbFolder string, dbName string, branches map[string]BranchEntry, c CommitEntry,
-	pub bool, buf *os.File, sha string, dbSize int64, oneLineDesc string, fullDesc string, createDefBranch bool,
-	branchName string, sourceURL string) error {
-	// Store the database file
-	err := StoreDatabaseFile(buf, sha, dbSize)
-	if err != nil {
-		return err
-	}
-
-	// Check for values which should be NULL
-	var nullable1LineDesc, nullableFullDesc pgx.NullString
-	if oneLineDesc == "" {
-		nullable1LineDesc.Valid = false
-	} else {
-		nullable1LineDesc.String = oneLineDesc
-		nullable1LineDesc.Valid = true
-	}
-	if fullDesc == "" {
-		nullableFullDesc.Valid = false
-	} else {
-		nullableFullDesc.String = fullDesc
-		nullableFullDesc.Valid = true
-	}
-
-	// Store the database metadata
-	cMap := map[string]CommitEntry{c.ID: c}
-	var commandTag pgx.CommandTag
+// Updates the SQL assertions run as status checks for the merge requests of a database.
+func StoreSQLAssertions(dbOwner string, dbFolder string, dbName string, assertions []SQLAssertion) error {
 	dbQuery := `
-		WITH root AS (
-			SELECT nextval('sqlite_databases_db_id_seq') AS val
-		)
-		INSERT INTO sqlite_databases (user_id, db_id, folder, db_name, public, one_line_description, full_description,
-			branch_heads, root_database, commit_list`
-	if sourceURL != "" {
-		dbQuery += `, source_url`
-	}
-	dbQuery +=
-		`)
-		SELECT (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)), (SELECT val FROM root), $2, $3, $4, $5, $6, $8, (SELECT val FROM root), $7`
-	if sourceURL != "" {
-		dbQuery += `, $9`
-	}
-	dbQuery += `
-		ON CONFLICT (user_id, folder, db_name)
-			DO UPDATE
-			SET commit_list = sqlite_databases.commit_list || $7,
-				branch_heads = sqlite_databases.branch_heads || $8,
-				last_modified = now()`
-	if sourceURL != "" {
-		dbQuery += `,
-			source_url = $9`
-		commandTag, err = pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, pub, nullable1LineDesc, nullableFullDesc,
-			cMap, branches, sourceURL)
-	} else {
-		commandTag, err = pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, pub, nullable1LineDesc, nullableFullDesc,
-			cMap, branches)
-	}
+		UPDATE sqlite_databases
+		SET sql_assertions = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, assertions)
 	if err != nil {
-		log.Printf("Storing database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		log.Printf("Updating SQL assertions for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected while storing database '%s%s%s'\n", numRows, dbOwner,
-			dbFolder, dbName)
+		log.Printf("Wrong number of rows (%v) affected when updating SQL assertions for database '%s%s%s'\n",
+			numRows, dbOwner, dbFolder, dbName)
 	}
+	return nil
+}
 
-	if createDefBranch {
-		err = StoreDefaultBranchName(dbOwner, dbFolder, dbName, branchName)
-		if err != nil {
-			log.Printf("Storing default branch '%s' name for '%s%s%s' failed: %v\n", branchName, dbOwner,
-				dbFolder, dbName, err)
-			return err
-		}
+// Store the status updates list for a user
+func StoreStatusUpdates(userName string, statusUpdates map[string][]StatusUpdateEntry) error {
+	dbQuery := `
+		UPDATE users
+		SET status_updates = $2
+		WHERE user_name = $1`
+	commandTag, err := pdb.Exec(dbQuery, userName, statusUpdates)
+	if err != nil {
+		log.Printf("Adding status update for user '%s' failed: %v", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows affected (%v) when storing status update for user '%s'", numRows,
+			userName)
+		return err
 	}
 	return nil
 }
 
-// Stores the default branch name for a database.
-func StoreDefaultBranchName(dbOwner string, folder string, dbName string, branchName string) error {
+// Store the tags for a database.
+func StoreTags(dbOwner string, dbFolder string, dbName string, tags map[string]TagEntry) error {
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET default_branch = $4
+		SET tag_list = $4, tags = $5
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
-				)
+			)
 			AND folder = $2
 			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, folder, dbName, branchName)
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, tags, len(tags))
 	if err != nil {
-		log.Printf("Changing default branch for database '%v' to '%v' failed: %v\n", dbName, branchName, err)
+		log.Printf("Storing tags for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected during update: database: %v, new branch name: '%v'\n",
-			numRows, dbName, branchName)
+		log.Printf("Wrong number of rows (%v) affected when storing tags for database: '%s%s%s'\n", numRows,
+			dbOwner, dbFolder, dbName)
 	}
 	return nil
 }
 
-// Stores the default table name for a database.
-func StoreDefaultTableName(dbOwner string, folder string, dbName string, tableName string) error {
-	var t pgx.NullString
-	if tableName != "" {
-		t.String = tableName
-		t.Valid = true
+// Creates a team in an organisation, or updates the description and role of an existing one.
+func StoreTeam(orgName string, teamName string, description string, role CollaboratorRole) error {
+	var desc pgx.NullString
+	if description != "" {
+		desc.String = description
+		desc.Valid = true
 	}
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET default_table = $4
-		WHERE user_id = (
+		INSERT INTO org_teams (org_id, team_name, description, role)
+		SELECT (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
-				)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, folder, dbName, t)
+					AND is_org = true
+			), $2, $3, $4
+		ON CONFLICT (org_id, team_name)
+			DO UPDATE SET description = $3, role = $4`
+	commandTag, err := pdb.Exec(dbQuery, orgName, teamName, desc, string(role))
 	if err != nil {
-		log.Printf("Changing default table for database '%v' to '%v' failed: %v\n", dbName, tableName, err)
+		log.Printf("Storing team '%s' of organisation '%s' failed: %v\n", teamName, orgName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected during update: database: %v, new table name: '%v'\n",
-			numRows, dbName, tableName)
+		log.Printf("Wrong number of rows (%v) affected when storing team '%s' of organisation '%s'\n", numRows,
+			teamName, orgName)
 	}
 	return nil
 }
 
-// Stores a new discussion for a database.
-func StoreDiscussion(dbOwner string, dbFolder string, dbName string, loggedInUser string, title string, text string,
-	discType DiscussionType, mr MergeRequestEntry) (newID int, err error) {
+// Gives an organisation team access to one of the organisation's databases.  Members of the team get the access of the
+// team's role.
+func StoreTeamDatabase(orgName string, teamName string, dbFolder string, dbName string) error {
+	dbQuery := `
+		INSERT INTO org_team_databases (team_id, db_id)
+		SELECT t.team_id, db.db_id
+		FROM org_teams AS t, sqlite_databases AS db, users AS u
+		WHERE u.user_id = t.org_id
+			AND db.user_id = t.org_id
+			AND lower(u.user_name) = lower($1)
+			AND u.is_org = true
+			AND t.team_name = $2
+			AND db.folder = $3
+			AND db.db_name = $4
+			AND db.is_deleted = false
+		ON CONFLICT (team_id, db_id)
+			DO NOTHING`
+	commandTag, err := pdb.Exec(dbQuery, orgName, teamName, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Adding database '%s%s%s' to team '%s' failed: %v\n", orgName, dbFolder, dbName, teamName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows > 1 {
+		log.Printf("Wrong number of rows (%v) affected when adding database '%s%s%s' to team '%s'\n", numRows,
+			orgName, dbFolder, dbName, teamName)
+	}
+	return nil
+}
 
+// Adds a user to an organisation's team.  If they're not yet a member of the organisation, they're added to it too.
+func StoreTeamMember(orgName string, teamName string, userName string) error {
 	// Begin a transaction
 	tx, err := pdb.Begin()
 	if err != nil {
-		return
+		return err
 	}
 	// Set up an automatic transaction roll back if the function exits without committing
 	defer tx.Rollback()
 
-	// Add the discussion details to PostgreSQL
+	// Make sure the user is a member of the organisation
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND db.folder = $2
-				AND db.db_name = $3
-		), next_id AS (
-			SELECT coalesce(max(disc.disc_id), 0) + 1 AS id
-			FROM discussions AS disc, d
-			WHERE disc.db_id = d.db_id
-		)
-		INSERT INTO discussions (db_id, disc_id, creator, title, description, open, discussion_type`
-	if discType == MERGE_REQUEST {
-		dbQuery += `, mr_source_db_id, mr_source_db_branch, mr_destination_branch, mr_commits`
-	}
-	dbQuery += `
-			)
-		SELECT (SELECT db_id FROM d),
-			(SELECT id FROM next_id),
-			(SELECT user_id FROM users WHERE lower(user_name) = lower($4)),
-			$5,
-			$6,
-			true,
-			$7`
-	if discType == MERGE_REQUEST {
-		dbQuery += `,(
-			SELECT db_id
-			FROM sqlite_databases
-			WHERE user_id = (
+		INSERT INTO org_members (org_id, user_id, role)
+		SELECT (
 				SELECT user_id
 				FROM users
-				WHERE lower(user_name) = lower($8))
-			AND folder = $9
-			AND db_name = $10
-			AND is_deleted = false
-		), $11, $12, $13`
-	}
-	dbQuery += `
-		RETURNING (SELECT id FROM next_id)`
-	if discType == MERGE_REQUEST {
-		err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, title, text, discType, mr.SourceOwner,
-			mr.SourceFolder, mr.SourceDBName, mr.SourceBranch, mr.DestBranch, mr.Commits).Scan(&newID)
-	} else {
-		err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, loggedInUser, title, text, discType).Scan(&newID)
-	}
+				WHERE lower(user_name) = lower($1)
+					AND is_org = true
+			), (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+					AND is_org = false
+			), $3
+		ON CONFLICT (org_id, user_id)
+			DO NOTHING`
+	_, err = tx.Exec(dbQuery, orgName, userName, string(ORG_MEMBER))
 	if err != nil {
-		log.Printf("Adding new discussion or merge request '%s' for '%s%s%s' failed: %v\n", title, dbOwner,
-			dbFolder, dbName, err)
-		return
+		log.Printf("Adding '%s' to organisation '%s' failed: %v\n", userName, orgName, err)
+		return err
 	}
 
-	// Increment the discussion or merge request counter for the database
+	// Add the user to the team
 	dbQuery = `
-		UPDATE sqlite_databases`
-	if discType == DISCUSSION {
-		dbQuery += `
-			SET discussions = discussions + 1`
-	} else {
-		dbQuery += `
-			SET merge_requests = merge_requests + 1`
-	}
-	dbQuery += `
-		WHERE user_id = (
+		INSERT INTO org_team_members (team_id, user_id)
+		SELECT (
+				SELECT team_id
+				FROM org_teams
+				WHERE org_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+							AND is_org = true
+					)
+					AND team_name = $2
+			), (
 				SELECT user_id
 				FROM users
-				WHERE lower(user_name) = lower($1)
+				WHERE lower(user_name) = lower($3)
 			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+		ON CONFLICT (team_id, user_id)
+			DO NOTHING`
+	_, err = tx.Exec(dbQuery, orgName, teamName, userName)
 	if err != nil {
-		log.Printf("Updating discussion counter for '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
-		return
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when updating discussion counter for '%s%s%s'\n",
-			numRows, dbOwner, dbFolder, dbName)
+		log.Printf("Adding '%s' to team '%s' of organisation '%s' failed: %v\n", userName, teamName, orgName, err)
+		return err
 	}
 
 	// Commit the transaction
-	err = tx.Commit()
-	if err != nil {
-		return
-	}
-	return
+	return tx.Commit()
 }
 
-// Store a licence.
-func StoreLicence(userName string, licenceName string, txt []byte, url string, orderNum int, fullName string,
-	fileFormat string) error {
-	// Store the licence in PostgreSQL
-	sha := sha256.Sum256(txt)
+// Offers a database to another user or organisation.  Only one offer can be pending for a database at a time, so this
+// replaces any earlier one.
+func StoreTransferOffer(dbOwner string, dbFolder string, dbName string, recipient string) error {
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		)
-		INSERT INTO database_licences (user_id, friendly_name, lic_sha256, licence_text, licence_url, display_order,
-			full_name, file_format)
-		SELECT (SELECT user_id FROM u), $2, $3, $4, $5, $6, $7, $8
-		ON CONFLICT (user_id, friendly_name)
-			DO UPDATE
-			SET friendly_name = $2,
-				lic_sha256 = $3,
-				licence_text = $4,
-				licence_url = $5,
-				user_id = (SELECT user_id FROM u),
-				display_order = $6,
-				full_name = $7,
-				file_format = $8`
-	commandTag, err := pdb.Exec(dbQuery, userName, licenceName, hex.EncodeToString(sha[:]), txt, url, orderNum,
-		fullName, fileFormat)
+		INSERT INTO database_transfers (db_id, from_user, to_user)
+		SELECT db.db_id, db.user_id, (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($4)
+			)
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+		ON CONFLICT (db_id)
+			DO UPDATE SET from_user = EXCLUDED.from_user, to_user = EXCLUDED.to_user, date_offered = now()`
+	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, recipient)
 	if err != nil {
-		log.Printf("Inserting licence '%v' in database failed: %v\n", licenceName, err)
+		log.Printf("Storing transfer offer of database '%s%s%s' to '%s' failed: %v\n", dbOwner, dbFolder, dbName,
+			recipient, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when storing licence '%v'\n", numRows, licenceName)
+		log.Printf("Wrong number of rows (%v) affected when storing transfer offer of database '%s%s%s' to '%s'\n",
+			numRows, dbOwner, dbFolder, dbName, recipient)
 	}
 	return nil
 }
 
-// Store the releases for a database.
-func StoreReleases(dbOwner string, dbFolder string, dbName string, releases map[string]ReleaseEntry) error {
+// Records the details of a new upload session.
+func StoreUploadSession(userName string, upload UploadSession) error {
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET release_list = $4, release_count = $5
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, releases, len(releases))
+		INSERT INTO upload_sessions (upload_id, user_id, db_owner_id, folder, db_name, total_size, received_size,
+			hash_state, form_values)
+		SELECT $2, (SELECT user_id FROM users WHERE lower(user_name) = lower($1)),
+			(SELECT user_id FROM users WHERE lower(user_name) = lower($3)), $4, $5, $6, $7, $8, $9`
+	commandTag, err := pdb.Exec(dbQuery, userName, upload.ID, upload.DBOwner, upload.Folder, upload.DBName,
+		upload.Size, upload.Received, upload.HashState, upload.FormValues)
 	if err != nil {
-		log.Printf("Storing releases for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		log.Printf("Storing upload session '%s' for user '%s' failed: %v\n", upload.ID, userName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when storing releases for database: '%s%s%s'\n", numRows,
-			dbOwner, dbFolder, dbName)
+		log.Printf("Wrong number of rows (%v) affected when storing upload session '%s' for user '%s'\n",
+			numRows, upload.ID, userName)
 	}
 	return nil
 }
 
-// Store the status updates list for a user
-func StoreStatusUpdates(userName string, statusUpdates map[string][]StatusUpdateEntry) error {
+// Adds a webhook to a database, returning its ID.  An empty event list means the webhook receives all events.
+func StoreWebhook(dbOwner string, dbFolder string, dbName string, creator string, hookURL string, secret string,
+	events []EventType) (webhookID int64, err error) {
+	var evList []int32
+	for _, e := range events {
+		evList = append(evList, int32(e))
+	}
 	dbQuery := `
-		UPDATE users
-		SET status_updates = $2
-		WHERE user_name = $1`
-	commandTag, err := pdb.Exec(dbQuery, userName, statusUpdates)
+		INSERT INTO webhooks (db_id, creator, url, secret, event_types)
+		SELECT (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1))
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7
+		RETURNING webhook_id`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName, creator, hookURL, secret, evList).Scan(&webhookID)
 	if err != nil {
-		log.Printf("Adding status update for user '%s' failed: %v", userName, err)
+		log.Printf("Storing webhook for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+	}
+	return
+}
+
+// Records the outcome of a webhook delivery attempt.
+func storeWebhookAttempt(deliveryID int64, state WebhookDeliveryState, attempts int, nextAttempt time.Time,
+	statusCode int, errMsg string) error {
+	var code pgx.NullInt32
+	if statusCode != 0 {
+		code = pgx.NullInt32{Int32: int32(statusCode), Valid: true}
+	}
+	dbQuery := `
+		UPDATE webhook_deliveries
+		SET state = $2, attempts = $3, next_attempt = $4, last_attempt = now(), status_code = $5, error = $6
+		WHERE delivery_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, deliveryID, string(state), attempts, nextAttempt, code, errMsg)
+	if err != nil {
+		log.Printf("Recording the result of webhook delivery '%d' failed: %v\n", deliveryID, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows affected (%v) when storing status update for user '%s'", numRows,
-			userName)
-		return err
+		log.Printf("Wrong number of rows (%v) affected when recording the result of webhook delivery '%d'\n",
+			numRows, deliveryID)
 	}
 	return nil
 }
 
-// Store the tags for a database.
-func StoreTags(dbOwner string, dbFolder string, dbName string, tags map[string]TagEntry) error {
+// Subscribes a user to a discussion or merge request, unless they've already subscribed or unsubscribed from it.
+func subscribeDiscussion(tx *pgx.Tx, dbOwner string, dbFolder string, dbName string, discID int, userName string) error {
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET tag_list = $4, tags = $5
+		INSERT INTO discussion_subscriptions (db_id, disc_id, user_id)
+		SELECT db_id, $4, (SELECT user_id FROM users WHERE lower(user_name) = lower($5))
+		FROM sqlite_databases
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 			)
 			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, tags, len(tags))
+			AND db_name = $3
+			AND is_deleted = false
+		ON CONFLICT (db_id, disc_id, user_id) DO NOTHING`
+	_, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID, userName)
 	if err != nil {
-		log.Printf("Storing tags for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when storing tags for database: '%s%s%s'\n", numRows,
-			dbOwner, dbFolder, dbName)
+		log.Printf("Subscribing user '%s' to discussion '%d' of '%s%s%s' failed: %v\n", userName, discID, dbOwner,
+			dbFolder, dbName, err)
 	}
-	return nil
+	return err
 }
 
 // Toggle on or off the starring of a database by a user.
@@ -3783,14 +7164,430 @@ func ToggleDBWatch(loggedInUser string, dbOwner string, dbFolder string, dbName
 		) WHERE db_id = (SELECT db_id FROM d)`
 	commandTag, err := pdb.Exec(updateQuery, dbOwner, dbFolder, dbName)
 	if err != nil {
-		log.Printf("Updating watchers count for '%s%s%s' failed: %v", dbOwner, dbFolder, dbName, err)
-		return err
+		log.Printf("Updating watchers count for '%s%s%s' failed: %v", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong # of rows affected (%v) when updating watchers count for '%s%s%s'", numRows, dbOwner,
+			dbFolder, dbName)
+	}
+	return nil
+}
+
+// Moves a database to a different owner, such as from a user to one of their organisations.  The database keeps its
+// ID, so its commit history, stars, watchers, discussions and forks all move with it.  Any custom licences (of the
+// old owner) used by the database are copied to the new owner, so they still resolve.  If the new owner already has
+// a different licence of the same name, the transfer is refused with a LicenceClashError.  The old location is
+// recorded, so links to it can be redirected.
+func TransferDatabase(dbOwner string, dbFolder string, dbName string, newOwner string) error {
+	// Gather the licences used through the history of the database
+	commitList, err := GetCommitList(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return err
+	}
+	lics := make(map[string]struct{})
+	for _, c := range commitList {
+		for _, e := range c.Tree.Entries {
+			if e.LicenceSHA != "" {
+				lics[e.LicenceSHA] = struct{}{}
+			}
+		}
+	}
+	var licenceSHAs []string
+	for l := range lics {
+		licenceSHAs = append(licenceSHAs, l)
+	}
+
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Copy the custom licences used by the database to the new owner
+	if len(licenceSHAs) > 0 {
+		// Make sure none of them clash with a different licence of the same name the new owner already has
+		dbQuery := `
+			SELECT old.friendly_name
+			FROM database_licences AS old, database_licences AS new
+			WHERE old.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND old.lic_sha256 = ANY($3)
+				AND new.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($2)
+				)
+				AND new.friendly_name = old.friendly_name
+				AND new.lic_sha256 != old.lic_sha256
+			ORDER BY old.friendly_name
+			LIMIT 1
+			FOR UPDATE OF new`
+		var clash string
+		err = tx.QueryRow(dbQuery, dbOwner, newOwner, licenceSHAs).Scan(&clash)
+		if err != nil && err != pgx.ErrNoRows {
+			log.Printf("Checking licences of '%s' for clashes failed: %v\n", newOwner, err)
+			return err
+		}
+		if err == nil {
+			return LicenceClashError(fmt.Sprintf("'%s' already has a different licence called '%s'.  Rename "+
+				"or remove one of them, then try again", newOwner, clash))
+		}
+
+		dbQuery = `
+			INSERT INTO database_licences (lic_sha256, friendly_name, user_id, licence_url, licence_text,
+				display_order, full_name, file_format)
+			SELECT lic_sha256, friendly_name, (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($2)
+				), licence_url, licence_text, display_order, full_name, file_format
+			FROM database_licences
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND lic_sha256 = ANY($3)
+			ON CONFLICT (user_id, friendly_name)
+				DO NOTHING`
+		_, err = tx.Exec(dbQuery, dbOwner, newOwner, licenceSHAs)
+		if err != nil {
+			log.Printf("Copying licences from '%s' to '%s' failed: %v\n", dbOwner, newOwner, err)
+			return err
+		}
+	}
+
+	// Remember the old location of the database, so links to it keep working
+	dbQuery := `
+		INSERT INTO database_redirects (user_id, folder, db_name, db_id)
+		SELECT user_id, folder, db_name, db_id
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false
+		ON CONFLICT (user_id, folder, db_name)
+			DO UPDATE SET db_id = EXCLUDED.db_id, date_created = now()`
+	_, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Recording redirect for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+
+	// Any pending transfer offer is no longer relevant
+	dbQuery = `
+		DELETE FROM database_transfers
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Removing transfer offer for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+
+	// Teams only have access to the databases of their own organisation, so the old owner's teams lose theirs
+	dbQuery = `
+		DELETE FROM org_team_databases
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Removing team access to database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+
+	// The database now lives at the new location, so there shouldn't be a redirect away from it
+	dbQuery = `
+		DELETE FROM database_redirects
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	_, err = tx.Exec(dbQuery, newOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Removing redirect for '%s%s%s' failed: %v\n", newOwner, dbFolder, dbName, err)
+		return err
+	}
+
+	// Change the owner of the database
+	dbQuery = `
+		UPDATE sqlite_databases
+		SET user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($4)
+			)
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName, newOwner)
+	if err != nil {
+		log.Printf("Transferring database '%s%s%s' to '%s' failed: %v\n", dbOwner, dbFolder, dbName, newOwner, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when transferring database '%s%s%s' to '%s'\n", numRows,
+			dbOwner, dbFolder, dbName, newOwner)
+	}
+
+	// The new owner doesn't need to be a collaborator any more
+	dbQuery = `
+		DELETE FROM database_collaborators
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	_, err = tx.Exec(dbQuery, newOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Removing '%s' as collaborator of '%s%s%s' failed: %v\n", newOwner, newOwner, dbFolder, dbName,
+			err)
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	log.Printf("Database '%s%s%s' transferred to '%s'\n", dbOwner, dbFolder, dbName, newOwner)
+	return nil
+}
+
+// Returns the pending transfer offer for a database.  If there isn't one, the returned Recipient field is empty.
+func TransferOffer(dbOwner string, dbFolder string, dbName string) (offer TransferOfferEntry, err error) {
+	dbQuery := `
+		SELECT u.user_name, t.date_offered
+		FROM database_transfers AS t, users AS u
+		WHERE t.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND u.user_id = t.to_user`
+	err = pdb.QueryRow(dbQuery, dbOwner, dbFolder, dbName).Scan(&offer.Recipient, &offer.DateOffered)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// No pending offer
+			return TransferOfferEntry{}, nil
+		}
+		log.Printf("Retrieving transfer offer for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
+	}
+	offer.Owner = dbOwner
+	offer.Folder = dbFolder
+	offer.DBName = dbName
+	return
+}
+
+// Returns the databases which have been offered to a user, including those offered to organisations they're an owner
+// of.
+func TransferOffersForUser(userName string) (list []TransferOfferEntry, err error) {
+	dbQuery := `
+		SELECT owner.user_name, db.folder, db.db_name, recipient.user_name, t.date_offered
+		FROM database_transfers AS t, sqlite_databases AS db, users AS owner, users AS recipient
+		WHERE db.db_id = t.db_id
+			AND db.is_deleted = false
+			AND owner.user_id = db.user_id
+			AND recipient.user_id = t.to_user
+			AND (lower(recipient.user_name) = lower($1) OR t.to_user IN (
+				SELECT org_id
+				FROM org_members
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND role = $2
+				)
+			)
+		ORDER BY t.date_offered DESC`
+	rows, err := pdb.Query(dbQuery, userName, string(ORG_OWNER))
+	if err != nil {
+		log.Printf("Retrieving transfer offers for user '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o TransferOfferEntry
+		err = rows.Scan(&o.Owner, &o.Folder, &o.DBName, &o.Recipient, &o.DateOffered)
+		if err != nil {
+			log.Printf("Error retrieving transfer offers for user '%s': %v\n", userName, err)
+			return
+		}
+		list = append(list, o)
+	}
+	return
+}
+
+// Stops a user from receiving notification emails, either for a single watched database or (with a database ID of 0)
+// for everything.  Any of their events waiting for a digest are discarded too.  The owner, folder, and name of the
+// database are returned, for showing to the user.
+func Unsubscribe(userName string, dbID int64) (dbOwner string, dbFolder string, dbName string, err error) {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	var userID int64
+	dbQuery := `
+		SELECT user_id
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = tx.QueryRow(dbQuery, userName).Scan(&userID)
+	if err != nil {
+		log.Printf("Looking up user '%s' for unsubscribing failed: %v\n", userName, err)
+		return
+	}
+
+	if dbID == 0 {
+		// Unsubscribe from everything, including the databases with their own settings
+		dbQuery = `
+			UPDATE users
+			SET notify_frequency = 'webui'
+			WHERE user_id = $1`
+		_, err = tx.Exec(dbQuery, userID)
+		if err != nil {
+			log.Printf("Unsubscribing user '%s' failed: %v\n", userName, err)
+			return
+		}
+		dbQuery = `
+			UPDATE watchers
+			SET notify_frequency = 'webui'
+			WHERE user_id = $1
+				AND notify_frequency IS NOT NULL`
+		_, err = tx.Exec(dbQuery, userID)
+		if err != nil {
+			log.Printf("Unsubscribing user '%s' from their watched databases failed: %v\n", userName, err)
+			return
+		}
+		dbQuery = `
+			DELETE FROM digest_queue
+			WHERE user_id = $1`
+		_, err = tx.Exec(dbQuery, userID)
+		if err != nil {
+			log.Printf("Removing queued digest entries for user '%s' failed: %v\n", userName, err)
+			return
+		}
+		err = tx.Commit()
+		return
+	}
+
+	// Look up the database, so its name can be shown
+	dbQuery = `
+		SELECT o.user_name, db.folder, db.db_name
+		FROM sqlite_databases AS db, users AS o
+		WHERE db.db_id = $1
+			AND o.user_id = db.user_id`
+	err = tx.QueryRow(dbQuery, dbID).Scan(&dbOwner, &dbFolder, &dbName)
+	if err != nil {
+		log.Printf("Looking up database '%d' for unsubscribing failed: %v\n", dbID, err)
+		return
+	}
+
+	// Keep the events the user wants shown in the web UI, but stop the emails
+	dbQuery = `
+		UPDATE watchers AS w
+		SET notify_frequency = 'webui',
+			notify_events = CASE WHEN w.notify_frequency IS NULL THEN u.notify_events ELSE w.notify_events END
+		FROM users AS u
+		WHERE w.user_id = $1
+			AND w.db_id = $2
+			AND u.user_id = w.user_id`
+	_, err = tx.Exec(dbQuery, userID, dbID)
+	if err != nil {
+		log.Printf("Unsubscribing user '%s' from database '%d' failed: %v\n", userName, dbID, err)
+		return
+	}
+
+	// Users who aren't watching the database get its emails through their discussion subscriptions, so those are
+	// stopped too
+	dbQuery = `
+		UPDATE discussion_subscriptions
+		SET subscribed = false
+		WHERE user_id = $1
+			AND db_id = $2
+			AND user_id NOT IN (
+				SELECT user_id
+				FROM watchers
+				WHERE db_id = $2
+			)`
+	_, err = tx.Exec(dbQuery, userID, dbID)
+	if err != nil {
+		log.Printf("Unsubscribing user '%s' from the discussions of database '%d' failed: %v\n", userName, dbID,
+			err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows affected (%v) when updating watchers count for '%s%s%s'", numRows, dbOwner,
-			dbFolder, dbName)
+	dbQuery = `
+		DELETE FROM digest_queue
+		WHERE user_id = $1
+			AND db_id = $2`
+	_, err = tx.Exec(dbQuery, userID, dbID)
+	if err != nil {
+		log.Printf("Removing queued digest entries for user '%s' failed: %v\n", userName, err)
+		return
 	}
-	return nil
+	err = tx.Commit()
+	return
 }
 
 // Updates the Avatar URL for a user.
@@ -3860,8 +7657,10 @@ func UpdateComment(dbOwner string, dbFolder string, dbName string, loggedInUser
 	// Set up an automatic transaction roll back if the function exits without committing
 	defer tx.Rollback()
 
-	// Retrieve the username of whoever created the comment
-	var comCreator string
+	// Retrieve the username of whoever created the comment, along with the details needed for notifying any newly
+	// mentioned users
+	var comCreator, oldText, discTitle string
+	var discType int64
 	dbQuery := `
 		WITH d AS (
 			SELECT db.db_id
@@ -3879,13 +7678,15 @@ func UpdateComment(dbOwner string, dbFolder string, dbName string, loggedInUser
 			WHERE db_id = (SELECT db_id FROM d)
 			AND disc_id = $4
 		)
-		SELECT u.user_name
-		FROM discussion_comments AS com, users AS u
+		SELECT u.user_name, com.body, disc.title, disc.discussion_type
+		FROM discussion_comments AS com, discussions AS disc, users AS u
 		WHERE com.db_id = (SELECT db_id FROM d)
 			AND com.disc_id = (SELECT int_id FROM int)
 			AND com.com_id = $5
-			AND com.commenter = u.user_id`
-	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID, comID).Scan(&comCreator)
+			AND com.commenter = u.user_id
+			AND disc.internal_id = com.disc_id`
+	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID, comID).Scan(&comCreator, &oldText, &discTitle,
+		&discType)
 	if err != nil {
 		log.Printf("Error retrieving name of comment creator for '%s%s%s', discussion '%d', comment '%d': %v\n",
 			dbOwner, dbFolder, dbName, discID, comID, err)
@@ -3932,6 +7733,18 @@ func UpdateComment(dbOwner string, dbFolder string, dbName string, loggedInUser
 			numRows, dbOwner, dbFolder, dbName, discID, comID)
 	}
 
+	// Notify anyone newly mentioned in the comment
+	commentURL := fmt.Sprintf("/discuss/%s%s%s?id=%d#c%d", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName),
+		discID, comID)
+	if DiscussionType(discType) == MERGE_REQUEST {
+		commentURL = fmt.Sprintf("/merge/%s%s%s?id=%d#c%d", url.PathEscape(dbOwner), dbFolder,
+			url.PathEscape(dbName), discID, comID)
+	}
+	err = storeMentions(tx, dbOwner, dbFolder, dbName, discID, loggedInUser, newText, oldText, discTitle, commentURL)
+	if err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	err = tx.Commit()
 	if err != nil {
@@ -3950,10 +7763,12 @@ func UpdateDiscussion(dbOwner string, dbFolder string, dbName string, loggedInUs
 	// Set up an automatic transaction roll back if the function exits without committing
 	defer tx.Rollback()
 
-	// Retrieve the name of the discussion creator
-	var discCreator string
+	// Retrieve the name of the discussion creator, along with the details needed for notifying any newly mentioned
+	// users
+	var discCreator, oldText string
+	var discType int64
 	dbQuery := `
-		SELECT u.user_name
+		SELECT u.user_name, disc.description, disc.discussion_type
 		FROM discussions AS disc, users AS u
 		WHERE disc.db_id = (
 				SELECT db.db_id
@@ -3968,7 +7783,7 @@ func UpdateDiscussion(dbOwner string, dbFolder string, dbName string, loggedInUs
 			)
 			AND disc.disc_id = $4
 			AND disc.creator = u.user_id`
-	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID).Scan(&discCreator)
+	err = tx.QueryRow(dbQuery, dbOwner, dbFolder, dbName, discID).Scan(&discCreator, &oldText, &discType)
 	if err != nil {
 		log.Printf("Error retrieving name of discussion creator for '%s%s%s', discussion '%d': %v\n",
 			dbOwner, dbFolder, dbName, discID, err)
@@ -4009,6 +7824,16 @@ func UpdateDiscussion(dbOwner string, dbFolder string, dbName string, loggedInUs
 			numRows, dbOwner, dbFolder, dbName, discID)
 	}
 
+	// Notify anyone newly mentioned in the discussion
+	discURL := fmt.Sprintf("/discuss/%s%s%s?id=%d", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName), discID)
+	if DiscussionType(discType) == MERGE_REQUEST {
+		discURL = fmt.Sprintf("/merge/%s%s%s?id=%d", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName), discID)
+	}
+	err = storeMentions(tx, dbOwner, dbFolder, dbName, discID, loggedInUser, newText, oldText, newTitle, discURL)
+	if err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	err = tx.Commit()
 	if err != nil {
@@ -4017,8 +7842,50 @@ func UpdateDiscussion(dbOwner string, dbFolder string, dbName string, loggedInUs
 	return nil
 }
 
-// Updates the commit list for a Merge Request
+// Recalculates the fork count for the root database of the fork tree a database is in.
+func updateForkCount(tx *pgx.Tx, dbOwner string, dbFolder string, dbName string) error {
+	dbQuery := `
+		WITH root_db AS (
+			SELECT root_database AS id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+		), new_count AS (
+			SELECT count(*) AS forks
+			FROM sqlite_databases AS db, root_db
+			WHERE db.root_database = root_db.id
+			AND db.is_deleted = false
+		)
+		UPDATE sqlite_databases
+		SET forks = new_count.forks - 1
+		FROM new_count, root_db
+		WHERE sqlite_databases.db_id = root_db.id`
+	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when updating fork count for database '%s%s%s'\n", numRows,
+			dbOwner, dbFolder, dbName)
+	}
+	return nil
+}
+
+// Updates the commit list for a Merge Request.  Approvals given for an older head commit are dismissed, as they don't
+// cover the new commits.
 func UpdateMergeRequestCommits(dbOwner string, dbFolder string, dbName string, discID int, mrCommits []CommitEntry) (err error) {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	dbQuery := `
 		WITH d AS (
 			SELECT db.db_id
@@ -4035,7 +7902,7 @@ func UpdateMergeRequestCommits(dbOwner string, dbFolder string, dbName string, d
 		SET mr_commits = $5
 		WHERE disc.db_id = (SELECT db_id FROM d)
 			AND disc.disc_id = $4`
-	commandTag, err := pdb.Exec(dbQuery, dbOwner, dbFolder, dbName, discID, mrCommits)
+	commandTag, err := tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID, mrCommits)
 	if err != nil {
 		log.Printf("Updating commit list for database '%s%s%s', MR '%d' failed: %v\n", dbOwner,
 			dbFolder, dbName, discID, err)
@@ -4046,18 +7913,62 @@ func UpdateMergeRequestCommits(dbOwner string, dbFolder string, dbName string, d
 			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s', MR '%d'\n",
 			numRows, dbOwner, dbFolder, dbName, discID)
 	}
+
+	// Dismiss the approvals which were given before the latest commits
+	if len(mrCommits) > 0 {
+		dbQuery = `
+			UPDATE merge_request_reviews
+			SET state = 'dismissed'
+			WHERE db_id = (
+					SELECT db_id
+					FROM sqlite_databases
+					WHERE user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($1)
+						)
+						AND folder = $2
+						AND db_name = $3
+				)
+				AND disc_id = $4
+				AND state = 'approved'
+				AND commit_id <> $5`
+		_, err = tx.Exec(dbQuery, dbOwner, dbFolder, dbName, discID, mrCommits[0].ID)
+		if err != nil {
+			log.Printf("Dismissing outdated approvals for database '%s%s%s', MR '%d' failed: %v\n", dbOwner,
+				dbFolder, dbName, discID, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Updates the amount of data received for an upload session, along with the state of its running sha256.
+func UpdateUploadSession(uploadID string, received int64, hashState []byte) error {
+	dbQuery := `
+		UPDATE upload_sessions
+		SET received_size = $2, hash_state = $3, last_modified = now()
+		WHERE upload_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, uploadID, received, hashState)
+	if err != nil {
+		log.Printf("Updating upload session '%s' failed: %v\n", uploadID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		log.Printf("Wrong number of rows (%v) affected when updating upload session '%s'\n", numRows, uploadID)
+	}
 	return nil
 }
 
 // Returns details for a user.
 func User(userName string) (user UserDetails, err error) {
 	dbQuery := `
-		SELECT user_name, display_name, email, avatar_url, password_hash, date_joined, client_cert
+		SELECT user_name, display_name, email, avatar_url, password_hash, date_joined, client_cert, is_org
 		FROM users
 		WHERE lower(user_name) = lower($1)`
 	var av, dn, em pgx.NullString
 	err = pdb.QueryRow(dbQuery, userName).Scan(&user.Username, &dn, &em, &av, &user.PHash, &user.DateJoined,
-		&user.ClientCert)
+		&user.ClientCert, &user.IsOrg)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// The error was just "no such user found"
@@ -4091,6 +8002,81 @@ func User(userName string) (user UserDetails, err error) {
 	return user, nil
 }
 
+// Returns whether a user account has been disabled, and the time from which their client certificates are valid.  A
+// zero time means all of their certificates are valid.
+func UserAccountStatus(userName string) (disabled bool, certsValidFrom time.Time, err error) {
+	dbQuery := `
+		SELECT disabled, certs_valid_from
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	var validFrom pgx.NullTime
+	err = pdb.QueryRow(dbQuery, userName).Scan(&disabled, &validFrom)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// Unknown user
+			return false, time.Time{}, nil
+		}
+		log.Printf("Retrieving account status for user '%s' failed: %v\n", userName, err)
+		return
+	}
+	if validFrom.Valid {
+		certsValidFrom = validFrom.Time
+	}
+	return
+}
+
+// Returns the number of (non deleted) databases owned by a user.
+func UserDatabaseCount(userName string) (count int, err error) {
+	dbQuery := `
+		SELECT count(*)
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1))
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&count)
+	if err != nil {
+		log.Printf("Counting the databases of user '%s' failed: %v\n", userName, err)
+		return 0, err
+	}
+	return
+}
+
+// Returns the sha256 and size of each database file used by the databases of a user, including the ones in their
+// trash.
+func UserDatabaseFiles(userName string) (files map[string]int64, err error) {
+	dbQuery := `
+		SELECT DISTINCT e->>'sha256', (e->>'size')::bigint
+		FROM sqlite_databases AS db
+			LEFT JOIN database_trash AS t ON t.db_id = db.db_id,
+			jsonb_each(db.commit_list) AS c, jsonb_array_elements(c.value->'tree'->'entries') AS e
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1))
+			AND (db.is_deleted = false OR t.db_id IS NOT NULL)
+			AND e->>'entry_type' = 'db'`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		log.Printf("Retrieving the database files of user '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	files = make(map[string]int64)
+	for rows.Next() {
+		var sha string
+		var size int64
+		err = rows.Scan(&sha, &size)
+		if err != nil {
+			log.Printf("Error retrieving the database files of user '%s': %v\n", userName, err)
+			return
+		}
+		files[sha] = size
+	}
+	return
+}
+
 // Returns the list of databases for a user.
 func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
 	// Construct SQL query for retrieving the requested database list
@@ -4224,6 +8210,158 @@ func UserNameFromAuth0ID(auth0id string) (string, error) {
 	return userName, nil
 }
 
+// Returns the notification settings of a user.  The first entry holds their defaults, followed by one entry for each
+// database they're watching.
+func UserNotifySettings(userName string) (list []NotifySettings, err error) {
+	var def NotifySettings
+	var freq string
+	var events []int32
+	dbQuery := `
+		SELECT notify_frequency, notify_events
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&freq, &events)
+	if err != nil {
+		log.Printf("Retrieving notification settings for user '%s' failed: %v\n", userName, err)
+		return
+	}
+	def.Frequency = NotifyFrequency(freq)
+	def.Events = []EventType{}
+	for _, e := range events {
+		def.Events = append(def.Events, EventType(e))
+	}
+	list = append(list, def)
+
+	dbQuery = `
+		SELECT o.user_name, db.folder, db.db_name, coalesce(w.notify_frequency, ''), w.notify_events
+		FROM watchers AS w, sqlite_databases AS db, users AS o
+		WHERE w.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.db_id = w.db_id
+			AND db.is_deleted = false
+			AND o.user_id = db.user_id
+		ORDER BY o.user_name, db.folder, db.db_name`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		log.Printf("Retrieving watched database notification settings for user '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s NotifySettings
+		err = rows.Scan(&s.Owner, &s.Folder, &s.DBName, &freq, &events)
+		if err != nil {
+			log.Printf("Error retrieving watched database notification settings for user '%s': %v\n", userName,
+				err)
+			return
+		}
+		s.Frequency = NotifyFrequency(freq)
+		s.Events = []EventType{}
+		for _, e := range events {
+			s.Events = append(s.Events, EventType(e))
+		}
+		list = append(list, s)
+	}
+	return
+}
+
+// Returns the organisations a user is a member of.
+func UserOrganisations(userName string) (list []OrgEntry, err error) {
+	dbQuery := `
+		SELECT o.user_name, o.display_name, o.avatar_url, m.role
+		FROM org_members AS m, users AS o
+		WHERE m.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND m.org_id = o.user_id
+		ORDER BY lower(o.user_name)`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		log.Printf("Retrieving organisations of '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o OrgEntry
+		var avatarURL, displayName pgx.NullString
+		var role string
+		err = rows.Scan(&o.Name, &displayName, &avatarURL, &role)
+		if err != nil {
+			log.Printf("Error retrieving organisations of '%s': %v\n", userName, err)
+			return
+		}
+		o.AvatarURL = avatarURL.String
+		o.DisplayName = displayName.String
+		o.Role = OrgRole(role)
+		list = append(list, o)
+	}
+	return
+}
+
+// Returns the most recent actions of a user on public databases, for showing to everyone.  Only the actions given are
+// included, and the entries use the current name of each database.  The IP address of the user is left out.
+func UserPublicActivity(userName string, actions []AuditAction, limit int) (list []AuditEntry, err error) {
+	names := make([]string, 0, len(actions))
+	for _, a := range actions {
+		names = append(names, string(a))
+	}
+	dbQuery := `
+		SELECT a.audit_id, a.date_created, a.actor, a.server_sw, u.user_name, db.folder, db.db_name, a.action,
+			a.details
+		FROM audit_log AS a, sqlite_databases AS db, users AS u
+		WHERE lower(a.actor) = lower($1)
+			AND a.action = ANY($2)
+			AND a.db_id = db.db_id
+			AND db.public = true
+			AND db.is_deleted = false
+			AND db.user_id = u.user_id
+		ORDER BY a.audit_id DESC
+		LIMIT $3`
+	rows, err := pdb.Query(dbQuery, userName, names, limit)
+	if err != nil {
+		log.Printf("Retrieving the public activity of user '%s' failed: %v\n", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		var action string
+		var details struct {
+			After  interface{} `json:"after"`
+			Before interface{} `json:"before"`
+		}
+		err = rows.Scan(&e.ID, &e.Date, &e.Actor, &e.ServerSw, &e.Owner, &e.Folder, &e.DBName, &action, &details)
+		if err != nil {
+			log.Printf("Error retrieving the public activity of user '%s': %v\n", userName, err)
+			return
+		}
+		e.Action = AuditAction(action)
+		e.After = details.After
+		e.Before = details.Before
+		list = append(list, e)
+	}
+	return
+}
+
+// Returns the limits set for a user on the admin server.
+func UserQuotas(userName string) (q UserQuota, err error) {
+	dbQuery := `
+		SELECT coalesce(max_upload_size, 0), coalesce(max_storage, 0), coalesce(max_databases, 0)
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&q.MaxUploadSize, &q.MaxStorage, &q.MaxDatabases)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Printf("Retrieving limits for user '%s' failed: %v\n", userName, err)
+		return UserQuota{}, err
+	}
+	return q, nil
+}
+
 // Returns the list of databases starred by a user.
 func UserStarredDBs(userName string) (list []DBEntry, err error) {
 	dbQuery := `
@@ -4241,6 +8379,7 @@ func UserStarredDBs(userName string) (list []DBEntry, err error) {
 			SELECT db.user_id, db.folder, db.db_name, stars.date_starred
 			FROM sqlite_databases AS db, stars
 			WHERE db.db_id = stars.db_id
+				AND db.is_deleted = false
 		)
 		SELECT users.user_name, db_users.folder, db_users.db_name, db_users.date_starred
 		FROM users, db_users
@@ -4380,6 +8519,7 @@ func UserWatchingDBs(userName string) (list []DBEntry, err error) {
 			SELECT db.user_id, db.folder, db.db_name, watching.date_watched
 			FROM sqlite_databases AS db, watching
 			WHERE db.db_id = watching.db_id
+				AND db.is_deleted = false
 		)
 		SELECT users.user_name, db_users.folder, db_users.db_name, db_users.date_watched
 		FROM users, db_users
@@ -4485,3 +8625,94 @@ func VisualisationSaveParams(dbOwner string, dbFolder string, dbName string, vis
 	}
 	return
 }
+
+// Returns the most recent webhook deliveries for a database.
+func WebhookDeliveries(dbOwner string, dbFolder string, dbName string, limit int) (list []WebhookDeliveryEntry,
+	err error) {
+	dbQuery := `
+		SELECT del.delivery_id, del.webhook_id, del.event_type, del.date_created, del.state, del.attempts,
+			del.next_attempt, del.last_attempt, del.status_code, del.error
+		FROM webhook_deliveries AS del, webhooks AS hook
+		WHERE del.webhook_id = hook.webhook_id
+			AND hook.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1))
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false)
+		ORDER BY del.delivery_id DESC
+		LIMIT $4`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName, limit)
+	if err != nil {
+		log.Printf("Retrieving webhook deliveries for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName,
+			err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d WebhookDeliveryEntry
+		var state string
+		var lastAttempt pgx.NullTime
+		var statusCode pgx.NullInt32
+		var errMsg pgx.NullString
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.DateCreated, &state, &d.Attempts, &d.NextAttempt,
+			&lastAttempt, &statusCode, &errMsg)
+		if err != nil {
+			log.Printf("Error retrieving webhook deliveries for database '%s%s%s': %v\n", dbOwner, dbFolder,
+				dbName, err)
+			return
+		}
+		d.Error = errMsg.String
+		d.LastAttempt = lastAttempt.Time
+		d.State = WebhookDeliveryState(state)
+		d.StatusCode = int(statusCode.Int32)
+		list = append(list, d)
+	}
+	return
+}
+
+// Returns the webhooks registered for a database.
+func Webhooks(dbOwner string, dbFolder string, dbName string) (list []WebhookEntry, err error) {
+	dbQuery := `
+		SELECT hook.webhook_id, hook.url, hook.secret, hook.event_types, hook.date_created, users.user_name
+		FROM webhooks AS hook
+			LEFT JOIN users ON hook.creator = users.user_id
+		WHERE hook.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1))
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false)
+		ORDER BY hook.webhook_id`
+	rows, err := pdb.Query(dbQuery, dbOwner, dbFolder, dbName)
+	if err != nil {
+		log.Printf("Retrieving webhooks for database '%s%s%s' failed: %v\n", dbOwner, dbFolder, dbName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h WebhookEntry
+		var evList []int32
+		var creator pgx.NullString
+		err = rows.Scan(&h.ID, &h.URL, &h.Secret, &evList, &h.DateCreated, &creator)
+		if err != nil {
+			log.Printf("Error retrieving webhooks for database '%s%s%s': %v\n", dbOwner, dbFolder, dbName, err)
+			return
+		}
+		h.Creator = creator.String
+		h.Events = []EventType{}
+		for _, e := range evList {
+			h.Events = append(h.Events, EventType(e))
+		}
+		list = append(list, h)
+	}
+	return
+}
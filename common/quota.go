@@ -0,0 +1,123 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Checks if a user is allowed to own another database.
+func CheckDatabaseQuota(userName string) error {
+	limit := MaxDatabases(userName)
+	if limit == 0 {
+		return nil
+	}
+	count, err := UserDatabaseCount(userName)
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		return fmt.Errorf("'%s' already has %d databases, which is the most allowed", userName, count)
+	}
+	return nil
+}
+
+// Checks if adding the given database files (sha256 -> size in bytes) to the ones already stored by a user would take
+// them over their storage limit.  Files the user already has don't count again.
+func CheckStorageQuota(userName string, newFiles map[string]int64) error {
+	limit := MaxStorage(userName)
+	if limit == 0 || len(newFiles) == 0 {
+		return nil
+	}
+	files, err := UserDatabaseFiles(userName)
+	if err != nil {
+		return err
+	}
+	used := storageUsed(files)
+	needed := used
+	for sha, size := range newFiles {
+		if _, ok := files[sha]; !ok {
+			needed += size
+		}
+	}
+	if needed > used && needed > limit {
+		return fmt.Errorf("That would take the storage used by '%s' to %d MB, over the limit of %d MB", userName,
+			needed/1024/1024, limit/1024/1024)
+	}
+	return nil
+}
+
+// Returns the database files (sha256 -> size in bytes) used by the given commits.
+func CommitDatabaseFiles(commits []CommitEntry) map[string]int64 {
+	files := make(map[string]int64)
+	for _, c := range commits {
+		for _, e := range c.Tree.Entries {
+			if e.EntryType == DATABASE {
+				files[e.Sha256] = e.Size
+			}
+		}
+	}
+	return files
+}
+
+// Returns the maximum number of databases a user can own.  Zero means there's no limit.  A limit set for the user on
+// the admin server takes precedence over the ones in the configuration file.
+func MaxDatabases(userName string) int {
+	limit := Conf.Upload.MaxDatabases
+	for u, l := range Conf.Upload.UserDatabaseLimits {
+		if strings.ToLower(u) == strings.ToLower(userName) {
+			limit = l
+			break
+		}
+	}
+	if q, err := UserQuotas(userName); err == nil && q.MaxDatabases > 0 {
+		limit = q.MaxDatabases
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return limit
+}
+
+// Returns the maximum total size (in bytes) of the database files a user can store.  Zero means there's no limit.  A
+// limit set for the user on the admin server takes precedence over the ones in the configuration file.
+func MaxStorage(userName string) int64 {
+	limit := Conf.Upload.MaxStorage
+	for u, l := range Conf.Upload.UserStorageLimits {
+		if strings.ToLower(u) == strings.ToLower(userName) {
+			limit = l
+			break
+		}
+	}
+	if q, err := UserQuotas(userName); err == nil && q.MaxStorage > 0 {
+		limit = q.MaxStorage
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return limit * 1024 * 1024
+}
+
+// Returns the storage used by a user, along with their limits.
+func Quota(userName string) (usage QuotaUsage, err error) {
+	files, err := UserDatabaseFiles(userName)
+	if err != nil {
+		return
+	}
+	usage.Storage = storageUsed(files)
+	usage.Databases, err = UserDatabaseCount(userName)
+	if err != nil {
+		return
+	}
+	usage.MaxDatabases = MaxDatabases(userName)
+	usage.MaxStorage = MaxStorage(userName)
+	usage.MaxUploadSize = MaxUploadSize(userName)
+	return
+}
+
+// Returns the total size of a set of database files.
+func storageUsed(files map[string]int64) (total int64) {
+	for _, size := range files {
+		total += size
+	}
+	return
+}
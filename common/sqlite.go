@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -428,6 +429,53 @@ func ReadSQLiteDBRedash(sdb *sqlite.Conn, dbTable string) (dash RedashTableData,
 	return dash, nil
 }
 
+// Reads a single row from a SQLite table, looking it up by its primary key values.  If a column name is given then only
+// the value of that column is returned, otherwise all of the row's values are returned as a slice.
+func ReadSQLiteRow(sdb *sqlite.Conn, dbTable string, primaryKey map[string]interface{}, column string) (
+	value interface{}, found bool, err error) {
+	if len(primaryKey) == 0 {
+		return nil, false, errors.New("No primary key values given")
+	}
+
+	// Sort the key columns, so the same query is generated each time
+	var keyCols []string
+	for k := range primaryKey {
+		keyCols = append(keyCols, k)
+	}
+	sort.Strings(keyCols)
+
+	// Construct the query
+	dbQuery := `SELECT *`
+	if column != "" {
+		dbQuery = sqlite.Mprintf(`SELECT "%w"`, column)
+	}
+	dbQuery += sqlite.Mprintf(` FROM "%w" WHERE `, dbTable)
+	var args []interface{}
+	for i, k := range keyCols {
+		if i > 0 {
+			dbQuery += " AND "
+		}
+		dbQuery += sqlite.Mprintf(`"%w" = ?`, k)
+		args = append(args, primaryKey[k])
+	}
+	stmt, err := sdb.Prepare(dbQuery, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer stmt.Finalize()
+	found, err = stmt.Next()
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if column != "" {
+		value, _ = stmt.ScanValue(0)
+		return value, true, nil
+	}
+	row := make([]interface{}, stmt.ColumnCount())
+	stmt.ScanValues(row)
+	return row, true, nil
+}
+
 // Performs basic sanity checks of an uploaded database.
 func SanityCheck(fileName string) (tables []string, err error) {
 	// Perform a read on the database, as a basic sanity check to ensure it's really a SQLite database
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"log"
+	"time"
+)
+
+// Periodically purges databases which have been in the trash for longer than the retention period, and removes the
+// database files they used from Minio once nothing else refers to them.
+func PurgeTrashLoop() {
+	log.Printf("Trash purge loop started.  Deleted databases are kept for %d days.\n", Conf.Trash.Retention)
+	for {
+		cutOff := time.Now().Add(-time.Duration(Conf.Trash.Retention) * 24 * time.Hour)
+		ids, err := ExpiredTrash(cutOff)
+		if err == nil {
+			for _, id := range ids {
+				shas, err := PurgeDatabase(id)
+				if err != nil {
+					continue
+				}
+				collectDatabaseFiles(shas)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// Removes the given database files from Minio, skipping any still used by another database.
+func collectDatabaseFiles(shas []string) {
+	for _, sha := range shas {
+		removed, err := DeleteUnusedDatabaseFile(sha)
+		if err == nil && removed {
+			log.Printf("Database file '%s' removed from Minio\n", sha)
+		}
+	}
+}
@@ -45,7 +45,7 @@ const (
 // Number of rows to display by default on the database page
 const DefaultNumDisplayRows = 25
 
-// The maximum database size accepted for upload (in MB)
+// The default maximum database size accepted for upload (in MB)
 const MaxDatabaseSize = 512
 
 // The maximum licence size accepted for upload (in MB)
@@ -67,12 +67,15 @@ type TomlConfig struct {
 	DB4S        DB4SInfo
 	Environment EnvInfo
 	DiskCache   DiskCacheInfo
+	Email       EmailInfo
 	Event       EventProcessingInfo
 	Licence     LicenceInfo
 	Memcache    MemcacheInfo
 	Minio       MinioInfo
 	Pg          PGInfo
 	Sign        SigningInfo
+	Trash       TrashInfo
+	Upload      UploadInfo
 	Web         WebInfo
 }
 
@@ -82,6 +85,7 @@ type AdminInfo struct {
 	CertificateKey string `toml:"certificate_key"`
 	HTTPS          bool
 	Server         string
+	Users          []string
 }
 
 // Auth0 connection parameters
@@ -105,6 +109,21 @@ type DiskCacheInfo struct {
 	Directory string
 }
 
+// Outgoing email settings.  Mode is either "smtp" to send through the relay server, or "mbox" to append the emails to
+// a local mbox file instead (useful for development and testing).  TLS is one of "none", "starttls", or "tls"
+type EmailInfo struct {
+	From        string
+	MaxAttempts int    `toml:"max_attempts"`
+	Mbox        string `toml:"mbox"`
+	Mode        string
+	Password    string
+	Port        int
+	Server      string
+	TemplateDir string `toml:"template_dir"`
+	TLS         string `toml:"tls"`
+	Username    string
+}
+
 // Environment info
 type EnvInfo struct {
 	Environment string
@@ -113,8 +132,10 @@ type EnvInfo struct {
 // Event processing loop
 type EventProcessingInfo struct {
 	Delay                     time.Duration `toml:"delay"`
-	EmailQueueDir             string        `toml:"email_queue_dir"`
 	EmailQueueProcessingDelay time.Duration `toml:"email_queue_processing_delay"`
+	UnsubscribeKey            string        `toml:"unsubscribe_key"`
+	WebhookMaxAttempts        int           `toml:"webhook_max_attempts"`
+	WebhookTimeout            time.Duration `toml:"webhook_timeout"`
 }
 
 // Path to the licence files
@@ -156,6 +177,23 @@ type SigningInfo struct {
 	IntermediateKey  string `toml:"intermediate_key"`
 }
 
+// Deleted database settings.  The retention period is in days
+type TrashInfo struct {
+	Retention int `toml:"retention"`
+}
+
+// Database upload settings.  Sizes are in MB, and zero storage or database limits mean there's no limit
+type UploadInfo struct {
+	ChunkSize          int64            `toml:"chunk_size"`
+	MaxDatabases       int              `toml:"max_databases"`
+	MaxDatabaseSize    int64            `toml:"max_database_size"`
+	MaxStorage         int64            `toml:"max_storage"`
+	SessionTimeout     time.Duration    `toml:"session_timeout"`
+	UserDatabaseLimits map[string]int   `toml:"user_database_limits"`
+	UserLimits         map[string]int64 `toml:"user_limits"`
+	UserStorageLimits  map[string]int64 `toml:"user_storage_limits"`
+}
+
 type WebInfo struct {
 	BaseDir              string `toml:"base_dir"`
 	BindAddress          string `toml:"bind_address"`
@@ -183,6 +221,164 @@ type ActivityStats struct {
 	Viewed    []ActivityRow
 }
 
+type AdminDBEntry struct {
+	DBID         int64
+	DBName       string
+	Deleted      bool
+	Folder       string
+	InTrash      bool
+	LastModified time.Time
+	Owner        string
+	Public       bool
+}
+
+type AdminStats struct {
+	APITokens      int
+	DatabaseFiles  int
+	Databases      int
+	DisabledUsers  int
+	Discussions    int
+	EmailsPending  int
+	EventsPending  int
+	MergeRequests  int
+	Orgs           int
+	PublicDBs      int
+	Stars          int
+	TrashedDBs     int
+	UploadSessions int
+	Users          int
+}
+
+type AdminUserEntry struct {
+	CertsValidFrom time.Time
+	DateJoined     time.Time
+	Disabled       bool
+	DisplayName    string
+	Email          string
+	IsOrg          bool
+	NumDatabases   int
+	Quota          UserQuota
+	UserName       string
+}
+
+type APIToken struct {
+	DateCreated time.Time       `json:"date_created"`
+	ID          int64           `json:"id"`
+	LastUsed    time.Time       `json:"last_used"`
+	Name        string          `json:"name"`
+	Scopes      []APITokenScope `json:"scopes"`
+}
+
+type APITokenScope string
+
+const (
+	API_SCOPE_READ  APITokenScope = "read"
+	API_SCOPE_WRITE               = "write"
+)
+
+// The kinds of state changing actions recorded in the audit log
+type AuditAction string
+
+const (
+	AUDIT_API_TOKEN_CREATE      AuditAction = "api_token.create"
+	AUDIT_API_TOKEN_DELETE                  = "api_token.delete"
+	AUDIT_BRANCH_CREATE                     = "branch.create"
+	AUDIT_BRANCH_DEFAULT                    = "branch.set_default"
+	AUDIT_BRANCH_DELETE                     = "branch.delete"
+	AUDIT_BRANCH_UPDATE                     = "branch.update"
+	AUDIT_CACHE_FLUSH                       = "cache.flush"
+	AUDIT_CACHE_INVALIDATE                  = "cache.invalidate"
+	AUDIT_CERT_GENERATE                     = "cert.generate"
+	AUDIT_COLLABORATOR_ADD                  = "collaborator.add"
+	AUDIT_COLLABORATOR_REMOVE               = "collaborator.remove"
+	AUDIT_COMMENT_CREATE                    = "comment.create"
+	AUDIT_COMMENT_DELETE                    = "comment.delete"
+	AUDIT_COMMENT_UPDATE                    = "comment.update"
+	AUDIT_COMMIT_CREATE                     = "commit.create"
+	AUDIT_COMMIT_DELETE                     = "commit.delete"
+	AUDIT_COMMIT_STATUS                     = "commit.status"
+	AUDIT_DATABASE_CREATE                   = "database.create"
+	AUDIT_DATABASE_DELETE                   = "database.delete"
+	AUDIT_DATABASE_FORK                     = "database.fork"
+	AUDIT_DATABASE_MAKE_PRIVATE             = "database.make_private"
+	AUDIT_DATABASE_RESTORE                  = "database.restore"
+	AUDIT_DATABASE_SETTINGS                 = "database.settings"
+	AUDIT_DATABASE_TRANSFER                 = "database.transfer"
+	AUDIT_DISCUSSION_CLOSE                  = "discussion.close"
+	AUDIT_DISCUSSION_CREATE                 = "discussion.create"
+	AUDIT_DISCUSSION_REOPEN                 = "discussion.reopen"
+	AUDIT_DISCUSSION_SUBSCRIBE              = "discussion.subscribe"
+	AUDIT_DISCUSSION_TRIAGE                 = "discussion.triage"
+	AUDIT_DISCUSSION_UPDATE                 = "discussion.update"
+	AUDIT_LABEL_DELETE                      = "label.delete"
+	AUDIT_LABEL_SAVE                        = "label.save"
+	AUDIT_LICENCE_ADD                       = "licence.add"
+	AUDIT_LICENCE_REMOVE                    = "licence.remove"
+	AUDIT_MILESTONE_DELETE                  = "milestone.delete"
+	AUDIT_MILESTONE_SAVE                    = "milestone.save"
+	AUDIT_MR_CREATE                         = "mr.create"
+	AUDIT_MR_MERGE                          = "mr.merge"
+	AUDIT_MR_REVIEW                         = "mr.review"
+	AUDIT_NOTIFY_UNSUBSCRIBE                = "notify.unsubscribe"
+	AUDIT_NOTIFY_UPDATE                     = "notify.update"
+	AUDIT_ORG_CREATE                        = "org.create"
+	AUDIT_ORG_MEMBER_ADD                    = "org.member_add"
+	AUDIT_ORG_MEMBER_REMOVE                 = "org.member_remove"
+	AUDIT_PREFERENCES_UPDATE                = "preferences.update"
+	AUDIT_RELEASE_CREATE                    = "release.create"
+	AUDIT_RELEASE_DELETE                    = "release.delete"
+	AUDIT_RELEASE_UPDATE                    = "release.update"
+	AUDIT_STAR_TOGGLE                       = "star.toggle"
+	AUDIT_TAG_CREATE                        = "tag.create"
+	AUDIT_TAG_DELETE                        = "tag.delete"
+	AUDIT_TAG_UPDATE                        = "tag.update"
+	AUDIT_TEAM_CREATE                       = "team.create"
+	AUDIT_TEAM_DATABASE_ADD                 = "team.database_add"
+	AUDIT_TEAM_DATABASE_REMOVE              = "team.database_remove"
+	AUDIT_TEAM_DELETE                       = "team.delete"
+	AUDIT_TEAM_MEMBER_ADD                   = "team.member_add"
+	AUDIT_TEAM_MEMBER_REMOVE                = "team.member_remove"
+	AUDIT_TRANSFER_CANCEL                   = "transfer.cancel"
+	AUDIT_TRANSFER_OFFER                    = "transfer.offer"
+	AUDIT_USER_CREATE                       = "user.create"
+	AUDIT_USER_DISABLE                      = "user.disable"
+	AUDIT_USER_ENABLE                       = "user.enable"
+	AUDIT_USER_RENAME                       = "user.rename"
+	AUDIT_USER_RESET_CERTS                  = "user.reset_certs"
+	AUDIT_USER_SET_QUOTA                    = "user.set_quota"
+	AUDIT_VIS_SAVE                          = "vis.save"
+	AUDIT_WATCH_TOGGLE                      = "watch.toggle"
+	AUDIT_WEBHOOK_CREATE                    = "webhook.create"
+	AUDIT_WEBHOOK_DELETE                    = "webhook.delete"
+	AUDIT_WEBHOOK_REDELIVER                 = "webhook.redeliver"
+)
+
+// An entry in the audit log.  Before and After hold the details of what was changed, and are stored as JSON
+type AuditEntry struct {
+	Action   AuditAction `json:"action"`
+	Actor    string      `json:"actor"`
+	After    interface{} `json:"after,omitempty"`
+	Before   interface{} `json:"before,omitempty"`
+	Date     time.Time   `json:"date"`
+	DBName   string      `json:"dbname"`
+	Folder   string      `json:"folder"`
+	ID       int64       `json:"id"`
+	IPAddr   string      `json:"ip_addr"`
+	Owner    string      `json:"owner"`
+	ServerSw string      `json:"server_sw"`
+}
+
+// The search criteria for audit log entries.  Empty (or zero) fields match everything
+type AuditFilter struct {
+	Action AuditAction
+	Actor  string
+	DBID   int64
+	DBName string
+	Limit  int
+	Offset int
+	Owner  string
+}
+
 type Auth0Set struct {
 	CallbackURL string
 	ClientID    string
@@ -195,15 +391,51 @@ type BranchEntry struct {
 	Description string `json:"description"`
 }
 
+type BranchProtectionEntry struct {
+	NoDelete       bool     `json:"no_delete"`
+	NoForcePush    bool     `json:"no_force_push"`
+	RequiredChecks []string `json:"required_checks"`
+	RequireMR      bool     `json:"require_mr"`
+}
+
+type CollaboratorEntry struct {
+	AvatarURL   string           `json:"avatar_url"`
+	DateAdded   time.Time        `json:"date_added"`
+	DisplayName string           `json:"display_name"`
+	Role        CollaboratorRole `json:"role"`
+	UserName    string           `json:"username"`
+}
+
+type CollaboratorRole string
+
+const (
+	COLLAB_READ  CollaboratorRole = "read"
+	COLLAB_WRITE                  = "write"
+	COLLAB_ADMIN                  = "admin"
+)
+
+// Points a merge request review comment at a table, row, and (optionally) column of the source database.  The row
+// is identified by its primary key values.  When the merge request gets new commits, the anchor is moved to the newest
+// one, and flagged as outdated if the data it points to has changed
+type CommentAnchor struct {
+	Column           string                 `json:"column"`
+	CommitID         string                 `json:"commit_id"`
+	OriginalCommitID string                 `json:"original_commit_id"`
+	Outdated         bool                   `json:"outdated"`
+	PrimaryKey       map[string]interface{} `json:"primary_key"`
+	Table            string                 `json:"table"`
+}
+
 type CommitData struct {
-	AuthorAvatar   string    `json:"author_avatar"`
-	AuthorEmail    string    `json:"author_email"`
-	AuthorName     string    `json:"author_name"`
-	AuthorUsername string    `json:"author_username"`
-	ID             string    `json:"id"`
-	LicenceChange  string    `json:"licence_change"`
-	Message        string    `json:"message"`
-	Timestamp      time.Time `json:"timestamp"`
+	AuthorAvatar    string    `json:"author_avatar"`
+	AuthorEmail     string    `json:"author_email"`
+	AuthorName      string    `json:"author_name"`
+	AuthorUsername  string    `json:"author_username"`
+	ID              string    `json:"id"`
+	LicenceChange   string    `json:"licence_change"`
+	Message         string    `json:"message"`
+	MessageRendered string    `json:"message_rendered"`
+	Timestamp       time.Time `json:"timestamp"`
 }
 
 type CommitEntry struct {
@@ -219,6 +451,18 @@ type CommitEntry struct {
 	Tree           DBTree    `json:"tree"`
 }
 
+// The state of a named status check for a commit.  These are posted by external runners through the API, or created
+// by the built in SQL assertion runner
+type CommitStatus struct {
+	CommitID    string           `json:"commit_id"`
+	Context     string           `json:"context"`
+	Creator     string           `json:"creator"`
+	DateUpdated time.Time        `json:"date_updated"`
+	Description string           `json:"description"`
+	State       StatusCheckState `json:"state"`
+	TargetURL   string           `json:"target_url"`
+}
+
 type DataValue struct {
 	Name  string
 	Type  ValType
@@ -299,6 +543,7 @@ const (
 )
 
 type DiscussionCommentEntry struct {
+	Anchor       *CommentAnchor        `json:"anchor,omitempty"`
 	AvatarURL    string                `json:"avatar_url"`
 	Body         string                `json:"body"`
 	BodyRendered string                `json:"body_rendered"`
@@ -316,6 +561,7 @@ const (
 )
 
 type DiscussionEntry struct {
+	Assignees    []string          `json:"assignees"`
 	AvatarURL    string            `json:"avatar_url"`
 	Body         string            `json:"body"`
 	BodyRendered string            `json:"body_rendered"`
@@ -323,18 +569,54 @@ type DiscussionEntry struct {
 	Creator      string            `json:"creator"`
 	DateCreated  time.Time         `json:"creation_date"`
 	ID           int               `json:"disc_id"`
+	Labels       []DiscussionLabel `json:"labels"`
 	LastModified time.Time         `json:"last_modified"`
+	Milestone    string            `json:"milestone"`
+	MilestoneID  int64             `json:"milestone_id"`
 	MRDetails    MergeRequestEntry `json:"mr_details"`
 	Open         bool              `json:"open"`
 	Title        string            `json:"title"`
 	Type         DiscussionType    `json:"discussion_type"`
 }
 
+// Filtering and sorting options for discussion and merge request lists.  Empty fields don't filter anything
+type DiscussionFilter struct {
+	Assignee  string
+	Label     string
+	Milestone string
+	Sort      DiscussionSort
+	State     string // "open" or "closed"
+}
+
+type DiscussionLabel struct {
+	Colour      string `json:"colour"`
+	Description string `json:"description"`
+	ID          int64  `json:"label_id"`
+	Name        string `json:"name"`
+}
+
+type DiscussionSort string
+
+const (
+	SORT_UPDATED  DiscussionSort = "updated"
+	SORT_CREATED                 = "created"
+	SORT_COMMENTS                = "comments"
+)
+
+// Returned when the labels, assignees, or milestone given for a discussion can't be used, as opposed to the
+// database server having a problem
+type DiscussionTrackingError string
+
+func (e DiscussionTrackingError) Error() string {
+	return string(e)
+}
+
 type EventDetails struct {
 	DBName    string    `json:"database_name"`
 	DiscID    int       `json:"discussion_id"`
 	Folder    string    `json:"database_folder"`
 	ID        string    `json:"event_id"`
+	Mentioned string    `json:"mentioned"`
 	Message   string    `json:"message"`
 	Owner     string    `json:"database_owner"`
 	Timestamp time.Time `json:"event_timestamp"`
@@ -344,13 +626,40 @@ type EventDetails struct {
 	UserName  string    `json:"username"`
 }
 
+type EmailQueueEntry struct {
+	ID              int64
+	MailTo          string
+	QueuedTimestamp time.Time
+	Sent            bool
+	SentTimestamp   time.Time
+	Subject         string
+}
+
+type EventQueueEntry struct {
+	DBName    string
+	ID        int64
+	Owner     string
+	Timestamp time.Time
+	Type      EventType
+}
+
 type EventType int
 
 const (
-	EVENT_NEW_DISCUSSION    EventType = 0 // These are not iota, as it would be seriously bad for these numbers to change
-	EVENT_NEW_MERGE_REQUEST           = 1
-	EVENT_NEW_COMMENT                 = 2
-	EVENT_NEW_RELEASE                 = 3
+	EVENT_NEW_DISCUSSION       EventType = 0 // These are not iota, as it would be seriously bad for these numbers to change
+	EVENT_NEW_MERGE_REQUEST              = 1
+	EVENT_NEW_COMMENT                    = 2
+	EVENT_NEW_RELEASE                    = 3
+	EVENT_NEW_COMMIT                     = 4
+	EVENT_NEW_TAG                        = 5
+	EVENT_MERGE_REQUEST_MERGED           = 6
+	EVENT_NEW_BRANCH                     = 7
+	EVENT_BRANCH_DELETED                 = 8
+	EVENT_NEW_FORK                       = 9
+	EVENT_NEW_STAR                       = 10
+	EVENT_MERGE_REQUEST_CLOSED           = 11
+	EVENT_DATABASE_PUBLIC                = 12
+	EVENT_MENTION                        = 13
 )
 
 type ForkEntry struct {
@@ -365,6 +674,29 @@ type ForkEntry struct {
 	Deleted    bool       `json:"deleted"`
 }
 
+type FsckProblem struct {
+	Commit   string          `json:"commit"`
+	Details  string          `json:"details"`
+	Name     string          `json:"name"`
+	Repaired bool            `json:"repaired"`
+	Type     FsckProblemType `json:"problem_type"`
+}
+
+type FsckProblemType string
+
+const (
+	FSCK_BAD_COMMIT_ID      FsckProblemType = "bad_commit_id"
+	FSCK_BAD_TREE_ID                        = "bad_tree_id"
+	FSCK_DANGLING_BRANCH                    = "dangling_branch"
+	FSCK_DANGLING_RELEASE                   = "dangling_release"
+	FSCK_DANGLING_TAG                       = "dangling_tag"
+	FSCK_MISSING_FILE_ENTRY                 = "missing_file_entry"
+	FSCK_MISSING_OBJECT                     = "missing_object"
+	FSCK_MISSING_PARENT                     = "missing_parent"
+	FSCK_UNREACHABLE_COMMIT                 = "unreachable_commit"
+	FSCK_WRONG_BRANCH_COUNT                 = "wrong_branch_commit_count"
+)
+
 type LicenceEntry struct {
 	FileFormat string `json:"file_format"`
 	FullName   string `json:"full_name"`
@@ -373,6 +705,42 @@ type LicenceEntry struct {
 	URL        string `json:"url"`
 }
 
+// A change pushed to the open pages of the webUI as it happens.  Updates are passed between servers using PostgreSQL
+// LISTEN/NOTIFY, so only the details needed to find the changed data are included
+type LiveUpdate struct {
+	Count  int            `json:"count,omitempty"`
+	DBName string         `json:"database,omitempty"`
+	DiscID int            `json:"discussion_id,omitempty"`
+	Folder string         `json:"folder,omitempty"`
+	Owner  string         `json:"owner,omitempty"`
+	Type   LiveUpdateType `json:"type"`
+	User   string         `json:"user,omitempty"`
+}
+
+type LiveUpdateType string
+
+const (
+	LIVE_COMMENTS       LiveUpdateType = "comments"
+	LIVE_DISCUSSION                    = "discussion"
+	LIVE_STATUS_UPDATES                = "status_updates"
+)
+
+// The rules a merge request needs to satisfy before it can be merged.  Approvals only count when given by users with
+// write access to the database, and required teams are teams of the organisation owning the database
+type MergePolicy struct {
+	MinApprovals int      `json:"min_approvals"`
+	Reviewers    []string `json:"reviewers"`
+	Teams        []string `json:"teams"`
+}
+
+// Where a merge request stands against the merge policy of its database
+type MergePolicyStatus struct {
+	Approvals int                  `json:"approvals"`
+	Missing   []string             `json:"missing"`
+	Reviews   []MergeRequestReview `json:"reviews"`
+	Satisfied bool                 `json:"satisfied"`
+}
+
 type MergeRequestState int
 
 const (
@@ -392,9 +760,32 @@ type MergeRequestEntry struct {
 	State        MergeRequestState `json:"state"`
 }
 
+type MergeRequestReview struct {
+	AvatarURL    string      `json:"avatar_url"`
+	CommitID     string      `json:"commit_id"`
+	DateReviewed time.Time   `json:"date_reviewed"`
+	State        ReviewState `json:"state"`
+	User         string      `json:"user"`
+}
+
+// A milestone for the discussions and merge requests of a database.  It can optionally point to the tag or release
+// the work is for
+type Milestone struct {
+	Description string    `json:"description"`
+	DueDate     time.Time `json:"due_date"`
+	ID          int64     `json:"milestone_id"`
+	Open        bool      `json:"open"`
+	Release     string    `json:"release"`
+	Tag         string    `json:"tag"`
+	Title       string    `json:"title"`
+}
+
 type MetaInfo struct {
 	AvatarURL        string
+	CanAdmin         bool
+	CanWrite         bool
 	Database         string
+	FeedURL          string
 	ForkDatabase     string
 	ForkDeleted      bool
 	ForkFolder       string
@@ -407,6 +798,71 @@ type MetaInfo struct {
 	Title            string
 }
 
+type OrgEntry struct {
+	AvatarURL   string  `json:"avatar_url"`
+	DisplayName string  `json:"display_name"`
+	Name        string  `json:"name"`
+	Role        OrgRole `json:"role"`
+}
+
+type OrgMemberEntry struct {
+	AvatarURL   string    `json:"avatar_url"`
+	DateJoined  time.Time `json:"date_joined"`
+	DisplayName string    `json:"display_name"`
+	Role        OrgRole   `json:"role"`
+	UserName    string    `json:"username"`
+}
+
+// How a user is told about the events on the databases they watch.  Digests collect the events into a single email
+// per day or week.  Events are always shown in the web UI, unless they've been filtered out
+type NotifyFrequency string
+
+const (
+	NOTIFY_IMMEDIATE NotifyFrequency = "immediate"
+	NOTIFY_DAILY                     = "daily"
+	NOTIFY_WEEKLY                    = "weekly"
+	NOTIFY_WEBUI                     = "webui"
+)
+
+// The notification settings of a user.  The entries without a database name are the defaults for the user, and the
+// others override them for a single watched database.  An empty event list means all events
+type NotifySettings struct {
+	DBName    string          `json:"database_name"`
+	Events    []EventType     `json:"events"`
+	Folder    string          `json:"database_folder"`
+	Frequency NotifyFrequency `json:"frequency"` // Empty for watched databases using the defaults
+	Owner     string          `json:"database_owner"`
+}
+
+// Organisation owners have full control over the organisation and its databases.  Members can see all of its
+// databases, and get any further access through the teams they're in
+type OrgRole string
+
+const (
+	ORG_OWNER  OrgRole = "owner"
+	ORG_MEMBER         = "member"
+)
+
+// The level of access a user has to a database.  Each level includes the ones below it
+type Permission int
+
+const (
+	PERM_NONE  Permission = 0 // These are not iota, as the levels are compared against each other
+	PERM_READ             = 1
+	PERM_WRITE            = 2
+	PERM_ADMIN            = 3
+	PERM_OWNER            = 4
+)
+
+// The storage used by a user, and their limits.  Sizes are in bytes, and zero limits mean there's no limit
+type QuotaUsage struct {
+	Databases     int
+	MaxDatabases  int
+	MaxStorage    int64
+	MaxUploadSize int64
+	Storage       int64
+}
+
 // When SQLite data is prepared for sending to Redash (as JSON), the RedashColumnMeta and RedashTableData structures
 // are used to hold it
 type RedashColumnMeta struct {
@@ -429,6 +885,21 @@ type ReleaseEntry struct {
 	Size          int64     `json:"size"`
 }
 
+type ReviewState string
+
+const (
+	REVIEW_APPROVED          ReviewState = "approved"
+	REVIEW_CHANGES_REQUESTED             = "changes_requested"
+	REVIEW_DISMISSED                     = "dismissed"
+)
+
+// A SQL query run against the head commit of merge requests as a status check.  The check passes when the query
+// returns no rows
+type SQLAssertion struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
 type SQLiteDBinfo struct {
 	Info     DBInfo
 	MaxRows  int
@@ -448,6 +919,14 @@ type SQLiteRecordSet struct {
 	TotalRows int
 }
 
+type StatusCheckState string
+
+const (
+	CHECK_PENDING StatusCheckState = "pending"
+	CHECK_SUCCESS                  = "success"
+	CHECK_FAILURE                  = "failure"
+)
+
 type StatusUpdateEntry struct {
 	DiscID int    `json:"discussion_id"`
 	Title  string `json:"title"`
@@ -462,18 +941,60 @@ type TagEntry struct {
 	TaggerName  string    `json:"name"`
 }
 
+// A team in an organisation.  Team members get the team's role on the databases the team has been given
+type TeamEntry struct {
+	Databases   []string         `json:"databases"`
+	Description string           `json:"description"`
+	Members     []string         `json:"members"`
+	Name        string           `json:"name"`
+	Role        CollaboratorRole `json:"role"`
+}
+
+type TransferOfferEntry struct {
+	DateOffered time.Time `json:"date_offered"`
+	DBName      string    `json:"database_name"`
+	Folder      string    `json:"folder"`
+	Owner       string    `json:"owner"`
+	Recipient   string    `json:"recipient"`
+}
+
+type TrashEntry struct {
+	DateDeleted time.Time `json:"date_deleted"`
+	DBID        int64     `json:"db_id"`
+	DBName      string    `json:"database_name"`
+	Expires     time.Time `json:"expires"`
+	Folder      string    `json:"folder"`
+	Owner       string    `json:"owner"`
+	Public      bool      `json:"public"`
+}
+
 type UploadRow struct {
 	DBName     string    `json:"dbname"`
 	Owner      string    `json:"owner"`
 	UploadDate time.Time `json:"upload_date"`
 }
 
+type UploadSession struct {
+	DateCreated  time.Time           `json:"date_created"`
+	DBName       string              `json:"dbname"`
+	DBOwner      string              `json:"dbowner"`
+	Folder       string              `json:"folder"`
+	FormValues   map[string][]string `json:"-"`
+	HashState    []byte              `json:"-"`
+	ID           string              `json:"upload_id"`
+	LastModified time.Time           `json:"last_modified"`
+	Owner        string              `json:"owner"`
+	Received     int64               `json:"offset"`
+	Size         int64               `json:"size"`
+}
+
 type UserDetails struct {
 	AvatarURL   string
 	ClientCert  []byte
 	DateJoined  time.Time
 	DisplayName string
 	Email       string
+	IsOrg       bool
 	Password    string
 	PHash       []byte
 	PVerify     string
@@ -486,6 +1007,14 @@ type UserInfo struct {
 	Username     string
 }
 
+// Limits set for a user on the admin server, which override the configuration file.  Sizes are in MB, and zero means
+// the limit hasn't been set
+type UserQuota struct {
+	MaxDatabases  int   `json:"max_databases"`
+	MaxStorage    int64 `json:"max_storage"`
+	MaxUploadSize int64 `json:"max_upload_size"`
+}
+
 type VisParamsV1 struct {
 	XAXisColumn string
 	YAXisColumn string
@@ -499,6 +1028,42 @@ type VisRowV1 struct {
 	Value int
 }
 
+type WebhookDeliveryEntry struct {
+	Attempts    int                  `json:"attempts"`
+	DateCreated time.Time            `json:"date_created"`
+	Error       string               `json:"error"`
+	EventType   EventType            `json:"event_type"`
+	ID          int64                `json:"id"`
+	LastAttempt time.Time            `json:"last_attempt"`
+	NextAttempt time.Time            `json:"next_attempt"`
+	State       WebhookDeliveryState `json:"state"`
+	StatusCode  int                  `json:"status_code"`
+	WebhookID   int64                `json:"webhook_id"`
+}
+
+type WebhookDeliveryState string
+
+const (
+	WEBHOOK_PENDING   WebhookDeliveryState = "pending"
+	WEBHOOK_DELIVERED                      = "delivered"
+	WEBHOOK_FAILED                         = "failed"
+)
+
+type WebhookEntry struct {
+	Creator     string      `json:"creator"`
+	DateCreated time.Time   `json:"date_created"`
+	Events      []EventType `json:"events"` // Empty means all events
+	ID          int64       `json:"id"`
+	Secret      string      `json:"secret"`
+	URL         string      `json:"url"`
+}
+
+// The JSON body sent to webhook receivers
+type WebhookPayload struct {
+	Details EventDetails `json:"details"`
+	Event   string       `json:"event"`
+}
+
 type WhereClause struct {
 	Column string
 	Type   string
@@ -0,0 +1,308 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads let large databases be sent in chunks, so a dropped connection doesn't mean starting over.  The
+// chunks are appended to a staging file in the disk cache, with the sha256 of the data received so far being kept up
+// to date as each chunk arrives.  Once all of the data has been received, the staged file is added to the system the
+// same way as a normal upload.
+
+// The most uploads a user can have in progress at once.  Each one has a staging file in the disk cache, so this stops
+// abandoned uploads filling it up
+const maxOpenUploads = 5
+
+var (
+	// Returned when a chunk is received for an upload which already has one in progress
+	ErrUploadBusy = errors.New("Another chunk for this upload is already being received")
+
+	// Returned when trying to finish an upload which hasn't received all of its data yet
+	ErrUploadIncomplete = errors.New("Not all of the data for this upload has been received yet")
+
+	// Returned when a chunk doesn't start where the previously received data finished
+	ErrUploadOffset = errors.New("Chunk offset doesn't match the amount of data received so far")
+
+	// Returned when a chunk would take an upload past the size it was started with
+	ErrUploadOverflow = errors.New("Chunk goes past the end of the upload")
+
+	// Returned when an upload ID isn't known for the user
+	ErrUploadNotFound = errors.New("Unknown upload ID")
+)
+
+// Returned when starting an upload would go over one of the upload limits
+type UploadLimitError string
+
+func (e UploadLimitError) Error() string {
+	return string(e)
+}
+
+// A database file which is already on disk, with its sha256 calculated as the data arrived.  Passing one of these to
+// AddDatabase() stores the file as it is, rather than copying it and generating the sha256 again
+type StagedDatabase struct {
+	File *os.File
+	Sha  string
+	Size int64
+}
+
+// Reads from the staged file, so a StagedDatabase can be used anywhere the database data is needed as an io.Reader.
+func (s *StagedDatabase) Read(p []byte) (int, error) {
+	return s.File.Read(p)
+}
+
+// The uploads which currently have a chunk being received
+var uploadsBusy = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+// Appends a chunk of data to an upload.  The offset needs to match the amount of data already received, so a chunk
+// which is resent after a dropped connection doesn't get added twice.  Any data left over from a previously failed
+// chunk is discarded first.
+func AppendUploadChunk(userName string, uploadID string, offset int64, chunk io.Reader) (upload UploadSession,
+	err error) {
+	// Only allow one chunk at a time to be received for each upload
+	uploadsBusy.Lock()
+	if uploadsBusy.ids[uploadID] {
+		uploadsBusy.Unlock()
+		return UploadSession{}, ErrUploadBusy
+	}
+	uploadsBusy.ids[uploadID] = true
+	uploadsBusy.Unlock()
+	defer func() {
+		uploadsBusy.Lock()
+		delete(uploadsBusy.ids, uploadID)
+		uploadsBusy.Unlock()
+	}()
+
+	// Retrieve the current state of the upload
+	upload, err = GetUploadSession(userName, uploadID)
+	if err != nil {
+		return
+	}
+	if offset != upload.Received {
+		err = ErrUploadOffset
+		return
+	}
+
+	// Restore the running sha256 of the data received so far
+	s := sha256.New()
+	err = s.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState)
+	if err != nil {
+		log.Printf("Restoring the sha256 state for upload '%s' failed: %v\n", uploadID, err)
+		return
+	}
+
+	// Open the staging file, and throw away anything after the data we know is good
+	f, err := os.OpenFile(uploadStagingFile(uploadID), os.O_WRONLY, 0)
+	if err != nil {
+		log.Printf("Opening the staging file for upload '%s' failed: %v\n", uploadID, err)
+		return
+	}
+	defer f.Close()
+	err = f.Truncate(upload.Received)
+	if err != nil {
+		return
+	}
+	_, err = f.Seek(upload.Received, 0)
+	if err != nil {
+		return
+	}
+
+	// Append the chunk to the staging file, reading at most one byte more than the upload has room for so oversized
+	// chunks can be detected
+	n, err := io.Copy(io.MultiWriter(f, s), io.LimitReader(chunk, upload.Size-upload.Received+1))
+	if err != nil {
+		log.Printf("Receiving chunk for upload '%s' failed: %v\n", uploadID, err)
+		return
+	}
+	if upload.Received+n > upload.Size {
+		err = ErrUploadOverflow
+		return
+	}
+
+	// Save the new state of the upload
+	hashState, err := s.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return
+	}
+	err = UpdateUploadSession(uploadID, upload.Received+n, hashState)
+	if err != nil {
+		return
+	}
+	upload.Received += n
+	upload.HashState = hashState
+	return
+}
+
+// Periodically removes upload sessions which haven't been added to for a while, along with their staged data.
+func ExpireUploadsLoop() {
+	log.Printf("Upload expiry loop started.  Unfinished uploads expire after %d hours.\n",
+		Conf.Upload.SessionTimeout)
+	for {
+		ids, err := StaleUploadSessions(Conf.Upload.SessionTimeout * time.Hour)
+		if err == nil {
+			for _, id := range ids {
+				err = RemoveUpload(id)
+				if err != nil {
+					log.Printf("Removing expired upload '%s' failed: %v\n", id, err)
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// Checks a new upload is within the limits, before anything is staged for it.  Users can only have a few uploads in
+// progress at once, and the uploads in progress for a database owner need to fit within their storage quota.
+// UploadLimitError is returned when a limit would be exceeded.
+func CheckUploadLimits(userName string, dbOwner string, size int64) error {
+	userUploads, ownerBytes, err := OpenUploads(userName, dbOwner)
+	if err != nil {
+		return err
+	}
+	if userUploads >= maxOpenUploads {
+		return UploadLimitError(fmt.Sprintf("You already have %d uploads in progress.  Finish one of them, or "+
+			"wait for the unfinished ones to expire", userUploads))
+	}
+	limit := MaxStorage(dbOwner)
+	if limit == 0 {
+		return nil
+	}
+	files, err := UserDatabaseFiles(dbOwner)
+	if err != nil {
+		return err
+	}
+	if needed := storageUsed(files) + ownerBytes + size; needed > limit {
+		return UploadLimitError(fmt.Sprintf("That would take the storage used by '%s' to %d MB, over the limit "+
+			"of %d MB", dbOwner, needed/1024/1024, limit/1024/1024))
+	}
+	return nil
+}
+
+// Returns the staged database for an upload which has received all of its data.  The caller should close the staged
+// file when done with it.
+func FinishUpload(userName string, uploadID string) (upload UploadSession, staged *StagedDatabase, err error) {
+	upload, err = GetUploadSession(userName, uploadID)
+	if err != nil {
+		return
+	}
+	if upload.Received != upload.Size {
+		err = ErrUploadIncomplete
+		return
+	}
+	s := sha256.New()
+	err = s.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState)
+	if err != nil {
+		log.Printf("Restoring the sha256 state for upload '%s' failed: %v\n", uploadID, err)
+		return
+	}
+	f, err := os.Open(uploadStagingFile(uploadID))
+	if err != nil {
+		log.Printf("Opening the staging file for upload '%s' failed: %v\n", uploadID, err)
+		return
+	}
+	staged = &StagedDatabase{File: f, Sha: hex.EncodeToString(s.Sum(nil)), Size: upload.Size}
+	return
+}
+
+// Returns the maximum database size (in bytes) a user is allowed to upload.  Zero means there's no limit.  A limit set
+// for the user on the admin server takes precedence over the ones in the configuration file.
+func MaxUploadSize(userName string) int64 {
+	limit := Conf.Upload.MaxDatabaseSize
+	for u, l := range Conf.Upload.UserLimits {
+		if strings.ToLower(u) == strings.ToLower(userName) {
+			limit = l
+			break
+		}
+	}
+	if q, err := UserQuotas(userName); err == nil && q.MaxUploadSize > 0 {
+		limit = q.MaxUploadSize
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return limit * 1024 * 1024
+}
+
+// Removes an upload session, and its staged data.
+func RemoveUpload(uploadID string) error {
+	err := os.Remove(uploadStagingFile(uploadID))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Removing the staging file for upload '%s' failed: %v\n", uploadID, err)
+		return err
+	}
+	return DeleteUploadSession(uploadID)
+}
+
+// Starts a new resumable upload of a database, with the given total size.  The database doesn't need to be in the
+// uploader's own namespace, though they need to be allowed to write to it both now and when the upload is finished.
+// The form values are those which would normally be sent along with the database in a single part upload, and are
+// used when the upload is finished.
+func StartUpload(userName string, dbOwner string, dbFolder string, dbName string, size int64,
+	formValues map[string][]string) (upload UploadSession, err error) {
+	// Create the directory for staging uploads in, if it's not already present
+	err = os.MkdirAll(filepath.Join(Conf.DiskCache.Directory, "uploads"), 0750)
+	if err != nil {
+		log.Printf("Creating the upload staging directory failed: %v\n", err)
+		return
+	}
+
+	// Store the initial state of the sha256, so it can be picked up again by each chunk
+	hashState, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	// Generate the upload ID from a secure random source, so it can't be guessed
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return
+	}
+	upload = UploadSession{
+		DBName:     dbName,
+		DBOwner:    dbOwner,
+		Folder:     dbFolder,
+		FormValues: formValues,
+		HashState:  hashState,
+		ID:         hex.EncodeToString(id),
+		Owner:      userName,
+		Size:       size,
+	}
+
+	// Create the (empty) staging file
+	f, err := os.OpenFile(uploadStagingFile(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		log.Printf("Creating the staging file for upload '%s' failed: %v\n", upload.ID, err)
+		return
+	}
+	f.Close()
+
+	// Record the upload session
+	err = StoreUploadSession(userName, upload)
+	if err != nil {
+		os.Remove(uploadStagingFile(upload.ID))
+		return UploadSession{}, err
+	}
+	upload.DateCreated = time.Now()
+	upload.LastModified = upload.DateCreated
+	return
+}
+
+// Returns the path of the staging file for an upload.
+func uploadStagingFile(uploadID string) string {
+	return filepath.Join(Conf.DiskCache.Directory, "uploads", uploadID)
+}
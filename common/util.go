@@ -3,6 +3,7 @@ package common
 
 import (
 	"bytes"
+	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -12,40 +13,61 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
 	"time"
 )
 
-// The main function which handles database upload processing for both the webUI and DB4S end points
+// Returned when the head of a branch has moved since the commit a new upload is based on was read, so storing the upload
+// would silently throw away the commits added in the meantime
+var ErrBranchMoved = errors.New("The branch has changed since the upload was started.  Update to the latest " +
+	"version of the database, then try again")
+
+// The main function which handles database upload processing for both the webUI and DB4S end points.  The commit ID
+// is the one the upload is based on, which needs to be the head of the branch unless force is set.  When force is set,
+// the commit history of the branch after the given commit is thrown away.
 func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder string, dbName string,
-	createBranch bool, branchName string, commitID string, public bool, licenceName string, commitMsg string,
+	createBranch bool, branchName string, commitID string, force bool, public bool, licenceName string, commitMsg string,
 	sourceURL string, newDB io.Reader, serverSw string, lastModified time.Time, commitTime time.Time,
 	authorName string, authorEmail string, committerName string, committerEmail string, otherParents []string,
 	dbSha string) (numBytes int64, newCommitID string, err error) {
 
-	// Create a temporary file to store the database in
-	tempDB, err := ioutil.TempFile(Conf.DiskCache.Directory, "dbhub-upload-")
-	if err != nil {
-		log.Printf("Error creating temporary file. User: '%s', Database: '%s%s%s', Filename: '%s', Error: %v\n",
-			loggedInUser, dbOwner, dbFolder, dbName, tempDB.Name(), err)
-		return 0, "", err
-	}
-	tempDBName := tempDB.Name()
+	var tempDB *os.File
+	var sha string
+	if staged, ok := newDB.(*StagedDatabase); ok {
+		// The database is already on disk with its sha256 known, so there's no need to copy and hash it again
+		tempDB = staged.File
+		sha = staged.Sha
+		numBytes = staged.Size
+	} else {
+		// Create a temporary file to store the database in
+		tempDB, err = ioutil.TempFile(Conf.DiskCache.Directory, "dbhub-upload-")
+		if err != nil {
+			log.Printf("Error creating temporary file. User: '%s', Database: '%s%s%s', Error: %v\n",
+				loggedInUser, dbOwner, dbFolder, dbName, err)
+			return 0, "", err
+		}
 
-	// Delete the temporary file when this function finishes
-	defer os.Remove(tempDBName)
+		// Delete the temporary file when this function finishes
+		defer os.Remove(tempDB.Name())
+		defer tempDB.Close()
 
-	// Write the database to the temporary file, so we can try opening it with SQLite to verify it's ok
-	bufSize := 16 << 20 // 16MB
-	buf := make([]byte, bufSize)
-	numBytes, err = io.CopyBuffer(tempDB, newDB, buf)
-	if err != nil {
-		log.Printf("Error when writing the uploaded db to a temp file. User: '%s', Database: '%s%s%s' "+
-			"Error: %v\n", loggedInUser, dbOwner, dbFolder, dbName, err)
-		return 0, "", err
+		// Write the database to the temporary file, so we can try opening it with SQLite to verify it's ok.  The
+		// sha256 of the file is generated at the same time
+		bufSize := 16 << 20 // 16MB
+		buf := make([]byte, bufSize)
+		s := sha256.New()
+		numBytes, err = io.CopyBuffer(io.MultiWriter(tempDB, s), newDB, buf)
+		if err != nil {
+			log.Printf("Error when writing the uploaded db to a temp file. User: '%s', Database: '%s%s%s' "+
+				"Error: %v\n", loggedInUser, dbOwner, dbFolder, dbName, err)
+			return 0, "", err
+		}
+		sha = hex.EncodeToString(s.Sum(nil))
 	}
+	tempDBName := tempDB.Name()
 
 	// Sanity check the uploaded database, and get the list of tables in the database
 	sTbls, err := SanityCheck(tempDBName)
@@ -63,45 +85,70 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 		return 0, "", errors.New("Seeking to the start of the temporary file failed")
 	}
 
-	// Generate sha256 of the uploaded file
-	// TODO: Using an io.MultiWriter to feed data from newDB into both the temp file and this sha256 function at the
-	// TODO  same time might be a better approach here
-	s := sha256.New()
-	_, err = io.CopyBuffer(s, tempDB, buf)
-	if err != nil {
-		return 0, "", err
-	}
-	sha := hex.EncodeToString(s.Sum(nil))
-
 	// If we were given a SHA256 for the file, make sure it matches our calculated one
 	if dbSha != "" && dbSha != sha {
 		return 0, "",
 			fmt.Errorf("SHA256 given (%s) for uploaded file doesn't match the calculated value (%s)", dbSha, sha)
 	}
 
+	// Make sure the user is allowed to write to the database
+	allowed, err := CheckWriteAccess(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		return 0, "", err
+	}
+	if !allowed {
+		return 0, "", fmt.Errorf("You don't have write access to '%s%s%s'", dbOwner, dbFolder, dbName)
+	}
+
 	// Check if the database already exists in the system
 	var defBranch string
 	needDefaultBranchCreated := false
 	var branches map[string]BranchEntry
-	exists, err := CheckDBExists(loggedInUser, loggedInUser, dbFolder, dbName)
-	if err != err {
+	exists, err := CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		return 0, "", err
+	}
+
+	// Make sure the new database (or database file) fits within the limits of the owner
+	if !exists {
+		err = CheckDatabaseQuota(dbOwner)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+	err = CheckStorageQuota(dbOwner, map[string]int64{sha: numBytes})
+	if err != nil {
 		return 0, "", err
 	}
+
 	if exists {
 		// Load the existing branchHeads for the database
-		branches, err = GetBranches(loggedInUser, dbFolder, dbName)
+		branches, err = GetBranches(dbOwner, dbFolder, dbName)
 		if err != nil {
 			return 0, "", err
 		}
 
 		// If no branch name was given, use the default for the database
-		defBranch, err = GetDefaultBranchName(loggedInUser, dbFolder, dbName)
+		defBranch, err = GetDefaultBranchName(dbOwner, dbFolder, dbName)
 		if err != nil {
 			return 0, "", err
 		}
 		if branchName == "" {
 			branchName = defBranch
 		}
+
+		// If the branch is protected such that changes need to arrive through a merge request, then don't allow
+		// new commits to be added to it directly
+		if _, ok := branches[branchName]; ok {
+			protection, err := GetBranchProtection(dbOwner, dbFolder, dbName)
+			if err != nil {
+				return 0, "", err
+			}
+			if protection[branchName].RequireMR {
+				return 0, "", fmt.Errorf("Branch '%s' is protected.  Changes to it need to be made through a "+
+					"merge request", branchName)
+			}
+		}
 	} else {
 		// No existing branches, so this will be the first
 		branches = make(map[string]BranchEntry)
@@ -124,7 +171,7 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 		// No licence was specified by the client, so check if the database is already in the system and
 		// already has one.  If so, we use that.
 		if exists {
-			lic, err := CommitLicenceSHA(loggedInUser, dbFolder, dbName, commitID)
+			lic, err := CommitLicenceSHA(dbOwner, dbFolder, dbName, commitID)
 			if err != nil {
 				return 0, "", err
 			}
@@ -158,13 +205,13 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 				commitMsg = fmt.Sprintf("Initial database upload, using licence %s.", licenceName)
 			} else {
 				// The database already exists, so check if the licence has changed
-				lic, err := CommitLicenceSHA(loggedInUser, dbFolder, dbName, commitID)
+				lic, err := CommitLicenceSHA(dbOwner, dbFolder, dbName, commitID)
 				if err != nil {
 					return 0, "", err
 				}
 				if e.LicenceSHA != lic {
 					// The licence has changed, so we create a reasonable commit message indicating this
-					l, _, err := GetLicenceInfoFromSha256(loggedInUser, lic)
+					l, _, err := GetLicenceInfoFromSha256(dbOwner, lic)
 					if err != nil {
 						return 0, "", err
 					}
@@ -219,14 +266,32 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 		c.OtherParents = otherParents
 	}
 
-	// If the database already exists, determine the commit ID to use as the parent
+	// If the database already exists, determine the commit ID to use as the parent.  The existing head of the branch
+	// is kept for the audit log, as force pushes replace it with an earlier commit
+	var oldHead string
 	if exists {
 		b, ok := branches[branchName]
 		if ok {
+			oldHead = b.Commit
 			// We're adding to a known branch.  If a commit was specifically provided, use that as the parent commit,
 			// otherwise use the head commit of the branch
 			if commitID != "" {
 				if b.Commit != commitID {
+					// Unless we've been told to rewrite the commit history, someone else has added to the branch
+					// since the upload was started
+					if !force {
+						return 0, "", ErrBranchMoved
+					}
+
+					// Force pushes throw away history, so they're not allowed for protected branches
+					protection, err := GetBranchProtection(dbOwner, dbFolder, dbName)
+					if err != nil {
+						return 0, "", err
+					}
+					if protection[branchName].NoForcePush {
+						return 0, "", fmt.Errorf("Branch '%s' is protected against force pushes", branchName)
+					}
+
 					// We're rewriting commit history
 					iTags, iRels, err := DeleteBranchHistory(dbOwner, dbFolder, dbName, branchName, commitID)
 					if err != nil {
@@ -281,7 +346,7 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 	// If the database already exists, count the number of commits in the new branch
 	commitCount := 1
 	if exists {
-		commitList, err := GetCommitList(loggedInUser, dbFolder, dbName)
+		commitList, err := GetCommitList(dbOwner, dbFolder, dbName)
 		if err != nil {
 			return 0, "", err
 		}
@@ -293,7 +358,7 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 			c2, ok = commitList[c2.Parent]
 			if !ok {
 				m := fmt.Sprintf("Error when counting commits in branch '%s' of database '%s%s%s'\n", branchName,
-					loggedInUser, dbFolder, dbName)
+					dbOwner, dbFolder, dbName)
 				log.Print(m)
 				return 0, "", errors.New(m)
 			}
@@ -310,20 +375,26 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 		return 0, "", errors.New("Seeking to start of temporary database file didn't work")
 	}
 
-	// Update the branch with the commit for this new database upload & the updated commit count for the branch
-	b := branches[branchName]
+	// Update the branch with the commit for this new database upload & the updated commit count for the branch.  The
+	// branch head is only moved if it's still at the parent of the new commit, and new branches are only created if
+	// they still don't exist
+	b, ok := branches[branchName]
+	var prevHead string
+	if ok {
+		prevHead = c.Parent
+	}
 	b.Commit = c.ID
 	b.CommitCount = commitCount
 	branches[branchName] = b
-	err = StoreDatabase(loggedInUser, dbFolder, dbName, branches, c, public, tempDB, sha, numBytes, "",
-		"", needDefaultBranchCreated, branchName, sourceURL)
+	err = StoreDatabase(dbOwner, dbFolder, dbName, branches, c, public, tempDB, sha, numBytes, "",
+		"", needDefaultBranchCreated, branchName, prevHead, sourceURL)
 	if err != nil {
 		return 0, "", err
 	}
 
 	// If the database already existed, update it's contributor count
 	if exists {
-		err = UpdateContributorsCount(loggedInUser, dbFolder, dbName)
+		err = UpdateContributorsCount(dbOwner, dbFolder, dbName)
 		if err != nil {
 			return 0, "", err
 		}
@@ -376,13 +447,77 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 	}
 
 	// Make a record of the upload
-	err = LogUpload(loggedInUser, dbFolder, dbName, loggedInUser, r.RemoteAddr, serverSw, userAgent, time.Now().UTC(), sha)
+	err = LogUpload(dbOwner, dbFolder, dbName, loggedInUser, r.RemoteAddr, serverSw, userAgent, time.Now().UTC(), sha)
 	if err != nil {
 		return 0, "", err
 	}
 
+	// Record the new commit (or database) in the audit log
+	var action AuditAction = AUDIT_COMMIT_CREATE
+	var before interface{}
+	if exists {
+		beforeCommit := oldHead
+		if beforeCommit == "" {
+			// New branches start from the commit they were created from
+			beforeCommit = c.Parent
+		}
+		before = map[string]string{"branch": branchName, "commit": beforeCommit}
+	} else {
+		action = AUDIT_DATABASE_CREATE
+	}
+	Audit(r, serverSw, loggedInUser, dbOwner, dbFolder, dbName, action, before, map[string]interface{}{
+		"branch": branchName, "commit": c.ID, "sha256": sha, "size": numBytes})
+
+	// Generate events about the new commit, and the new branch if one was created.  Brand new databases don't have
+	// anyone watching them yet, so they're skipped.  This covers uploads from all of our servers, including pushes
+	// from DB4S
+	if exists && createBranch {
+		details := EventDetails{
+			DBName:   dbName,
+			Folder:   dbFolder,
+			Owner:    dbOwner,
+			Title:    branchName,
+			Type:     EVENT_NEW_BRANCH,
+			URL:      fmt.Sprintf("/branches/%s%s%s", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName)),
+			UserName: loggedInUser,
+		}
+		err = NewEvent(details)
+		if err != nil {
+			log.Printf("Error when creating a new event: %s\n", err.Error())
+		}
+	}
+	if exists {
+		title := strings.SplitN(c.Message, "\n", 2)[0]
+		if title == "" {
+			title = fmt.Sprintf("New commit on branch '%s'", branchName)
+		}
+		details := EventDetails{
+			DBName:  dbName,
+			Folder:  dbFolder,
+			Message: c.Message,
+			Owner:   dbOwner,
+			Title:   title,
+			Type:    EVENT_NEW_COMMIT,
+			URL: fmt.Sprintf("/commits/%s%s%s?branch=%s", url.PathEscape(dbOwner), dbFolder,
+				url.PathEscape(dbName), url.QueryEscape(branchName)),
+			UserName: loggedInUser,
+		}
+		err = NewEvent(details)
+		if err != nil {
+			log.Printf("Error when creating a new event: %s\n", err.Error())
+		}
+	}
+
+	// Close any discussions the commit message says it fixes, when the commit lands on the default branch
+	if exists && branchName == defBranch {
+		err = CloseDiscussionsFromCommits(r, serverSw, loggedInUser, dbOwner, dbFolder, dbName, []CommitEntry{c})
+		if err != nil {
+			log.Printf("Error when closing discussions referenced by commit '%s': %s\n", c.ID, err.Error())
+		}
+	}
+
 	// Invalidate the memcached entry for the database (only really useful if we're updating an existing database)
-	err = InvalidateCacheEntry(loggedInUser, loggedInUser, "/", dbName, "") // Empty string indicates "for all versions"
+	err = InvalidateCacheEntry(loggedInUser, dbOwner, "/", dbName, "") // Empty string indicates "for all versions"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for the database
 		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
@@ -390,7 +525,7 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 	}
 
 	// Invalidate any memcached entries for the previous highest version # of the database
-	err = InvalidateCacheEntry(loggedInUser, loggedInUser, dbFolder, dbName, c.ID) // And empty string indicates "for all commits"
+	err = InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, c.ID) // And empty string indicates "for all commits"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for any previous database
 		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
@@ -401,6 +536,31 @@ func AddDatabase(r *http.Request, loggedInUser string, dbOwner string, dbFolder
 	return numBytes, c.ID, nil
 }
 
+// Checks if a user is allowed to write to a database location.  For an existing database this needs write access to
+// it.  New databases can be created by the owner themselves, or by members of an owning organisation with write
+// access through one of their teams.  If an error occurred, the true/false value should be ignored.
+func CheckWriteAccess(loggedInUser string, dbOwner string, dbFolder string, dbName string) (bool, error) {
+	if loggedInUser == "" {
+		return false, nil
+	}
+	if strings.ToLower(loggedInUser) == strings.ToLower(dbOwner) {
+		return true, nil
+	}
+	allowed, err := CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, PERM_WRITE)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	exists, err := CheckDBExists(dbOwner, dbOwner, dbFolder, dbName)
+	if err != nil || exists {
+		return false, err
+	}
+	orgPerm, err := OrgPermission(loggedInUser, dbOwner)
+	if err != nil {
+		return false, err
+	}
+	return orgPerm >= PERM_WRITE, nil
+}
+
 // Returns the licence used by the database in a given commit
 func CommitLicenceSHA(dbOwner string, dbFolder string, dbName string, commitID string) (licenceSHA string, err error) {
 	commits, err := GetCommitList(dbOwner, dbFolder, dbName)
@@ -825,6 +985,12 @@ func GetCurrentFunctionName() (FuncName string) {
 	return
 }
 
+// Returns the hash of an API access token, as stored in the database
+func HashAPIToken(token string) string {
+	s := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(s[:])
+}
+
 // Checks if a given commit ID is in the history of the given branch
 func IsCommitInBranchHistory(dbOwner string, dbFolder string, dbName string, branchName string, commitID string) (bool, error) {
 	// Get the commit list for the database
@@ -872,6 +1038,46 @@ func IsCommitInBranchHistory(dbOwner string, dbFolder string, dbName string, bra
 	return found, nil
 }
 
+// Returns the given URL adjusted to point at the new location of a database which has been transferred.  Both the
+// "owner/database" path components and the "username" / "dbname" form fields are updated, so it works for the webUI
+// pages and the DB4S end points.
+func MovedDatabaseURL(u *url.URL, dbOwner string, dbName string, newOwner string, newName string) string {
+	newURL := *u
+
+	// Update the path components
+	pathStrings := strings.Split(newURL.Path, "/")
+	for i := 0; i+1 < len(pathStrings); i++ {
+		if strings.ToLower(pathStrings[i]) == strings.ToLower(dbOwner) && pathStrings[i+1] == dbName {
+			pathStrings[i] = newOwner
+			pathStrings[i+1] = newName
+			break
+		}
+	}
+	newURL.Path = strings.Join(pathStrings, "/")
+	newURL.RawPath = ""
+
+	// Update the form fields
+	q := newURL.Query()
+	if strings.ToLower(q.Get("username")) == strings.ToLower(dbOwner) && q.Get("dbname") == dbName {
+		q.Set("username", newOwner)
+		q.Set("dbname", newName)
+		newURL.RawQuery = q.Encode()
+	}
+	return newURL.String()
+}
+
+// Generates a new API access token.  Returns both the token (only ever shown to the user once) and its hash
+func NewAPIToken() (token string, tokenHash string, err error) {
+	b := make([]byte, 20)
+	_, err = crand.Read(b)
+	if err != nil {
+		return
+	}
+	token = "dbh_" + hex.EncodeToString(b)
+	tokenHash = HashAPIToken(token)
+	return
+}
+
 // Look for the next child fork in a fork tree
 func nextChild(loggedInUser string, rawListPtr *[]ForkEntry, outputListPtr *[]ForkEntry, forkTrailPtr *[]int, iconDepth int) ([]ForkEntry, []int, bool) {
 	// TODO: This approach feels half arsed.  Maybe redo it as a recursive function instead?
@@ -974,6 +1180,7 @@ func StatusUpdateCheck(dbOwner string, dbFolder string, dbName string, thisID in
 					log.Printf("Error when updating user status updates # in memcached: %v", err)
 					return
 				}
+				PublishLiveUpdate(LiveUpdate{Count: numStatusUpdates, Type: LIVE_STATUS_UPDATES, User: userName})
 				return
 			}
 		}
@@ -195,6 +195,26 @@ func ValidateFolder(folder string) error {
 	return nil
 }
 
+// Validate the provided label colour.  This is a HTML style hex colour, eg "#d73a4a".
+func ValidateLabelColour(colour string) error {
+	err := Validate.Var(colour, "required,hexcolor")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided label name.  These follow the same rules as discussion titles.
+func ValidateLabelName(name string) error {
+	err := Validate.Var(name, "required,discussiontitle,max=50") // 50 is plenty for a label
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Validate the provided licence name (ID).
 func ValidateLicence(licence string) error {
 	err := Validate.Var(licence, "licence,min=1,max=13") // 13 is the length of our longest licence name (thus far)
@@ -250,6 +270,37 @@ func ValidateDiscussionTitle(fieldName string) error {
 	return nil
 }
 
+// Validate the provided status check name.  These follow the same rules as SQLite field names, so things like
+// "ci/tests" work.
+func ValidateStatusContext(context string) error {
+	err := Validate.Var(context, "required,fieldname,min=1,max=100")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided organisation team name.  These follow the same rules as user names.
+func ValidateTeamName(teamName string) error {
+	err := Validate.Var(teamName, "required,username,min=1,max=63")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided upload ID.
+func ValidateUploadID(uploadID string) error {
+	err := Validate.Var(uploadID, "alphanum,len=32") // Always 32 alphanumeric characters
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Validate the provided username.
 func ValidateUser(user string) error {
 	err := Validate.Var(user, "required,username,min=2,max=63")
@@ -0,0 +1,200 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The names used for each event type in webhook payloads and the X-DBHub-Event header
+var EventNames = map[EventType]string{
+	EVENT_NEW_DISCUSSION:       "discussion.new",
+	EVENT_NEW_MERGE_REQUEST:    "merge_request.new",
+	EVENT_NEW_COMMENT:          "comment.new",
+	EVENT_NEW_RELEASE:          "release.new",
+	EVENT_NEW_COMMIT:           "commit.new",
+	EVENT_NEW_TAG:              "tag.new",
+	EVENT_MERGE_REQUEST_MERGED: "merge_request.merged",
+	EVENT_NEW_BRANCH:           "branch.new",
+	EVENT_BRANCH_DELETED:       "branch.deleted",
+	EVENT_NEW_FORK:             "fork.new",
+	EVENT_NEW_STAR:             "star.new",
+	EVENT_MERGE_REQUEST_CLOSED: "merge_request.closed",
+	EVENT_DATABASE_PUBLIC:      "database.public",
+	EVENT_MENTION:              "mention.new",
+}
+
+// The most webhook deliveries claimed by a server at once
+const webhookBatchSize = 20
+
+// A webhook delivery waiting to be sent
+type webhookJob struct {
+	Attempts  int
+	EventType EventType
+	ID        int64
+	Payload   json.RawMessage
+	Secret    string
+	URL       string
+	WebhookID int64
+}
+
+// Checks a webhook URL uses http or https, and that its host only resolves to public addresses.
+func CheckWebhookURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("Webhook URLs need to be http or https")
+	}
+	_, err := webhookAddresses(context.Background(), u.Hostname())
+	return err
+}
+
+// Generates a new secret for signing webhook payloads.
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := crand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Periodically sends queued webhook deliveries.  Failed deliveries are retried with exponential backoff, until the
+// maximum number of attempts is reached.
+func WebhookDeliveryLoop() {
+	log.Printf("Webhook delivery loop started.  %d second refresh.", Conf.Event.Delay)
+	client := webhookClient()
+
+	// Claimed deliveries are held for long enough to send the whole batch, even if every receiver times out
+	lease := webhookBatchSize*Conf.Event.WebhookTimeout*time.Second + time.Minute
+	for {
+		jobs, err := claimWebhookDeliveries(lease)
+		if err == nil {
+			for _, j := range jobs {
+				deliverWebhook(client, j)
+			}
+		}
+		time.Sleep(Conf.Event.Delay * time.Second)
+	}
+}
+
+// Returns the signature of a webhook payload, as sent in the X-DBHub-Signature-256 header.  Receivers can verify it
+// by calculating the HMAC-SHA256 of the request body with their copy of the secret.
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Makes one delivery attempt for a webhook, and records the result.
+func deliverWebhook(client *http.Client, j webhookJob) {
+	attempts := j.Attempts + 1
+	var statusCode int
+	var errMsg string
+	req, err := http.NewRequest("POST", j.URL, bytes.NewReader(j.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "DBHub.io-Webhook")
+		req.Header.Set("X-DBHub-Delivery", strconv.FormatInt(j.ID, 10))
+		req.Header.Set("X-DBHub-Event", EventNames[j.EventType])
+		req.Header.Set("X-DBHub-Signature-256", WebhookSignature(j.Secret, j.Payload))
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err == nil {
+			// The response body isn't kept, so webhooks can't be used to read pages from other servers
+			statusCode = resp.StatusCode
+			resp.Body.Close()
+			if statusCode < 200 || statusCode > 299 {
+				err = fmt.Errorf("Receiver returned status code %d", statusCode)
+			}
+		}
+	}
+
+	// Work out what happens next
+	var state WebhookDeliveryState = WEBHOOK_DELIVERED
+	nextAttempt := time.Now()
+	if err != nil {
+		errMsg = err.Error()
+		if attempts >= Conf.Event.WebhookMaxAttempts {
+			state = WEBHOOK_FAILED
+			log.Printf("Giving up on webhook delivery '%d' to '%s' after %d attempts: %s\n", j.ID, j.URL, attempts,
+				errMsg)
+		} else {
+			// Wait 1, 2, 4, 8, ... minutes between attempts
+			state = WEBHOOK_PENDING
+			nextAttempt = nextAttempt.Add(time.Duration(1<<uint(attempts-1)) * time.Minute)
+		}
+	}
+	storeWebhookAttempt(j.ID, state, attempts, nextAttempt, statusCode, errMsg)
+}
+
+// Resolves the host of a webhook URL, returning an error unless all of its addresses are public ones.  This stops
+// webhooks being used to reach services on our own network, such as the database server or cloud metadata endpoints.
+func webhookAddresses(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't look up webhook host '%s'", host)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("Webhook host '%s' has no addresses", host)
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		ip := a.IP
+		if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+			ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+			return nil, fmt.Errorf("Webhooks can't be sent to the non-public address of '%s'", host)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// Returns the HTTP client used for sending webhooks.  The host is resolved and checked each time a connection is
+// made, with the connection going to the checked address, so DNS changes after the check don't get around it.
+// Redirects are checked the same way.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: Conf.Event.WebhookTimeout * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			ips, err := webhookAddresses(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			var conn net.Conn
+			for _, ip := range ips {
+				conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+				if err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: Conf.Event.WebhookTimeout * time.Second,
+	}
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("Stopped after 10 redirects")
+			}
+			return CheckWebhookURL(req.URL)
+		},
+		Timeout:   Conf.Event.WebhookTimeout * time.Second,
+		Transport: transport,
+	}
+}
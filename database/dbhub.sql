@@ -30,10 +30,123 @@ CREATE EXTENSION IF NOT EXISTS plpgsql WITH SCHEMA pg_catalog;
 COMMENT ON EXTENSION plpgsql IS 'PL/pgSQL procedural language';
 
 
+--
+-- Name: audit_log_append_only(); Type: FUNCTION; Schema: public; Owner: -
+--
+
+CREATE FUNCTION public.audit_log_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION 'The audit log is append only';
+END;
+$$;
+
+
 SET default_tablespace = '';
 
 SET default_with_oids = false;
 
+--
+-- Name: api_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.api_tokens (
+    token_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    name text NOT NULL,
+    token_hash text NOT NULL,
+    scopes jsonb NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    last_used timestamp with time zone
+);
+
+
+--
+-- Name: api_tokens_token_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.api_tokens_token_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: api_tokens_token_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.api_tokens_token_id_seq OWNED BY public.api_tokens.token_id;
+
+
+--
+-- Name: audit_log; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.audit_log (
+    audit_id bigint NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    actor text,
+    ip_addr text,
+    server_sw text NOT NULL,
+    db_id bigint,
+    owner text,
+    folder text,
+    db_name text,
+    action text NOT NULL,
+    details jsonb
+);
+
+
+--
+-- Name: audit_log_audit_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.audit_log_audit_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: audit_log_audit_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.audit_log_audit_id_seq OWNED BY public.audit_log.audit_id;
+
+
+--
+-- Name: commit_statuses; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.commit_statuses (
+    db_id bigint NOT NULL,
+    commit_id text NOT NULL,
+    context text NOT NULL,
+    state text NOT NULL,
+    description text,
+    target_url text,
+    user_id bigint,
+    date_updated timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: database_collaborators; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.database_collaborators (
+    db_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    role text NOT NULL,
+    date_added timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
 --
 -- Name: database_downloads; Type: TABLE; Schema: public; Owner: -
 --
@@ -117,6 +230,19 @@ CREATE SEQUENCE public.database_licences_lic_id_seq
 ALTER SEQUENCE public.database_licences_lic_id_seq OWNED BY public.database_licences.lic_id;
 
 
+--
+-- Name: database_redirects; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.database_redirects (
+    user_id bigint NOT NULL,
+    folder text NOT NULL,
+    db_name text NOT NULL,
+    db_id bigint NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
 --
 -- Name: database_stars; Type: TABLE; Schema: public; Owner: -
 --
@@ -128,6 +254,30 @@ CREATE TABLE public.database_stars (
 );
 
 
+--
+-- Name: database_transfers; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.database_transfers (
+    db_id bigint NOT NULL,
+    from_user bigint NOT NULL,
+    to_user bigint NOT NULL,
+    date_offered timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: database_trash; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.database_trash (
+    db_id bigint NOT NULL,
+    db_name text NOT NULL,
+    public boolean NOT NULL,
+    date_deleted timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
 --
 -- Name: database_uploads; Type: TABLE; Schema: public; Owner: -
 --
@@ -163,6 +313,69 @@ CREATE SEQUENCE public.database_uploads_up_id_seq
 ALTER SEQUENCE public.database_uploads_up_id_seq OWNED BY public.database_uploads.up_id;
 
 
+--
+-- Name: digest_queue; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.digest_queue (
+    digest_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    frequency text NOT NULL,
+    summary text NOT NULL,
+    title text,
+    url text NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: digest_queue_digest_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.digest_queue_digest_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: digest_queue_digest_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.digest_queue_digest_id_seq OWNED BY public.digest_queue.digest_id;
+
+
+--
+-- Name: discussion_assignees; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.discussion_assignees (
+    db_id bigint NOT NULL,
+    disc_id integer NOT NULL,
+    user_id bigint NOT NULL,
+    date_assigned timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: discussion_comment_anchors; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.discussion_comment_anchors (
+    com_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    commit_id text NOT NULL,
+    original_commit_id text NOT NULL,
+    table_name text NOT NULL,
+    primary_key jsonb NOT NULL,
+    column_name text,
+    outdated boolean DEFAULT false NOT NULL
+);
+
+
 --
 -- Name: discussion_comments; Type: TABLE; Schema: public; Owner: -
 --
@@ -197,6 +410,74 @@ CREATE SEQUENCE public.discussion_comments_com_id_seq
 ALTER SEQUENCE public.discussion_comments_com_id_seq OWNED BY public.discussion_comments.com_id;
 
 
+--
+-- Name: discussion_label_map; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.discussion_label_map (
+    db_id bigint NOT NULL,
+    disc_id integer NOT NULL,
+    label_id bigint NOT NULL
+);
+
+
+--
+-- Name: discussion_labels; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.discussion_labels (
+    label_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    name text NOT NULL,
+    colour text NOT NULL,
+    description text,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: discussion_labels_label_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.discussion_labels_label_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: discussion_labels_label_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.discussion_labels_label_id_seq OWNED BY public.discussion_labels.label_id;
+
+
+--
+-- Name: discussion_milestones; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.discussion_milestones (
+    db_id bigint NOT NULL,
+    disc_id integer NOT NULL,
+    milestone_id bigint NOT NULL
+);
+
+
+--
+-- Name: discussion_subscriptions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.discussion_subscriptions (
+    db_id bigint NOT NULL,
+    disc_id integer NOT NULL,
+    user_id bigint NOT NULL,
+    subscribed boolean DEFAULT true NOT NULL,
+    date_subscribed timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
 --
 -- Name: discussions; Type: TABLE; Schema: public; Owner: -
 --
@@ -258,7 +539,12 @@ CREATE TABLE public.email_queue (
     body text NOT NULL,
     sent boolean DEFAULT false NOT NULL,
     sent_timestamp timestamp with time zone,
-    subject text NOT NULL
+    subject text NOT NULL,
+    unsubscribe_url text,
+    html_body text,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_attempt timestamp with time zone DEFAULT now() NOT NULL,
+    last_error text
 );
 
 
@@ -313,6 +599,121 @@ CREATE SEQUENCE public.events_event_id_seq
 ALTER SEQUENCE public.events_event_id_seq OWNED BY public.events.event_id;
 
 
+--
+-- Name: merge_request_reviews; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.merge_request_reviews (
+    db_id bigint NOT NULL,
+    disc_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    state text NOT NULL,
+    commit_id text NOT NULL,
+    date_reviewed timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: milestones; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.milestones (
+    milestone_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    title text NOT NULL,
+    description text,
+    due_date timestamp with time zone,
+    tag_name text,
+    release_name text,
+    open boolean DEFAULT true NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: milestones_milestone_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.milestones_milestone_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: milestones_milestone_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.milestones_milestone_id_seq OWNED BY public.milestones.milestone_id;
+
+
+--
+-- Name: org_members; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.org_members (
+    org_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    role text NOT NULL,
+    date_joined timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: org_team_databases; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.org_team_databases (
+    team_id bigint NOT NULL,
+    db_id bigint NOT NULL
+);
+
+
+--
+-- Name: org_team_members; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.org_team_members (
+    team_id bigint NOT NULL,
+    user_id bigint NOT NULL
+);
+
+
+--
+-- Name: org_teams; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.org_teams (
+    team_id bigint NOT NULL,
+    org_id bigint NOT NULL,
+    team_name text NOT NULL,
+    description text,
+    role text NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: org_teams_team_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.org_teams_team_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: org_teams_team_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.org_teams_team_id_seq OWNED BY public.org_teams.team_id;
+
+
 --
 -- Name: sqlite_databases; Type: TABLE; Schema: public; Owner: -
 --
@@ -347,7 +748,10 @@ CREATE TABLE public.sqlite_databases (
     release_list jsonb,
     release_count integer DEFAULT 0 NOT NULL,
     download_count bigint DEFAULT 0,
-    page_views bigint DEFAULT 0
+    page_views bigint DEFAULT 0,
+    branch_protection jsonb,
+    merge_policy jsonb,
+    sql_assertions jsonb
 );
 
 
@@ -371,10 +775,29 @@ ALTER SEQUENCE public.sqlite_databases_db_id_seq OWNED BY public.sqlite_database
 
 
 --
--- Name: users; Type: TABLE; Schema: public; Owner: -
+-- Name: upload_sessions; Type: TABLE; Schema: public; Owner: -
 --
 
-CREATE TABLE public.users (
+CREATE TABLE public.upload_sessions (
+    upload_id text NOT NULL,
+    user_id bigint NOT NULL,
+    db_owner_id bigint NOT NULL,
+    folder text DEFAULT '/'::text NOT NULL,
+    db_name text NOT NULL,
+    total_size bigint NOT NULL,
+    received_size bigint DEFAULT 0 NOT NULL,
+    hash_state bytea NOT NULL,
+    form_values jsonb,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    last_modified timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.users (
     user_id bigint NOT NULL,
     user_name text NOT NULL,
     auth0_id text NOT NULL,
@@ -387,488 +810,1261 @@ CREATE TABLE public.users (
     default_licence integer,
     display_name text,
     avatar_url text,
-    status_updates jsonb
+    status_updates jsonb,
+    is_org boolean DEFAULT false NOT NULL,
+    disabled boolean DEFAULT false NOT NULL,
+    certs_valid_from timestamp with time zone,
+    max_upload_size bigint,
+    max_storage bigint,
+    max_databases integer,
+    notify_frequency text DEFAULT 'immediate'::text NOT NULL,
+    notify_events integer[] DEFAULT '{}'::integer[] NOT NULL
+);
+
+
+--
+-- Name: users_user_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.users_user_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: users_user_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.users_user_id_seq OWNED BY public.users.user_id;
+
+
+--
+-- Name: vis_params; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.vis_params (
+    db_id bigint,
+    user_id bigint,
+    name text NOT NULL,
+    date_created timestamp with time zone DEFAULT now(),
+    parameters jsonb
+);
+
+
+--
+-- Name: vis_result_cache; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.vis_result_cache (
+    db_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    commit_id text NOT NULL,
+    hash text NOT NULL,
+    results jsonb
+);
+
+
+--
+-- Name: watchers; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.watchers (
+    db_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    date_watched timestamp with time zone DEFAULT now() NOT NULL,
+    notify_frequency text,
+    notify_events integer[] DEFAULT '{}'::integer[] NOT NULL
+);
+
+
+--
+-- Name: webhook_deliveries; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.webhook_deliveries (
+    delivery_id bigint NOT NULL,
+    webhook_id bigint NOT NULL,
+    event_type integer NOT NULL,
+    payload jsonb NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    state text DEFAULT 'pending'::text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_attempt timestamp with time zone DEFAULT now() NOT NULL,
+    last_attempt timestamp with time zone,
+    status_code integer,
+    error text
+);
+
+
+--
+-- Name: webhook_deliveries_delivery_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.webhook_deliveries_delivery_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: webhook_deliveries_delivery_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.webhook_deliveries_delivery_id_seq OWNED BY public.webhook_deliveries.delivery_id;
+
+
+--
+-- Name: webhooks; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.webhooks (
+    webhook_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    creator bigint,
+    url text NOT NULL,
+    secret text NOT NULL,
+    event_types integer[],
+    date_created timestamp with time zone DEFAULT now() NOT NULL
 );
 
 
 --
--- Name: users_user_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+-- Name: webhooks_webhook_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.webhooks_webhook_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: webhooks_webhook_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.webhooks_webhook_id_seq OWNED BY public.webhooks.webhook_id;
+
+
+--
+-- Name: api_tokens token_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_tokens ALTER COLUMN token_id SET DEFAULT nextval('public.api_tokens_token_id_seq'::regclass);
+
+
+--
+-- Name: audit_log audit_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.audit_log ALTER COLUMN audit_id SET DEFAULT nextval('public.audit_log_audit_id_seq'::regclass);
+
+
+--
+-- Name: database_downloads dl_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_downloads ALTER COLUMN dl_id SET DEFAULT nextval('public.database_downloads_dl_id_seq'::regclass);
+
+
+--
+-- Name: database_licences lic_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_licences ALTER COLUMN lic_id SET DEFAULT nextval('public.database_licences_lic_id_seq'::regclass);
+
+
+--
+-- Name: database_uploads up_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_uploads ALTER COLUMN up_id SET DEFAULT nextval('public.database_uploads_up_id_seq'::regclass);
+
+
+--
+-- Name: digest_queue digest_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.digest_queue ALTER COLUMN digest_id SET DEFAULT nextval('public.digest_queue_digest_id_seq'::regclass);
+
+
+--
+-- Name: discussion_comments com_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_comments ALTER COLUMN com_id SET DEFAULT nextval('public.discussion_comments_com_id_seq'::regclass);
+
+
+--
+-- Name: discussion_labels label_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_labels ALTER COLUMN label_id SET DEFAULT nextval('public.discussion_labels_label_id_seq'::regclass);
+
+
+--
+-- Name: discussions internal_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussions ALTER COLUMN internal_id SET DEFAULT nextval('public.discussions_disc_id_seq'::regclass);
+
+
+--
+-- Name: email_queue email_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.email_queue ALTER COLUMN email_id SET DEFAULT nextval('public.email_queue_email_id_seq'::regclass);
+
+
+--
+-- Name: events event_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.events ALTER COLUMN event_id SET DEFAULT nextval('public.events_event_id_seq'::regclass);
+
+
+--
+-- Name: milestones milestone_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.milestones ALTER COLUMN milestone_id SET DEFAULT nextval('public.milestones_milestone_id_seq'::regclass);
+
+
+--
+-- Name: org_teams team_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.org_teams ALTER COLUMN team_id SET DEFAULT nextval('public.org_teams_team_id_seq'::regclass);
+
+
+--
+-- Name: sqlite_databases db_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sqlite_databases ALTER COLUMN db_id SET DEFAULT nextval('public.sqlite_databases_db_id_seq'::regclass);
+
+
+--
+-- Name: users user_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.users ALTER COLUMN user_id SET DEFAULT nextval('public.users_user_id_seq'::regclass);
+
+
+--
+-- Name: webhook_deliveries delivery_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhook_deliveries ALTER COLUMN delivery_id SET DEFAULT nextval('public.webhook_deliveries_delivery_id_seq'::regclass);
+
+
+--
+-- Name: webhooks webhook_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhooks ALTER COLUMN webhook_id SET DEFAULT nextval('public.webhooks_webhook_id_seq'::regclass);
+
+
+--
+-- Name: api_tokens api_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_pkey PRIMARY KEY (token_id);
+
+
+--
+-- Name: api_tokens api_tokens_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: audit_log audit_log_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_pkey PRIMARY KEY (audit_id);
+
+
+--
+-- Name: commit_statuses commit_statuses_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.commit_statuses
+    ADD CONSTRAINT commit_statuses_pkey PRIMARY KEY (db_id, commit_id, context);
+
+
+--
+-- Name: database_collaborators database_collaborators_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_collaborators
+    ADD CONSTRAINT database_collaborators_pkey PRIMARY KEY (db_id, user_id);
+
+
+--
+-- Name: database_downloads database_downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_downloads
+    ADD CONSTRAINT database_downloads_pkey PRIMARY KEY (dl_id);
+
+
+--
+-- Name: database_files database_files_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_files
+    ADD CONSTRAINT database_files_pkey PRIMARY KEY (db_sha256);
+
+
+--
+-- Name: database_licences database_licences_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_licences
+    ADD CONSTRAINT database_licences_pkey PRIMARY KEY (user_id, friendly_name);
+
+
+--
+-- Name: database_redirects database_redirects_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_redirects
+    ADD CONSTRAINT database_redirects_pkey PRIMARY KEY (user_id, folder, db_name);
+
+
+--
+-- Name: database_stars database_stars_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_stars
+    ADD CONSTRAINT database_stars_pkey PRIMARY KEY (db_id, user_id);
+
+
+--
+-- Name: database_transfers database_transfers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_transfers
+    ADD CONSTRAINT database_transfers_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: database_trash database_trash_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_trash
+    ADD CONSTRAINT database_trash_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: database_uploads database_uploads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.database_uploads
+    ADD CONSTRAINT database_uploads_pkey PRIMARY KEY (up_id);
+
+
+--
+-- Name: digest_queue digest_queue_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.digest_queue
+    ADD CONSTRAINT digest_queue_pkey PRIMARY KEY (digest_id);
+
+
+--
+-- Name: discussion_assignees discussion_assignees_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_assignees
+    ADD CONSTRAINT discussion_assignees_pkey PRIMARY KEY (db_id, disc_id, user_id);
+
+
+--
+-- Name: discussion_comment_anchors discussion_comment_anchors_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_comment_anchors
+    ADD CONSTRAINT discussion_comment_anchors_pkey PRIMARY KEY (com_id);
+
+
+--
+-- Name: discussion_comments discussion_comments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_comments
+    ADD CONSTRAINT discussion_comments_pkey PRIMARY KEY (com_id);
+
+
+--
+-- Name: discussion_label_map discussion_label_map_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_label_map
+    ADD CONSTRAINT discussion_label_map_pkey PRIMARY KEY (db_id, disc_id, label_id);
+
+
+--
+-- Name: discussion_labels discussion_labels_db_id_name_unique; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_labels
+    ADD CONSTRAINT discussion_labels_db_id_name_unique UNIQUE (db_id, name);
+
+
+--
+-- Name: discussion_labels discussion_labels_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_labels
+    ADD CONSTRAINT discussion_labels_pkey PRIMARY KEY (label_id);
+
+
+--
+-- Name: discussion_milestones discussion_milestones_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_milestones
+    ADD CONSTRAINT discussion_milestones_pkey PRIMARY KEY (db_id, disc_id);
+
+
+--
+-- Name: discussion_subscriptions discussion_subscriptions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussion_subscriptions
+    ADD CONSTRAINT discussion_subscriptions_pkey PRIMARY KEY (db_id, disc_id, user_id);
+
+
+--
+-- Name: discussions discussions_db_id_disc_id_unique; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussions
+    ADD CONSTRAINT discussions_db_id_disc_id_unique UNIQUE (db_id, disc_id);
+
+
+--
+-- Name: discussions discussions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.discussions
+    ADD CONSTRAINT discussions_pkey PRIMARY KEY (internal_id);
+
+
+--
+-- Name: email_queue email_queue_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.email_queue
+    ADD CONSTRAINT email_queue_pkey PRIMARY KEY (email_id);
+
+
+--
+-- Name: events events_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.events
+    ADD CONSTRAINT events_pkey PRIMARY KEY (event_id);
+
+
+--
+-- Name: merge_request_reviews merge_request_reviews_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_request_reviews
+    ADD CONSTRAINT merge_request_reviews_pkey PRIMARY KEY (db_id, disc_id, user_id);
+
+
+--
+-- Name: milestones milestones_db_id_title_unique; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.milestones
+    ADD CONSTRAINT milestones_db_id_title_unique UNIQUE (db_id, title);
+
+
+--
+-- Name: milestones milestones_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.milestones
+    ADD CONSTRAINT milestones_pkey PRIMARY KEY (milestone_id);
+
+
+--
+-- Name: org_members org_members_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.org_members
+    ADD CONSTRAINT org_members_pkey PRIMARY KEY (org_id, user_id);
+
+
+--
+-- Name: org_team_databases org_team_databases_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.org_team_databases
+    ADD CONSTRAINT org_team_databases_pkey PRIMARY KEY (team_id, db_id);
+
+
+--
+-- Name: org_team_members org_team_members_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.org_team_members
+    ADD CONSTRAINT org_team_members_pkey PRIMARY KEY (team_id, user_id);
+
+
+--
+-- Name: org_teams org_teams_org_id_team_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.org_teams
+    ADD CONSTRAINT org_teams_org_id_team_name_key UNIQUE (org_id, team_name);
+
+
+--
+-- Name: org_teams org_teams_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.org_teams
+    ADD CONSTRAINT org_teams_pkey PRIMARY KEY (team_id);
+
+
+--
+-- Name: sqlite_databases sqlite_databases_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sqlite_databases
+    ADD CONSTRAINT sqlite_databases_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: sqlite_databases sqlite_databases_user_id_folder_db_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sqlite_databases
+    ADD CONSTRAINT sqlite_databases_user_id_folder_db_name_key UNIQUE (user_id, folder, db_name);
+
+
+--
+-- Name: upload_sessions upload_sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.upload_sessions
+    ADD CONSTRAINT upload_sessions_pkey PRIMARY KEY (upload_id);
+
+
+--
+-- Name: users users_auth0_id_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_auth0_id_key UNIQUE (auth0_id);
+
+
+--
+-- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: users users_user_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_user_name_key UNIQUE (user_name);
+
+
+--
+-- Name: vis_params vis_params_pk; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.vis_params
+    ADD CONSTRAINT vis_params_pk UNIQUE (db_id, user_id, name);
+
+
+--
+-- Name: vis_result_cache vis_result_cache_pk; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.vis_result_cache
+    ADD CONSTRAINT vis_result_cache_pk PRIMARY KEY (db_id, user_id, commit_id, hash);
+
+
+--
+-- Name: watchers watchers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.watchers
+    ADD CONSTRAINT watchers_pkey PRIMARY KEY (db_id, user_id);
+
+
+--
+-- Name: webhook_deliveries webhook_deliveries_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (delivery_id);
+
+
+--
+-- Name: webhooks webhooks_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhooks
+    ADD CONSTRAINT webhooks_pkey PRIMARY KEY (webhook_id);
+
+
+--
+-- Name: audit_log_date_created_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX audit_log_date_created_idx ON public.audit_log USING btree (date_created);
+
+
+--
+-- Name: audit_log_db_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX audit_log_db_id_idx ON public.audit_log USING btree (db_id);
+
+
+--
+-- Name: database_licences_lic_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_licences_lic_id_idx ON public.database_licences USING btree (lic_id);
+
+
+--
+-- Name: database_licences_lic_sha256_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_licences_lic_sha256_idx ON public.database_licences USING btree (lic_sha256);
+
+
+--
+-- Name: database_licences_user_id_friendly_name_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_licences_user_id_friendly_name_idx ON public.database_licences USING btree (user_id, friendly_name);
+
+
+--
+-- Name: digest_queue_user_id_frequency_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX digest_queue_user_id_frequency_idx ON public.digest_queue USING btree (user_id, frequency);
+
+
+--
+-- Name: discussion_assignees_user_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussion_assignees_user_id_idx ON public.discussion_assignees USING btree (user_id);
+
+
+--
+-- Name: discussion_comment_anchors_db_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussion_comment_anchors_db_id_idx ON public.discussion_comment_anchors USING btree (db_id);
+
+
+--
+-- Name: discussion_label_map_label_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussion_label_map_label_id_idx ON public.discussion_label_map USING btree (label_id);
+
+
+--
+-- Name: discussion_milestones_milestone_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussion_milestones_milestone_id_idx ON public.discussion_milestones USING btree (milestone_id);
+
+
+--
+-- Name: discussion_subscriptions_user_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussion_subscriptions_user_id_idx ON public.discussion_subscriptions USING btree (user_id);
+
+
+--
+-- Name: discussions_discussion_type_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussions_discussion_type_idx ON public.discussions USING btree (discussion_type);
+
+
+--
+-- Name: email_queue_sent_next_attempt_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX email_queue_sent_next_attempt_idx ON public.email_queue USING btree (sent, next_attempt);
+
+
+--
+-- Name: events_event_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX events_event_id_idx ON public.events USING btree (event_id);
+
+
+--
+-- Name: fki_api_tokens_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_api_tokens_user_id_fkey ON public.api_tokens USING btree (user_id);
+
+
+--
+-- Name: fki_database_collaborators_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_collaborators_user_id_fkey ON public.database_collaborators USING btree (user_id);
+
+
+--
+-- Name: fki_database_downloads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_downloads_db_id_fkey ON public.database_downloads USING btree (db_id);
+
+
+--
+-- Name: fki_database_downloads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_downloads_user_id_fkey ON public.database_downloads USING btree (user_id);
+
+
+--
+-- Name: fki_database_redirects_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_redirects_db_id_fkey ON public.database_redirects USING btree (db_id);
+
+
+--
+-- Name: fki_database_transfers_to_user_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_transfers_to_user_fkey ON public.database_transfers USING btree (to_user);
+
+
+--
+-- Name: fki_database_uploads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_uploads_db_id_fkey ON public.database_uploads USING btree (db_id);
+
+
+--
+-- Name: fki_database_uploads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_uploads_user_id_fkey ON public.database_uploads USING btree (user_id);
+
+
+--
+-- Name: fki_discussion_comments_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_discussion_comments_db_id_fkey ON public.discussion_comments USING btree (db_id);
+
+
+--
+-- Name: fki_discussions_source_db_id_fkey; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE SEQUENCE public.users_user_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
+CREATE INDEX fki_discussions_source_db_id_fkey ON public.discussions USING btree (mr_source_db_id);
 
 
 --
--- Name: users_user_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+-- Name: fki_org_members_user_id_fkey; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER SEQUENCE public.users_user_id_seq OWNED BY public.users.user_id;
+CREATE INDEX fki_org_members_user_id_fkey ON public.org_members USING btree (user_id);
 
 
 --
--- Name: vis_params; Type: TABLE; Schema: public; Owner: -
+-- Name: fki_org_team_databases_db_id_fkey; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE TABLE public.vis_params (
-    db_id bigint,
-    user_id bigint,
-    name text NOT NULL,
-    date_created timestamp with time zone DEFAULT now(),
-    parameters jsonb
-);
+CREATE INDEX fki_org_team_databases_db_id_fkey ON public.org_team_databases USING btree (db_id);
 
 
 --
--- Name: vis_result_cache; Type: TABLE; Schema: public; Owner: -
+-- Name: fki_org_team_members_user_id_fkey; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE TABLE public.vis_result_cache (
-    db_id bigint NOT NULL,
-    user_id bigint NOT NULL,
-    commit_id text NOT NULL,
-    hash text NOT NULL,
-    results jsonb
-);
+CREATE INDEX fki_org_team_members_user_id_fkey ON public.org_team_members USING btree (user_id);
 
 
 --
--- Name: watchers; Type: TABLE; Schema: public; Owner: -
+-- Name: merge_request_reviews_user_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE TABLE public.watchers (
-    db_id bigint NOT NULL,
-    user_id bigint NOT NULL,
-    date_watched timestamp with time zone DEFAULT now() NOT NULL
-);
+CREATE INDEX merge_request_reviews_user_id_idx ON public.merge_request_reviews USING btree (user_id);
 
 
 --
--- Name: database_downloads dl_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_lower_user_name_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_downloads ALTER COLUMN dl_id SET DEFAULT nextval('public.database_downloads_dl_id_seq'::regclass);
+CREATE INDEX users_lower_user_name_idx ON public.users USING btree (lower(user_name));
 
 
 --
--- Name: database_licences lic_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_user_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_licences ALTER COLUMN lic_id SET DEFAULT nextval('public.database_licences_lic_id_seq'::regclass);
+CREATE INDEX users_user_id_idx ON public.users USING btree (user_id);
 
 
 --
--- Name: database_uploads up_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_user_name_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_uploads ALTER COLUMN up_id SET DEFAULT nextval('public.database_uploads_up_id_seq'::regclass);
+CREATE INDEX users_user_name_idx ON public.users USING btree (user_name);
 
 
 --
--- Name: discussion_comments com_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: watchers_db_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussion_comments ALTER COLUMN com_id SET DEFAULT nextval('public.discussion_comments_com_id_seq'::regclass);
+CREATE INDEX watchers_db_id_idx ON public.watchers USING btree (db_id);
 
 
 --
--- Name: discussions internal_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: webhook_deliveries_state_next_attempt_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussions ALTER COLUMN internal_id SET DEFAULT nextval('public.discussions_disc_id_seq'::regclass);
+CREATE INDEX webhook_deliveries_state_next_attempt_idx ON public.webhook_deliveries USING btree (state, next_attempt);
 
 
 --
--- Name: email_queue email_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: webhook_deliveries_webhook_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.email_queue ALTER COLUMN email_id SET DEFAULT nextval('public.email_queue_email_id_seq'::regclass);
+CREATE INDEX webhook_deliveries_webhook_id_idx ON public.webhook_deliveries USING btree (webhook_id);
 
 
 --
--- Name: events event_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: webhooks_db_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.events ALTER COLUMN event_id SET DEFAULT nextval('public.events_event_id_seq'::regclass);
+CREATE INDEX webhooks_db_id_idx ON public.webhooks USING btree (db_id);
 
 
 --
--- Name: sqlite_databases db_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: audit_log audit_log_append_only; Type: TRIGGER; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.sqlite_databases ALTER COLUMN db_id SET DEFAULT nextval('public.sqlite_databases_db_id_seq'::regclass);
+CREATE TRIGGER audit_log_append_only BEFORE DELETE OR UPDATE OR TRUNCATE ON public.audit_log FOR EACH STATEMENT EXECUTE PROCEDURE public.audit_log_append_only();
 
 
 --
--- Name: users user_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: api_tokens api_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.users ALTER COLUMN user_id SET DEFAULT nextval('public.users_user_id_seq'::regclass);
+ALTER TABLE ONLY public.api_tokens
+    ADD CONSTRAINT api_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_downloads database_downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: commit_statuses commit_statuses_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_downloads
-    ADD CONSTRAINT database_downloads_pkey PRIMARY KEY (dl_id);
+ALTER TABLE ONLY public.commit_statuses
+    ADD CONSTRAINT commit_statuses_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_files database_files_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: commit_statuses commit_statuses_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_files
-    ADD CONSTRAINT database_files_pkey PRIMARY KEY (db_sha256);
+ALTER TABLE ONLY public.commit_statuses
+    ADD CONSTRAINT commit_statuses_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: database_licences database_licences_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_collaborators database_collaborators_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_licences
-    ADD CONSTRAINT database_licences_pkey PRIMARY KEY (user_id, friendly_name);
+ALTER TABLE ONLY public.database_collaborators
+    ADD CONSTRAINT database_collaborators_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_stars database_stars_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_collaborators database_collaborators_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_stars
-    ADD CONSTRAINT database_stars_pkey PRIMARY KEY (db_id, user_id);
+ALTER TABLE ONLY public.database_collaborators
+    ADD CONSTRAINT database_collaborators_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_uploads database_uploads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_downloads database_downloads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_uploads
-    ADD CONSTRAINT database_uploads_pkey PRIMARY KEY (up_id);
+ALTER TABLE ONLY public.database_downloads
+    ADD CONSTRAINT database_downloads_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_downloads database_downloads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussion_comments
-    ADD CONSTRAINT discussion_comments_pkey PRIMARY KEY (com_id);
+ALTER TABLE ONLY public.database_downloads
+    ADD CONSTRAINT database_downloads_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_db_id_disc_id_unique; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_licences database_licences_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussions
-    ADD CONSTRAINT discussions_db_id_disc_id_unique UNIQUE (db_id, disc_id);
+ALTER TABLE ONLY public.database_licences
+    ADD CONSTRAINT database_licences_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_redirects database_redirects_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussions
-    ADD CONSTRAINT discussions_pkey PRIMARY KEY (internal_id);
+ALTER TABLE ONLY public.database_redirects
+    ADD CONSTRAINT database_redirects_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: email_queue email_queue_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_redirects database_redirects_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.email_queue
-    ADD CONSTRAINT email_queue_pkey PRIMARY KEY (email_id);
+ALTER TABLE ONLY public.database_redirects
+    ADD CONSTRAINT database_redirects_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events events_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_stars database_stars_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.events
-    ADD CONSTRAINT events_pkey PRIMARY KEY (event_id);
+ALTER TABLE ONLY public.database_stars
+    ADD CONSTRAINT database_stars_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: sqlite_databases sqlite_databases_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_stars database_stars_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.sqlite_databases
-    ADD CONSTRAINT sqlite_databases_pkey PRIMARY KEY (db_id);
+ALTER TABLE ONLY public.database_stars
+    ADD CONSTRAINT database_stars_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: sqlite_databases sqlite_databases_user_id_folder_db_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_transfers database_transfers_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.sqlite_databases
-    ADD CONSTRAINT sqlite_databases_user_id_folder_db_name_key UNIQUE (user_id, folder, db_name);
+ALTER TABLE ONLY public.database_transfers
+    ADD CONSTRAINT database_transfers_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_auth0_id_key; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_transfers database_transfers_from_user_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_auth0_id_key UNIQUE (auth0_id);
+ALTER TABLE ONLY public.database_transfers
+    ADD CONSTRAINT database_transfers_from_user_fkey FOREIGN KEY (from_user) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_transfers database_transfers_to_user_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_pkey PRIMARY KEY (user_id);
+ALTER TABLE ONLY public.database_transfers
+    ADD CONSTRAINT database_transfers_to_user_fkey FOREIGN KEY (to_user) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_user_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_trash database_trash_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_user_name_key UNIQUE (user_name);
+ALTER TABLE ONLY public.database_trash
+    ADD CONSTRAINT database_trash_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: vis_params vis_params_pk; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_uploads database_uploads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.vis_params
-    ADD CONSTRAINT vis_params_pk UNIQUE (db_id, user_id, name);
+ALTER TABLE ONLY public.database_uploads
+    ADD CONSTRAINT database_uploads_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: vis_result_cache vis_result_cache_pk; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_uploads database_uploads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.vis_result_cache
-    ADD CONSTRAINT vis_result_cache_pk PRIMARY KEY (db_id, user_id, commit_id, hash);
+ALTER TABLE ONLY public.database_uploads
+    ADD CONSTRAINT database_uploads_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: watchers watchers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: digest_queue digest_queue_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.watchers
-    ADD CONSTRAINT watchers_pkey PRIMARY KEY (db_id, user_id);
+ALTER TABLE ONLY public.digest_queue
+    ADD CONSTRAINT digest_queue_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences_lic_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: digest_queue digest_queue_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX database_licences_lic_id_idx ON public.database_licences USING btree (lic_id);
+ALTER TABLE ONLY public.digest_queue
+    ADD CONSTRAINT digest_queue_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences_lic_sha256_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_assignees discussion_assignees_db_id_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX database_licences_lic_sha256_idx ON public.database_licences USING btree (lic_sha256);
+ALTER TABLE ONLY public.discussion_assignees
+    ADD CONSTRAINT discussion_assignees_db_id_disc_id_fkey FOREIGN KEY (db_id, disc_id) REFERENCES public.discussions(db_id, disc_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences_user_id_friendly_name_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_assignees discussion_assignees_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX database_licences_user_id_friendly_name_idx ON public.database_licences USING btree (user_id, friendly_name);
+ALTER TABLE ONLY public.discussion_assignees
+    ADD CONSTRAINT discussion_assignees_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions_discussion_type_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_comment_anchors discussion_comment_anchors_com_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX discussions_discussion_type_idx ON public.discussions USING btree (discussion_type);
+ALTER TABLE ONLY public.discussion_comment_anchors
+    ADD CONSTRAINT discussion_comment_anchors_com_id_fkey FOREIGN KEY (com_id) REFERENCES public.discussion_comments(com_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events_event_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_comment_anchors discussion_comment_anchors_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX events_event_id_idx ON public.events USING btree (event_id);
+ALTER TABLE ONLY public.discussion_comment_anchors
+    ADD CONSTRAINT discussion_comment_anchors_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_downloads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_comments discussion_comments_commenter_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_downloads_db_id_fkey ON public.database_downloads USING btree (db_id);
+ALTER TABLE ONLY public.discussion_comments
+    ADD CONSTRAINT discussion_comments_commenter_fkey FOREIGN KEY (commenter) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_downloads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_comments discussion_comments_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_downloads_user_id_fkey ON public.database_downloads USING btree (user_id);
+ALTER TABLE ONLY public.discussion_comments
+    ADD CONSTRAINT discussion_comments_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_uploads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_comments discussion_comments_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_uploads_db_id_fkey ON public.database_uploads USING btree (db_id);
+ALTER TABLE ONLY public.discussion_comments
+    ADD CONSTRAINT discussion_comments_disc_id_fkey FOREIGN KEY (disc_id) REFERENCES public.discussions(internal_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_uploads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_label_map discussion_label_map_db_id_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_uploads_user_id_fkey ON public.database_uploads USING btree (user_id);
+ALTER TABLE ONLY public.discussion_label_map
+    ADD CONSTRAINT discussion_label_map_db_id_disc_id_fkey FOREIGN KEY (db_id, disc_id) REFERENCES public.discussions(db_id, disc_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_discussion_comments_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_label_map discussion_label_map_label_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_discussion_comments_db_id_fkey ON public.discussion_comments USING btree (db_id);
+ALTER TABLE ONLY public.discussion_label_map
+    ADD CONSTRAINT discussion_label_map_label_id_fkey FOREIGN KEY (label_id) REFERENCES public.discussion_labels(label_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_discussions_source_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_labels discussion_labels_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_discussions_source_db_id_fkey ON public.discussions USING btree (mr_source_db_id);
+ALTER TABLE ONLY public.discussion_labels
+    ADD CONSTRAINT discussion_labels_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users_lower_user_name_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_milestones discussion_milestones_db_id_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX users_lower_user_name_idx ON public.users USING btree (lower(user_name));
+ALTER TABLE ONLY public.discussion_milestones
+    ADD CONSTRAINT discussion_milestones_db_id_disc_id_fkey FOREIGN KEY (db_id, disc_id) REFERENCES public.discussions(db_id, disc_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users_user_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_milestones discussion_milestones_milestone_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX users_user_id_idx ON public.users USING btree (user_id);
+ALTER TABLE ONLY public.discussion_milestones
+    ADD CONSTRAINT discussion_milestones_milestone_id_fkey FOREIGN KEY (milestone_id) REFERENCES public.milestones(milestone_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users_user_name_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_subscriptions discussion_subscriptions_db_id_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX users_user_name_idx ON public.users USING btree (user_name);
+ALTER TABLE ONLY public.discussion_subscriptions
+    ADD CONSTRAINT discussion_subscriptions_db_id_disc_id_fkey FOREIGN KEY (db_id, disc_id) REFERENCES public.discussions(db_id, disc_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: watchers_db_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: discussion_subscriptions discussion_subscriptions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX watchers_db_id_idx ON public.watchers USING btree (db_id);
+ALTER TABLE ONLY public.discussion_subscriptions
+    ADD CONSTRAINT discussion_subscriptions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_downloads database_downloads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: discussions discussions_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_downloads
-    ADD CONSTRAINT database_downloads_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.discussions
+    ADD CONSTRAINT discussions_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_downloads database_downloads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: discussions discussions_mr_source_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_downloads
-    ADD CONSTRAINT database_downloads_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.discussions
+    ADD CONSTRAINT discussions_mr_source_db_id_fkey FOREIGN KEY (mr_source_db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE SET NULL ON DELETE SET NULL;
 
 
 --
--- Name: database_licences database_licences_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: discussions discussions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_licences
-    ADD CONSTRAINT database_licences_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.discussions
+    ADD CONSTRAINT discussions_user_id_fkey FOREIGN KEY (creator) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_stars database_stars_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: events events_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_stars
-    ADD CONSTRAINT database_stars_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.events
+    ADD CONSTRAINT events_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_stars database_stars_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: merge_request_reviews merge_request_reviews_db_id_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_stars
-    ADD CONSTRAINT database_stars_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.merge_request_reviews
+    ADD CONSTRAINT merge_request_reviews_db_id_disc_id_fkey FOREIGN KEY (db_id, disc_id) REFERENCES public.discussions(db_id, disc_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_uploads database_uploads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: merge_request_reviews merge_request_reviews_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_uploads
-    ADD CONSTRAINT database_uploads_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.merge_request_reviews
+    ADD CONSTRAINT merge_request_reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_uploads database_uploads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: milestones milestones_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.database_uploads
-    ADD CONSTRAINT database_uploads_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.milestones
+    ADD CONSTRAINT milestones_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_commenter_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_members org_members_org_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussion_comments
-    ADD CONSTRAINT discussion_comments_commenter_fkey FOREIGN KEY (commenter) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.org_members
+    ADD CONSTRAINT org_members_org_id_fkey FOREIGN KEY (org_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_members org_members_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussion_comments
-    ADD CONSTRAINT discussion_comments_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.org_members
+    ADD CONSTRAINT org_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_team_databases org_team_databases_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussion_comments
-    ADD CONSTRAINT discussion_comments_disc_id_fkey FOREIGN KEY (disc_id) REFERENCES public.discussions(internal_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.org_team_databases
+    ADD CONSTRAINT org_team_databases_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_team_databases org_team_databases_team_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussions
-    ADD CONSTRAINT discussions_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.org_team_databases
+    ADD CONSTRAINT org_team_databases_team_id_fkey FOREIGN KEY (team_id) REFERENCES public.org_teams(team_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_mr_source_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_team_members org_team_members_team_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussions
-    ADD CONSTRAINT discussions_mr_source_db_id_fkey FOREIGN KEY (mr_source_db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE SET NULL ON DELETE SET NULL;
+ALTER TABLE ONLY public.org_team_members
+    ADD CONSTRAINT org_team_members_team_id_fkey FOREIGN KEY (team_id) REFERENCES public.org_teams(team_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_team_members org_team_members_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.discussions
-    ADD CONSTRAINT discussions_user_id_fkey FOREIGN KEY (creator) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.org_team_members
+    ADD CONSTRAINT org_team_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events events_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: org_teams org_teams_org_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY public.events
-    ADD CONSTRAINT events_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY public.org_teams
+    ADD CONSTRAINT org_teams_org_id_fkey FOREIGN KEY (org_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
@@ -879,6 +2075,22 @@ ALTER TABLE ONLY public.sqlite_databases
     ADD CONSTRAINT sqlite_databases_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: upload_sessions upload_sessions_db_owner_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.upload_sessions
+    ADD CONSTRAINT upload_sessions_db_owner_id_fkey FOREIGN KEY (db_owner_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: upload_sessions upload_sessions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.upload_sessions
+    ADD CONSTRAINT upload_sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: vis_params vis_params_sqlite_databases_db_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
@@ -927,6 +2139,30 @@ ALTER TABLE ONLY public.watchers
     ADD CONSTRAINT watchers_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: webhook_deliveries webhook_deliveries_webhook_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_webhook_id_fkey FOREIGN KEY (webhook_id) REFERENCES public.webhooks(webhook_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: webhooks webhooks_creator_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhooks
+    ADD CONSTRAINT webhooks_creator_fkey FOREIGN KEY (creator) REFERENCES public.users(user_id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+
+--
+-- Name: webhooks webhooks_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.webhooks
+    ADD CONSTRAINT webhooks_db_id_fkey FOREIGN KEY (db_id) REFERENCES public.sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- PostgreSQL database dump complete
 --
@@ -92,6 +92,9 @@ func main() {
 		log.Fatalf(err.Error())
 	}
 
+	// Start the background goroutine which removes unfinished uploads once they've expired
+	go com.ExpireUploadsLoop()
+
 	// Load our self signed CA chain
 	ourCAPool = x509.NewCertPool()
 	certFile, err := ioutil.ReadFile(com.Conf.DB4S.CAChain)
@@ -109,11 +112,16 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", rootHandler)
 	mux.HandleFunc("/branch/list", branchListHandler)
+	mux.HandleFunc("/changeset/apply", changesetApplyHandler)
 	mux.HandleFunc("/licence/add", licenceAddHandler)
 	mux.HandleFunc("/licence/get", licenceGetHandler)
 	mux.HandleFunc("/licence/list", licenceListHandler)
 	mux.HandleFunc("/licence/remove", licenceRemoveHandler)
 	mux.HandleFunc("/metadata/get", metadataGetHandler)
+	mux.HandleFunc("/upload/chunk", uploadChunkHandler)
+	mux.HandleFunc("/upload/finish", uploadFinishHandler)
+	mux.HandleFunc("/upload/start", uploadStartHandler)
+	mux.HandleFunc("/upload/status", uploadStatusHandler)
 
 	// Load our self signed CA Cert chain, request client certificates, and set TLS1.2 as minimum
 	newTLSConfig := &tls.Config{
@@ -165,6 +173,9 @@ func branchListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, userAcc, dbOwner, dbFolder, dbName) {
+			return
+		}
 		fmt.Fprint(w, "{}")
 		return
 	}
@@ -202,6 +213,254 @@ func branchListHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// Applies a SQLite changeset (or patchset) to a branch, storing the result as a new commit.  The "commit" field needs
+// to be the commit the changeset was made against.  If that's no longer the head of the branch, the changeset is still
+// accepted as long as it applies cleanly to the current head.  An example curl command to simulate the request is:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -F changeset=@changes.bin -F "username=someuser" \
+//       -F "dbname=someupload.sqlite" -F "branch=master" -F "commitmsg=stuff" \
+//       -F "commit=51d494f2c5eb6734ddaa204eccb9597b426091c79c951924ac83c72038f22b55" \
+//       https://db4s.dbhub.io:5550/changeset/apply
+//
+func changesetApplyHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Changeset apply handler"
+
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("Unknown request type: %v\n", r.Method), http.StatusBadRequest)
+		return
+	}
+
+	// The "public" user isn't allowed to make changes
+	if userAcc == "public" {
+		log.Printf("User from '%s' attempted to apply a changeset using the public certificate", r.RemoteAddr)
+		http.Error(w, "You're using the 'public' certificate, which isn't allowed to make changes on the server",
+			http.StatusUnauthorized)
+		return
+	}
+
+	// Changesets can't be larger than a full database upload
+	if maxSize := com.MaxUploadSize(userAcc); maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	// Extract and validate the form variables
+	dbOwner, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil || dbOwner == "" || dbName == "" {
+		http.Error(w, "Missing or incorrect data supplied", http.StatusBadRequest)
+		return
+	}
+	if dbFolder == "" {
+		dbFolder = "/"
+	}
+	baseCommit, err := com.GetFormCommit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if baseCommit == "" {
+		http.Error(w, "The commit ID the changeset was made against is needed", http.StatusBadRequest)
+		return
+	}
+	var branchName string
+	if z := r.PostFormValue("branch"); z != "" {
+		err = com.ValidateBranchName(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid branch name value: '%v'", z), http.StatusBadRequest)
+			return
+		}
+		branchName = z
+	}
+	var commitMsg string
+	if z := r.PostFormValue("commitmsg"); z != "" {
+		err = com.Validate.Var(z, "markdownsource,max=1024") // 1024 seems like a reasonable first guess
+		if err != nil {
+			http.Error(w, "Validation failed for the commit message", http.StatusBadRequest)
+			return
+		}
+		commitMsg = z
+	}
+	var commitTime time.Time
+	if z := r.PostFormValue("committimestamp"); z != "" {
+		commitTime, err = time.Parse(time.RFC3339, z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid commit timestamp value: '%v'", z), http.StatusBadRequest)
+			return
+		}
+		commitTime = commitTime.UTC()
+	}
+	var authorName, authorEmail, committerName, committerEmail string
+	if z := r.PostFormValue("authorname"); z != "" {
+		err = com.ValidateDisplayName(z)
+		if err != nil {
+			http.Error(w, "Validation failed for the author name", http.StatusBadRequest)
+			return
+		}
+		authorName = z
+	}
+	if z := r.PostFormValue("authoremail"); z != "" {
+		err = com.ValidateEmail(z)
+		if err != nil {
+			http.Error(w, "Validation failed for the author email", http.StatusBadRequest)
+			return
+		}
+		authorEmail = z
+	}
+	if z := r.PostFormValue("committername"); z != "" {
+		err = com.ValidateDisplayName(z)
+		if err != nil {
+			http.Error(w, "Validation failed for the committer name", http.StatusBadRequest)
+			return
+		}
+		committerName = z
+	}
+	if z := r.PostFormValue("committeremail"); z != "" {
+		err = com.ValidateEmail(z)
+		if err != nil {
+			http.Error(w, "Validation failed for the committer email", http.StatusBadRequest)
+			return
+		}
+		committerEmail = z
+	}
+
+	// Grab the changeset
+	csFile, _, err := r.FormFile("changeset")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Something went wrong when grabbing the changeset data: '%s'", err.Error()),
+			http.StatusBadRequest)
+		return
+	}
+	defer csFile.Close()
+	changeset, err := ioutil.ReadAll(csFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Verify the user is writing to a location they have write access for
+	allowed, err := com.CheckDBPermissions(userAcc, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		log.Printf("%s: Attempt by '%s' to write to unauthorised location: %s%s%s\n", pageName, userAcc,
+			dbOwner, dbFolder, dbName)
+		http.Error(w, fmt.Sprintf("Error code 401: You don't have write permission for '%s%s%s'", dbOwner,
+			dbFolder, dbName), http.StatusForbidden)
+		return
+	}
+
+	// Make sure the database exists
+	exists, err := com.CheckDBExists(userAcc, dbOwner, dbFolder, dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName),
+			http.StatusNotFound)
+		return
+	}
+
+	// If no branch name was given, use the default one for the database
+	if branchName == "" {
+		branchName, err = com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	branchList, err := com.GetBranches(dbOwner, dbFolder, dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	brDetails, ok := branchList[branchName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown branch: '%s'", branchName), http.StatusNotFound)
+		return
+	}
+
+	// If the branch is protected such that changes need to arrive through a merge request, then changesets can't be
+	// applied to it directly
+	protection, err := com.GetBranchProtection(dbOwner, dbFolder, dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if protection[branchName].RequireMR {
+		http.Error(w, fmt.Sprintf("Branch '%s' is protected.  Changes to it need to be made through a "+
+			"merge request", branchName), http.StatusForbidden)
+		return
+	}
+
+	// If the base commit isn't the branch head, it still needs to be in the branch history
+	if baseCommit != brDetails.Commit {
+		found, err := com.IsCommitInBranchHistory(dbOwner, dbFolder, dbName, branchName, baseCommit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("Commit ID '%s' isn't in the commit history of branch '%s'", baseCommit,
+				branchName), http.StatusNotFound)
+			return
+		}
+	}
+
+	// Apply the changeset to the head of the branch, and add the result as a new commit
+	numBytes, commitID, err := com.CommitChangeset(r, userAcc, dbOwner, dbFolder, dbName, branchName,
+		brDetails.Commit, changeset, commitMsg, "db4s", commitTime, authorName, authorEmail, committerName,
+		committerEmail)
+	if err != nil {
+		if err == com.ErrBranchMoved {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if _, ok := err.(com.ChangesetConflictError); ok {
+			if baseCommit != brDetails.Commit {
+				http.Error(w, fmt.Sprintf("Commit '%s' isn't the head of branch '%s', and the changeset "+
+					"conflicts with the changes made since then: %s", baseCommit, branchName, err),
+					http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Log the successful changeset
+	log.Printf("Changeset applied: '%s%s%s', branch: '%s', database bytes: %v\n", dbOwner, dbFolder, dbName,
+		branchName, numBytes)
+
+	// Construct message data for returning to sender
+	u := server + filepath.Join("/", dbOwner, dbFolder, dbName)
+	u += fmt.Sprintf(`?branch=%s&commit=%s`, branchName, commitID)
+	m := map[string]string{"commit_id": commitID, "url": u}
+
+	// Convert to JSON
+	var msg bytes.Buffer
+	enc := json.NewEncoder(&msg)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send return message back to the client
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, msg.String())
+}
+
 func extractUserAndServer(w http.ResponseWriter, r *http.Request) (userAcc string, certServer string, err error) {
 
 	// Extract the account name and associated server from the validated client certificate
@@ -232,6 +491,20 @@ func extractUserAndServer(w http.ResponseWriter, r *http.Request) (userAcc strin
 		return
 	}
 
+	// Make sure the account is allowed to connect, and the certificate hasn't been revoked
+	disabled, validFrom, err := com.UserAccountStatus(userAcc)
+	if err != nil {
+		return
+	}
+	if disabled {
+		err = errors.New("This account has been disabled")
+		return
+	}
+	if r.TLS.PeerCertificates[0].NotBefore.Before(validFrom) {
+		err = errors.New("This client certificate has been revoked.  Please generate a new one")
+		return
+	}
+
 	// Everything is ok, so return
 	return
 }
@@ -358,6 +631,9 @@ func getHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, userAcc, dbOwner, dbFolder, dbName) {
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName),
 			http.StatusNotFound)
 		return
@@ -422,6 +698,30 @@ func getHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		return
 	}
 
+	// If the client already has an earlier version of the database, it can ask for just the changes since then
+	var have string
+	if z := r.FormValue("have"); z != "" {
+		err = com.ValidateCommitID(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid have value: '%v'", z), http.StatusBadRequest)
+			return
+		}
+		if _, ok = commitList[z]; !ok {
+			http.Error(w, "Have commit not found", http.StatusNotFound)
+			return
+		}
+		have = z
+	}
+
+	// If the client has an earlier version of the database, send it a delta
+	if have != "" && have != commit {
+		err = retrieveDatabaseDelta(w, r, pageName, userAcc, dbOwner, dbFolder, dbName, branchName, commit, have)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// A specific database was requested, so send it to the user
 	err = retrieveDatabase(w, r, pageName, userAcc, dbOwner, dbFolder, dbName, branchName, commit)
 	if err != nil {
@@ -575,6 +875,8 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 			http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "db4s", userAcc, "", "", "", com.AUDIT_LICENCE_ADD, nil,
+		map[string]string{"licence": licID, "full_name": licName, "source_url": sourceURL, "format": fileFormat})
 
 	// Send a success message back to the client
 	w.WriteHeader(http.StatusCreated)
@@ -724,6 +1026,8 @@ func licenceRemoveHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	com.Audit(r, "db4s", userAcc, "", "", "", com.AUDIT_LICENCE_REMOVE, map[string]string{"licence": licenceName},
+		nil)
 
 	// Send a success message back to the client
 	w.WriteHeader(http.StatusOK)
@@ -757,6 +1061,9 @@ func metadataGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, userAcc, dbOwner, dbFolder, dbName) {
+			return
+		}
 		http.Error(w, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName),
 			http.StatusNotFound)
 		return
@@ -839,9 +1146,6 @@ func metadataGetHandler(w http.ResponseWriter, r *http.Request) {
 func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 	pageName := "POST request handler"
 
-	// Set the maximum accepted database size for uploading
-	r.Body = http.MaxBytesReader(w, r.Body, com.MaxDatabaseSize*1024*1024)
-
 	// The "public" user isn't allowed to make changes
 	if userAcc == "public" {
 		log.Printf("User from '%s' attempted to add a database using the public certificate", r.RemoteAddr)
@@ -868,14 +1172,19 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 	}
 
 	// Check whether the uploaded database is too large
-	// TODO: Have a list of users (from the config.toml file) which don't have this check applied
-	if r.ContentLength > (com.MaxDatabaseSize * 1024 * 1024) {
-		http.Error(w,
-			fmt.Sprintf("Database is too large. Maximum database upload size is %d MB, yours is %d MB",
-				com.MaxDatabaseSize, r.ContentLength/1024/1024), http.StatusBadRequest)
-		log.Println(fmt.Sprintf("'%s' attempted to upload an oversized database %d MB in size.  Limit is %d MB\n",
-			userAcc, r.ContentLength/1024/1024, com.MaxDatabaseSize))
-		return
+	maxSize := com.MaxUploadSize(userAcc)
+	if maxSize > 0 {
+		// Set the maximum accepted database size for uploading
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+		if r.ContentLength > maxSize {
+			http.Error(w,
+				fmt.Sprintf("Database is too large. Maximum database upload size is %d MB, yours is %d MB",
+					maxSize/1024/1024, r.ContentLength/1024/1024), http.StatusBadRequest)
+			log.Println(fmt.Sprintf("'%s' attempted to upload an oversized database %d MB in size.  Limit is %d MB\n",
+				userAcc, r.ContentLength/1024/1024, maxSize/1024/1024))
+			return
+		}
 	}
 
 	// Grab the uploaded file and form variables
@@ -905,8 +1214,17 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		return
 	}
 
+	// Add the database to the system
 	// TODO: Add support for folders
-	targetFolder := "/"
+	processUpload(w, r, pageName, userAcc, targetUser, "/", targetDB, tempFile)
+}
+
+// Validates the form values sent along with an uploaded database, then adds the database to the system.  This is used
+// for both single part uploads, and resumable uploads once all of their data has been received.  Returns true if the
+// database was added, otherwise an error has already been sent to the client.
+func processUpload(w http.ResponseWriter, r *http.Request, pageName string, userAcc string, targetUser string,
+	targetFolder string, targetDB string, dbFile io.Reader) bool {
+	var err error
 
 	// If a branch name was provided then validate it
 	var branchName string
@@ -914,7 +1232,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err := com.Validate.Var(z, "branchortagname,min=1,max=32") // 32 seems a reasonable first guess.
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Invalid branch name value: '%v'", z), http.StatusBadRequest)
-			return
+			return false
 		}
 		branchName = z
 	}
@@ -927,7 +1245,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 			// Force value couldn't be parsed
 			http.Error(w, fmt.Sprintf("Error when converting force '%s' value to boolean: %v\n", z, err),
 				http.StatusBadRequest)
-			return
+			return false
 		}
 	}
 
@@ -938,19 +1256,19 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Validation failed for licence name value: '%s': %s", z, err),
 				http.StatusBadRequest)
-			return
+			return false
 		}
 
 		// Make sure the licence is one that's known to us
 		licenceList, err := com.GetLicences(userAcc)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return false
 		}
 		_, ok := licenceList[z]
 		if !ok {
 			http.Error(w, fmt.Sprintf("Unknown licence: '%s'", z), http.StatusBadRequest)
-			return
+			return false
 		}
 		licenceName = z
 	}
@@ -961,7 +1279,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.Validate.Var(z, "url,min=5,max=255") // 255 seems like a reasonable first guess
 		if err != nil {
 			http.Error(w, "Validation failed for source URL value", http.StatusBadRequest)
-			return
+			return false
 		}
 		sourceURL = z
 	}
@@ -970,7 +1288,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 	commit, err := com.GetFormCommit(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// If a commit message was provided then use it
@@ -979,7 +1297,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.Validate.Var(z, "markdownsource,max=1024") // 1024 seems like a reasonable first guess
 		if err != nil {
 			http.Error(w, "Validation failed for the commit message", http.StatusBadRequest)
-			return
+			return false
 		}
 		commitMsg = z
 	}
@@ -991,7 +1309,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error when converting public value to boolean: %v\n", err),
 				http.StatusBadRequest)
-			return
+			return false
 		}
 	}
 
@@ -1001,7 +1319,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		lastMod, err = time.Parse(time.RFC3339, z)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Invalid lastmodified value: '%v'", z), http.StatusBadRequest)
-			return
+			return false
 		}
 		lastMod = lastMod.UTC()
 	} else {
@@ -1015,7 +1333,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		commitTime, err = time.Parse(time.RFC3339, z)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Invalid commit timestamp value: '%v'", z), http.StatusBadRequest)
-			return
+			return false
 		}
 		commitTime = commitTime.UTC()
 	}
@@ -1026,7 +1344,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.ValidateDisplayName(z)
 		if err != nil {
 			http.Error(w, "Validation failed for the author name", http.StatusBadRequest)
-			return
+			return false
 		}
 		authorName = z
 	}
@@ -1037,7 +1355,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.ValidateEmail(z)
 		if err != nil {
 			http.Error(w, "Validation failed for the author email", http.StatusBadRequest)
-			return
+			return false
 		}
 		authorEmail = z
 	}
@@ -1048,7 +1366,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.ValidateDisplayName(z)
 		if err != nil {
 			http.Error(w, "Validation failed for the committer name", http.StatusBadRequest)
-			return
+			return false
 		}
 		committerName = z
 	}
@@ -1059,7 +1377,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.ValidateEmail(z)
 		if err != nil {
 			http.Error(w, "Validation failed for the committer email", http.StatusBadRequest)
-			return
+			return false
 		}
 		committerEmail = z
 	}
@@ -1070,7 +1388,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		x, err := url.QueryUnescape(z)
 		if err != nil {
 			http.Error(w, "Validation failed for the other parents field", http.StatusBadRequest)
-			return
+			return false
 		}
 		commits := strings.Split(x, ",")
 		for _, j := range commits {
@@ -1078,7 +1396,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 			err = com.ValidateCommitID(j)
 			if err != nil {
 				http.Error(w, "Validation failed for the other parents field", http.StatusBadRequest)
-				return
+				return false
 			}
 			otherParents = append(otherParents, j)
 		}
@@ -1090,25 +1408,30 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		err = com.Validate.Var(z, "hexadecimal,min=64,max=64")
 		if err != nil {
 			http.Error(w, "Validation failed for the database SHA256", http.StatusBadRequest)
-			return
+			return false
 		}
 		dbSHA256 = z
 	}
 
 	// Verify the user is uploading to a location they have write access for
-	if strings.ToLower(targetUser) != strings.ToLower(userAcc) {
+	allowed, err := com.CheckWriteAccess(userAcc, targetUser, targetFolder, targetDB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
 		log.Printf("%s: Attempt by '%s' to write to unauthorised location: %v\n", pageName, userAcc,
 			r.URL.Path)
 		http.Error(w, fmt.Sprintf("Error code 401: You don't have write permission for '%s'",
 			r.URL.Path), http.StatusForbidden)
-		return
+		return false
 	}
 
 	// Check if the database exists already
 	exists, err := com.CheckDBExists(userAcc, targetUser, targetFolder, targetDB)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 	if !exists && branchName == "" {
 		// If the database doesn't already exist, and no branch name was provided, then default to master
@@ -1123,14 +1446,21 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		if commit == "" {
 			http.Error(w, "No commit ID was provided.  You probably need to upgrade your client before trying this "+
 				"again.", http.StatusUpgradeRequired)
-			return
+			return false
 		}
 
 		// Retrieve the branch list for the database
 		branchList, err := com.GetBranches(targetUser, targetFolder, targetDB)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return false
+		}
+
+		// Retrieve the branch protection settings for the database
+		protection, err := com.GetBranchProtection(targetUser, targetFolder, targetDB)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
 		}
 
 		// If a branch name was given, check if it's a branch we know about
@@ -1152,7 +1482,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 				a, err := com.IsCommitInBranchHistory(targetUser, targetFolder, targetDB, branch, commit)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
+					return false
 				}
 				if a {
 					found = true
@@ -1161,9 +1491,17 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 			if !found {
 				// The commit wasn't found in the history of any branch
 				http.Error(w, fmt.Sprintf("Unknown commit ID: '%s'", commit), http.StatusNotFound)
-				return
+				return false
 			}
 		} else {
+			// If the branch is protected such that changes need to arrive through a merge request, then direct
+			// pushes to it aren't allowed
+			if protection[branchName].RequireMR {
+				http.Error(w, fmt.Sprintf("Branch '%s' is protected.  Changes to it need to be made through a "+
+					"merge request", branchName), http.StatusForbidden)
+				return false
+			}
+
 			// * Collision detection piece *
 
 			// Check if the provided commit ID is the latest head commit for the branch.  If it is, then things
@@ -1177,7 +1515,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 				found, err := com.IsCommitInBranchHistory(targetUser, targetFolder, targetDB, branchName, commit)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
+					return false
 				}
 
 				if !found {
@@ -1185,7 +1523,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 					// wrong.  We need to error out and let the client know
 					http.Error(w, fmt.Sprintf("Commit ID '%s' isn't in the commit history of branch '%s'",
 						commit, branchName), http.StatusNotFound)
-					return
+					return false
 				}
 
 				// * To get here, this push is a collision *
@@ -1198,7 +1536,14 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 				if !force {
 					http.Error(w, fmt.Sprintf("Outdated commit '%s' provided.  You're probably using an "+
 						"old version of the database", commit), http.StatusConflict)
-					return
+					return false
+				}
+
+				// Force pushes throw away history, so they're not allowed for protected branches
+				if protection[branchName].NoForcePush {
+					http.Error(w, fmt.Sprintf("Branch '%s' is protected against force pushes", branchName),
+						http.StatusForbidden)
+					return false
 				}
 
 				// * To get here, the client has told us to rewrite the commit history for a branch, given us the
@@ -1214,11 +1559,15 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 
 	// Sanity check the uploaded database, and if ok then add it to the system
 	numBytes, commitID, err := com.AddDatabase(r, userAcc, targetUser, targetFolder, targetDB, createBranch,
-		branchName, commit, public, licenceName, commitMsg, sourceURL, tempFile, "db4s", lastMod,
+		branchName, commit, force, public, licenceName, commitMsg, sourceURL, dbFile, "db4s", lastMod,
 		commitTime, authorName, authorEmail, committerName, committerEmail, otherParents, dbSHA256)
+	if err == com.ErrBranchMoved {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Log the successful database upload
@@ -1236,12 +1585,28 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(m); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Send return message back to the client
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, msg.String())
+	return true
+}
+
+// If the requested database has been transferred to a new owner, redirect the client to its new location.  Only GET
+// requests are redirected, as clients don't resend the body of other requests.  Returns true if a redirect was sent.
+func redirectMovedDatabase(w http.ResponseWriter, r *http.Request, userAcc string, dbOwner string, dbFolder string,
+	dbName string) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	newOwner, _, newName, err := com.DatabaseRedirect(userAcc, dbOwner, dbFolder, dbName)
+	if err != nil || newOwner == "" {
+		return false
+	}
+	http.Redirect(w, r, com.MovedDatabaseURL(r.URL, dbOwner, dbName, newOwner, newName), http.StatusFound)
+	return true
 }
 
 // Returns a file requested by the client.  An example curl command to simulate the request is:
@@ -1325,6 +1690,123 @@ func retrieveDatabase(w http.ResponseWriter, r *http.Request, pageName string, u
 	return nil
 }
 
+// Returns the changes between the version of a database the client already has, and the version it's requesting.
+// The changes are sent as a page level delta (see common/delta.go for the format), unless the delta turns out to be
+// no smaller than the requested database itself, in which case the whole database is sent instead.  An example curl
+// command to simulate the request is:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -G -o delta.bin \
+//       -d have=<commit id> https://db4s.dbhub.io:5550/someuser/somedb.sqlite
+//
+func retrieveDatabaseDelta(w http.ResponseWriter, r *http.Request, pageName string, userAcc string, dbOwner string,
+	dbFolder string, dbName string, branchName string, commit string, have string) (err error) {
+	pageName += ":retrieveDatabaseDelta()"
+
+	// Retrieve the Minio details for both versions of the database
+	bucket, id, lastMod, err := com.MinioLocation(dbOwner, dbFolder, dbName, commit, userAcc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	haveBucket, haveID, _, err := com.MinioLocation(dbOwner, dbFolder, dbName, have, userAcc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Make sure both versions of the database are in the disk cache
+	newFile, err := com.CacheMinioObject(bucket, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	oldFile, err := com.CacheMinioObject(haveBucket, haveID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Create the delta in a temporary file, so we know its size before sending it
+	deltaFile, err := ioutil.TempFile(com.Conf.DiskCache.Directory, "dbhub-delta-")
+	if err != nil {
+		log.Printf("%s: Error creating temporary file for delta: %v\n", pageName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(deltaFile.Name())
+	defer deltaFile.Close()
+	changedPages, err := com.CreateDatabaseDelta(oldFile, newFile, deltaFile)
+	if err != nil {
+		log.Printf("%s: Error creating delta for '%s%s%s' between commits '%s' and '%s': %v\n", pageName,
+			dbOwner, dbFolder, dbName, have, commit, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deltaSize, err := deltaFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// If the delta isn't any smaller than the database itself, just send the database instead
+	newStat, err := os.Stat(newFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deltaSize >= newStat.Size() {
+		return retrieveDatabase(w, r, pageName, userAcc, dbOwner, dbFolder, dbName, branchName, commit)
+	}
+	if _, err = deltaFile.Seek(0, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Was a user agent part of the request?
+	var userAgent string
+	ua, ok := r.Header["User-Agent"]
+	if ok {
+		userAgent = ua[0]
+	}
+
+	// Make a record of the download
+	err = com.LogDownload(dbOwner, dbFolder, dbName, userAcc, r.RemoteAddr, "db4s", userAgent, time.Now().UTC(),
+		bucket+id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send the delta to the user
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; modification-date="%s";`,
+		url.QueryEscape(dbName), lastMod.Format(time.RFC3339)))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", deltaSize))
+	w.Header().Set("Content-Type", com.DeltaContentType)
+	w.Header().Set("Branch", branchName)
+	w.Header().Set("Commit-ID", commit)
+	w.Header().Set("Base-Commit-ID", have)
+	bytesWritten, err := io.Copy(w, deltaFile)
+	if err != nil {
+		log.Printf("%s: Error returning delta: %v\n", pageName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// If downloaded by someone other than the owner, increment the download count for the database
+	if strings.ToLower(userAcc) != strings.ToLower(dbOwner) {
+		err = com.IncrementDownloadCount(dbOwner, dbFolder, dbName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Log the transfer
+	log.Printf("'%s%s%s' delta (%d changed pages) downloaded by user '%v', %v bytes", dbOwner, dbFolder, dbName,
+		changedPages, userAcc, bytesWritten)
+	return nil
+}
+
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	pageName := "Main page"
 
@@ -1349,6 +1831,306 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// Sends the current state of a resumable upload to the client, as JSON.  The number of bytes received so far is also
+// given in the "Upload-Offset" header.
+func sendUploadStatus(w http.ResponseWriter, upload com.UploadSession, status int) {
+	info := struct {
+		com.UploadSession
+		ChunkSize int64 `json:"chunk_size"`
+	}{
+		UploadSession: upload,
+		ChunkSize:     com.Conf.Upload.ChunkSize * 1024 * 1024,
+	}
+	jsonInfo, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when JSON marshalling the upload status: %v\n", err)
+		log.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", fmt.Sprint(upload.Received))
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(jsonInfo))
+}
+
+// Receives a chunk of data for a resumable upload.  The chunk is the request body, and the "offset" given needs to be
+// the number of bytes received so far for the upload.  An example curl command to simulate the request is:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -T chunk.bin \
+//       "https://db4s.dbhub.io:5550/upload/chunk?id=lcfi6i8ky3duhxbifnxmsjcvvxytzcxa&offset=0"
+//
+func uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Upload chunk handler"
+
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method != "PUT" {
+		http.Error(w, fmt.Sprintf("Unknown request type: %v\n", r.Method), http.StatusBadRequest)
+		return
+	}
+
+	// Validate the upload ID and offset
+	uploadID := r.URL.Query().Get("id")
+	err = com.ValidateUploadID(uploadID)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid upload offset", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the chunk isn't too large
+	maxChunk := com.Conf.Upload.ChunkSize * 1024 * 1024
+	if r.ContentLength > maxChunk {
+		http.Error(w, fmt.Sprintf("Chunk is too large.  Maximum chunk size is %d MB",
+			com.Conf.Upload.ChunkSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxChunk)
+
+	// Add the chunk to the upload
+	upload, err := com.AppendUploadChunk(userAcc, uploadID, offset, r.Body)
+	switch err {
+	case nil:
+		sendUploadStatus(w, upload, http.StatusOK)
+	case com.ErrUploadNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case com.ErrUploadOffset:
+		// Let the client know where it needs to continue from
+		sendUploadStatus(w, upload, http.StatusConflict)
+	case com.ErrUploadBusy:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case com.ErrUploadOverflow:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		log.Printf("%s: Receiving chunk for upload '%s' from '%s' failed: %v\n", pageName, uploadID, userAcc, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Finishes a resumable upload once all of its data has been received, adding the database to the system.  Any form
+// values given here override the ones given when the upload was started, which allows (for example) a "force" value
+// to be added if the upload turns out to be a collision.  An example curl command to simulate the request is:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -F "id=lcfi6i8ky3duhxbifnxmsjcvvxytzcxa" \
+//       https://db4s.dbhub.io:5550/upload/finish
+//
+func uploadFinishHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Upload finish handler"
+
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("Unknown request type: %v\n", r.Method), http.StatusBadRequest)
+		return
+	}
+
+	// Validate the upload ID
+	uploadID := r.FormValue("id")
+	err = com.ValidateUploadID(uploadID)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve the staged database
+	upload, staged, err := com.FinishUpload(userAcc, uploadID)
+	switch err {
+	case nil:
+	case com.ErrUploadNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case com.ErrUploadIncomplete:
+		sendUploadStatus(w, upload, http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer staged.File.Close()
+
+	// Use the form values from when the upload was started, overridden by any given now
+	form := url.Values(upload.FormValues)
+	if form == nil {
+		form = make(url.Values)
+	}
+	for k, v := range r.Form {
+		if k != "id" {
+			form[k] = v
+		}
+	}
+
+	r.Form = form
+	r.PostForm = form
+
+	// Add the database to the system.  The staged file is stored as it is, using the sha256 generated as the chunks
+	// arrived.  This checks again that the user is allowed to write to the database, as that may have changed since
+	// the upload was started
+	if !processUpload(w, r, pageName, userAcc, upload.DBOwner, upload.Folder, upload.DBName, staged) {
+		return
+	}
+
+	// The upload is complete, so remove its staged data
+	err = com.RemoveUpload(uploadID)
+	if err != nil {
+		log.Printf("%s: Removing finished upload '%s' failed: %v\n", pageName, uploadID, err)
+	}
+}
+
+// Starts a resumable upload.  This takes the same form values as a single part upload (see postHandler), along with
+// the name of the database ("dbname") and its total size in bytes ("size").  The database is uploaded to the user's
+// own namespace, unless a different owner is given ("dbowner").  An example curl command to simulate the request is:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -F "dbname=someupload.sqlite" -F "size=1073741824" \
+//       -F "branch=master" -F "commitmsg=stuff" -F "licence=CC0" -F "public=true" \
+//       https://db4s.dbhub.io:5550/upload/start
+//
+func uploadStartHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Upload start handler"
+
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("Unknown request type: %v\n", r.Method), http.StatusBadRequest)
+		return
+	}
+
+	// The "public" user isn't allowed to make changes
+	if userAcc == "public" {
+		log.Printf("User from '%s' attempted to start an upload using the public certificate", r.RemoteAddr)
+		http.Error(w, "You're using the 'public' certificate, which isn't allowed to make changes on the server",
+			http.StatusUnauthorized)
+		return
+	}
+
+	// Validate the database name
+	dbName := r.FormValue("dbname")
+	err = com.ValidateDB(dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate the database owner, if given
+	// TODO: Add support for folders
+	dbOwner := userAcc
+	dbFolder := "/"
+	if z := r.FormValue("dbowner"); z != "" {
+		err = com.ValidateUser(z)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbOwner = z
+	}
+
+	// Verify the user is uploading to a location they have write access for
+	allowed, err := com.CheckWriteAccess(userAcc, dbOwner, dbFolder, dbName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		log.Printf("%s: Attempt by '%s' to start an upload to unauthorised location: %s%s%s\n", pageName, userAcc,
+			dbOwner, dbFolder, dbName)
+		http.Error(w, fmt.Sprintf("Error code 401: You don't have write permission for '%s%s%s'", dbOwner, dbFolder,
+			dbName), http.StatusForbidden)
+		return
+	}
+
+	// Check whether the database will be too large
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "Invalid database size", http.StatusBadRequest)
+		return
+	}
+	if maxSize := com.MaxUploadSize(userAcc); maxSize > 0 && size > maxSize {
+		http.Error(w,
+			fmt.Sprintf("Database is too large. Maximum database upload size is %d MB, yours is %d MB",
+				maxSize/1024/1024, size/1024/1024), http.StatusBadRequest)
+		log.Println(fmt.Sprintf("'%s' attempted to start an upload of an oversized database %d MB in size.  "+
+			"Limit is %d MB\n", userAcc, size/1024/1024, maxSize/1024/1024))
+		return
+	}
+
+	// Make sure the user doesn't have too many uploads in progress, and the owner has room for the database
+	err = com.CheckUploadLimits(userAcc, dbOwner, size)
+	if _, ok := err.(com.UploadLimitError); ok {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the rest of the form values, for when the upload is finished
+	form := make(url.Values)
+	for k, v := range r.Form {
+		if k != "dbname" && k != "dbowner" && k != "size" {
+			form[k] = v
+		}
+	}
+
+	// Start the upload
+	upload, err := com.StartUpload(userAcc, dbOwner, dbFolder, dbName, size, form)
+	if err != nil {
+		log.Printf("%s: Starting upload for '%s' failed: %v\n", pageName, userAcc, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendUploadStatus(w, upload, http.StatusCreated)
+}
+
+// Returns the current state of a resumable upload, so a client can find out where to continue from after a dropped
+// connection.  An example curl command to simulate the request is:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -G https://db4s.dbhub.io:5550/upload/status?id=5f2b9c0e8d41a7763e0fb1d29a4c6e38
+//
+func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate the upload ID
+	uploadID := r.FormValue("id")
+	err = com.ValidateUploadID(uploadID)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve the upload details
+	upload, err := com.GetUploadSession(userAcc, uploadID)
+	if err == com.ErrUploadNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendUploadStatus(w, upload, http.StatusOK)
+}
+
 // Returns the list of databases available to the user.  To simulate, the following curl command can be used:
 //
 //   $ curl -kE ~/my.cert.pem -D headers.out -G https://db4s.dbhub.io:5550/someuser
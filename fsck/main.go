@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+func main() {
+	// Command line options
+	dbOwner := flag.String("owner", "", "Only check databases belonging to this user")
+	dbName := flag.String("db", "", "Only check the database with this name (requires -owner)")
+	repair := flag.Bool("repair", false, "Repair any problems which can be safely fixed")
+	flag.Parse()
+	if *dbName != "" && *dbOwner == "" {
+		log.Fatalf("The -db option needs the database owner to be given with -owner as well")
+	}
+
+	// Read server configuration
+	var err error
+	if err = com.ReadConfig(); err != nil {
+		log.Fatalf("Configuration file problem\n\n%v", err)
+	}
+
+	// Connect to Minio server
+	err = com.ConnectMinio()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	// Connect to PostgreSQL server
+	err = com.ConnectPostgreSQL()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	defer com.DisconnectPostgreSQL()
+
+	// Connect to the Memcached server
+	err = com.ConnectCache()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	// Work out which databases to check
+	dbList, err := com.AllDatabases()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	// Check each of the databases, reporting any problems found
+	var numChecked, numProblems, numRepaired int
+	for _, db := range dbList {
+		if *dbOwner != "" && strings.ToLower(db.Owner) != strings.ToLower(*dbOwner) {
+			continue
+		}
+		if *dbName != "" && db.DBName != *dbName {
+			continue
+		}
+		numChecked++
+
+		problems, err := com.Fsck(db.Owner, db.Folder, db.DBName, *repair)
+		if err != nil {
+			log.Printf("Checking database '%s%s%s' failed: %v\n", db.Owner, db.Folder, db.DBName, err)
+			numProblems++
+			continue
+		}
+		for _, p := range problems {
+			status := ""
+			if p.Repaired {
+				status = " (repaired)"
+				numRepaired++
+			}
+			fmt.Printf("%s%s%s: %s: %s%s\n", db.Owner, db.Folder, db.DBName, p.Type, p.Details, status)
+		}
+		numProblems += len(problems)
+	}
+
+	// Summary
+	fmt.Printf("%d database(s) checked, %d problem(s) found, %d repaired\n", numChecked, numProblems, numRepaired)
+	if numProblems > numRepaired {
+		com.DisconnectPostgreSQL()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,1290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite "github.com/gwenn/gosqlite"
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// The versioned JSON API.  All requests arrive at apiHandler(), which authenticates the caller then passes the
+// request on to the function for the resource being asked for:
+//
+//   GET    /api/v1/user
+//   GET    /api/v1/databases/{owner}
+//   GET    /api/v1/databases/{owner}/{database}
+//   POST   /api/v1/databases/{owner}/{database}                   (write scope)
+//   GET    /api/v1/databases/{owner}/{database}/branches
+//   GET    /api/v1/databases/{owner}/{database}/commits
+//   GET    /api/v1/databases/{owner}/{database}/discussions
+//   POST   /api/v1/databases/{owner}/{database}/discussions/{id}  (write scope)
+//   GET    /api/v1/databases/{owner}/{database}/download
+//   GET    /api/v1/databases/{owner}/{database}/labels
+//   POST   /api/v1/databases/{owner}/{database}/labels            (write scope)
+//   DELETE /api/v1/databases/{owner}/{database}/labels/{id}       (write scope)
+//   GET    /api/v1/databases/{owner}/{database}/milestones
+//   POST   /api/v1/databases/{owner}/{database}/milestones        (write scope)
+//   DELETE /api/v1/databases/{owner}/{database}/milestones/{id}   (write scope)
+//   GET    /api/v1/databases/{owner}/{database}/mrs
+//   POST   /api/v1/databases/{owner}/{database}/mrs/{id}          (write scope)
+//   GET    /api/v1/databases/{owner}/{database}/releases
+//   GET    /api/v1/databases/{owner}/{database}/statuses/{commit}
+//   POST   /api/v1/databases/{owner}/{database}/statuses/{commit} (write scope)
+//   GET    /api/v1/databases/{owner}/{database}/tables
+//   GET    /api/v1/databases/{owner}/{database}/tables/{table}
+//   GET    /api/v1/databases/{owner}/{database}/tags
+//   POST   /api/v1/databases/{owner}/{database}/tags              (write scope)
+//   DELETE /api/v1/databases/{owner}/{database}/tags/{tag}        (write scope)
+//
+// The discussion and merge request lists can be filtered with the "label", "assignee", "milestone", and "state"
+// parameters, and ordered with "sort" ("updated", "created", or "comments").
+//
+// Requests without a token, or with a token lacking the read scope, can only see public databases.  Errors are always
+// returned as a JSON object of the form {"error": {"status": 404, "message": "..."}}
+
+// The maximum number of table rows returned by a single request
+const apiMaxRows = 1000
+
+// Details of the user making an API request.  For anonymous requests the user name is empty
+type apiCaller struct {
+	Scopes   []com.APITokenScope
+	UserName string
+}
+
+// Returns true if the caller has the given scope
+func (c apiCaller) can(scope com.APITokenScope) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the user name to check read access to databases with.  Without the read scope this is empty, so only public
+// databases can be seen, the same as for requests without a token
+func (c apiCaller) reader() string {
+	if !c.can(com.API_SCOPE_READ) {
+		return ""
+	}
+	return c.UserName
+}
+
+// The database details returned by the API
+type apiDatabase struct {
+	Branches      int       `json:"branches"`
+	CommitID      string    `json:"commit_id"`
+	Contributors  int       `json:"contributors"`
+	DateCreated   time.Time `json:"date_created"`
+	DefaultBranch string    `json:"default_branch"`
+	DefaultTable  string    `json:"default_table,omitempty"`
+	Description   string    `json:"description"`
+	Discussions   int       `json:"discussions"`
+	Forks         int       `json:"forks"`
+	FullDesc      string    `json:"full_description,omitempty"`
+	LastModified  time.Time `json:"last_modified"`
+	MRs           int       `json:"merge_requests"`
+	Name          string    `json:"name"`
+	Owner         string    `json:"owner"`
+	Public        bool      `json:"public"`
+	Releases      int       `json:"releases"`
+	SHA256        string    `json:"sha256"`
+	Size          int64     `json:"size"`
+	SourceURL     string    `json:"source_url"`
+	Stars         int       `json:"stars"`
+	Tags          int       `json:"tags"`
+	URL           string    `json:"url"`
+	Watchers      int       `json:"watchers"`
+}
+
+// The error object returned by the API
+type apiError struct {
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+// Checks the caller is allowed to make changes to a database.  If not, an error response is sent and false returned
+func apiCheckWrite(w http.ResponseWriter, caller apiCaller, dbOwner string, dbFolder string, dbName string) bool {
+	if caller.UserName == "" {
+		apiErrorResponse(w, http.StatusUnauthorized, "An API token is needed to make changes")
+		return false
+	}
+	if !caller.can(com.API_SCOPE_WRITE) {
+		apiErrorResponse(w, http.StatusForbidden, "The API token doesn't have the write scope")
+		return false
+	}
+	allowed, err := com.CheckWriteAccess(caller.UserName, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !allowed {
+		apiErrorResponse(w, http.StatusForbidden, "You don't have write access to that database")
+		return false
+	}
+	return true
+}
+
+// Returns the commit ID requested by the "commit" or "branch" parameters of a request.  If neither was given then an
+// empty string is returned, which means the head of the default branch.
+func apiCommit(r *http.Request, dbOwner string, dbFolder string, dbName string) (commitID string, status int,
+	err error) {
+	if c := r.FormValue("commit"); c != "" {
+		err = com.ValidateCommitID(c)
+		if err != nil {
+			return "", http.StatusBadRequest, fmt.Errorf("Invalid commit ID: '%s'", c)
+		}
+		var commitList map[string]com.CommitEntry
+		commitList, err = com.GetCommitList(dbOwner, dbFolder, dbName)
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		if _, ok := commitList[c]; !ok {
+			return "", http.StatusNotFound, fmt.Errorf("Unknown commit ID: '%s'", c)
+		}
+		return c, http.StatusOK, nil
+	}
+	if b := r.FormValue("branch"); b != "" {
+		err = com.ValidateBranchName(b)
+		if err != nil {
+			return "", http.StatusBadRequest, fmt.Errorf("Invalid branch name: '%s'", b)
+		}
+		var branches map[string]com.BranchEntry
+		branches, err = com.GetBranches(dbOwner, dbFolder, dbName)
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		br, ok := branches[b]
+		if !ok {
+			return "", http.StatusNotFound, fmt.Errorf("Unknown branch: '%s'", b)
+		}
+		return br.Commit, http.StatusOK, nil
+	}
+	return "", http.StatusOK, nil
+}
+
+// Returns the commit history of a branch, newest commit first
+func apiCommits(w http.ResponseWriter, r *http.Request, dbOwner string, dbFolder string, dbName string) {
+	branchName := r.FormValue("branch")
+	if branchName == "" {
+		var err error
+		branchName, err = com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
+		if err != nil {
+			apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	err := com.ValidateBranchName(branchName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid branch name: '%s'", branchName))
+		return
+	}
+	branches, err := com.GetBranches(dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	br, ok := branches[branchName]
+	if !ok {
+		apiErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown branch: '%s'", branchName))
+		return
+	}
+	commitList, err := com.GetCommitList(dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Walk the branch history, starting from the head commit
+	history := []com.CommitEntry{}
+	for c, ok := commitList[br.Commit]; ok; c, ok = commitList[c.Parent] {
+		history = append(history, c)
+		if c.Parent == "" {
+			break
+		}
+	}
+	apiResponse(w, http.StatusOK, history)
+}
+
+// Returns the status checks for a commit
+func apiCommitStatuses(w http.ResponseWriter, r *http.Request, dbOwner string, dbFolder string, dbName string,
+	commitID string) {
+	if com.ValidateCommitID(commitID) != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid commit ID")
+		return
+	}
+	statuses, err := com.CommitStatuses(dbOwner, dbFolder, dbName, commitID)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	apiResponse(w, http.StatusOK, statuses)
+}
+
+// Creates a new tag for a database
+func apiCreateTag(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+
+	// Validate the tag name and description
+	tagName := r.FormValue("name")
+	if tagName == "" {
+		apiErrorResponse(w, http.StatusBadRequest, "Missing tag name")
+		return
+	}
+	err := com.ValidateBranchName(tagName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid tag name: '%s'", tagName))
+		return
+	}
+	tagDesc := r.FormValue("description")
+	if tagDesc != "" {
+		err = com.Validate.Var(tagDesc, "markdownsource")
+		if err != nil {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid characters in tag description")
+			return
+		}
+	}
+
+	// Work out the commit to tag
+	commitID, status, err := apiCommit(r, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	if commitID == "" {
+		commitID, err = com.DefaultCommit(dbOwner, dbFolder, dbName)
+		if err != nil {
+			apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Make sure the tag doesn't already exist
+	tags, err := com.GetTags(dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, ok := tags[tagName]; ok {
+		apiErrorResponse(w, http.StatusConflict, "A tag of that name already exists")
+		return
+	}
+
+	// Create the tag
+	usr, err := com.User(caller.UserName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, "An error occurred when retrieving user details")
+		return
+	}
+	newTag := com.TagEntry{
+		Commit:      commitID,
+		Date:        time.Now(),
+		Description: tagDesc,
+		TaggerEmail: usr.Email,
+		TaggerName:  usr.DisplayName,
+	}
+	tags[tagName] = newTag
+	err = com.StoreTags(dbOwner, dbFolder, dbName, tags)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_TAG_CREATE, nil,
+		map[string]string{"tag": tagName, "commit": commitID, "description": tagDesc})
+
+	// Generate an event about the new tag
+	details := com.EventDetails{
+		DBName:   dbName,
+		Folder:   dbFolder,
+		Message:  tagDesc,
+		Owner:    dbOwner,
+		Title:    tagName,
+		Type:     com.EVENT_NEW_TAG,
+		URL:      fmt.Sprintf("/tags/%s%s%s", dbOwner, dbFolder, dbName),
+		UserName: caller.UserName,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		log.Printf("Error when creating a new event: %s\n", err.Error())
+	}
+
+	// Invalidate the memcache data for the database, so the new tag count gets picked up
+	err = com.InvalidateCacheEntry(caller.UserName, dbOwner, dbFolder, dbName, "")
+	if err != nil {
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	}
+	apiResponse(w, http.StatusCreated, newTag)
+}
+
+// Returns the details of a database
+func apiDatabaseDetails(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string) {
+	commitID, status, err := apiCommit(r, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	var db com.SQLiteDBinfo
+	err = com.DBDetails(&db, caller.UserName, dbOwner, dbFolder, dbName, commitID)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	apiResponse(w, http.StatusOK, apiDatabaseInfo(dbOwner, db.Info))
+}
+
+// Converts the internal database details to those returned by the API
+func apiDatabaseInfo(dbOwner string, db com.DBInfo) apiDatabase {
+	return apiDatabase{
+		Branches:      db.Branches,
+		CommitID:      db.CommitID,
+		Contributors:  db.Contributors,
+		DateCreated:   db.DateCreated,
+		DefaultBranch: db.DefaultBranch,
+		DefaultTable:  db.DefaultTable,
+		Description:   db.OneLineDesc,
+		Discussions:   db.Discussions,
+		Forks:         db.Forks,
+		FullDesc:      db.FullDesc,
+		LastModified:  db.RepoModified,
+		MRs:           db.MRs,
+		Name:          db.Database,
+		Owner:         dbOwner,
+		Public:        db.Public,
+		Releases:      db.Releases,
+		SHA256:        db.DBEntry.Sha256,
+		Size:          db.DBEntry.Size,
+		SourceURL:     db.SourceURL,
+		Stars:         db.Stars,
+		Tags:          db.Tags,
+		URL:           fmt.Sprintf("https://%s/%s%s%s", com.Conf.Web.ServerName, dbOwner, db.Folder, db.Database),
+		Watchers:      db.Watchers,
+	}
+}
+
+// Returns the list of databases for a user
+func apiDatabaseList(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string) {
+	if r.Method != http.MethodGet {
+		apiMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	err := com.ValidateUser(dbOwner)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid user name")
+		return
+	}
+	exists, err := com.CheckUserExists(dbOwner)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		apiErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown user '%s'", dbOwner))
+		return
+	}
+
+	// Private databases are only included when the caller can read them, the same as on the web pages
+	access := com.DB_PUBLIC
+	if caller.reader() != "" {
+		access = com.DB_BOTH
+	}
+	dbList, err := com.UserDBs(dbOwner, access)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	list := []apiDatabase{}
+	for _, db := range dbList {
+		if !db.Public {
+			perm, err := com.DBPermission(caller.reader(), dbOwner, db.Folder, db.Database)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if perm < com.PERM_READ {
+				continue
+			}
+		}
+		list = append(list, apiDatabaseInfo(dbOwner, db))
+	}
+	apiResponse(w, http.StatusOK, list)
+}
+
+// Handles the requests for a specific database, and the resources belonging to it
+func apiDatabaseRequest(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbName string,
+	resource []string) {
+	// TODO: Add folder support
+	dbFolder := "/"
+
+	// Validate the owner and database name, and make sure the caller can see the database.  Reading private databases
+	// needs the read scope, whereas requests making changes are checked for the write scope later on
+	err := com.ValidateUserDB(dbOwner, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid owner or database name")
+		return
+	}
+	visibleTo := caller.UserName
+	if r.Method == http.MethodGet {
+		visibleTo = caller.reader()
+	}
+	exists, err := com.CheckDBExists(visibleTo, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		// Uploading a new database is the one case where it's fine for it not to exist yet
+		if len(resource) != 0 || r.Method != http.MethodPost {
+			apiErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner,
+				dbFolder, dbName))
+			return
+		}
+	}
+
+	// The database itself
+	if len(resource) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			apiDatabaseDetails(w, r, caller, dbOwner, dbFolder, dbName)
+		case http.MethodPost:
+			apiUpload(w, r, caller, dbOwner, dbFolder, dbName, exists)
+		default:
+			apiMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	// Resources belonging to the database
+	switch {
+	case len(resource) == 2 && resource[0] == "tables":
+		if r.Method != http.MethodGet {
+			apiMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		apiTableData(w, r, caller, dbOwner, dbFolder, dbName, resource[1])
+	case len(resource) == 2 && resource[0] == "tags":
+		if r.Method != http.MethodDelete {
+			apiMethodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		apiDeleteTag(w, r, caller, dbOwner, dbFolder, dbName, resource[1])
+	case len(resource) == 2 && resource[0] == "labels":
+		if r.Method != http.MethodDelete {
+			apiMethodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		apiDeleteLabel(w, r, caller, dbOwner, dbFolder, dbName, resource[1])
+	case len(resource) == 2 && resource[0] == "milestones":
+		if r.Method != http.MethodDelete {
+			apiMethodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		apiDeleteMilestone(w, r, caller, dbOwner, dbFolder, dbName, resource[1])
+	case len(resource) == 2 && resource[0] == "statuses":
+		switch r.Method {
+		case http.MethodGet:
+			apiCommitStatuses(w, r, dbOwner, dbFolder, dbName, resource[1])
+		case http.MethodPost:
+			apiSaveCommitStatus(w, r, caller, dbOwner, dbFolder, dbName, resource[1])
+		default:
+			apiMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(resource) == 2 && (resource[0] == "discussions" || resource[0] == "mrs"):
+		if r.Method != http.MethodPost {
+			apiMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		apiDiscussionTracking(w, r, caller, dbOwner, dbFolder, dbName, resource[1])
+	case len(resource) == 1 && resource[0] == "labels" && r.Method == http.MethodPost:
+		apiSaveLabel(w, r, caller, dbOwner, dbFolder, dbName)
+	case len(resource) == 1 && resource[0] == "milestones" && r.Method == http.MethodPost:
+		apiSaveMilestone(w, r, caller, dbOwner, dbFolder, dbName)
+	case len(resource) == 1 && resource[0] == "tags" && r.Method == http.MethodPost:
+		apiCreateTag(w, r, caller, dbOwner, dbFolder, dbName)
+	case len(resource) == 1:
+		if r.Method != http.MethodGet {
+			if resource[0] == "labels" || resource[0] == "milestones" || resource[0] == "tags" {
+				apiMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+			} else {
+				apiMethodNotAllowed(w, http.MethodGet)
+			}
+			return
+		}
+		switch resource[0] {
+		case "branches":
+			branches, err := com.GetBranches(dbOwner, dbFolder, dbName)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			apiResponse(w, http.StatusOK, branches)
+		case "commits":
+			apiCommits(w, r, dbOwner, dbFolder, dbName)
+		case "discussions":
+			apiDiscussions(w, r, dbOwner, dbFolder, dbName, com.DISCUSSION)
+		case "download":
+			apiDownload(w, r, caller, dbOwner, dbFolder, dbName)
+		case "labels":
+			labels, _, err := trackingLists(dbOwner, dbFolder, dbName)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			apiResponse(w, http.StatusOK, labels)
+		case "milestones":
+			_, milestones, err := trackingLists(dbOwner, dbFolder, dbName)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			apiResponse(w, http.StatusOK, milestones)
+		case "mrs":
+			apiDiscussions(w, r, dbOwner, dbFolder, dbName, com.MERGE_REQUEST)
+		case "releases":
+			rels, err := com.GetReleases(dbOwner, dbFolder, dbName)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			apiResponse(w, http.StatusOK, rels)
+		case "tables":
+			apiTables(w, r, caller, dbOwner, dbFolder, dbName)
+		case "tags":
+			tags, err := com.GetTags(dbOwner, dbFolder, dbName)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			apiResponse(w, http.StatusOK, tags)
+		default:
+			apiErrorResponse(w, http.StatusNotFound, "Unknown API end point")
+		}
+	default:
+		apiErrorResponse(w, http.StatusNotFound, "Unknown API end point")
+	}
+}
+
+// Removes a discussion label from a database
+func apiDeleteLabel(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string, id string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	labelID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid label id: '%s'", id))
+		return
+	}
+	err = com.DeleteDiscussionLabel(dbOwner, dbFolder, dbName, labelID)
+	if err != nil {
+		apiErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_LABEL_DELETE,
+		map[string]int64{"id": labelID}, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Removes a milestone from a database
+func apiDeleteMilestone(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string, id string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	milestoneID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid milestone id: '%s'", id))
+		return
+	}
+	err = com.DeleteMilestone(dbOwner, dbFolder, dbName, milestoneID)
+	if err != nil {
+		apiErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_MILESTONE_DELETE,
+		map[string]int64{"id": milestoneID}, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Removes a tag from a database
+func apiDeleteTag(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string, tagName string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	err := com.ValidateBranchName(tagName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid tag name: '%s'", tagName))
+		return
+	}
+	tags, err := com.GetTags(dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	oldTag, ok := tags[tagName]
+	if !ok {
+		apiErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown tag: '%s'", tagName))
+		return
+	}
+	delete(tags, tagName)
+	err = com.StoreTags(dbOwner, dbFolder, dbName, tags)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_TAG_DELETE,
+		map[string]string{"tag": tagName, "commit": oldTag.Commit, "description": oldTag.Description}, nil)
+
+	// Invalidate the memcache data for the database, so the new tag count gets picked up
+	err = com.InvalidateCacheEntry(caller.UserName, dbOwner, dbFolder, dbName, "")
+	if err != nil {
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Sets the labels, assignees, and milestone of a discussion or merge request
+func apiDiscussionTracking(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string,
+	dbFolder string, dbName string, id string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	discID, err := strconv.Atoi(id)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid discussion id: '%s'", id))
+		return
+	}
+	labels, assignees, milestoneID, err := trackingFromForm(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	disc, status, err := storeDiscussionTracking(dbOwner, dbFolder, dbName, discID, labels, assignees, milestoneID)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_DISCUSSION_TRIAGE, nil,
+		map[string]interface{}{"discussion": discID, "labels": labels, "assignees": assignees,
+			"milestone": milestoneID})
+	apiResponse(w, http.StatusOK, disc)
+}
+
+// Returns the discussions or merge requests for a database
+func apiDiscussions(w http.ResponseWriter, r *http.Request, dbOwner string, dbFolder string, dbName string,
+	discType com.DiscussionType) {
+	filter, err := discussionFilterFromForm(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := com.FilteredDiscussions(dbOwner, dbFolder, dbName, discType, 0, filter)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if list == nil {
+		list = []com.DiscussionEntry{}
+	}
+	apiResponse(w, http.StatusOK, list)
+}
+
+// Sends a database file to the caller
+func apiDownload(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string) {
+	commitID, status, err := apiCommit(r, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	bucket, id, _, err := com.MinioLocation(dbOwner, dbFolder, dbName, commitID, caller.UserName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if id == "" {
+		apiErrorResponse(w, http.StatusNotFound, "Database file not found")
+		return
+	}
+	userDB, err := com.MinioHandle(bucket, id)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer com.MinioHandleClose(userDB)
+	stat, err := userDB.Stat()
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Make a record of the download
+	err = com.LogDownload(dbOwner, dbFolder, dbName, caller.UserName, r.RemoteAddr, "api", r.UserAgent(),
+		time.Now(), bucket+id)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Send the database
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, dbName))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size))
+	w.Header().Set("Content-Type", "application/x-sqlite3")
+	bytesWritten, err := io.Copy(w, userDB)
+	if err != nil {
+		log.Printf("API: Error returning DB file: %v\n", err)
+		return
+	}
+
+	// If downloaded by someone other than the owner, increment the download count for the database
+	if strings.ToLower(caller.UserName) != strings.ToLower(dbOwner) {
+		err = com.IncrementDownloadCount(dbOwner, dbFolder, dbName)
+		if err != nil {
+			log.Printf("API: Error incrementing download count for '%s%s%s': %v\n", dbOwner, dbFolder, dbName,
+				err)
+		}
+	}
+	log.Printf("API: '%s%s%s' downloaded. %d bytes", dbOwner, dbFolder, dbName, bytesWritten)
+}
+
+// Sends an error response in the standard API format
+func apiErrorResponse(w http.ResponseWriter, status int, msg string) {
+	var resp struct {
+		Error apiError `json:"error"`
+	}
+	resp.Error = apiError{Message: msg, Status: status}
+	apiResponse(w, status, resp)
+}
+
+// Entry point for the API.  Authenticates the caller, then works out which resource is being requested
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	// Authenticate the caller
+	var caller apiCaller
+	token := r.Header.Get("Authorization")
+	if token != "" {
+		var err error
+		switch {
+		case strings.HasPrefix(token, "token "):
+			token = strings.TrimPrefix(token, "token ")
+		case strings.HasPrefix(token, "Bearer "):
+			token = strings.TrimPrefix(token, "Bearer ")
+		default:
+			apiErrorResponse(w, http.StatusUnauthorized, "Unknown authorization type")
+			return
+		}
+		caller.UserName, caller.Scopes, err = com.APITokenUser(com.HashAPIToken(strings.TrimSpace(token)))
+		if err != nil {
+			apiErrorResponse(w, http.StatusInternalServerError, "Error when checking the API token")
+			return
+		}
+		if caller.UserName == "" {
+			apiErrorResponse(w, http.StatusUnauthorized, "Invalid API token")
+			return
+		}
+	}
+
+	// Split the request path into its (unescaped) components
+	var resource []string
+	for _, p := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/api/v1/"), "/"), "/") {
+		s, err := url.PathUnescape(p)
+		if err != nil {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid URL")
+			return
+		}
+		resource = append(resource, s)
+	}
+
+	switch {
+	case len(resource) == 1 && resource[0] == "user":
+		apiUser(w, r, caller)
+	case len(resource) == 2 && resource[0] == "databases":
+		apiDatabaseList(w, r, caller, resource[1])
+	case len(resource) >= 3 && resource[0] == "databases":
+		apiDatabaseRequest(w, r, caller, resource[1], resource[2], resource[3:])
+	default:
+		apiErrorResponse(w, http.StatusNotFound, "Unknown API end point")
+	}
+}
+
+// Returns true if a commit is part of a database, or of one of its merge requests
+func apiKnownCommit(dbOwner string, dbFolder string, dbName string, commitID string) (bool, error) {
+	commits, err := com.GetCommitList(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := commits[commitID]; ok {
+		return true, nil
+	}
+	mrs, err := com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, 0)
+	if err != nil {
+		return false, err
+	}
+	for _, mr := range mrs {
+		for _, c := range mr.MRDetails.Commits {
+			if c.ID == commitID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Sends a "method not allowed" error, listing the methods which are allowed in the "Allow" header
+func apiMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	apiErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// Opens the SQLite database for the commit requested by the caller
+func apiOpenDatabase(r *http.Request, caller apiCaller, dbOwner string, dbFolder string, dbName string) (
+	sdb *sqlite.Conn, status int, err error) {
+	commitID, status, err := apiCommit(r, dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	bucket, id, _, err := com.MinioLocation(dbOwner, dbFolder, dbName, commitID, caller.UserName)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if id == "" {
+		return nil, http.StatusNotFound, errors.New("Database file not found")
+	}
+	sdb, err = com.OpenMinioObject(bucket, id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error when opening the database")
+	}
+	return sdb, http.StatusOK, nil
+}
+
+// Sends a JSON response
+func apiResponse(w http.ResponseWriter, status int, data interface{}) {
+	jsonResponse, err := json.MarshalIndent(data, "", " ")
+	if err != nil {
+		log.Printf("API: Error when JSON encoding response: %v\n", err)
+		http.Error(w, `{"error": {"status": 500, "message": "Error when encoding response"}}`,
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\n", jsonResponse)
+}
+
+// Adds or updates a status check for a commit.  This is how external runners report their results
+func apiSaveCommitStatus(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string, commitID string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	if com.ValidateCommitID(commitID) != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid commit ID")
+		return
+	}
+	known, err := apiKnownCommit(dbOwner, dbFolder, dbName, commitID)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !known {
+		apiErrorResponse(w, http.StatusNotFound, "Unknown commit ID")
+		return
+	}
+
+	// Validate the status check details
+	s := com.CommitStatus{
+		CommitID:    commitID,
+		Context:     r.PostFormValue("context"),
+		Description: r.PostFormValue("description"),
+		State:       com.StatusCheckState(r.PostFormValue("state")),
+		TargetURL:   r.PostFormValue("target_url"),
+	}
+	if com.ValidateStatusContext(s.Context) != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid status check name")
+		return
+	}
+	if strings.HasPrefix(s.Context, com.SQLAssertionContext("")) {
+		apiErrorResponse(w, http.StatusBadRequest,
+			"Status check names starting with 'sql/' are reserved for SQL assertions")
+		return
+	}
+	if s.State != com.CHECK_PENDING && s.State != com.CHECK_SUCCESS && s.State != com.CHECK_FAILURE {
+		apiErrorResponse(w, http.StatusBadRequest, "The state needs to be 'pending', 'success', or 'failure'")
+		return
+	}
+	if len(s.Description) > 255 {
+		apiErrorResponse(w, http.StatusBadRequest, "The description is too long")
+		return
+	}
+	if s.TargetURL != "" {
+		u, err := url.Parse(s.TargetURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(s.TargetURL) > 1024 {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid target URL")
+			return
+		}
+	}
+
+	// Save the status check
+	err = com.StoreCommitStatus(dbOwner, dbFolder, dbName, caller.UserName, s)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_COMMIT_STATUS, nil, s)
+	s.Creator = caller.UserName
+	s.DateUpdated = time.Now()
+	apiResponse(w, http.StatusOK, s)
+}
+
+// Adds or updates a discussion label
+func apiSaveLabel(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	label, err := labelFromForm(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if label.ID == 0 {
+		status = http.StatusCreated
+	}
+	label.ID, err = com.StoreDiscussionLabel(dbOwner, dbFolder, dbName, label)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_LABEL_SAVE, nil, label)
+	apiResponse(w, status, label)
+}
+
+// Adds or updates a milestone
+func apiSaveMilestone(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+	m, status, err := milestoneFromForm(r, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	if m.ID == 0 {
+		status = http.StatusCreated
+	}
+	m.ID, err = com.StoreMilestone(dbOwner, dbFolder, dbName, m)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "api", caller.UserName, dbOwner, dbFolder, dbName, com.AUDIT_MILESTONE_SAVE, nil, m)
+	apiResponse(w, status, m)
+}
+
+// Returns the data in a table or view
+func apiTableData(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string, table string) {
+	// Validate the paging and sorting parameters
+	maxRows := com.DefaultNumDisplayRows
+	if z := r.FormValue("limit"); z != "" {
+		l, err := strconv.Atoi(z)
+		if err != nil || l < 1 {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid limit value")
+			return
+		}
+		maxRows = l
+		if maxRows > apiMaxRows {
+			maxRows = apiMaxRows
+		}
+	}
+	var rowOffset int
+	if z := r.FormValue("offset"); z != "" {
+		o, err := strconv.Atoi(z)
+		if err != nil || o < 0 {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid offset value")
+			return
+		}
+		rowOffset = o
+	}
+	sortCol := r.FormValue("sort")
+	if sortCol != "" {
+		err := com.ValidateFieldName(sortCol)
+		if err != nil {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid sort column name")
+			return
+		}
+	}
+	sortDir := strings.ToUpper(r.FormValue("dir"))
+	if sortDir != "" && sortDir != "ASC" && sortDir != "DESC" {
+		apiErrorResponse(w, http.StatusBadRequest, "The sort direction needs to be either 'asc' or 'desc'")
+		return
+	}
+
+	// Open the database
+	sdb, status, err := apiOpenDatabase(r, caller, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	defer sdb.Close()
+
+	// Make sure the table and sort column exist
+	tables, err := com.Tables(sdb, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, "Error when reading the list of tables")
+		return
+	}
+	found := false
+	for _, t := range tables {
+		if t == table {
+			found = true
+		}
+	}
+	if !found {
+		apiErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown table: '%s'", table))
+		return
+	}
+	if sortCol != "" {
+		colList, err := sdb.Columns("", table)
+		if err != nil {
+			apiErrorResponse(w, http.StatusInternalServerError, "Error when reading the list of columns")
+			return
+		}
+		found = false
+		for _, c := range colList {
+			if c.Name == sortCol {
+				found = true
+			}
+		}
+		if !found {
+			apiErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Unknown sort column: '%s'", sortCol))
+			return
+		}
+	}
+
+	// Read the data
+	dataRows, err := com.ReadSQLiteDB(sdb, table, maxRows, sortCol, sortDir, rowOffset)
+	if err != nil {
+		log.Printf("API: Error occurred when reading table data for '%s%s%s': %s\n", dbOwner, dbFolder, dbName,
+			err.Error())
+		apiErrorResponse(w, http.StatusInternalServerError, "Error when reading the table data")
+		return
+	}
+	dataRows.TotalRows, err = com.GetSQLiteRowCount(sdb, table)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, "Error when counting the table rows")
+		return
+	}
+	apiResponse(w, http.StatusOK, dataRows)
+}
+
+// Returns the list of tables and views in a database
+func apiTables(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string) {
+	sdb, status, err := apiOpenDatabase(r, caller, dbOwner, dbFolder, dbName)
+	if err != nil {
+		apiErrorResponse(w, status, err.Error())
+		return
+	}
+	defer sdb.Close()
+	tables, err := com.Tables(sdb, dbName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, "Error when reading the list of tables")
+		return
+	}
+	if tables == nil {
+		tables = []string{}
+	}
+	apiResponse(w, http.StatusOK, tables)
+}
+
+// Adds a new database, or a new commit to an existing one
+func apiUpload(w http.ResponseWriter, r *http.Request, caller apiCaller, dbOwner string, dbFolder string,
+	dbName string, exists bool) {
+	if !apiCheckWrite(w, caller, dbOwner, dbFolder, dbName) {
+		return
+	}
+
+	// Limit the size of the upload
+	maxSize := com.MaxUploadSize(caller.UserName)
+	if maxSize > 0 {
+		if r.ContentLength > maxSize {
+			apiErrorResponse(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Database is too large. Maximum database upload size is %d MB", maxSize/1024/1024))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "The upload needs to be multipart/form-data")
+		return
+	}
+	dbFile, _, err := r.FormFile("file")
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Database file missing from upload data")
+		return
+	}
+	defer dbFile.Close()
+
+	// Validate the optional fields
+	branchName, err := com.GetFormBranch(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid branch name")
+		return
+	}
+	commitID, err := com.GetFormCommit(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Invalid commit ID")
+		return
+	}
+	licenceName, err := com.GetFormLicence(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Validation failed for licence value")
+		return
+	}
+	sourceURL, err := com.GetFormSourceURL(r)
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, "Validation failed for source URL value")
+		return
+	}
+	commitMsg := r.FormValue("commitmsg")
+	if commitMsg != "" {
+		err = com.ValidateMarkdown(commitMsg)
+		if err != nil {
+			apiErrorResponse(w, http.StatusBadRequest, "Validation failed for the commit message")
+			return
+		}
+	}
+	var public bool
+	if z := r.FormValue("public"); z != "" {
+		public, err = strconv.ParseBool(z)
+		if err != nil {
+			apiErrorResponse(w, http.StatusBadRequest, "Invalid public value")
+			return
+		}
+	}
+
+	// New commits for an existing database need to be based on the head of the branch they're for.  This stops
+	// changes made by someone else in the meantime from being silently thrown away.  The branch head is checked here
+	// to give a clear error early on, and again when the new commit is stored, in case of a push in between
+	if exists {
+		if branchName == "" {
+			branchName, err = com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		branches, err := com.GetBranches(dbOwner, dbFolder, dbName)
+		if err != nil {
+			apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		br, ok := branches[branchName]
+		if !ok {
+			apiErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown branch: '%s'", branchName))
+			return
+		}
+		if commitID == "" {
+			apiErrorResponse(w, http.StatusBadRequest, "The commit ID of the branch head is needed when "+
+				"adding a commit to an existing database")
+			return
+		}
+		if commitID != br.Commit {
+			apiErrorResponse(w, http.StatusConflict, fmt.Sprintf("Commit '%s' isn't the head of branch '%s'",
+				commitID, branchName))
+			return
+		}
+		protection, err := com.GetBranchProtection(dbOwner, dbFolder, dbName)
+		if err != nil {
+			apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if protection[branchName].RequireMR {
+			apiErrorResponse(w, http.StatusForbidden, fmt.Sprintf("Branch '%s' is protected.  Changes to it "+
+				"need to be made through a merge request", branchName))
+			return
+		}
+
+		// Keep the existing public/private setting unless a new one was given
+		if r.FormValue("public") == "" {
+			var db com.SQLiteDBinfo
+			err = com.DBDetails(&db, caller.UserName, dbOwner, dbFolder, dbName, "")
+			if err != nil {
+				apiErrorResponse(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			public = db.Info.Public
+		}
+	}
+
+	// Add the database to the system
+	numBytes, newCommit, err := com.AddDatabase(r, caller.UserName, dbOwner, dbFolder, dbName, false, branchName,
+		commitID, false, public, licenceName, commitMsg, sourceURL, dbFile, "api", time.Now(), time.Time{}, "", "",
+		"", "", nil, "")
+	if err == com.ErrBranchMoved {
+		apiErrorResponse(w, http.StatusConflict, fmt.Sprintf("Commit '%s' isn't the head of branch '%s'",
+			commitID, branchName))
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("API: Username: '%s', database '%s%s%s' uploaded', bytes: %v\n", caller.UserName, dbOwner,
+		dbFolder, dbName, numBytes)
+
+	var resp struct {
+		CommitID string `json:"commit_id"`
+		URL      string `json:"url"`
+	}
+	resp.CommitID = newCommit
+	resp.URL = fmt.Sprintf("https://%s/%s%s%s?commit=%s", com.Conf.Web.ServerName, dbOwner, dbFolder, dbName,
+		newCommit)
+	apiResponse(w, http.StatusCreated, resp)
+}
+
+// Returns the details of the user the API token belongs to
+func apiUser(w http.ResponseWriter, r *http.Request, caller apiCaller) {
+	if r.Method != http.MethodGet {
+		apiMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if caller.UserName == "" {
+		apiErrorResponse(w, http.StatusUnauthorized, "An API token is needed for this request")
+		return
+	}
+	usr, err := com.User(caller.UserName)
+	if err != nil {
+		apiErrorResponse(w, http.StatusInternalServerError, "An error occurred when retrieving user details")
+		return
+	}
+	var resp struct {
+		AvatarURL   string              `json:"avatar_url"`
+		DateJoined  time.Time           `json:"date_joined"`
+		DisplayName string              `json:"display_name"`
+		Scopes      []com.APITokenScope `json:"scopes"`
+		UserName    string              `json:"username"`
+	}
+	resp.AvatarURL = usr.AvatarURL
+	resp.DateJoined = usr.DateJoined
+	resp.DisplayName = usr.DisplayName
+	resp.Scopes = caller.Scopes
+	resp.UserName = usr.Username
+	apiResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// The maximum number of entries in a feed
+const feedLength = 50
+
+// The actions included in the activity feed of a user, along with how they're described
+var feedActions = map[com.AuditAction]string{
+	com.AUDIT_COMMENT_CREATE:    "commented on a discussion of",
+	com.AUDIT_COMMIT_CREATE:     "pushed a commit to",
+	com.AUDIT_DATABASE_CREATE:   "created",
+	com.AUDIT_DATABASE_FORK:     "forked",
+	com.AUDIT_DISCUSSION_CREATE: "started a discussion on",
+	com.AUDIT_MR_CREATE:         "opened a merge request on",
+	com.AUDIT_MR_MERGE:          "merged a merge request into",
+	com.AUDIT_RELEASE_CREATE:    "created a release of",
+	com.AUDIT_TAG_CREATE:        "created a tag on",
+}
+
+// An Atom feed, as described in RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Entries []atomEntry `xml:"entry"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Title   string      `xml:"title"`
+	Updated time.Time   `xml:"updated"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Author    atomAuthor `xml:"author"`
+	Content   *atomText  `xml:"content,omitempty"`
+	ID        string     `xml:"id"`
+	Link      atomLink   `xml:"link"`
+	Published *time.Time `xml:"published,omitempty"`
+	Title     string     `xml:"title"`
+	Updated   time.Time  `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomText struct {
+	Body string `xml:",chardata"`
+	Type string `xml:"type,attr"`
+}
+
+// Serves the Atom feeds.  These are:
+//
+//   /feeds/new                            - Recently updated public databases
+//   /feeds/user/{user}                    - The activity of a user on public databases
+//   /feeds/commits/{owner}/{database}     - The commits on a branch of a database (the default branch, unless given)
+//   /feeds/discussions/{owner}/{database} - The discussions and merge requests of a database
+//   /feeds/releases/{owner}/{database}    - The releases and tags of a database
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any).  Feed readers generally aren't logged in, but people viewing the feed of a
+	// private database in their browser are
+	var loggedInUser string
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+	}
+
+	pathStrings := strings.Split(r.URL.Path, "/")
+	if len(pathStrings) < 3 {
+		errorPage(w, r, http.StatusNotFound, "Unknown feed")
+		return
+	}
+	var feed atomFeed
+	var err error
+	public := true
+	switch pathStrings[2] {
+	case "new":
+		feed, err = newDatabasesFeed()
+	case "user":
+		if len(pathStrings) < 4 {
+			errorPage(w, r, http.StatusNotFound, "Unknown feed")
+			return
+		}
+		userName := pathStrings[3]
+		err = com.ValidateUser(userName)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid user name")
+			return
+		}
+		var exists bool
+		exists, err = com.CheckUserExists(userName)
+		if err == nil && !exists {
+			errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Unknown user: %s", userName))
+			return
+		}
+		if err == nil {
+			feed, err = userActivityFeed(userName)
+		}
+	case "commits", "discussions", "releases":
+		// Retrieve the database owner & name
+		// TODO: Add folder support
+		dbFolder := "/"
+		var dbOwner, dbName string
+		dbOwner, dbName, err = com.GetOD(2, r) // 2 = Ignore "/feeds/{type}/" at the start of the URL
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Private databases only have feeds for the people allowed to see them
+		var exists bool
+		exists, err = com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !exists {
+			errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
+				dbName))
+			return
+		}
+
+		// Feeds of private databases mustn't be kept by shared caches, as they'd then be served to anyone
+		public, err = com.CheckDBExists("", dbOwner, dbFolder, dbName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		switch pathStrings[2] {
+		case "commits":
+			// If no branch name was given, we use the default branch
+			branchName := r.FormValue("branch")
+			if branchName == "" {
+				branchName, err = com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
+				if err != nil {
+					errorPage(w, r, http.StatusInternalServerError, err.Error())
+					return
+				}
+			}
+			err = com.ValidateBranchName(branchName)
+			if err != nil {
+				errorPage(w, r, http.StatusBadRequest, "Validation failed for branch name")
+				return
+			}
+			var branches map[string]com.BranchEntry
+			branches, err = com.GetBranches(dbOwner, dbFolder, dbName)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			branch, ok := branches[branchName]
+			if !ok {
+				errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Branch '%s' not found", branchName))
+				return
+			}
+			feed, err = commitsFeed(dbOwner, dbFolder, dbName, branchName, branch.Commit)
+		case "discussions":
+			feed, err = discussionsFeed(dbOwner, dbFolder, dbName)
+		case "releases":
+			feed, err = releasesFeed(dbOwner, dbFolder, dbName)
+		}
+	default:
+		errorPage(w, r, http.StatusNotFound, "Unknown feed")
+		return
+	}
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeFeed(w, r, feed, public)
+}
+
+// Returns the feed of commits on a branch of a database, starting from the head commit of the branch.
+func commitsFeed(dbOwner string, dbFolder string, dbName string, branchName string, headID string) (feed atomFeed,
+	err error) {
+	commitList, err := com.GetCommitList(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	refTypes, _ := com.DiscussionTypes(dbOwner, dbFolder, dbName)
+
+	// Walk the branch back from its head commit
+	dbPath := fmt.Sprintf("%s%s%s", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName))
+	feed = newFeed(fmt.Sprintf("Commits on branch '%s' of %s%s%s", branchName, dbOwner, dbFolder, dbName),
+		databaseFeedPath("commits", dbOwner, dbFolder, dbName)+"?branch="+url.QueryEscape(branchName),
+		fmt.Sprintf("/commits/%s?branch=%s", dbPath, url.QueryEscape(branchName)))
+	c, ok := commitList[headID]
+	for ok && len(feed.Entries) < feedLength {
+		title := strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0]
+		if title == "" {
+			title = fmt.Sprintf("Commit %.8s", c.ID)
+		}
+		link := feedURL(fmt.Sprintf("/%s?branch=%s&commit=%s", dbPath, url.QueryEscape(branchName), c.ID))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Author:  atomAuthor{Name: c.AuthorName},
+			Content: feedContent(com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, c.Message, refTypes)),
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Title:   title,
+			Updated: c.Timestamp,
+		})
+		c, ok = commitList[c.Parent]
+	}
+	return
+}
+
+// Returns the feed of discussions and merge requests for a database, most recently active first.
+func discussionsFeed(dbOwner string, dbFolder string, dbName string) (feed atomFeed, err error) {
+	dbPath := fmt.Sprintf("%s%s%s", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName))
+	feed = newFeed(fmt.Sprintf("Discussions and merge requests of %s%s%s", dbOwner, dbFolder, dbName),
+		databaseFeedPath("discussions", dbOwner, dbFolder, dbName), fmt.Sprintf("/discuss/%s", dbPath))
+	for _, discType := range []com.DiscussionType{com.DISCUSSION, com.MERGE_REQUEST} {
+		var list []com.DiscussionEntry
+		list, err = com.Discussions(dbOwner, dbFolder, dbName, discType, 0)
+		if err != nil {
+			return
+		}
+		for _, d := range list {
+			page, kind := "discuss", "Discussion"
+			if discType == com.MERGE_REQUEST {
+				page, kind = "merge", "Merge request"
+			}
+			created := d.DateCreated
+			link := feedURL(fmt.Sprintf("/%s/%s?id=%d", page, dbPath, d.ID))
+			feed.Entries = append(feed.Entries, atomEntry{
+				Author:    atomAuthor{Name: d.Creator},
+				Content:   feedContent(d.BodyRendered),
+				ID:        link,
+				Link:      atomLink{Href: link},
+				Published: &created,
+				Title:     fmt.Sprintf("%s #%d: %s", kind, d.ID, d.Title),
+				Updated:   d.LastModified,
+			})
+		}
+	}
+	return
+}
+
+// Returns the feed of recently updated public databases.
+func newDatabasesFeed() (feed atomFeed, err error) {
+	feed = newFeed("Recently updated public databases", "/feeds/new", "/")
+	list, err := com.RecentUploads(feedLength)
+	if err != nil {
+		return
+	}
+	for _, j := range list {
+		link := feedURL(fmt.Sprintf("/%s/%s", url.PathEscape(j.Owner), url.PathEscape(j.DBName)))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Author:  atomAuthor{Name: j.Owner},
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Title:   fmt.Sprintf("%s/%s", j.Owner, j.DBName),
+			Updated: j.UploadDate,
+		})
+	}
+	return
+}
+
+// Returns the feed of releases and tags for a database, newest first.
+func releasesFeed(dbOwner string, dbFolder string, dbName string) (feed atomFeed, err error) {
+	releases, err := com.GetReleases(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	tags, err := com.GetTags(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	refTypes, _ := com.DiscussionTypes(dbOwner, dbFolder, dbName)
+
+	dbPath := fmt.Sprintf("%s%s%s", url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName))
+	feed = newFeed(fmt.Sprintf("Releases and tags of %s%s%s", dbOwner, dbFolder, dbName),
+		databaseFeedPath("releases", dbOwner, dbFolder, dbName), fmt.Sprintf("/releases/%s", dbPath))
+	for name, rel := range releases {
+		link := feedURL(fmt.Sprintf("/%s?commit=%s", dbPath, rel.Commit))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Author:  atomAuthor{Name: rel.ReleaserName},
+			Content: feedContent(com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, rel.Description, refTypes)),
+			ID:      feedURL(fmt.Sprintf("/releases/%s#%s", dbPath, url.PathEscape(name))),
+			Link:    atomLink{Href: link},
+			Title:   fmt.Sprintf("Release %s", name),
+			Updated: rel.Date,
+		})
+	}
+	for name, tag := range tags {
+		link := feedURL(fmt.Sprintf("/%s?commit=%s", dbPath, tag.Commit))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Author:  atomAuthor{Name: tag.TaggerName},
+			Content: feedContent(com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, tag.Description, refTypes)),
+			ID:      feedURL(fmt.Sprintf("/tags/%s#%s", dbPath, url.PathEscape(name))),
+			Link:    atomLink{Href: link},
+			Title:   fmt.Sprintf("Tag %s", name),
+			Updated: tag.Date,
+		})
+	}
+	return
+}
+
+// Returns the feed of a user's activity on public databases.
+func userActivityFeed(userName string) (feed atomFeed, err error) {
+	feed = newFeed(fmt.Sprintf("Activity of %s", userName), fmt.Sprintf("/feeds/user/%s", url.PathEscape(userName)),
+		fmt.Sprintf("/%s", url.PathEscape(userName)))
+	var actions []com.AuditAction
+	for a := range feedActions {
+		actions = append(actions, a)
+	}
+	list, err := com.UserPublicActivity(userName, actions, feedLength)
+	if err != nil {
+		return
+	}
+	for _, e := range list {
+		link := feedURL(fmt.Sprintf("/%s%s%s", url.PathEscape(e.Owner), e.Folder, url.PathEscape(e.DBName)))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Author:  atomAuthor{Name: e.Actor},
+			ID:      feedURL(fmt.Sprintf("/%s#activity-%d", url.PathEscape(userName), e.ID)),
+			Link:    atomLink{Href: link},
+			Title:   fmt.Sprintf("%s %s %s%s%s", e.Actor, feedActions[e.Action], e.Owner, e.Folder, e.DBName),
+			Updated: e.Date,
+		})
+	}
+	return
+}
+
+// Returns the path of one of the feeds for a database.
+func databaseFeedPath(feed string, dbOwner string, dbFolder string, dbName string) string {
+	return fmt.Sprintf("/feeds/%s/%s%s%s", feed, url.PathEscape(dbOwner), dbFolder, url.PathEscape(dbName))
+}
+
+// Wraps rendered HTML as the content of a feed entry.  Empty content is left out.
+func feedContent(html string) *atomText {
+	if strings.TrimSpace(html) == "" {
+		return nil
+	}
+	return &atomText{Body: html, Type: "html"}
+}
+
+// Returns the absolute URL for a path on this server, as feed readers need full URLs.
+func feedURL(path string) string {
+	return "https://" + com.Conf.Web.ServerName + path
+}
+
+// Creates a new, empty feed.  The paths are for the feed itself, and the webUI page showing the same information.
+func newFeed(title string, feedPath string, pagePath string) atomFeed {
+	return atomFeed{
+		ID: feedURL(feedPath),
+		Links: []atomLink{
+			{Href: feedURL(feedPath), Rel: "self"},
+			{Href: feedURL(pagePath), Rel: "alternate"},
+		},
+		Title: "DBHub.io - " + title,
+	}
+}
+
+// Sends a feed to the client.  The entries are sorted newest first and trimmed to the maximum feed length.  The
+// ETag and Last-Modified headers are set so feed readers can use conditional requests, which http.ServeContent()
+// then answers with "304 Not Modified" when nothing has changed.  Feeds which aren't public are marked as private, so
+// shared caches don't keep them.
+func writeFeed(w http.ResponseWriter, r *http.Request, feed atomFeed, public bool) {
+	sort.SliceStable(feed.Entries, func(i, j int) bool {
+		return feed.Entries[i].Updated.After(feed.Entries[j].Updated)
+	})
+	if len(feed.Entries) > feedLength {
+		feed.Entries = feed.Entries[:feedLength]
+	}
+	var lastModified time.Time
+	if len(feed.Entries) > 0 {
+		lastModified = feed.Entries[0].Updated.UTC()
+	}
+	feed.Updated = lastModified
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+	if !public {
+		w.Header().Set("Cache-Control", "private")
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(body)))
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(body))
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// Removes a discussion label from a database.  Returns the updated label and milestone lists.
+func deleteLabelHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := trackingWriteCheck(w, r)
+	if !ok {
+		return
+	}
+	labelID, err := strconv.ParseInt(r.PostFormValue("labelid"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid label id")
+		return
+	}
+	err = com.DeleteDiscussionLabel(dbOwner, dbFolder, dbName, labelID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_LABEL_DELETE,
+		map[string]int64{"id": labelID}, nil)
+	trackingResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Removes a milestone from a database.  Returns the updated label and milestone lists.
+func deleteMilestoneHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := trackingWriteCheck(w, r)
+	if !ok {
+		return
+	}
+	milestoneID, err := strconv.ParseInt(r.PostFormValue("milestoneid"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid milestone id")
+		return
+	}
+	err = com.DeleteMilestone(dbOwner, dbFolder, dbName, milestoneID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_MILESTONE_DELETE,
+		map[string]int64{"id": milestoneID}, nil)
+	trackingResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Returns the discussion list filter given in the request parameters.
+func discussionFilterFromForm(r *http.Request) (filter com.DiscussionFilter, err error) {
+	filter.Label = r.FormValue("label")
+	if filter.Label != "" && com.ValidateLabelName(filter.Label) != nil {
+		return filter, errors.New("Invalid label name")
+	}
+	filter.Assignee = r.FormValue("assignee")
+	if filter.Assignee != "" && com.ValidateUser(filter.Assignee) != nil {
+		return filter, errors.New("Invalid assignee name")
+	}
+	filter.Milestone = r.FormValue("milestone")
+	if filter.Milestone != "" && com.ValidateLabelName(filter.Milestone) != nil {
+		return filter, errors.New("Invalid milestone name")
+	}
+	switch s := r.FormValue("state"); s {
+	case "", "all":
+	case "open", "closed":
+		filter.State = s
+	default:
+		return filter, errors.New("Unknown discussion state")
+	}
+	switch s := com.DiscussionSort(r.FormValue("sort")); s {
+	case "":
+	case com.SORT_UPDATED, com.SORT_CREATED, com.SORT_COMMENTS:
+		filter.Sort = s
+	default:
+		return filter, errors.New("Unknown sort order")
+	}
+	return
+}
+
+// Returns the discussion label given in the form data.  An existing label is updated when its ID is included.
+func labelFromForm(r *http.Request) (label com.DiscussionLabel, err error) {
+	if id := r.FormValue("labelid"); id != "" {
+		label.ID, err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return label, errors.New("Invalid label id")
+		}
+	}
+	label.Name = strings.TrimSpace(r.FormValue("name"))
+	if com.ValidateLabelName(label.Name) != nil {
+		return label, errors.New("Invalid label name")
+	}
+	label.Colour = strings.ToLower(r.FormValue("colour"))
+	if com.ValidateLabelColour(label.Colour) != nil {
+		return label, errors.New("Label colours need to be given in #rrggbb form")
+	}
+	label.Description = r.FormValue("description")
+	if label.Description != "" && com.Validate.Var(label.Description, "markdownsource,max=200") != nil {
+		return label, errors.New("Invalid label description")
+	}
+	return
+}
+
+// Returns the milestone given in the form data.  An existing milestone is updated when its ID is included.  Tags and
+// releases the milestone points to need to exist in the database.
+func milestoneFromForm(r *http.Request, dbOwner string, dbFolder string, dbName string) (m com.Milestone, status int,
+	err error) {
+	status = http.StatusBadRequest
+	if id := r.FormValue("milestoneid"); id != "" {
+		m.ID, err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return m, status, errors.New("Invalid milestone id")
+		}
+	}
+	m.Title = strings.TrimSpace(r.FormValue("title"))
+	if com.ValidateLabelName(m.Title) != nil {
+		return m, status, errors.New("Invalid milestone title")
+	}
+	m.Description = r.FormValue("description")
+	if m.Description != "" && com.Validate.Var(m.Description, "markdownsource,max=1024") != nil {
+		return m, status, errors.New("Invalid milestone description")
+	}
+	if d := r.FormValue("due"); d != "" {
+		m.DueDate, err = time.Parse("2006-01-02", d)
+		if err != nil {
+			return m, status, errors.New("Due dates need to be given in YYYY-MM-DD form")
+		}
+	}
+	m.Open = r.FormValue("open") != "false"
+
+	// Check the tag and release exist
+	if m.Tag = r.FormValue("tag"); m.Tag != "" {
+		tags, err := com.GetTags(dbOwner, dbFolder, dbName)
+		if err != nil {
+			return m, http.StatusInternalServerError, err
+		}
+		if _, ok := tags[m.Tag]; !ok {
+			return m, status, fmt.Errorf("Unknown tag: '%s'", m.Tag)
+		}
+	}
+	if m.Release = r.FormValue("release"); m.Release != "" {
+		rels, err := com.GetReleases(dbOwner, dbFolder, dbName)
+		if err != nil {
+			return m, http.StatusInternalServerError, err
+		}
+		if _, ok := rels[m.Release]; !ok {
+			return m, status, fmt.Errorf("Unknown release: '%s'", m.Release)
+		}
+	}
+	return m, http.StatusOK, nil
+}
+
+// Sets the labels, assignees, and milestone of a discussion or merge request.  Returns the updated discussion.
+func saveDiscussionTrackingHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := trackingWriteCheck(w, r)
+	if !ok {
+		return
+	}
+	discID, err := strconv.Atoi(r.PostFormValue("discid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid discussion id")
+		return
+	}
+	labels, assignees, milestoneID, err := trackingFromForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	disc, status, err := storeDiscussionTracking(dbOwner, dbFolder, dbName, discID, labels, assignees, milestoneID)
+	if err != nil {
+		w.WriteHeader(status)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_DISCUSSION_TRIAGE, nil,
+		map[string]interface{}{"discussion": discID, "labels": labels, "assignees": assignees,
+			"milestone": milestoneID})
+	jsonData, err := json.Marshal(disc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Adds or updates a discussion label.  Returns the updated label and milestone lists.
+func saveLabelHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := trackingWriteCheck(w, r)
+	if !ok {
+		return
+	}
+	label, err := labelFromForm(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	label.ID, err = com.StoreDiscussionLabel(dbOwner, dbFolder, dbName, label)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_LABEL_SAVE, nil, label)
+	trackingResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Adds or updates a milestone.  Returns the updated label and milestone lists.
+func saveMilestoneHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := trackingWriteCheck(w, r)
+	if !ok {
+		return
+	}
+	m, status, err := milestoneFromForm(r, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(status)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	m.ID, err = com.StoreMilestone(dbOwner, dbFolder, dbName, m)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_MILESTONE_SAVE, nil, m)
+	trackingResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Saves the labels, assignees, and milestone of a discussion or merge request, then returns its updated details.
+func storeDiscussionTracking(dbOwner string, dbFolder string, dbName string, discID int, labels []int64,
+	assignees []string, milestoneID int64) (disc com.DiscussionEntry, status int, err error) {
+	err = com.StoreDiscussionTracking(dbOwner, dbFolder, dbName, discID, labels, assignees, milestoneID)
+	if err != nil {
+		if _, ok := err.(com.DiscussionTrackingError); ok {
+			return disc, http.StatusBadRequest, err
+		}
+		return disc, http.StatusInternalServerError, err
+	}
+
+	// The same IDs are used for discussions and merge requests, so check both types
+	list, err := com.Discussions(dbOwner, dbFolder, dbName, com.DISCUSSION, discID)
+	if err == nil && len(list) == 0 {
+		list, err = com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, discID)
+	}
+	if err != nil {
+		return disc, http.StatusInternalServerError, err
+	}
+	if len(list) == 0 {
+		return disc, http.StatusNotFound, errors.New("Discussion not found")
+	}
+	return list[0], http.StatusOK, nil
+}
+
+// Returns the label IDs, assignee names, and milestone ID given in the form data.  The labels and assignees are comma
+// separated lists, and a missing or 0 milestone means none.
+func trackingFromForm(r *http.Request) (labels []int64, assignees []string, milestoneID int64, err error) {
+	if l := r.FormValue("labels"); l != "" {
+		for _, s := range strings.Split(l, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return nil, nil, 0, errors.New("Invalid label list")
+			}
+			labels = append(labels, id)
+		}
+	}
+	if a := r.FormValue("assignees"); a != "" {
+		for _, s := range strings.Split(a, ",") {
+			s = strings.TrimPrefix(strings.TrimSpace(s), "@")
+			if s == "" {
+				continue
+			}
+			if com.ValidateUser(s) != nil {
+				return nil, nil, 0, fmt.Errorf("Invalid assignee name: '%s'", s)
+			}
+			assignees = append(assignees, s)
+		}
+	}
+	if m := r.FormValue("milestone"); m != "" {
+		milestoneID, err = strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			return nil, nil, 0, errors.New("Invalid milestone id")
+		}
+	}
+	return
+}
+
+// Sends the labels and milestones for a database back to the caller.
+func trackingResponse(w http.ResponseWriter, dbOwner string, dbFolder string, dbName string) {
+	var lists struct {
+		Labels     []com.DiscussionLabel `json:"labels"`
+		Milestones []com.Milestone       `json:"milestones"`
+	}
+	var err error
+	lists.Labels, lists.Milestones, err = trackingLists(dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	jsonData, err := json.Marshal(lists)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonData))
+}
+
+// Returns the discussion labels and milestones for a database.
+func trackingLists(dbOwner string, dbFolder string, dbName string) (labels []com.DiscussionLabel,
+	milestones []com.Milestone, err error) {
+	labels, err = com.DiscussionLabels(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	milestones, err = com.Milestones(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	if labels == nil {
+		labels = []com.DiscussionLabel{}
+	}
+	if milestones == nil {
+		milestones = []com.Milestone{}
+	}
+	return
+}
+
+// Checks the request is from a user with write access to the database given in the form data, sending an error
+// response if it isn't.
+func trackingWriteCheck(w http.ResponseWriter, r *http.Request) (loggedInUser string, dbOwner string,
+	dbFolder string, dbName string, ok bool) {
+	// Retrieve session data (if any)
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	loggedInUser = u.(string)
+
+	// Extract the database details
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner = strings.ToLower(usr)
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName)
+		return
+	}
+
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckWriteAccess(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You don't have write access to that database")
+		return
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// How often a comment is sent down idle live update streams, so proxies don't close them
+const liveKeepAlive = 30 * time.Second
+
+// Streams live updates to an open page of the webUI as Server-Sent Events.  Logged in users are sent the number of
+// status updates waiting for them.  When a discussion or merge request is given, its comments and state are sent
+// whenever they change too.
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Streaming isn't supported")
+		return
+	}
+
+	// Extract the discussion details, if given
+	var dbOwner, dbFolder, dbName string
+	var discID int
+	var discType com.DiscussionType
+	if r.FormValue("dbname") != "" {
+		usr, f, n, err := com.GetUFD(r, true)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing or incorrect data supplied")
+			return
+		}
+		discID, err = strconv.Atoi(r.FormValue("discid"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing or incorrect discussion ID")
+			return
+		}
+
+		// Make sure the database is visible to the user, and the discussion exists
+		exists, err := com.CheckDBExists(loggedInUser, usr, f, n)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", usr, f, n)
+			return
+		}
+		types, err := com.DiscussionTypes(usr, f, n)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		discType, ok = types[discID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Unknown discussion ID")
+			return
+		}
+		dbOwner, dbFolder, dbName = usr, f, n
+	}
+
+	// If there's nothing to send, tell the browser not to reconnect
+	if loggedInUser == "" && dbName == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	updates, stop := com.SubscribeLiveUpdates()
+	defer stop()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var data interface{}
+		var upd com.LiveUpdate
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+			continue
+		case upd = <-updates:
+			switch upd.Type {
+			case com.LIVE_STATUS_UPDATES:
+				if loggedInUser == "" || strings.ToLower(upd.User) != strings.ToLower(loggedInUser) {
+					continue
+				}
+				data = upd.Count
+			case com.LIVE_COMMENTS, com.LIVE_DISCUSSION:
+				if dbName == "" || upd.DiscID != discID || strings.ToLower(upd.Owner) != strings.ToLower(dbOwner) ||
+					upd.Folder != dbFolder || upd.DBName != dbName {
+					continue
+				}
+
+				// The database may have been made private, or the user's access to it removed, since the stream
+				// was opened.  If so, the stream is closed
+				exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+				if err != nil {
+					continue
+				}
+				if !exists {
+					return
+				}
+
+				// Send the latest version of the changed data, rather than just the notification of it
+				if upd.Type == com.LIVE_COMMENTS {
+					data, err = com.DiscussionComments(dbOwner, dbFolder, dbName, discID, 0)
+				} else {
+					var list []com.DiscussionEntry
+					list, err = com.Discussions(dbOwner, dbFolder, dbName, discType, discID)
+					if err == nil && len(list) == 1 {
+						data = list[0]
+					}
+				}
+				if err != nil || data == nil {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		// Send the update
+		j, err := json.Marshal(data)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", upd.Type, j)
+		flusher.Flush()
+	}
+}
@@ -314,6 +314,92 @@ func branchNamesHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(data))
 }
 
+// Creates a new API access token for the logged in user.  The token itself is only ever returned here, as just its
+// hash is stored.
+func createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Create API token handler"
+
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Validate the token name
+	tokenName := r.PostFormValue("name")
+	err := com.ValidateDisplayName(tokenName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid token name")
+		return
+	}
+
+	// Work out what the token is allowed to do.  Reading private databases needs the read scope, and making changes
+	// needs the write scope
+	scopes := []com.APITokenScope{}
+	for _, s := range []com.APITokenScope{com.API_SCOPE_READ, com.API_SCOPE_WRITE} {
+		z := r.PostFormValue(string(s))
+		if z == "" {
+			continue
+		}
+		allowed, err := strconv.ParseBool(z)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid %s scope value", s)
+			return
+		}
+		if allowed {
+			scopes = append(scopes, s)
+		}
+	}
+
+	// Create the token
+	token, tokenHash, err := com.NewAPIToken()
+	if err != nil {
+		log.Printf("%s: Error when generating API token: %v\n", pageName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tokenID, err := com.StoreAPIToken(loggedInUser, tokenName, tokenHash, scopes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, "", "", "", com.AUDIT_API_TOKEN_CREATE, nil,
+		map[string]interface{}{"id": tokenID, "name": tokenName, "scopes": scopes})
+
+	// Return the new token, so it can be shown to the user
+	data, err := json.MarshalIndent(struct {
+		ID    int64  `json:"id"`
+		Token string `json:"token"`
+	}{tokenID, token}, "", " ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
+
 func createBranchHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve session data (if any)
 	var loggedInUser string
@@ -377,9 +463,14 @@ func createBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
-		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "You don't have write access to that database")
 		return
 	}
 
@@ -431,6 +522,24 @@ func createBranchHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_BRANCH_CREATE, nil,
+		map[string]string{"branch": branchName, "commit": commit, "description": branchDesc})
+
+	// Generate an event about the new branch
+	details := com.EventDetails{
+		DBName:   dbName,
+		Folder:   dbFolder,
+		Message:  branchDesc,
+		Owner:    dbOwner,
+		Title:    branchName,
+		Type:     com.EVENT_NEW_BRANCH,
+		URL:      fmt.Sprintf("/branches/%s%s%s", dbOwner, dbFolder, dbName),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		log.Printf("Error when creating a new event: %s\n", err.Error())
+	}
 
 	// Invalidate the memcache data for the database, so the new branch count gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -441,7 +550,151 @@ func createBranchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Bounce to the branches page
-	http.Redirect(w, r, fmt.Sprintf("/branches/%s%s%s", loggedInUser, dbFolder, dbName), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/branches/%s%s%s", dbOwner, dbFolder, dbName), http.StatusSeeOther)
+}
+
+// Adds a collaborator to a database, or changes the role of an existing one.
+func createCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+
+	// Validate the collaborator name
+	collabName := r.PostFormValue("collaborator")
+	err = com.ValidateUser(collabName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid user name")
+		return
+	}
+	if strings.ToLower(collabName) == dbOwner {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "The database owner can't be added as a collaborator")
+		return
+	}
+
+	// Validate the role
+	role := com.CollaboratorRole(r.PostFormValue("role"))
+	switch role {
+	case com.COLLAB_READ, com.COLLAB_WRITE, com.COLLAB_ADMIN:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown collaborator role")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName)
+		return
+	}
+
+	// Make sure the logged in user has admin access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_ADMIN)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You don't have admin access to that database")
+		return
+	}
+
+	// Make sure the collaborator has an account
+	userExists, err := com.CheckUserExists(collabName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !userExists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Unknown user '%s'", collabName)
+		return
+	}
+	collabUser, err := com.User(collabName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if collabUser.IsOrg {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Organisations can't be collaborators")
+		return
+	}
+
+	// Save the collaborator
+	err = com.StoreCollaborator(dbOwner, dbFolder, dbName, collabName, role)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_COLLABORATOR_ADD, nil,
+		map[string]interface{}{"user": collabName, "role": role})
+
+	// Invalidate the memcache data for the database, so the collaborator's view of it gets refreshed
+	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		return
+	}
+
+	// Send the updated collaborator list back to the caller
+	collabs, err := com.Collaborators(dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	jsonList, err := json.Marshal(collabs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonList))
 }
 
 // Receives incoming info for adding a comment to an existing discussion
@@ -542,14 +795,96 @@ func createCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Review comments on merge requests can point to a row or cell in the source database.  If one does, make sure
+	// the data is there
+	var anchor *com.CommentAnchor
+	if r.PostFormValue("anchortable") != "" && comText != "" {
+		a := com.CommentAnchor{
+			Column:   r.PostFormValue("anchorcolumn"),
+			CommitID: r.PostFormValue("anchorcommit"),
+			Table:    r.PostFormValue("anchortable"),
+		}
+		if com.ValidateCommitID(a.CommitID) != nil || com.ValidatePGTable(a.Table) != nil ||
+			(a.Column != "" && com.ValidateFieldName(a.Column) != nil) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid commit, table, or column name for the review comment")
+			return
+		}
+		err = json.Unmarshal([]byte(r.PostFormValue("anchorkey")), &a.PrimaryKey)
+		if err != nil || len(a.PrimaryKey) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "The primary key for a review comment needs to be a JSON object of column names and values")
+			return
+		}
+		for k := range a.PrimaryKey {
+			if com.ValidateFieldName(k) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Invalid primary key column name: '%s'", k)
+				return
+			}
+		}
+		mr, err := com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, discID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if len(mr) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Only merge request comments can point to data")
+			return
+		}
+		err = com.CheckCommentAnchor(mr[0].MRDetails, a)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		anchor = &a
+	}
+
 	// Add the comment to PostgreSQL
 	err = com.StoreComment(dbOwner, dbFolder, dbName, loggedInUser, discID, comText, discClose,
-		com.CLOSED_WITHOUT_MERGE) // com.CLOSED_WITHOUT_MERGE is ignored for discussions.  It's only used for MRs
+		com.CLOSED_WITHOUT_MERGE, anchor) // com.CLOSED_WITHOUT_MERGE is ignored for discussions.  It's only used for MRs
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	if comText != "" {
+		com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_COMMENT_CREATE, nil,
+			map[string]interface{}{"discussion": discID, "text": comText, "anchor": anchor})
+	}
+	if discClose {
+		var action com.AuditAction = com.AUDIT_DISCUSSION_CLOSE
+		if open, err := com.DiscussionOpen(dbOwner, dbFolder, dbName, discID); err == nil && open {
+			action = com.AUDIT_DISCUSSION_REOPEN
+		}
+		com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, action, nil,
+			map[string]interface{}{"discussion": discID})
+
+		// Generate an event when a merge request is closed without being merged
+		if action == com.AUDIT_DISCUSSION_CLOSE {
+			mr, err := com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, discID)
+			if err == nil && len(mr) == 1 {
+				details := com.EventDetails{
+					DBName:   dbName,
+					DiscID:   discID,
+					Folder:   dbFolder,
+					Message:  comText,
+					Owner:    dbOwner,
+					Title:    mr[0].Title,
+					Type:     com.EVENT_MERGE_REQUEST_CLOSED,
+					URL:      fmt.Sprintf("/merge/%s%s%s?id=%d", dbOwner, dbFolder, dbName, discID),
+					UserName: loggedInUser,
+				}
+				err = com.NewEvent(details)
+				if err != nil {
+					log.Printf("Error when creating a new event: %s\n", err.Error())
+				}
+			}
+		}
+	}
 
 	// Invalidate the memcache data for the database, so if the discussion counter for the database was changed it
 	// gets picked up
@@ -642,6 +977,8 @@ func createDiscussHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_DISCUSSION_CREATE, nil,
+		map[string]interface{}{"discussion": id, "title": discTitle})
 
 	// Generate an event about the new discussion
 	details := com.EventDetails{
@@ -944,6 +1281,9 @@ func createMergeHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, destOwner, destFolder, destDBName, com.AUDIT_MR_CREATE, nil,
+		map[string]interface{}{"mr": x.ID, "title": title, "source": fmt.Sprintf("%s%s%s", srcOwner, srcFolder,
+			srcDBName), "source_branch": srcBranch, "branch": destBranch})
 
 	// Generate an event about the new merge request
 	details := com.EventDetails{
@@ -1052,9 +1392,14 @@ func createTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
-		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "You don't have write access to that database")
 		return
 	}
 
@@ -1107,6 +1452,24 @@ func createTagHandler(w http.ResponseWriter, r *http.Request) {
 			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
+		com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_RELEASE_CREATE, nil,
+			map[string]string{"release": tagName, "commit": commit, "description": tagDesc})
+
+		// Generate an event about the new release
+		details := com.EventDetails{
+			DBName:   dbName,
+			Folder:   dbFolder,
+			Message:  tagDesc,
+			Owner:    dbOwner,
+			Title:    tagName,
+			Type:     com.EVENT_NEW_RELEASE,
+			URL:      fmt.Sprintf("/releases/%s%s%s", dbOwner, dbFolder, dbName),
+			UserName: loggedInUser,
+		}
+		err = com.NewEvent(details)
+		if err != nil {
+			log.Printf("Error when creating a new event: %s\n", err.Error())
+		}
 
 		// Invalidate the memcache data for the database
 		err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -1117,7 +1480,7 @@ func createTagHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Bounce to the releases page
-		http.Redirect(w, r, fmt.Sprintf("/releases/%s%s%s", loggedInUser, dbFolder, dbName), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/releases/%s%s%s", dbOwner, dbFolder, dbName), http.StatusSeeOther)
 		return
 	}
 
@@ -1152,6 +1515,24 @@ func createTagHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_TAG_CREATE, nil,
+		map[string]string{"tag": tagName, "commit": commit, "description": tagDesc})
+
+	// Generate an event about the new tag
+	details := com.EventDetails{
+		DBName:   dbName,
+		Folder:   dbFolder,
+		Message:  tagDesc,
+		Owner:    dbOwner,
+		Title:    tagName,
+		Type:     com.EVENT_NEW_TAG,
+		URL:      fmt.Sprintf("/tags/%s%s%s", dbOwner, dbFolder, dbName),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		log.Printf("Error when creating a new event: %s\n", err.Error())
+	}
 
 	// Invalidate the memcache data for the database, so the new tag count gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -1162,7 +1543,7 @@ func createTagHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Bounce to the tags page
-	http.Redirect(w, r, fmt.Sprintf("/tags/%s%s%s", loggedInUser, dbFolder, dbName), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/tags/%s%s%s", dbOwner, dbFolder, dbName), http.StatusSeeOther)
 }
 
 func createUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -1332,6 +1713,8 @@ func createUserHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, "Something went wrong during user creation")
 		return
 	}
+	com.Audit(r, "webui", userName, "", "", "", com.AUDIT_USER_CREATE, nil,
+		map[string]string{"email": email, "display_name": displayName})
 
 	// Remove the temporary username selection session data
 	sess.Options.MaxAge = -1
@@ -1393,10 +1776,8 @@ func checkNameHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-// This function deletes a branch.
-func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Delete Branch handler"
-
+// Revokes one of the API access tokens for the logged in user.
+func deleteAPITokenHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve session data (if any)
 	var loggedInUser string
 	var u interface{}
@@ -1422,25 +1803,72 @@ func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract the required form variables
-	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	// Validate the token ID
+	tokenID, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	dbOwner := strings.ToLower(usr)
 
-	// Check if a branch name was requested
-	branchName, err := com.GetFormBranch(r)
+	// Revoke the token
+	err = com.DeleteAPIToken(loggedInUser, tokenID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, "", "", "", com.AUDIT_API_TOKEN_DELETE, map[string]int64{"id": tokenID},
+		nil)
+	w.WriteHeader(http.StatusOK)
+}
 
-	// If any of the required values were empty, indicate failure
-	if branchName == "" || dbFolder == "" || dbName == "" || dbOwner == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+// This function deletes a branch.
+func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Delete Branch handler"
+
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+
+	// Check if a branch name was requested
+	branchName, err := com.GetFormBranch(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// If any of the required values were empty, indicate failure
+	if branchName == "" || dbFolder == "" || dbName == "" || dbOwner == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	// Make sure the database exists in the system
@@ -1455,14 +1883,20 @@ func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing branchHeads for the database
-	branchList, err := com.GetBranches(loggedInUser, dbFolder, dbName)
+	branchList, err := com.GetBranches(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -1475,6 +1909,18 @@ func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Make sure the branch isn't protected against deletion
+	protection, err := com.GetBranchProtection(dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if protection[branchName].NoDelete {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Branch '%s' is protected against deletion", branchName)))
+		return
+	}
+
 	// Make sure the branch being deleted isn't the default one
 	defBranch, err := com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
 	if err != nil {
@@ -1788,6 +2234,28 @@ func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	var delCommits []string
+	for cid := range lst {
+		delCommits = append(delCommits, cid)
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_BRANCH_DELETE, map[string]interface{}{
+		"branch": branchName, "commit": branch.Commit, "description": branch.Description, "deleted_commits": delCommits},
+		nil)
+
+	// Generate an event about the deleted branch
+	details := com.EventDetails{
+		DBName:   dbName,
+		Folder:   dbFolder,
+		Owner:    dbOwner,
+		Title:    branchName,
+		Type:     com.EVENT_BRANCH_DELETED,
+		URL:      fmt.Sprintf("/branches/%s%s%s", dbOwner, dbFolder, dbName),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		log.Printf("Error when creating a new event: %s\n", err.Error())
+	}
 
 	// Invalidate the memcache data for the database, so the new branch count gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -1801,6 +2269,97 @@ func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// Removes a collaborator from a database.
+func deleteCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+	collabName := r.PostFormValue("collaborator")
+	err = com.ValidateUser(collabName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid user name")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName)
+		return
+	}
+
+	// Admins can remove anyone, and collaborators can always remove themselves
+	if strings.ToLower(collabName) != strings.ToLower(loggedInUser) {
+		allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_ADMIN)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "You don't have admin access to that database")
+			return
+		}
+	}
+
+	// Remove the collaborator
+	err = com.DeleteCollaborator(dbOwner, dbFolder, dbName, collabName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_COLLABORATOR_REMOVE,
+		map[string]string{"user": collabName}, nil)
+
+	// Invalidate the memcache data for the database, so the removed collaborator loses access to cached pages
+	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // This function deletes a given comment from a discussion.
 func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve session data (if any)
@@ -1882,22 +2441,24 @@ func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the logged in user is allowed to delete the requested comment
+	// Retrieve the details for the requested comment, so we can check if the logged in user is the comment creator
+	rq, err := com.DiscussionComments(dbOwner, dbFolder, dbName, discID, comID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if len(rq) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Check if the logged in user is allowed to delete the requested comment.  The database owner can delete any
+	// discussion comment on their databases
 	deleteAllowed := false
-	if strings.ToLower(dbOwner) == strings.ToLower(loggedInUser) {
-		// The database owner can delete any discussion comment on their databases
+	if strings.ToLower(dbOwner) == strings.ToLower(loggedInUser) ||
+		strings.ToLower(rq[0].Commenter) == strings.ToLower(loggedInUser) {
 		deleteAllowed = true
-	} else {
-		// Retrieve the details for the requested comment, so we can check if the logged in user is the comment creator
-		rq, err := com.DiscussionComments(dbOwner, dbFolder, dbName, discID, comID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, err.Error())
-			return
-		}
-		if strings.ToLower(rq[0].Commenter) == strings.ToLower(loggedInUser) {
-			deleteAllowed = true
-		}
 	}
 
 	// If the logged in user isn't allowed to delete the requested comment, then reject the request
@@ -1913,6 +2474,9 @@ func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_COMMENT_DELETE,
+		map[string]interface{}{"discussion": discID, "comment": comID, "commenter": rq[0].Commenter,
+			"text": rq[0].Body}, nil)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1985,14 +2549,20 @@ func deleteCommitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing branchHeads for the database
-	branches, err := com.GetBranches(loggedInUser, dbFolder, dbName)
+	branches, err := com.GetBranches(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -2013,6 +2583,18 @@ func deleteCommitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Deleting commits rewrites the branch history, which isn't allowed for protected branches
+	protection, err := com.GetBranchProtection(dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if protection[branchName].NoForcePush {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Branch '%s' is protected against history rewriting", branchName)))
+		return
+	}
+
 	// Determine the commit ID we'll be rewinding to
 	commitList, err := com.GetCommitList(dbOwner, dbFolder, dbName)
 	if err != nil {
@@ -2126,6 +2708,9 @@ func deleteCommitHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Commit deletion failed, internal server error"))
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_COMMIT_DELETE,
+		map[string]string{"branch": branchName, "commit": commit}, map[string]string{"branch": branchName,
+			"commit": prevCommit})
 
 	// Invalidate the memcache data for the database, so the new branch count gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -2200,8 +2785,14 @@ func deleteDatabaseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user owns the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_OWNER)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprint(w, "You don't have permission to delete that database")
 		return
@@ -2219,9 +2810,12 @@ func deleteDatabaseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Record the deletion in the audit log first, as afterwards the database can't be looked up by its name
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_DATABASE_DELETE, nil, nil)
+
 	// Delete the database
 	err = com.DeleteDatabase(dbOwner, dbFolder, dbName)
-	if err != err {
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, "Internal server error")
 		return
@@ -2293,14 +2887,20 @@ func deleteReleaseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing releases for the database
-	releases, err := com.GetReleases(loggedInUser, dbFolder, dbName)
+	releases, err := com.GetReleases(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -2313,12 +2913,15 @@ func deleteReleaseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete the release
+	oldRel := releases[relName]
 	delete(releases, relName)
 	err = com.StoreReleases(dbOwner, dbFolder, dbName, releases)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_RELEASE_DELETE,
+		map[string]string{"release": relName, "commit": oldRel.Commit, "description": oldRel.Description}, nil)
 
 	// Invalidate the memcache data for the database, so the new release count gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -2394,14 +2997,20 @@ func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing tags for the database
-	tags, err := com.GetTags(loggedInUser, dbFolder, dbName)
+	tags, err := com.GetTags(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -2414,12 +3023,15 @@ func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete the tag
+	oldTag := tags[tagName]
 	delete(tags, tagName)
 	err = com.StoreTags(dbOwner, dbFolder, dbName, tags)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_TAG_DELETE,
+		map[string]string{"tag": tagName, "commit": oldTag.Commit, "description": oldTag.Description}, nil)
 
 	// Invalidate the memcache data for the database, so the new tag count gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -3067,6 +3679,23 @@ func forkDBHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, loggedInUser, dbFolder, dbName, com.AUDIT_DATABASE_FORK, nil,
+		map[string]string{"forked_from": fmt.Sprintf("%s%s%s", dbOwner, dbFolder, dbName)})
+
+	// Generate an event on the source database about the new fork
+	details := com.EventDetails{
+		DBName:   dbName,
+		Folder:   dbFolder,
+		Owner:    dbOwner,
+		Title:    fmt.Sprintf("Forked to %s%s%s", loggedInUser, dbFolder, dbName),
+		Type:     com.EVENT_NEW_FORK,
+		URL:      fmt.Sprintf("/forks/%s%s%s", dbOwner, dbFolder, dbName),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		log.Printf("Error when creating a new event: %s\n", err.Error())
+	}
 
 	// Add the user to the watch list for the forked database
 	if !exists {
@@ -3127,6 +3756,7 @@ func generateCertHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, "Error generating client certificate")
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, "", "", "", com.AUDIT_CERT_GENERATE, nil, nil)
 
 	// Send the client certificate to the user
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cert.pem"`, loggedInUser))
@@ -3189,6 +3819,15 @@ func logReq(fn http.HandlerFunc) http.HandlerFunc {
 		u := sess.Values["UserName"]
 		if u != nil {
 			loggedInUser = u.(string)
+
+			// Log out users whose account has been disabled
+			disabled, _, err := com.UserAccountStatus(loggedInUser)
+			if err == nil && disabled {
+				sess.Options.MaxAge = -1
+				sess.Save(r, w)
+				errorPage(w, r, http.StatusForbidden, "This account has been disabled")
+				return
+			}
 		} else {
 			loggedInUser = "-"
 		}
@@ -3269,15 +3908,29 @@ func main() {
 	// Start the view count flushing routine in the background
 	go com.FlushViewCount()
 
+	// Start listening for the live updates and new event notifications sent through PostgreSQL
+	go com.LiveUpdatesLoop()
+
 	// Start the status update processing goroutine in the background (will likely need moving into a separate daemon)
 	go com.StatusUpdatesLoop()
 
 	// Start the email sending goroutine in the background
 	go com.SendEmails()
 
+	// Start the goroutine which purges expired databases from the trash
+	go com.PurgeTrashLoop()
+
+	// Start the webhook delivery goroutine in the background
+	go com.WebhookDeliveryLoop()
+
+	// Start the daily and weekly digest email goroutine in the background
+	go com.DigestLoop()
+
 	// Our pages
 	http.Handle("/", gz.GzipHandler(logReq(mainHandler)))
 	http.Handle("/about", gz.GzipHandler(logReq(aboutPage)))
+	http.Handle("/api/v1/", gz.GzipHandler(logReq(apiHandler)))
+	http.Handle("/audit/", gz.GzipHandler(logReq(auditPage)))
 	http.Handle("/branches/", gz.GzipHandler(logReq(branchesPage)))
 	http.Handle("/commits/", gz.GzipHandler(logReq(commitsPage)))
 	http.Handle("/compare/", gz.GzipHandler(logReq(comparePage)))
@@ -3287,6 +3940,7 @@ func main() {
 	http.Handle("/creatediscuss/", gz.GzipHandler(logReq(createDiscussionPage)))
 	http.Handle("/createtag/", gz.GzipHandler(logReq(createTagPage)))
 	http.Handle("/discuss/", gz.GzipHandler(logReq(discussPage)))
+	http.Handle("/feeds/", gz.GzipHandler(logReq(feedHandler)))
 	http.Handle("/forks/", gz.GzipHandler(logReq(forksPage)))
 	http.Handle("/logout", gz.GzipHandler(logReq(logoutHandler)))
 	http.Handle("/merge/", gz.GzipHandler(logReq(mergePage)))
@@ -3301,33 +3955,64 @@ func main() {
 	http.Handle("/upload/", gz.GzipHandler(logReq(uploadPage)))
 	http.Handle("/vis/", gz.GzipHandler(logReq(visualisePage)))
 	http.Handle("/watchers/", gz.GzipHandler(logReq(watchersPage)))
+	http.Handle("/x/accepttransfer", gz.GzipHandler(logReq(acceptTransferHandler)))
 	http.Handle("/x/branchnames", gz.GzipHandler(logReq(branchNamesHandler)))
 	http.Handle("/x/callback", gz.GzipHandler(logReq(auth0CallbackHandler)))
+	http.Handle("/x/canceltransfer", gz.GzipHandler(logReq(cancelTransferHandler)))
 	http.Handle("/x/checkname", gz.GzipHandler(logReq(checkNameHandler)))
+	http.Handle("/x/createapitoken", gz.GzipHandler(logReq(createAPITokenHandler)))
 	http.Handle("/x/createbranch", gz.GzipHandler(logReq(createBranchHandler)))
+	http.Handle("/x/createcollaborator", gz.GzipHandler(logReq(createCollaboratorHandler)))
 	http.Handle("/x/createcomment/", gz.GzipHandler(logReq(createCommentHandler)))
 	http.Handle("/x/creatediscuss", gz.GzipHandler(logReq(createDiscussHandler)))
 	http.Handle("/x/createmerge/", gz.GzipHandler(logReq(createMergeHandler)))
+	http.Handle("/x/createorg", gz.GzipHandler(logReq(createOrgHandler)))
+	http.Handle("/x/createorgmember", gz.GzipHandler(logReq(createOrgMemberHandler)))
 	http.Handle("/x/createtag", gz.GzipHandler(logReq(createTagHandler)))
+	http.Handle("/x/createteam", gz.GzipHandler(logReq(createTeamHandler)))
+	http.Handle("/x/createteamdatabase", gz.GzipHandler(logReq(createTeamDatabaseHandler)))
+	http.Handle("/x/createteammember", gz.GzipHandler(logReq(createTeamMemberHandler)))
+	http.Handle("/x/createwebhook", gz.GzipHandler(logReq(createWebhookHandler)))
+	http.Handle("/x/deleteapitoken", gz.GzipHandler(logReq(deleteAPITokenHandler)))
 	http.Handle("/x/deletebranch/", gz.GzipHandler(logReq(deleteBranchHandler)))
+	http.Handle("/x/deletecollaborator", gz.GzipHandler(logReq(deleteCollaboratorHandler)))
 	http.Handle("/x/deletecomment/", gz.GzipHandler(logReq(deleteCommentHandler)))
 	http.Handle("/x/deletecommit/", gz.GzipHandler(logReq(deleteCommitHandler)))
 	http.Handle("/x/deletedatabase/", gz.GzipHandler(logReq(deleteDatabaseHandler)))
+	http.Handle("/x/deletelabel", gz.GzipHandler(logReq(deleteLabelHandler)))
+	http.Handle("/x/deletemilestone", gz.GzipHandler(logReq(deleteMilestoneHandler)))
+	http.Handle("/x/deleteorgmember", gz.GzipHandler(logReq(deleteOrgMemberHandler)))
 	http.Handle("/x/deleterelease/", gz.GzipHandler(logReq(deleteReleaseHandler)))
 	http.Handle("/x/deletetag/", gz.GzipHandler(logReq(deleteTagHandler)))
+	http.Handle("/x/deleteteam", gz.GzipHandler(logReq(deleteTeamHandler)))
+	http.Handle("/x/deleteteamdatabase", gz.GzipHandler(logReq(deleteTeamDatabaseHandler)))
+	http.Handle("/x/deleteteammember", gz.GzipHandler(logReq(deleteTeamMemberHandler)))
+	http.Handle("/x/deletewebhook", gz.GzipHandler(logReq(deleteWebhookHandler)))
 	http.Handle("/x/diffcommitlist/", gz.GzipHandler(logReq(diffCommitListHandler)))
 	http.Handle("/x/download/", gz.GzipHandler(logReq(downloadHandler)))
 	http.Handle("/x/downloadcsv/", gz.GzipHandler(logReq(downloadCSVHandler)))
 	http.Handle("/x/downloadredashjson/", gz.GzipHandler(logReq(downloadRedashJSONHandler)))
 	http.Handle("/x/forkdb/", gz.GzipHandler(logReq(forkDBHandler)))
 	http.Handle("/x/gencert", gz.GzipHandler(logReq(generateCertHandler)))
+	http.Handle("/x/live", logReq(liveHandler)) // Not compressed, as the updates need to arrive as they're sent
 	http.Handle("/x/markdownpreview/", gz.GzipHandler(logReq(markdownPreview)))
 	http.Handle("/x/mergerequest/", gz.GzipHandler(logReq(mergeRequestHandler)))
+	http.Handle("/x/offertransfer", gz.GzipHandler(logReq(offerTransferHandler)))
+	http.Handle("/x/redeliverwebhook", gz.GzipHandler(logReq(redeliverWebhookHandler)))
+	http.Handle("/x/restoredatabase", gz.GzipHandler(logReq(restoreDatabaseHandler)))
+	http.Handle("/x/reviewmergerequest", gz.GzipHandler(logReq(reviewMergeRequestHandler)))
+	http.Handle("/x/savediscussiontracking", gz.GzipHandler(logReq(saveDiscussionTrackingHandler)))
+	http.Handle("/x/savelabel", gz.GzipHandler(logReq(saveLabelHandler)))
+	http.Handle("/x/savemilestone", gz.GzipHandler(logReq(saveMilestoneHandler)))
+	http.Handle("/x/savenotifications", gz.GzipHandler(logReq(saveNotificationsHandler)))
 	http.Handle("/x/savesettings", gz.GzipHandler(logReq(saveSettingsHandler)))
 	http.Handle("/x/setdefaultbranch/", gz.GzipHandler(logReq(setDefaultBranchHandler)))
 	http.Handle("/x/star/", gz.GzipHandler(logReq(starToggleHandler)))
+	http.Handle("/x/subscribediscussion/", gz.GzipHandler(logReq(subscribeDiscussionHandler)))
 	http.Handle("/x/table/", gz.GzipHandler(logReq(tableViewHandler)))
 	http.Handle("/x/tablenames/", gz.GzipHandler(logReq(tableNamesHandler)))
+	http.Handle("/x/transferdatabase", gz.GzipHandler(logReq(transferDatabaseHandler)))
+	http.Handle("/x/unsubscribe", gz.GzipHandler(logReq(unsubscribeHandler)))
 	http.Handle("/x/updatebranch/", gz.GzipHandler(logReq(updateBranchHandler)))
 	http.Handle("/x/updatecomment/", gz.GzipHandler(logReq(updateCommentHandler)))
 	http.Handle("/x/updatediscuss/", gz.GzipHandler(logReq(updateDiscussHandler)))
@@ -3715,10 +4400,16 @@ func mergeRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure the request is coming from the database owner
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "Only the database owner can merge in merge requests")
+		fmt.Fprint(w, "You don't have write access to that database")
 		return
 	}
 
@@ -3743,6 +4434,33 @@ func mergeRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Make sure the MR has the approvals required by the merge policy of the database
+	policyStatus, err := com.CheckMergePolicy(dbOwner, dbFolder, dbName, disc[0])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !policyStatus.Satisfied {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "The merge policy for this database isn't satisfied yet: %s",
+			strings.Join(policyStatus.Missing, ", "))
+		return
+	}
+
+	// Make sure the status checks required by the destination branch have passed
+	_, checksMissing, err := com.CheckRequiredStatuses(dbOwner, dbFolder, dbName, disc[0].MRDetails)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if len(checksMissing) > 0 {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Required status checks haven't passed: %s", strings.Join(checksMissing, ", "))
+		return
+	}
+
 	// Get the details of the head commit for the destination database branch
 	branchList, err := com.GetBranches(dbOwner, dbFolder, dbName) // Destination branch list
 	if err != nil {
@@ -3772,6 +4490,14 @@ func mergeRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Make sure the merged database files fit within the storage limit of the destination owner
+	err = com.CheckStorageQuota(dbOwner, com.CommitDatabaseFiles(commitDiffList))
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
 	// * The required details have been collected, and sanity checks completed, so merge the MR *
 
 	// Add the source commits directly to the destination commit list
@@ -3822,12 +4548,40 @@ func mergeRequestHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Change the status of the MR to closed, and indicate it was successfully merged
 	err = com.StoreComment(dbOwner, dbFolder, dbName, loggedInUser, mrID, "", true,
-		com.CLOSED_WITH_MERGE)
+		com.CLOSED_WITH_MERGE, nil)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_MR_MERGE,
+		map[string]string{"branch": branchName, "commit": destCommitID}, map[string]interface{}{"branch": branchName,
+			"commit": mrg.ID, "mr": mrID})
+
+	// Generate an event about the merge
+	details := com.EventDetails{
+		DBName:   dbName,
+		DiscID:   mrID,
+		Folder:   dbFolder,
+		Owner:    dbOwner,
+		Title:    disc[0].Title,
+		Type:     com.EVENT_MERGE_REQUEST_MERGED,
+		URL:      fmt.Sprintf("/merge/%s%s%s?id=%d", dbOwner, dbFolder, dbName, mrID),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		log.Printf("Error when creating a new event: %s\n", err.Error())
+	}
+
+	// Close any discussions the merged commit messages say they fix, when merging into the default branch
+	defBranch, err := com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
+	if err == nil && branchName == defBranch {
+		err = com.CloseDiscussionsFromCommits(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, commitDiffList)
+		if err != nil {
+			log.Printf("Error when closing discussions referenced by merge request '%d': %s\n", mrID, err.Error())
+		}
+	}
 
 	// Invalidate the memcached entries for the destination database case
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -3938,17 +4692,247 @@ func prefHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO  needed so looking up an old email finds the correct username.  For example when looking through historical
 	// TODO  commit data
 
+	// Keep the existing preferences, for the audit log
+	usr, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving user details")
+		return
+	}
+	before := map[string]interface{}{"display_name": usr.DisplayName, "email": usr.Email,
+		"max_rows": com.PrefUserMaxRows(loggedInUser)}
+
 	// Update the preference data in the database
 	err = com.SetUserPreferences(loggedInUser, maxRowsNum, displayName, email)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, "Error when updating preferences")
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, "", "", "", com.AUDIT_PREFERENCES_UPDATE, before,
+		map[string]interface{}{"display_name": displayName, "email": email, "max_rows": maxRowsNum})
 
 	// Bounce to the user home page
 	http.Redirect(w, r, "/"+loggedInUser, http.StatusSeeOther)
 }
 
+// Restores a database from the trash.
+func restoreDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract the required form variables
+	dbOwner := r.PostFormValue("username")
+	err := com.ValidateUser(dbOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid user name")
+		return
+	}
+	dbID, err := strconv.ParseInt(r.PostFormValue("dbid"), 10, 64)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid database ID")
+		return
+	}
+
+	// Only the owner of a database can restore it.  For organisations, that's any of the organisation owners
+	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+		orgPerm, err := com.OrgPermission(loggedInUser, dbOwner)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if orgPerm != com.PERM_OWNER {
+			errorPage(w, r, http.StatusUnauthorized, "Only the owner of a database can restore it")
+			return
+		}
+	}
+
+	// Retrieve the trash entry for the database
+	entry, err := com.DeletedDatabase(dbOwner, dbID)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entry.DBName == "" {
+		errorPage(w, r, http.StatusNotFound, "That database isn't in the trash")
+		return
+	}
+
+	// Make sure a database with the same name hasn't been added since
+	exists, err := com.CheckDBExists(dbOwner, dbOwner, entry.Folder, entry.DBName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		errorPage(w, r, http.StatusConflict, fmt.Sprintf("There's already a database called '%s'.  It will need "+
+			"to be renamed or deleted before this one can be restored", entry.DBName))
+		return
+	}
+
+	// Make sure restoring the database won't take the owner over their database limit
+	err = com.CheckDatabaseQuota(dbOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Restore the database
+	err = com.RestoreDatabase(dbOwner, dbID)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, entry.Folder, entry.DBName, com.AUDIT_DATABASE_RESTORE, nil, nil)
+
+	// Bounce to the restored database
+	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", entry.Owner, entry.Folder, entry.DBName), http.StatusSeeOther)
+}
+
+// Records an approval or change request for a merge request by the logged in user.  Returns the updated merge policy
+// status of the MR.
+func reviewMergeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
+		return
+	}
+	loggedInUser = u.(string)
+
+	// Extract and validate the form variables
+	dbOwner, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+	mrID, err := strconv.Atoi(r.PostFormValue("mrid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error when parsing merge request id value")
+		return
+	}
+	state := com.ReviewState(r.PostFormValue("state"))
+	if state != com.REVIEW_APPROVED && state != com.REVIEW_CHANGES_REQUESTED {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown review state")
+		return
+	}
+
+	// Check if the requested database exists
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName)
+		return
+	}
+
+	// Only people who could merge the MR themselves can review it
+	allowed, err := com.CheckWriteAccess(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "You don't have write access to that database")
+		return
+	}
+
+	// Retrieve the MR, and make sure it can still be reviewed
+	disc, err := com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, mrID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if len(disc) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Unknown merge request ID")
+		return
+	}
+	if !disc[0].Open {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Cannot review a closed merge request")
+		return
+	}
+	if strings.ToLower(disc[0].Creator) == strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "You can't review your own merge request")
+		return
+	}
+	if len(disc[0].MRDetails.Commits) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "The merge request doesn't have any commits to review")
+		return
+	}
+	headID := disc[0].MRDetails.Commits[0].ID
+
+	// Save the review
+	err = com.StoreMergeRequestReview(dbOwner, dbFolder, dbName, mrID, loggedInUser, state, headID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_MR_REVIEW, nil,
+		map[string]interface{}{"mr_id": mrID, "state": state, "commit_id": headID})
+
+	// Return the updated merge policy status
+	status, err := com.CheckMergePolicy(dbOwner, dbFolder, dbName, disc[0])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonData))
+}
+
 // Handler for the Database Settings page
 func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve session data (if any)
@@ -3996,34 +4980,184 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
-		errorPage(w, r, http.StatusBadRequest, "You can only change settings for your own databases.")
+	// Make sure the logged in user has admin access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_ADMIN)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusBadRequest, "You don't have admin access to that database")
+		return
+	}
+
+	// Keep the existing settings, for the audit log
+	var oldDB com.SQLiteDBinfo
+	err = com.DBDetails(&oldDB, loggedInUser, dbOwner, dbFolder, dbName, "")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	oldProtection, err := com.GetBranchProtection(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	oldPolicy, err := com.GetMergePolicy(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	oldAssertions, err := com.GetSQLAssertions(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Extract the form variables
+	oneLineDesc := r.PostFormValue("onelinedesc")
+	newName := r.PostFormValue("newname")
+	fullDesc := r.PostFormValue("fulldesc")
+	defTable := r.PostFormValue("defaulttable") // TODO: Update the default table to be "per branch"
+	licences := r.PostFormValue("licences")
+	protection := r.PostFormValue("protection")
+	policy := r.PostFormValue("mergepolicy")
+	assertions := r.PostFormValue("sqlassertions")
+
+	// Validate the licence names
+	branchLics := make(map[string]string)
+	err = json.Unmarshal([]byte(licences), &branchLics)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	for bName, lName := range branchLics {
+		err = com.ValidateLicence(lName)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, fmt.Sprintf(
+				"Validation failed on licence name for branch '%s'", bName))
+			return
+		}
+	}
+
+	// Parse the branch protection settings
+	branchProtection := make(map[string]com.BranchProtectionEntry)
+	if protection != "" {
+		err = json.Unmarshal([]byte(protection), &branchProtection)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Branch protection settings are incorrect")
+			return
+		}
+	}
+	for bName, p := range branchProtection {
+		var checks []string
+		for _, c := range p.RequiredChecks {
+			c = strings.TrimSpace(c)
+			if c == "" {
+				continue
+			}
+			err = com.ValidateStatusContext(c)
+			if err != nil {
+				errorPage(w, r, http.StatusBadRequest, fmt.Sprintf(
+					"Validation failed on required status check name for branch '%s'", bName))
+				return
+			}
+			checks = append(checks, c)
+		}
+		p.RequiredChecks = checks
+		branchProtection[bName] = p
+	}
+
+	// Parse and validate the SQL assertions
+	sqlAssertions := []com.SQLAssertion{}
+	if assertions != "" {
+		err = json.Unmarshal([]byte(assertions), &sqlAssertions)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "SQL assertion settings are incorrect")
+			return
+		}
+	}
+	assertionNames := make(map[string]bool)
+	for _, a := range sqlAssertions {
+		err = com.ValidateStatusContext(com.SQLAssertionContext(a.Name))
+		if err != nil || a.Name == "" {
+			errorPage(w, r, http.StatusBadRequest, "Validation failed on SQL assertion name")
+			return
+		}
+		if assertionNames[a.Name] {
+			errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("There's more than one SQL assertion named '%s'",
+				a.Name))
+			return
+		}
+		assertionNames[a.Name] = true
+		if strings.TrimSpace(a.Query) == "" || len(a.Query) > 4096 {
+			errorPage(w, r, http.StatusBadRequest, fmt.Sprintf(
+				"The query for SQL assertion '%s' is missing or too long", a.Name))
+			return
+		}
+	}
 
-	// Extract the form variables
-	oneLineDesc := r.PostFormValue("onelinedesc")
-	newName := r.PostFormValue("newname")
-	fullDesc := r.PostFormValue("fulldesc")
-	defTable := r.PostFormValue("defaulttable") // TODO: Update the default table to be "per branch"
-	licences := r.PostFormValue("licences")
-
-	// Validate the licence names
-	branchLics := make(map[string]string)
-	err = json.Unmarshal([]byte(licences), &branchLics)
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
+	// Parse and validate the merge policy
+	mergePolicy := com.MergePolicy{Reviewers: []string{}, Teams: []string{}}
+	if policy != "" {
+		err = json.Unmarshal([]byte(policy), &mergePolicy)
+		if err != nil || mergePolicy.MinApprovals < 0 || mergePolicy.MinApprovals > 20 {
+			errorPage(w, r, http.StatusBadRequest, "Merge policy settings are incorrect")
+			return
+		}
 	}
-	for bName, lName := range branchLics {
-		err = com.ValidateLicence(lName)
+	reviewers := []string{}
+	for _, reviewer := range mergePolicy.Reviewers {
+		reviewer = strings.TrimSpace(reviewer)
+		if reviewer == "" {
+			continue
+		}
+		err = com.ValidateUser(reviewer)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid user name in the list of required reviewers")
+			return
+		}
+		canWrite, err := com.CheckWriteAccess(reviewer, dbOwner, dbFolder, dbName)
 		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !canWrite {
 			errorPage(w, r, http.StatusBadRequest, fmt.Sprintf(
-				"Validation failed on licence name for branch '%s'", bName))
+				"Required reviewer '%s' doesn't have write access to the database", reviewer))
+			return
+		}
+		reviewers = append(reviewers, reviewer)
+	}
+	mergePolicy.Reviewers = reviewers
+	teams := []string{}
+	if len(mergePolicy.Teams) > 0 {
+		orgTeams, err := com.OrgTeams(dbOwner)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
+		for _, teamName := range mergePolicy.Teams {
+			teamName = strings.TrimSpace(teamName)
+			if teamName == "" {
+				continue
+			}
+			found := false
+			for _, t := range orgTeams {
+				if strings.ToLower(t.Name) == strings.ToLower(teamName) {
+					teamName = t.Name
+					found = true
+					break
+				}
+			}
+			if !found {
+				errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown team '%s'", teamName))
+				return
+			}
+			teams = append(teams, teamName)
+		}
 	}
+	mergePolicy.Teams = teams
 
 	// Validate the source URL
 	sourceURL, err := com.GetFormSourceURL(r)
@@ -4168,7 +5302,7 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		licSHA := dbEntry.LicenceSHA
 		var oldLic string
 		if licSHA != "" {
-			oldLic, _, err = com.GetLicenceInfoFromSha256(loggedInUser, licSHA)
+			oldLic, _, err = com.GetLicenceInfoFromSha256(dbOwner, licSHA)
 			if err != nil {
 				errorPage(w, r, http.StatusInternalServerError, err.Error())
 				return
@@ -4261,6 +5395,40 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Save the branch protection settings, only keeping entries for existing branches which have some protection.  If
+	// no settings were sent, the existing ones are left alone rather than being removed
+	newProtection := oldProtection
+	if protection != "" {
+		newProtection = make(map[string]com.BranchProtectionEntry)
+		for bName, p := range branchProtection {
+			if _, ok := branchList[bName]; !ok {
+				continue
+			}
+			if p.NoDelete || p.NoForcePush || p.RequireMR || len(p.RequiredChecks) > 0 {
+				newProtection[bName] = p
+			}
+		}
+		err = com.StoreBranchProtection(dbOwner, dbFolder, dbName, newProtection)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Save the merge policy
+	err = com.StoreMergePolicy(dbOwner, dbFolder, dbName, mergePolicy)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Save the SQL assertions
+	err = com.StoreSQLAssertions(dbOwner, dbFolder, dbName, sqlAssertions)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// If the database doesn't have a 1-liner description, don't save the placeholder text as one
 	if oneLineDesc == "No description" {
 		oneLineDesc = ""
@@ -4290,8 +5458,59 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Record the changed settings in the audit log
+	if oldDB.Info.OneLineDesc == "No description" {
+		oldDB.Info.OneLineDesc = ""
+	}
+	if oldDB.Info.FullDesc == "No full description" {
+		oldDB.Info.FullDesc = ""
+	}
+	finalName := dbName
+	if newName != "" {
+		finalName = newName
+	}
+	before := make(map[string]interface{})
+	after := make(map[string]interface{})
+	com.AuditChange(before, after, "name", dbName, finalName)
+	com.AuditChange(before, after, "public", oldDB.Info.Public, public)
+	com.AuditChange(before, after, "one_line_description", oldDB.Info.OneLineDesc, oneLineDesc)
+	com.AuditChange(before, after, "full_description", oldDB.Info.FullDesc, fullDesc)
+	com.AuditChange(before, after, "default_table", oldDB.Info.DefaultTable, defTable)
+	com.AuditChange(before, after, "default_branch", oldDB.Info.DefaultBranch, defBranch)
+	com.AuditChange(before, after, "source_url", oldDB.Info.SourceURL, sourceURL)
+	com.AuditChange(before, after, "branch_protection", oldProtection, newProtection)
+	com.AuditChange(before, after, "merge_policy", oldPolicy, mergePolicy)
+	com.AuditChange(before, after, "sql_assertions", oldAssertions, sqlAssertions)
+	if branchesUpdated {
+		for bName, bEntry := range newBranchHeads {
+			com.AuditChange(before, after, "branch_heads."+bName, branchList[bName].Commit, bEntry.Commit)
+		}
+		after["licences"] = branchLics
+	}
+	if len(after) > 0 {
+		com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, finalName, com.AUDIT_DATABASE_SETTINGS, before,
+			after)
+	}
+
+	// Generate an event if the database has just been made public
+	if public && !oldDB.Info.Public {
+		details := com.EventDetails{
+			DBName:   finalName,
+			Folder:   dbFolder,
+			Owner:    dbOwner,
+			Title:    "Database made public",
+			Type:     com.EVENT_DATABASE_PUBLIC,
+			URL:      fmt.Sprintf("/%s%s%s", dbOwner, dbFolder, finalName),
+			UserName: loggedInUser,
+		}
+		err = com.NewEvent(details)
+		if err != nil {
+			log.Printf("Error when creating a new event: %s\n", err.Error())
+		}
+	}
+
 	// Settings saved, so bounce back to the database page
-	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", loggedInUser, dbFolder, newName), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", dbOwner, dbFolder, newName), http.StatusSeeOther)
 }
 
 // This function sets a branch as the default for a given database.
@@ -4356,8 +5575,14 @@ func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -4376,11 +5601,18 @@ func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set the default branch
+	oldDefault, err := com.GetDefaultBranchName(dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	err = com.StoreDefaultBranchName(dbOwner, dbFolder, dbName, branchName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_BRANCH_DEFAULT,
+		map[string]string{"branch": oldDefault}, map[string]string{"branch": branchName})
 
 	// Invalidate the memcache data for the database, so the new default branch gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -4394,6 +5626,18 @@ func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// Works out what the logged in user is allowed to change for a database, and sets the matching flags in the page
+// meta info.
+func setPermissions(meta *com.MetaInfo, loggedInUser string, dbOwner string, dbFolder string, dbName string) error {
+	perm, err := com.DBPermission(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		return err
+	}
+	meta.CanAdmin = perm >= com.PERM_ADMIN
+	meta.CanWrite = perm >= com.PERM_WRITE
+	return nil
+}
+
 // Handles JSON requests from the front end to toggle a database's star.
 func starToggleHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the user and database name
@@ -4437,6 +5681,27 @@ func starToggleHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "-1") // -1 tells the front end not to update the displayed star count
 		return
 	}
+	if starred, err := com.CheckDBStarred(loggedInUser, dbOwner, "/", dbName); err == nil {
+		com.Audit(r, "webui", loggedInUser, dbOwner, "/", dbName, com.AUDIT_STAR_TOGGLE, nil,
+			map[string]bool{"starred": starred})
+
+		// Generate an event about the new star.  Removing a star isn't announced
+		if starred {
+			details := com.EventDetails{
+				DBName:   dbName,
+				Folder:   "/",
+				Owner:    dbOwner,
+				Title:    fmt.Sprintf("Starred by %s", loggedInUser),
+				Type:     com.EVENT_NEW_STAR,
+				URL:      fmt.Sprintf("/stars/%s/%s", dbOwner, dbName),
+				UserName: loggedInUser,
+			}
+			err = com.NewEvent(details)
+			if err != nil {
+				log.Printf("Error when creating a new event: %s\n", err.Error())
+			}
+		}
+	}
 
 	// Invalidate the old memcached entry for the database
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, "/", dbName, "") // Empty string indicates "for all versions"
@@ -4515,15 +5780,21 @@ func tableNamesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has admin access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_ADMIN)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprint(w, "Access denied")
 		return
 	}
 
 	// Load the existing branchHeads for the database
-	branches, err := com.GetBranches(loggedInUser, dbFolder, dbName)
+	branches, err := com.GetBranches(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -4866,6 +6137,125 @@ func tableViewHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", jsonResponse)
 }
 
+// Moves a database to a different owner, either one of the logged in user's organisations or the logged in user
+// themselves.  The database keeps its history, stars, and watchers.
+func transferDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+	newOwner := r.PostFormValue("newowner")
+	err = com.ValidateUser(newOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid new owner name")
+		return
+	}
+	if strings.ToLower(newOwner) == dbOwner {
+		errorPage(w, r, http.StatusBadRequest, "The database already belongs to that owner")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
+			dbName))
+		return
+	}
+
+	// Make sure the logged in user owns the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_OWNER)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "Only the owner of a database can transfer it")
+		return
+	}
+
+	// Databases can be moved to the logged in user, or to an organisation they're an owner of
+	if strings.ToLower(newOwner) != strings.ToLower(loggedInUser) {
+		orgPerm, err := com.OrgPermission(loggedInUser, newOwner)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if orgPerm != com.PERM_OWNER {
+			errorPage(w, r, http.StatusUnauthorized,
+				"Databases can only be transferred to yourself, or to an organisation you're an owner of")
+			return
+		}
+	}
+
+	// Make sure the new owner doesn't already have a database of the same name
+	exists, err = com.CheckDBExists(newOwner, newOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		errorPage(w, r, http.StatusConflict, fmt.Sprintf("'%s' already has a database called '%s'", newOwner,
+			dbName))
+		return
+	}
+
+	// Transfer the database
+	err = com.TransferDatabase(dbOwner, dbFolder, dbName, newOwner)
+	if err != nil {
+		if _, ok := err.(com.LicenceClashError); ok {
+			errorPage(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, newOwner, dbFolder, dbName, com.AUDIT_DATABASE_TRANSFER,
+		map[string]string{"owner": dbOwner}, map[string]string{"owner": newOwner})
+
+	// Invalidate the memcache data for the database under its old owner
+	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	}
+
+	// Bounce to the database at its new location
+	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", newOwner, dbFolder, dbName), http.StatusSeeOther)
+}
+
 // This function processes branch rename and description updates.
 func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
 	pageName := "Update Branch handler"
@@ -4959,14 +6349,20 @@ func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing branchHeads for the database
-	branches, err := com.GetBranches(loggedInUser, dbFolder, dbName)
+	branches, err := com.GetBranches(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -5008,6 +6404,27 @@ func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// If the branch is being renamed, move its protection settings across to the new name
+	if newName != branchName {
+		protection, err := com.GetBranchProtection(dbOwner, dbFolder, dbName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if p, ok := protection[branchName]; ok {
+			delete(protection, branchName)
+			protection[newName] = p
+			err = com.StoreBranchProtection(dbOwner, dbFolder, dbName, protection)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_BRANCH_UPDATE,
+		map[string]string{"branch": branchName, "description": oldInfo.Description},
+		map[string]string{"branch": newName, "description": newDesc})
+
 	// Invalidate the memcache data for the database, so the new branch name gets picked up
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
 	if err != nil {
@@ -5125,6 +6542,18 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Retrieve the existing comment text, for the audit log
+	oldCom, err := com.DiscussionComments(dbOwner, dbFolder, dbName, discID, comID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if len(oldCom) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
 	// Update the discussion text
 	err = com.UpdateComment(dbOwner, dbFolder, dbName, loggedInUser, discID, comID, newTxt)
 	if err != nil {
@@ -5132,10 +6561,14 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_COMMENT_UPDATE,
+		map[string]interface{}{"discussion": discID, "comment": comID, "text": oldCom[0].Body},
+		map[string]interface{}{"discussion": discID, "comment": comID, "text": newTxt})
 
 	// Update succeeded
+	refTypes, _ := com.DiscussionTypes(dbOwner, dbFolder, dbName)
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(gfm.Markdown([]byte(newTxt))))
+	fmt.Fprint(w, com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, newTxt, refTypes))
 }
 
 // This function processes discussion title and body text updates.
@@ -5248,6 +6681,23 @@ func updateDiscussHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Retrieve the existing discussion details, for the audit log.  This handler is used for both discussions and
+	// merge requests, so check both types
+	oldDisc, err := com.Discussions(dbOwner, dbFolder, dbName, com.DISCUSSION, discID)
+	if err == nil && len(oldDisc) == 0 {
+		oldDisc, err = com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, discID)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if len(oldDisc) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Discussion not found")
+		return
+	}
+
 	// Update the discussion text
 	err = com.UpdateDiscussion(dbOwner, dbFolder, dbName, loggedInUser, discID, newTitle, newTxt)
 	if err != nil {
@@ -5255,10 +6705,14 @@ func updateDiscussHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_DISCUSSION_UPDATE,
+		map[string]interface{}{"discussion": discID, "title": oldDisc[0].Title, "text": oldDisc[0].Body},
+		map[string]interface{}{"discussion": discID, "title": newTitle, "text": newTxt})
 
 	// Update succeeded
+	refTypes, _ := com.DiscussionTypes(dbOwner, dbFolder, dbName)
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(gfm.Markdown([]byte(newTxt))))
+	fmt.Fprint(w, com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, newTxt, refTypes))
 }
 
 // This function processes release rename and description updates.
@@ -5354,14 +6808,20 @@ func updateReleaseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing releases for the database
-	releases, err := com.GetReleases(loggedInUser, dbFolder, dbName)
+	releases, err := com.GetReleases(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -5390,6 +6850,9 @@ func updateReleaseHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_RELEASE_UPDATE,
+		map[string]string{"release": relName, "description": oldInfo.Description},
+		map[string]string{"release": newName, "description": newDesc})
 
 	// Update succeeded
 	w.WriteHeader(http.StatusOK)
@@ -5488,14 +6951,20 @@ func updateTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Load the existing tags for the database
-	tags, err := com.GetTags(loggedInUser, dbFolder, dbName)
+	tags, err := com.GetTags(dbOwner, dbFolder, dbName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -5523,6 +6992,9 @@ func updateTagHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_TAG_UPDATE,
+		map[string]string{"tag": tagName, "description": oldInfo.Description},
+		map[string]string{"tag": newName, "description": newMsg})
 
 	// Update succeeded
 	w.WriteHeader(http.StatusOK)
@@ -5535,9 +7007,6 @@ func uploadDataHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: Investigate getting the last modified timestamp of the database file selected for upload
 	// TODO   * https://developer.mozilla.org/en-US/docs/Web/API/File/lastModified
 
-	// Set the maximum accepted database size for uploading
-	r.Body = http.MaxBytesReader(w, r.Body, com.MaxDatabaseSize*1024*1024)
-
 	// Retrieve session data (if any)
 	var loggedInUser string
 	var u interface{}
@@ -5564,13 +7033,19 @@ func uploadDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check whether the uploaded database is too large
-	if r.ContentLength > (com.MaxDatabaseSize * 1024 * 1024) {
-		errorPage(w, r, http.StatusBadRequest,
-			fmt.Sprintf("Database is too large. Maximum database upload size is %d MB, yours is %d MB",
-				com.MaxDatabaseSize, r.ContentLength/1024/1024))
-		log.Println(fmt.Sprintf("'%s' attempted to upload an oversized database %d MB in size.  Limit is %d MB\n",
-			loggedInUser, r.ContentLength/1024/1024, com.MaxDatabaseSize))
-		return
+	maxSize := com.MaxUploadSize(loggedInUser)
+	if maxSize > 0 {
+		// Set the maximum accepted database size for uploading
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+		if r.ContentLength > maxSize {
+			errorPage(w, r, http.StatusBadRequest,
+				fmt.Sprintf("Database is too large. Maximum database upload size is %d MB, yours is %d MB",
+					maxSize/1024/1024, r.ContentLength/1024/1024))
+			log.Println(fmt.Sprintf("'%s' attempted to upload an oversized database %d MB in size.  Limit is %d MB\n",
+				loggedInUser, r.ContentLength/1024/1024, maxSize/1024/1024))
+			return
+		}
 	}
 
 	// Prepare the form data
@@ -5682,8 +7157,12 @@ func uploadDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Sanity check the uploaded database, and if ok then add it to the system
 	numBytes, _, err := com.AddDatabase(r, loggedInUser, loggedInUser, dbFolder, dbName, createBranch, branchName,
-		commitID, public, licenceName, commitMsg, sourceURL, tempFile, "webui", time.Now(), time.Time{},
+		commitID, false, public, licenceName, commitMsg, sourceURL, tempFile, "webui", time.Now(), time.Time{},
 		"", "", "", "", nil, "")
+	if err == com.ErrBranchMoved {
+		errorPage(w, r, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
@@ -5741,6 +7220,10 @@ func watchToggleHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, err.Error())
 		return
 	}
+	if watching, err := com.CheckDBWatched(loggedInUser, dbOwner, dbFolder, dbName); err == nil {
+		com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_WATCH_TOGGLE, nil,
+			map[string]bool{"watching": watching})
+	}
 
 	// Invalidate the old memcached entry for the database
 	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// Saves the notification settings of the logged in user.  Without a database in the form data, the defaults for the
+// user are saved.  Otherwise the settings are for one of the databases they're watching.
+func saveNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	loggedInUser := u.(string)
+
+	// Extract the database details, if given
+	var dbOwner, dbFolder, dbName string
+	if r.PostFormValue("dbname") != "" {
+		usr, f, n, err := com.GetUFD(r, false)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing or incorrect data supplied")
+			return
+		}
+		dbOwner, dbFolder, dbName = strings.ToLower(usr), f, n
+	}
+
+	// Validate the delivery frequency.  Watched databases can also use the defaults of the user, indicated by an empty
+	// value
+	freq := com.NotifyFrequency(r.PostFormValue("frequency"))
+	switch freq {
+	case com.NOTIFY_IMMEDIATE, com.NOTIFY_DAILY, com.NOTIFY_WEEKLY, com.NOTIFY_WEBUI:
+	case "":
+		if dbName == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing notification frequency")
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown notification frequency")
+		return
+	}
+
+	// Validate the event filter.  No events selected means all of them
+	events := []com.EventType{}
+	if e := r.PostFormValue("events"); e != "" {
+		for _, s := range strings.Split(e, ",") {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "Invalid event list")
+				return
+			}
+			if _, ok := com.EventNames[com.EventType(n)]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Unknown event type '%d'", n)
+				return
+			}
+			events = append(events, com.EventType(n))
+		}
+	}
+
+	// Save the settings
+	err := com.StoreNotifySettings(loggedInUser, dbOwner, dbFolder, dbName, freq, events)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_NOTIFY_UPDATE, nil,
+		map[string]interface{}{"frequency": freq, "events": events})
+	w.WriteHeader(http.StatusOK)
+}
+
+// Unsubscribes a user from notification emails, using the signed link included in each email.  This doesn't need the
+// user to be logged in, as mail clients can call it directly (RFC 8058).
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	userName := r.FormValue("user")
+	err := com.ValidateUser(userName)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid user name")
+		return
+	}
+	dbID, err := strconv.ParseInt(r.FormValue("db"), 10, 64)
+	if err != nil || dbID < 0 {
+		errorPage(w, r, http.StatusBadRequest, "Invalid database id")
+		return
+	}
+	if !com.ValidUnsubscribeSignature(userName, dbID, r.FormValue("sig")) {
+		errorPage(w, r, http.StatusForbidden, "That unsubscribe link isn't valid")
+		return
+	}
+
+	// Stop the emails
+	dbOwner, dbFolder, dbName, err := com.Unsubscribe(userName, dbID)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Unsubscribing failed")
+		return
+	}
+	com.Audit(r, "webui", userName, dbOwner, dbFolder, dbName, com.AUDIT_NOTIFY_UNSUBSCRIBE, nil,
+		map[string]interface{}{"frequency": com.NOTIFY_WEBUI})
+
+	// Mail clients doing a one-click unsubscribe only need the status code
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	unsubscribePage(w, r, dbOwner, dbFolder, dbName)
+}
+
+// Subscribes or unsubscribes the logged in user from a discussion or merge request.
+func subscribeDiscussionHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
+		return
+	}
+	loggedInUser := u.(string)
+
+	// Extract and validate the form variables
+	dbOwner, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+	discID, err := strconv.Atoi(r.PostFormValue("discid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect discussion id")
+		return
+	}
+	subscribe := r.PostFormValue("subscribe") == "true"
+
+	// Check if the requested database exists
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName)
+		return
+	}
+
+	// Save the subscription
+	err = com.StoreDiscussionSubscription(loggedInUser, dbOwner, dbFolder, dbName, discID, subscribe)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_DISCUSSION_SUBSCRIBE, nil,
+		map[string]interface{}{"discussion": discID, "subscribed": subscribe})
+	w.WriteHeader(http.StatusOK)
+}
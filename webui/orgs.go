@@ -0,0 +1,692 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// Receives the form data for creating a new organisation.  The logged in user becomes its first owner.
+func createOrgHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Validate the organisation name.  Organisations share their name space with users
+	orgName := r.PostFormValue("orgname")
+	err := com.ValidateUser(orgName)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid organisation name")
+		return
+	}
+	exists, err := com.CheckUserExists(orgName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		errorPage(w, r, http.StatusConflict, fmt.Sprintf("The name '%s' is already taken", orgName))
+		return
+	}
+
+	// Validate the (optional) display name
+	displayName := r.PostFormValue("displayname")
+	if displayName != "" {
+		err = com.ValidateDisplayName(displayName)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid display name")
+			return
+		}
+	}
+
+	// Create the organisation
+	err = com.CreateOrganisation(loggedInUser, orgName, displayName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_ORG_CREATE, nil,
+		map[string]string{"display_name": displayName})
+
+	// Bounce to the page for the new organisation
+	http.Redirect(w, r, fmt.Sprintf("/%s", orgName), http.StatusSeeOther)
+}
+
+// Adds a user to an organisation, or changes their role in it.  Returns the updated member list.
+func createOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate the member name and role
+	member, ok := orgMemberName(w, r)
+	if !ok {
+		return
+	}
+	role := com.OrgRole(r.PostFormValue("role"))
+	if role != com.ORG_OWNER && role != com.ORG_MEMBER {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown member role")
+		return
+	}
+
+	// Don't allow the last owner to be demoted, as that would leave the organisation unmanageable
+	if role != com.ORG_OWNER {
+		members, err := com.OrgMembers(orgName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if isLastOrgOwner(members, member) {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "An organisation needs at least one owner")
+			return
+		}
+	}
+
+	// Save the member
+	err := com.StoreOrgMember(orgName, member, role)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_ORG_MEMBER_ADD, nil,
+		map[string]string{"member": member, "role": string(role)})
+
+	// Return the updated member list
+	members, err := com.OrgMembers(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	jsonList, err := json.Marshal(members)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonList))
+}
+
+// Creates a team in an organisation, or updates an existing one.  Returns the updated team list.
+func createTeamHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate the team details
+	teamName := r.PostFormValue("name")
+	err := com.ValidateTeamName(teamName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid team name")
+		return
+	}
+	desc := r.PostFormValue("description")
+	if desc != "" {
+		err = com.ValidateDiscussionTitle(desc)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid team description")
+			return
+		}
+	}
+	role := com.CollaboratorRole(r.PostFormValue("role"))
+	switch role {
+	case com.COLLAB_READ, com.COLLAB_WRITE, com.COLLAB_ADMIN:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown team role")
+		return
+	}
+
+	// Save the team
+	err = com.StoreTeam(orgName, teamName, desc, role)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_TEAM_CREATE, nil,
+		map[string]string{"team": teamName, "description": desc, "role": string(role)})
+	orgTeamsResponse(w, orgName)
+}
+
+// Gives a team of an organisation access to one of the organisation's databases.  Returns the updated team list.
+func createTeamDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+	teamName, dbName, ok := teamDatabaseNames(w, r)
+	if !ok {
+		return
+	}
+
+	// TODO: Add folder support
+	dbFolder := "/"
+	exists, err := com.CheckDBExists(orgName, orgName, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", orgName, dbFolder, dbName)
+		return
+	}
+
+	// Give the team access to the database
+	err = com.StoreTeamDatabase(orgName, teamName, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, dbFolder, dbName, com.AUDIT_TEAM_DATABASE_ADD, nil,
+		map[string]string{"team": teamName})
+	orgTeamsResponse(w, orgName)
+}
+
+// Adds a user to a team of an organisation.  Returns the updated team list.
+func createTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate the team and member names
+	teamName := r.PostFormValue("team")
+	err := com.ValidateTeamName(teamName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid team name")
+		return
+	}
+	member, ok := orgMemberName(w, r)
+	if !ok {
+		return
+	}
+
+	// Add the user to the team
+	err = com.StoreTeamMember(orgName, teamName, member)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_TEAM_MEMBER_ADD, nil,
+		map[string]string{"team": teamName, "member": member})
+	orgTeamsResponse(w, orgName)
+}
+
+// Removes a user from an organisation.  Owners can remove anyone, and members can always remove themselves.  Returns
+// the updated member and team lists.
+func deleteOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Validate the organisation and member names
+	orgName := r.PostFormValue("orgname")
+	err := com.ValidateUser(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid organisation name")
+		return
+	}
+	member := r.PostFormValue("member")
+	err = com.ValidateUser(member)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid user name")
+		return
+	}
+
+	// Only owners can remove other people
+	if strings.ToLower(member) != strings.ToLower(loggedInUser) {
+		perm, err := com.OrgPermission(loggedInUser, orgName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if perm != com.PERM_OWNER {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "Only organisation owners can do that")
+			return
+		}
+	}
+
+	// Don't allow the last owner to leave
+	members, err := com.OrgMembers(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if isLastOrgOwner(members, member) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "An organisation needs at least one owner")
+		return
+	}
+
+	// Remove the member
+	err = com.DeleteOrgMember(orgName, member)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_ORG_MEMBER_REMOVE,
+		map[string]string{"member": member}, nil)
+
+	// Return the updated member and team lists
+	var lists struct {
+		Members []com.OrgMemberEntry `json:"members"`
+		Teams   []com.TeamEntry      `json:"teams"`
+	}
+	lists.Members, err = com.OrgMembers(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	lists.Teams, err = com.OrgTeams(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if lists.Teams == nil {
+		lists.Teams = []com.TeamEntry{}
+	}
+	jsonList, err := json.Marshal(lists)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonList))
+}
+
+// Deletes a team from an organisation.  Returns the updated team list.
+func deleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+	teamName := r.PostFormValue("team")
+	err := com.ValidateTeamName(teamName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid team name")
+		return
+	}
+	err = com.DeleteTeam(orgName, teamName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_TEAM_DELETE, map[string]string{"team": teamName},
+		nil)
+	orgTeamsResponse(w, orgName)
+}
+
+// Takes away the access of an organisation team to one of the organisation's databases.  Returns the updated team
+// list.
+func deleteTeamDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+	teamName, dbName, ok := teamDatabaseNames(w, r)
+	if !ok {
+		return
+	}
+
+	// TODO: Add folder support
+	dbFolder := "/"
+	err := com.DeleteTeamDatabase(orgName, teamName, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, dbFolder, dbName, com.AUDIT_TEAM_DATABASE_REMOVE,
+		map[string]string{"team": teamName}, nil)
+	orgTeamsResponse(w, orgName)
+}
+
+// Removes a user from a team of an organisation.  Returns the updated team list.
+func deleteTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, orgName, ok := orgOwnerCheck(w, r)
+	if !ok {
+		return
+	}
+	teamName := r.PostFormValue("team")
+	err := com.ValidateTeamName(teamName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid team name")
+		return
+	}
+	member := r.PostFormValue("member")
+	err = com.ValidateUser(member)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid user name")
+		return
+	}
+	err = com.DeleteTeamMember(orgName, teamName, member)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, orgName, "", "", com.AUDIT_TEAM_MEMBER_REMOVE,
+		map[string]string{"team": teamName, "member": member}, nil)
+	orgTeamsResponse(w, orgName)
+}
+
+// Returns true if the given user is the only owner in an organisation member list.
+func isLastOrgOwner(members []com.OrgMemberEntry, userName string) bool {
+	isOwner := false
+	numOwners := 0
+	for _, m := range members {
+		if m.Role != com.ORG_OWNER {
+			continue
+		}
+		numOwners++
+		if strings.ToLower(m.UserName) == strings.ToLower(userName) {
+			isOwner = true
+		}
+	}
+	return isOwner && numOwners == 1
+}
+
+// Validates the "member" form field of organisation requests, making sure it's an existing user (not an
+// organisation).  If not, an error response is sent and false returned.
+func orgMemberName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	member := r.PostFormValue("member")
+	err := com.ValidateUser(member)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid user name")
+		return "", false
+	}
+	usr, err := com.User(member)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return "", false
+	}
+	if usr.Username == "" || usr.IsOrg {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Unknown user '%s'", member)
+		return "", false
+	}
+	return usr.Username, true
+}
+
+// Checks the "orgname" form field of a request refers to an organisation the logged in user is an owner of.  If not,
+// an error response is sent and false returned.
+func orgOwnerCheck(w http.ResponseWriter, r *http.Request) (loggedInUser string, orgName string, ok bool) {
+	// Retrieve session data (if any)
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	loggedInUser = u.(string)
+
+	// Validate the organisation name
+	orgName = r.PostFormValue("orgname")
+	err := com.ValidateUser(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid organisation name")
+		return
+	}
+
+	// Make sure the logged in user is an owner of the organisation
+	perm, err := com.OrgPermission(loggedInUser, orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if perm != com.PERM_OWNER {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "Only organisation owners can do that")
+		return
+	}
+	ok = true
+	return
+}
+
+// Renders the page for an organisation, listing its databases, members and teams.  Organisation owners can manage
+// the members and teams from here too.
+func orgPage(w http.ResponseWriter, r *http.Request, orgName string) {
+	// Structure to hold page data
+	var pageData struct {
+		Auth0        com.Auth0Set
+		DBRows       []com.DBInfo
+		FullName     string
+		IsOwner      bool
+		Members      []com.OrgMemberEntry
+		Meta         com.MetaInfo
+		OrgAvatarURL string
+		Teams        []com.TeamEntry
+	}
+	pageData.Meta.Server = com.Conf.Web.ServerName
+
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		pageData.Meta.LoggedInUser = loggedInUser
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Retrieve the details for the organisation
+	org, err := com.User(orgName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.FullName = org.DisplayName
+	pageData.Meta.Owner = org.Username
+	pageData.Meta.Title = org.Username
+	if org.AvatarURL != "" {
+		pageData.OrgAvatarURL = org.AvatarURL + "&s=48"
+	}
+
+	// Members of the organisation can see the private databases they have access to, as well as the public ones
+	perm, err := com.OrgPermission(loggedInUser, orgName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.IsOwner = perm == com.PERM_OWNER
+	access := com.DB_PUBLIC
+	if perm >= com.PERM_READ {
+		access = com.DB_BOTH
+	}
+	dbList, err := com.UserDBs(orgName, access)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	for _, db := range dbList {
+		if !db.Public && !pageData.IsOwner {
+			dbPerm, err := com.DBPermission(loggedInUser, orgName, db.Folder, db.Database)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if dbPerm < com.PERM_READ {
+				continue
+			}
+		}
+		pageData.DBRows = append(pageData.DBRows, db)
+	}
+
+	// Retrieve the members and teams of the organisation
+	pageData.Members, err = com.OrgMembers(orgName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	pageData.Teams, err = com.OrgTeams(orgName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	if pageData.Teams == nil {
+		pageData.Teams = []com.TeamEntry{}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+
+	// Render the page
+	t := tmpl.Lookup("orgPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		log.Printf("Error: %s", err)
+	}
+}
+
+// Sends the team list of an organisation back to the caller, as JSON.
+func orgTeamsResponse(w http.ResponseWriter, orgName string) {
+	teams, err := com.OrgTeams(orgName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if teams == nil {
+		teams = []com.TeamEntry{}
+	}
+	jsonList, err := json.Marshal(teams)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonList))
+}
+
+// Validates the "team" and "dbname" form fields of requests changing the databases of a team.  If they're not valid, an
+// error response is sent and false returned.
+func teamDatabaseNames(w http.ResponseWriter, r *http.Request) (teamName string, dbName string, ok bool) {
+	teamName = r.PostFormValue("team")
+	err := com.ValidateTeamName(teamName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid team name")
+		return
+	}
+	dbName = r.PostFormValue("dbname")
+	err = com.ValidateDB(dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid database name")
+		return
+	}
+	ok = true
+	return
+}
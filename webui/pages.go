@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -69,6 +70,152 @@ func aboutPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Render the audit log page, which lists the recorded changes to a database.  Only the owner of a database can see it.
+func auditPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0     com.Auth0Set
+		Entries   []com.AuditEntry
+		Meta      com.MetaInfo
+		NextPage  int
+		PageLimit int
+		PrevPage  int
+	}
+	pageData.Meta.Title = "Audit log"
+	pageData.PageLimit = 50
+
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		pageData.Meta.LoggedInUser = loggedInUser
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Retrieve the database owner and database name
+	// TODO: Add folder support
+	dbFolder := "/"
+	dbOwner, dbName, err := com.GetOD(1, r) // 1 = Ignore "/audit/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dbOwner == "" || dbName == "" {
+		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
+		return
+	}
+
+	// Check if the database exists
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
+			dbName))
+		return
+	}
+
+	// Only the database owner can see its audit log
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_OWNER)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "Only the owner of a database can view its audit log")
+		return
+	}
+
+	// Work out which page of entries to show
+	offset := 0
+	if o := r.FormValue("offset"); o != "" {
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			errorPage(w, r, http.StatusBadRequest, "Invalid offset value")
+			return
+		}
+	}
+
+	// Retrieve the audit log entries for the database.  One more than the page size is asked for, so we know if
+	// there's another page after this one
+	pageData.Entries, err = com.DatabaseAuditEntries(dbOwner, dbFolder, dbName, pageData.PageLimit+1, offset)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(pageData.Entries) > pageData.PageLimit {
+		pageData.Entries = pageData.Entries[:pageData.PageLimit]
+		pageData.NextPage = offset + pageData.PageLimit
+	}
+	if pageData.Entries == nil {
+		pageData.Entries = []com.AuditEntry{}
+	}
+	pageData.PrevPage = offset - pageData.PageLimit
+	if offset == 0 {
+		pageData.PrevPage = -1
+	} else if pageData.PrevPage < 0 {
+		pageData.PrevPage = 0
+	}
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(dbOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+	pageData.Meta.Database = dbName
+
+	// Retrieve the details and status updates count for the logged in user
+	ur, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ur.AvatarURL != "" {
+		pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+
+	// Render the page
+	t := tmpl.Lookup("auditPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		log.Printf("Error: %s", err)
+	}
+}
+
 // Render the branches page, which lists the branches for a database.
 func branchesPage(w http.ResponseWriter, r *http.Request) {
 	// Structure to hold page data
@@ -127,6 +274,9 @@ func branchesPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -161,6 +311,13 @@ func branchesPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.Owner = usr.Username
 	pageData.Meta.Database = dbName
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	for i, j := range branches {
 		// Create a branch entry
 		var r string
@@ -279,6 +436,9 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -340,6 +500,9 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 		avatarURL += "&s=30"
 	}
 
+	// The known discussion IDs are used for linking #123 references in the commit messages
+	refTypes, _ := com.DiscussionTypes(dbOwner, dbFolder, dbName)
+
 	// Create the history entry
 	pageData.History = []HistEntry{
 		{
@@ -350,7 +513,7 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 			CommitterEmail: rawList[headID].CommitterEmail,
 			CommitterName:  rawList[headID].CommitterName,
 			ID:             rawList[headID].ID,
-			Message:        string(gfm.Markdown([]byte(rawList[headID].Message))),
+			Message:        com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, rawList[headID].Message, refTypes),
 			Parent:         rawList[headID].Parent,
 			Timestamp:      rawList[headID].Timestamp,
 		},
@@ -380,7 +543,7 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 			CommitterEmail: commitData.CommitterEmail,
 			CommitterName:  commitData.CommitterName,
 			ID:             commitData.ID,
-			Message:        string(gfm.Markdown([]byte(commitData.Message))),
+			Message:        com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, commitData.Message, refTypes),
 			Parent:         commitData.Parent,
 			Timestamp:      commitData.Timestamp,
 		}
@@ -395,6 +558,13 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
 		ur, err := com.User(loggedInUser)
@@ -414,6 +584,8 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 
 	// Fill out the metadata
 	pageData.Meta.Database = dbName
+	pageData.Meta.FeedURL = databaseFeedPath("commits", dbOwner, dbFolder, dbName) + "?branch=" +
+		url.QueryEscape(branchName)
 	pageData.Branch = branchName
 	for i := range branches {
 		pageData.Branches = append(pageData.Branches, i)
@@ -579,6 +751,13 @@ func comparePage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
 		ur, err := com.User(loggedInUser)
@@ -690,10 +869,12 @@ func comparePage(w http.ResponseWriter, r *http.Request) {
 // Displays a web page asking the user to confirm deleting their database.
 func confirmDeletePage(w http.ResponseWriter, r *http.Request) {
 	var pageData struct {
-		Auth0 com.Auth0Set
-		Meta  com.MetaInfo
+		Auth0     com.Auth0Set
+		Meta      com.MetaInfo
+		Retention int
 	}
 	pageData.Meta.Title = "Confirm database deletion"
+	pageData.Retention = com.Conf.Trash.Retention
 
 	// Retrieve session data (if any)
 	var loggedInUser string
@@ -737,13 +918,21 @@ func confirmDeletePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
+	// Make sure the logged in user owns the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_OWNER)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
 		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
 		return
 	}
@@ -845,6 +1034,9 @@ func contributorsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -993,14 +1185,22 @@ func createBranchPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
-		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "You don't have write access to that database")
 		return
 	}
 
@@ -1094,6 +1294,9 @@ func createDiscussionPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -1191,14 +1394,22 @@ func createTagPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
-		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+	// Make sure the logged in user has write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "You don't have write access to that database")
 		return
 	}
 
@@ -1362,6 +1573,9 @@ func databasePage(w http.ResponseWriter, r *http.Request, dbOwner string, dbFold
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -1610,6 +1824,13 @@ func databasePage(w http.ResponseWriter, r *http.Request, dbOwner string, dbFold
 			return
 		}
 
+		// Work out what the logged in user is allowed to change
+		err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
 		// Retrieve the status updates count for the logged in user
 		if loggedInUser != "" {
 			pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
@@ -1739,6 +1960,13 @@ func databasePage(w http.ResponseWriter, r *http.Request, dbOwner string, dbFold
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Ensure the correct Avatar URL is displayed
 	pageData.Meta.AvatarURL = avatarURL
 
@@ -1753,6 +1981,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, dbOwner string, dbFold
 
 	// Fill out various metadata fields
 	pageData.Meta.Database = dbName
+	pageData.Meta.FeedURL = databaseFeedPath("commits", dbOwner, dbFolder, dbName)
 	pageData.Meta.Server = com.Conf.Web.ServerName
 	pageData.Meta.Title = fmt.Sprintf("%s %s %s", dbOwner, dbFolder, dbName)
 
@@ -1867,9 +2096,13 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 		CommentList    []com.DiscussionCommentEntry
 		DB             com.SQLiteDBinfo
 		DiscussionList []com.DiscussionEntry
+		Filter         com.DiscussionFilter
+		Labels         []com.DiscussionLabel
 		Meta           com.MetaInfo
+		Milestones     []com.Milestone
 		SelectedID     int
 		MyStar         bool
+		MySubscribe    bool
 		MyWatch        bool
 	}
 
@@ -1912,6 +2145,13 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Check if the list is being filtered
+	pageData.Filter, err = discussionFilterFromForm(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Validate the supplied information
 	if dbOwner == "" || dbName == "" {
 		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
@@ -1925,6 +2165,9 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -1959,7 +2202,15 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the list of discussions for this database
-	pageData.DiscussionList, err = com.Discussions(dbOwner, dbFolder, dbName, com.DISCUSSION, pageData.SelectedID)
+	pageData.DiscussionList, err = com.FilteredDiscussions(dbOwner, dbFolder, dbName, com.DISCUSSION, pageData.SelectedID,
+		pageData.Filter)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the labels and milestones which can be used for discussions
+	pageData.Labels, pageData.Milestones, err = trackingLists(dbOwner, dbFolder, dbName)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
@@ -1980,6 +2231,13 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
 		ur, err := com.User(loggedInUser)
@@ -2010,6 +2268,7 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 
 	// Fill out the metadata
 	pageData.Meta.Database = dbName
+	pageData.Meta.FeedURL = databaseFeedPath("discussions", dbOwner, dbFolder, dbName)
 	pageData.Meta.Title = "Discussion List"
 
 	// Add Auth0 info to the page data
@@ -2046,6 +2305,14 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 				errorPage(w, r, http.StatusInternalServerError, err.Error())
 				return
 			}
+
+			// Check if the user is subscribed to the discussion
+			pageData.MySubscribe, err = com.CheckDiscussionSubscribed(loggedInUser, dbOwner, dbFolder, dbName,
+				pageData.SelectedID)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, "Couldn't retrieve subscription status")
+				return
+			}
 		}
 
 		// Render the discussion comments page
@@ -2256,6 +2523,7 @@ func frontPage(w http.ResponseWriter, r *http.Request) {
 
 	// Set other relevant metadata
 	pageData.Meta.Title = `SQLite storage "in the cloud"`
+	pageData.Meta.FeedURL = "/feeds/new"
 
 	// Add Auth0 info to the page data
 	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
@@ -2290,20 +2558,27 @@ func frontPage(w http.ResponseWriter, r *http.Request) {
 func mergePage(w http.ResponseWriter, r *http.Request) {
 	var pageData struct {
 		Auth0               com.Auth0Set
+		ChecksMissing       []string
 		CommentList         []com.DiscussionCommentEntry
 		CommitList          []com.CommitData
 		DB                  com.SQLiteDBinfo
 		DestBranchNameOK    bool
 		DestBranchUsable    bool
+		Filter              com.DiscussionFilter
+		Labels              []com.DiscussionLabel
 		LicenceWarning      string
 		MRList              []com.DiscussionEntry
+		MergePolicy         com.MergePolicyStatus
 		Meta                com.MetaInfo
+		Milestones          []com.Milestone
 		SelectedID          int
+		StatusChecks        []com.CommitStatus
 		StatusMessage       string
 		StatusMessageColour string
 		SourceBranchOK      bool
 		SourceDBOK          bool
 		MyStar              bool
+		MySubscribe         bool
 		MyWatch             bool
 	}
 
@@ -2346,6 +2621,13 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Check if the list is being filtered
+	pageData.Filter, err = discussionFilterFromForm(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Validate the supplied information
 	if dbOwner == "" || dbName == "" {
 		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
@@ -2359,6 +2641,9 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -2393,7 +2678,15 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the list of MRs for this database
-	pageData.MRList, err = com.Discussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, pageData.SelectedID)
+	pageData.MRList, err = com.FilteredDiscussions(dbOwner, dbFolder, dbName, com.MERGE_REQUEST, pageData.SelectedID,
+		pageData.Filter)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the labels and milestones which can be used for MRs
+	pageData.Labels, pageData.Milestones, err = trackingLists(dbOwner, dbFolder, dbName)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
@@ -2414,6 +2707,13 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
 		ur, err := com.User(loggedInUser)
@@ -2444,6 +2744,7 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 
 	// Fill out the metadata
 	pageData.Meta.Database = dbName
+	pageData.Meta.FeedURL = databaseFeedPath("discussions", dbOwner, dbFolder, dbName)
 	pageData.Meta.Title = "Merge Requests"
 
 	// Set the default status message colour
@@ -2556,6 +2857,18 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 						errorPage(w, r, http.StatusInternalServerError, err.Error())
 						return
 					}
+
+					// Keep the review comments pointing at the latest version of the data
+					err = com.CarryForwardCommentAnchors(dbOwner, dbFolder, dbName, pageData.SelectedID, mr.MRDetails)
+					if err != nil {
+						log.Printf("Error when updating review comment anchors: %s\n", err.Error())
+					}
+
+					// Run the SQL assertions of the database against any new commits
+					err = com.StartSQLAssertions(dbOwner, dbFolder, dbName, mr.MRDetails)
+					if err != nil {
+						log.Printf("Error when starting SQL assertions: %s\n", err.Error())
+					}
 				}
 			}
 		}
@@ -2575,12 +2888,14 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 
 		// Add the commit author's username and avatar URL to the commit list entries, and check for licence changes
 		var licenceChanges bool
+		refTypes, _ := com.DiscussionTypes(dbOwner, dbFolder, dbName)
 		for _, j := range mr.MRDetails.Commits {
 			var c com.CommitData
 			c.AuthorEmail = j.AuthorEmail
 			c.AuthorName = j.AuthorName
 			c.ID = j.ID
 			c.Message = j.Message
+			c.MessageRendered = com.RenderDatabaseMarkdown(dbOwner, dbFolder, dbName, j.Message, refTypes)
 			c.Timestamp = j.Timestamp
 			c.AuthorUsername, c.AuthorAvatar, err = com.GetUsernameFromEmail(j.AuthorEmail)
 			if err != nil {
@@ -2619,6 +2934,21 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Check the reviews of the MR against the merge policy
+		pageData.MergePolicy, err = com.CheckMergePolicy(dbOwner, dbFolder, dbName, *mr)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Retrieve the status checks for the head commit, and see which of the required ones haven't passed
+		pageData.StatusChecks, pageData.ChecksMissing, err = com.CheckRequiredStatuses(dbOwner, dbFolder, dbName,
+			mr.MRDetails)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
 		// If this MR matches one of the user's status updates, remove the status update from the list
 		if loggedInUser != "" {
 			pageData.Meta.NumStatusUpdates, err = com.StatusUpdateCheck(dbOwner, dbFolder, dbName, pageData.SelectedID, loggedInUser)
@@ -2626,6 +2956,14 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 				errorPage(w, r, http.StatusInternalServerError, err.Error())
 				return
 			}
+
+			// Check if the user is subscribed to the MR
+			pageData.MySubscribe, err = com.CheckDiscussionSubscribed(loggedInUser, dbOwner, dbFolder, dbName,
+				pageData.SelectedID)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, "Couldn't retrieve subscription status")
+				return
+			}
 		}
 
 		// Render the MR comments page
@@ -2648,11 +2986,18 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 // Renders the user Preferences page.
 func prefPage(w http.ResponseWriter, r *http.Request, loggedInUser string) {
 	var pageData struct {
-		Auth0       com.Auth0Set
-		DisplayName string
-		Email       string
-		MaxRows     int
-		Meta        com.MetaInfo
+		APITokens      []com.APIToken
+		Auth0          com.Auth0Set
+		DisplayName    string
+		Email          string
+		MaxRows        int
+		Meta           com.MetaInfo
+		NotifyEvents   map[com.EventType]string
+		NotifySettings []com.NotifySettings
+		Orgs           []com.OrgEntry
+		Quota          com.QuotaUsage
+		TransferOffers []com.TransferOfferEntry
+		Trash          []com.TrashEntry
 	}
 	pageData.Meta.Title = "Preferences"
 	pageData.Meta.LoggedInUser = loggedInUser
@@ -2679,6 +3024,52 @@ func prefPage(w http.ResponseWriter, r *http.Request, loggedInUser string) {
 	// Retrieve the user preference data
 	pageData.MaxRows = com.PrefUserMaxRows(loggedInUser)
 
+	// Retrieve the API tokens for the user
+	pageData.APITokens, err = com.APITokens(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	if pageData.APITokens == nil {
+		pageData.APITokens = []com.APIToken{}
+	}
+
+	// Retrieve the notification settings for the user, and the databases they're watching
+	pageData.NotifySettings, err = com.UserNotifySettings(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	pageData.NotifyEvents = com.EventNames
+
+	// Retrieve the organisations the user is a member of
+	pageData.Orgs, err = com.UserOrganisations(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Retrieve the databases which have been offered to the user
+	pageData.TransferOffers, err = com.TransferOffersForUser(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Retrieve the databases in the trash of the user
+	pageData.Trash, err = com.DeletedDatabases(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Retrieve the storage used by the user, and their limits
+	pageData.Quota, err = com.Quota(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
 	// Retrieve the details and status updates count for the logged in user
 	ur, err := com.User(loggedInUser)
 	if err != nil {
@@ -2861,6 +3252,9 @@ func releasesPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -2896,8 +3290,16 @@ func releasesPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Fill out the metadata
 	pageData.Meta.Database = dbName
+	pageData.Meta.FeedURL = databaseFeedPath("releases", dbOwner, dbFolder, dbName)
 	pageData.ReleaseList = make(map[string]relEntry)
 	if len(releases) > 0 {
 		for i, j := range releases {
@@ -3024,11 +3426,24 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 	var pageData struct {
 		Auth0            com.Auth0Set
 		BranchLics       map[string]string
+		BranchProtection map[string]com.BranchProtectionEntry
+		Collaborators    []com.CollaboratorEntry
 		DB               com.SQLiteDBinfo
 		FullDescRendered string
+		IsOwner          bool
+		Labels           []com.DiscussionLabel
 		Licences         map[string]com.LicenceEntry
+		MergePolicy      com.MergePolicy
 		Meta             com.MetaInfo
+		Milestones       []com.Milestone
 		NumLicences      int
+		SQLAssertions    []com.SQLAssertion
+		Teams            []string
+		TransferOffer    com.TransferOfferEntry
+		TransferTargets  []string
+		WebhookEvents    map[com.EventType]string
+		WebhookLog       []com.WebhookDeliveryEntry
+		Webhooks         []com.WebhookEntry
 	}
 	pageData.Meta.Title = "Database settings"
 
@@ -3072,9 +3487,14 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
 		return
 	}
-	if strings.ToLower(dbOwner) != strings.ToLower(loggedInUser) {
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_ADMIN)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
 		errorPage(w, r, http.StatusBadRequest,
-			"You can only access the settings page for your own databases")
+			"You need admin access to a database to change its settings")
 		return
 	}
 
@@ -3085,6 +3505,9 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -3159,6 +3582,18 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 		pageData.BranchLics[bName] = a
 	}
 
+	// Retrieve the branch protection settings, including an (unprotected) entry for any branches without one
+	pageData.BranchProtection, err = com.GetBranchProtection(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for bName := range branchHeads {
+		if _, ok := pageData.BranchProtection[bName]; !ok {
+			pageData.BranchProtection[bName] = com.BranchProtectionEntry{}
+		}
+	}
+
 	// Populate the licence list
 	pageData.Licences, err = com.GetLicences(loggedInUser)
 	if err != nil {
@@ -3170,6 +3605,85 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 	// Render the full description markdown
 	pageData.FullDescRendered = string(gfm.Markdown([]byte(pageData.DB.Info.FullDesc)))
 
+	// Retrieve the list of collaborators
+	pageData.Collaborators, err = com.Collaborators(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if pageData.Collaborators == nil {
+		pageData.Collaborators = []com.CollaboratorEntry{}
+	}
+
+	// Retrieve the webhooks, and their recent deliveries
+	pageData.Webhooks, pageData.WebhookLog, err = webhookLists(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.WebhookEvents = com.EventNames
+
+	// Retrieve the discussion labels and milestones
+	pageData.Labels, pageData.Milestones, err = trackingLists(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the merge policy, and the teams which can be required to review merge requests
+	pageData.MergePolicy, err = com.GetMergePolicy(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	teams, err := com.OrgTeams(dbOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Teams = []string{}
+	for _, t := range teams {
+		pageData.Teams = append(pageData.Teams, t.Name)
+	}
+
+	// Retrieve the SQL assertions run against merge requests
+	pageData.SQLAssertions, err = com.GetSQLAssertions(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Only owners can delete or transfer a database.  They can transfer it to themselves, or to an organisation they
+	// own
+	perm, err := com.DBPermission(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.IsOwner = perm == com.PERM_OWNER
+	if pageData.IsOwner {
+		if strings.ToLower(loggedInUser) != strings.ToLower(dbOwner) {
+			pageData.TransferTargets = append(pageData.TransferTargets, loggedInUser)
+		}
+		orgs, err := com.UserOrganisations(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, o := range orgs {
+			if o.Role == com.ORG_OWNER && strings.ToLower(o.Name) != strings.ToLower(dbOwner) {
+				pageData.TransferTargets = append(pageData.TransferTargets, o.Name)
+			}
+		}
+
+		// Retrieve the pending transfer offer (if any) for the database
+		pageData.TransferOffer, err = com.TransferOffer(dbOwner, dbFolder, dbName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	// Retrieve correctly capitalised username for the database owner
 	usr, err := com.User(dbOwner)
 	if err != nil {
@@ -3367,6 +3881,9 @@ func tagsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
+		if redirectMovedDatabase(w, r, loggedInUser, dbOwner, dbFolder, dbName) {
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
 			dbName))
 		return
@@ -3402,8 +3919,16 @@ func tagsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Fill out the metadata
 	pageData.Meta.Database = dbName
+	pageData.Meta.FeedURL = databaseFeedPath("releases", dbOwner, dbFolder, dbName)
 	pageData.TagList = make(map[string]tgEntry)
 	if len(tags) > 0 {
 		for i, j := range tags {
@@ -3470,6 +3995,67 @@ func tagsPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Tells the user they've been unsubscribed from notification emails.
+func unsubscribePage(w http.ResponseWriter, r *http.Request, dbOwner string, dbFolder string, dbName string) {
+	var pageData struct {
+		Auth0    com.Auth0Set
+		Database string
+		Meta     com.MetaInfo
+	}
+	if dbName != "" {
+		pageData.Database = fmt.Sprintf("%s%s%s", dbOwner, dbFolder, dbName)
+	}
+
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		pageData.Meta.LoggedInUser = loggedInUser
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	pageData.Meta.Title = "Unsubscribed"
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+
+	// Render the page
+	t := tmpl.Lookup("unsubscribePage")
+	err := t.Execute(w, pageData)
+	if err != nil {
+		log.Printf("Error: %s", err)
+	}
+}
+
 // This function presents the status updates page to logged in users.
 func updatesPage(w http.ResponseWriter, r *http.Request) {
 	var pageData struct {
@@ -3685,6 +4271,17 @@ func userPage(w http.ResponseWriter, r *http.Request, userName string) {
 		return
 	}
 
+	// Retrieve the details for the user who's page we're looking at.  Organisations have a page of their own
+	usr, err := com.User(userName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if usr.IsOrg {
+		orgPage(w, r, usr.Username)
+		return
+	}
+
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
 		ur, err := com.User(loggedInUser)
@@ -3702,15 +4299,11 @@ func userPage(w http.ResponseWriter, r *http.Request, userName string) {
 		}
 	}
 
-	// Retrieve the details for the user who's page we're looking at
-	usr, err := com.User(userName)
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
-	}
+	// Fill out the details for the user who's page we're looking at
 	pageData.FullName = usr.DisplayName
 	pageData.Meta.Owner = usr.Username
 	pageData.Meta.Title = usr.Username
+	pageData.Meta.FeedURL = "/feeds/user/" + url.PathEscape(usr.Username)
 	if usr.AvatarURL != "" {
 		pageData.UserAvatarURL = usr.AvatarURL + "&s=48"
 	}
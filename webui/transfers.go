@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// Accepts a pending transfer offer, moving the database to the recipient.
+func acceptTransferHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+
+	// Retrieve the transfer offer
+	offer, err := com.TransferOffer(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if offer.Recipient == "" {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("There's no transfer offer for database '%s%s%s'",
+			dbOwner, dbFolder, dbName))
+		return
+	}
+
+	// Make sure the logged in user can accept the offer
+	allowed, err := transferRecipientCheck(loggedInUser, offer.Recipient)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "The database wasn't offered to you")
+		return
+	}
+
+	// Make sure the recipient doesn't already have a database of the same name.  This was checked when the offer was
+	// made, but one may have been created since
+	exists, err := com.CheckDBExists(offer.Recipient, offer.Recipient, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		errorPage(w, r, http.StatusConflict, fmt.Sprintf("'%s' already has a database called '%s'.  It will "+
+			"need to be renamed or deleted before the transfer can be accepted", offer.Recipient, dbName))
+		return
+	}
+
+	// Make sure the database fits within the limits of the recipient
+	err = com.CheckDatabaseQuota(offer.Recipient)
+	if err != nil {
+		errorPage(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	commitList, err := com.GetCommitList(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var commits []com.CommitEntry
+	for _, c := range commitList {
+		commits = append(commits, c)
+	}
+	err = com.CheckStorageQuota(offer.Recipient, com.CommitDatabaseFiles(commits))
+	if err != nil {
+		errorPage(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Transfer the database
+	err = com.TransferDatabase(dbOwner, dbFolder, dbName, offer.Recipient)
+	if err != nil {
+		if _, ok := err.(com.LicenceClashError); ok {
+			errorPage(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, offer.Recipient, dbFolder, dbName, com.AUDIT_DATABASE_TRANSFER,
+		map[string]string{"owner": dbOwner}, map[string]string{"owner": offer.Recipient})
+
+	// Invalidate the memcache data for the database under its old owner
+	err = com.InvalidateCacheEntry(loggedInUser, dbOwner, dbFolder, dbName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	}
+
+	// Bounce to the database at its new location
+	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", offer.Recipient, dbFolder, dbName), http.StatusSeeOther)
+}
+
+// Withdraws (for the database owner) or declines (for the recipient) a pending transfer offer.
+func cancelTransferHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+
+	// Retrieve the transfer offer
+	offer, err := com.TransferOffer(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if offer.Recipient == "" {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("There's no transfer offer for database '%s%s%s'",
+			dbOwner, dbFolder, dbName))
+		return
+	}
+
+	// The offer can be withdrawn by the owner of the database, or declined by the recipient
+	isOwner, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_OWNER)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !isOwner {
+		isRecipient, err := transferRecipientCheck(loggedInUser, offer.Recipient)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !isRecipient {
+			errorPage(w, r, http.StatusUnauthorized, "You're not allowed to cancel that transfer offer")
+			return
+		}
+	}
+
+	// Remove the offer
+	err = com.DeleteTransferOffer(dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_TRANSFER_CANCEL,
+		map[string]string{"recipient": offer.Recipient}, nil)
+
+	// Bounce back to the page the request came from
+	if isOwner {
+		http.Redirect(w, r, fmt.Sprintf("/settings/%s%s%s", dbOwner, dbFolder, dbName), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/pref", http.StatusSeeOther)
+}
+
+// Offers a database to another user or organisation.  The database doesn't move until the recipient accepts.
+func offerTransferHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve session data (if any)
+	var loggedInUser string
+	var u interface{}
+	validSession := false
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u != nil {
+		loggedInUser = u.(string)
+		validSession = true
+	}
+
+	// Ensure we have a valid logged in user
+	if validSession != true {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract the required form variables
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner := strings.ToLower(usr)
+	recipient := r.PostFormValue("recipient")
+	err = com.ValidateUser(recipient)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid recipient name")
+		return
+	}
+	if strings.ToLower(recipient) == dbOwner {
+		errorPage(w, r, http.StatusBadRequest, "The database already belongs to that owner")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", dbOwner, dbFolder,
+			dbName))
+		return
+	}
+
+	// Make sure the logged in user owns the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_OWNER)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusUnauthorized, "Only the owner of a database can transfer it")
+		return
+	}
+
+	// Make sure the recipient exists
+	usrExists, err := com.CheckUserExists(recipient)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !usrExists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Unknown user '%s'", recipient))
+		return
+	}
+
+	// Make sure the recipient doesn't already have a database of the same name
+	exists, err = com.CheckDBExists(recipient, recipient, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		errorPage(w, r, http.StatusConflict, fmt.Sprintf("'%s' already has a database called '%s'", recipient,
+			dbName))
+		return
+	}
+
+	// Record the offer
+	err = com.StoreTransferOffer(dbOwner, dbFolder, dbName, recipient)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_TRANSFER_OFFER, nil,
+		map[string]string{"recipient": recipient})
+
+	// Bounce back to the settings page
+	http.Redirect(w, r, fmt.Sprintf("/settings/%s%s%s", dbOwner, dbFolder, dbName), http.StatusSeeOther)
+}
+
+// If the requested database has been transferred to a new owner, redirect the request to its new location.  Returns
+// true if a redirect was sent.
+func redirectMovedDatabase(w http.ResponseWriter, r *http.Request, loggedInUser string, dbOwner string,
+	dbFolder string, dbName string) bool {
+	newOwner, _, newName, err := com.DatabaseRedirect(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil || newOwner == "" {
+		return false
+	}
+	http.Redirect(w, r, com.MovedDatabaseURL(r.URL, dbOwner, dbName, newOwner, newName), http.StatusFound)
+	return true
+}
+
+// Checks if the logged in user can act on behalf of the recipient of a transfer offer.  That's either the recipient
+// themselves, or an owner of the recipient organisation.
+func transferRecipientCheck(loggedInUser string, recipient string) (bool, error) {
+	if strings.ToLower(loggedInUser) == strings.ToLower(recipient) {
+		return true, nil
+	}
+	perm, err := com.OrgPermission(loggedInUser, recipient)
+	if err != nil {
+		return false, err
+	}
+	return perm == com.PERM_OWNER, nil
+}
@@ -440,6 +440,13 @@ func visualisePage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Owner = usr.Username
 
+	// Work out what the logged in user is allowed to change
+	err = setPermissions(&pageData.Meta, loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Ensure the correct Avatar URL is displayed
 	pageData.Meta.AvatarURL = avatarURL
 
@@ -857,10 +864,16 @@ func visSaveRequestHandler(w http.ResponseWriter, r *http.Request) {
 		loggedInUser = u.(string)
 	}
 
-	// Make sure the save request is coming from the database owner
-	if loggedInUser != dbOwner {
+	// Make sure the save request is coming from someone with write access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_WRITE)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
 		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "Only the database owner is allowed to save a visualisation (at least for now)")
+		fmt.Fprint(w, "You need write access to a database to save visualisations for it")
 		return
 	}
 
@@ -1016,6 +1029,8 @@ func visSaveRequestHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_VIS_SAVE, nil,
+		map[string]interface{}{"name": visName, "table": requestedTable, "params": visParams})
 
 	// Save succeeded
 	w.WriteHeader(http.StatusOK)
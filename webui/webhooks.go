@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	com "github.com/sqlitebrowser/dbhub.io/common"
+)
+
+// Adds a webhook to a database.  Returns the updated webhook and delivery lists.
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := webhookAdminCheck(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate the receiver URL
+	hookURL := r.PostFormValue("url")
+	err := com.Validate.Var(hookURL, "url,max=1024")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid webhook URL")
+		return
+	}
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid webhook URL")
+		return
+	}
+	err = com.CheckWebhookURL(u)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Validate the event filter.  No events selected means all of them
+	var events []com.EventType
+	if e := r.PostFormValue("events"); e != "" {
+		for _, s := range strings.Split(e, ",") {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "Invalid event list")
+				return
+			}
+			if _, ok := com.EventNames[com.EventType(n)]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Unknown event type '%d'", n)
+				return
+			}
+			events = append(events, com.EventType(n))
+		}
+	}
+
+	// Use the supplied signing secret, or generate one if none was given
+	secret := r.PostFormValue("secret")
+	if secret == "" {
+		secret, err = com.NewWebhookSecret()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+	} else if len(secret) > 255 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "The webhook secret is too long")
+		return
+	}
+
+	// Save the webhook
+	webhookID, err := com.StoreWebhook(dbOwner, dbFolder, dbName, loggedInUser, hookURL, secret, events)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_WEBHOOK_CREATE, nil,
+		map[string]interface{}{"id": webhookID, "url": hookURL, "events": events})
+	webhooksResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Removes a webhook from a database.  Returns the updated webhook and delivery lists.
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := webhookAdminCheck(w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := strconv.ParseInt(r.PostFormValue("webhookid"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid webhook id")
+		return
+	}
+	err = com.DeleteWebhook(dbOwner, dbFolder, dbName, webhookID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_WEBHOOK_DELETE,
+		map[string]int64{"id": webhookID}, nil)
+	webhooksResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Queues a webhook payload to be sent again.  Returns the updated webhook and delivery lists.
+func redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, dbOwner, dbFolder, dbName, ok := webhookAdminCheck(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PostFormValue("deliveryid"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid delivery id")
+		return
+	}
+	err = com.RedeliverWebhook(dbOwner, dbFolder, dbName, deliveryID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	com.Audit(r, "webui", loggedInUser, dbOwner, dbFolder, dbName, com.AUDIT_WEBHOOK_REDELIVER, nil,
+		map[string]int64{"delivery": deliveryID})
+	webhooksResponse(w, dbOwner, dbFolder, dbName)
+}
+
+// Checks the request is from a user with admin access to the database given in the form data, sending an error
+// response if it isn't.
+func webhookAdminCheck(w http.ResponseWriter, r *http.Request) (loggedInUser string, dbOwner string,
+	dbFolder string, dbName string, ok bool) {
+	// Retrieve session data (if any)
+	var u interface{}
+	if com.Conf.Environment.Environment != "docker" {
+		sess, err := store.Get(r, "dbhub-user")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u = sess.Values["UserName"]
+	} else {
+		u = "default"
+	}
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	loggedInUser = u.(string)
+
+	// Extract the database details
+	usr, dbFolder, dbName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+	dbOwner = strings.ToLower(usr)
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckDBExists(loggedInUser, dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", dbOwner, dbFolder, dbName)
+		return
+	}
+
+	// Make sure the logged in user has admin access to the database
+	allowed, err := com.CheckDBPermissions(loggedInUser, dbOwner, dbFolder, dbName, com.PERM_ADMIN)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You don't have admin access to that database")
+		return
+	}
+	ok = true
+	return
+}
+
+// Returns the webhooks for a database and their recent deliveries.
+func webhookLists(dbOwner string, dbFolder string, dbName string) (hooks []com.WebhookEntry,
+	deliveries []com.WebhookDeliveryEntry, err error) {
+	hooks, err = com.Webhooks(dbOwner, dbFolder, dbName)
+	if err != nil {
+		return
+	}
+	deliveries, err = com.WebhookDeliveries(dbOwner, dbFolder, dbName, 50)
+	if err != nil {
+		return
+	}
+	if hooks == nil {
+		hooks = []com.WebhookEntry{}
+	}
+	if deliveries == nil {
+		deliveries = []com.WebhookDeliveryEntry{}
+	}
+	return
+}
+
+// Sends the webhooks for a database and their recent deliveries back to the caller.
+func webhooksResponse(w http.ResponseWriter, dbOwner string, dbFolder string, dbName string) {
+	var lists struct {
+		Deliveries []com.WebhookDeliveryEntry `json:"deliveries"`
+		Webhooks   []com.WebhookEntry         `json:"webhooks"`
+	}
+	var err error
+	lists.Webhooks, lists.Deliveries, err = webhookLists(dbOwner, dbFolder, dbName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	jsonList, err := json.Marshal(lists)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	fmt.Fprint(w, string(jsonList))
+}